@@ -0,0 +1,224 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"strconv"
+	"strings"
+)
+
+// BodyStructure represents the MIME structure of a message, as returned by the
+// BODYSTRUCTURE and BODY data items of a FETCH response (RFC 3501 section
+// 7.4.2). Multipart bodies contain one or more Parts. A body of type
+// MESSAGE/RFC822 contains the structure of the encapsulated message in
+// Parts[0]. Fields that are not sent by the server, including the optional
+// extension data, are left at their zero values.
+type BodyStructure struct {
+	MIMEType    string            // Media type in upper case (e.g. "TEXT")
+	MIMESubtype string            // Media subtype in upper case (e.g. "PLAIN")
+	Params      map[string]string // Body parameters (keys in upper case)
+	ID          string            // Content-ID (single part only)
+	Description string            // Content-Description (single part only)
+	Encoding    string            // Content-Transfer-Encoding (single part only)
+	Size        uint32            // Body size in octets (single part only)
+	Lines       uint32            // Size in text lines (TEXT and MESSAGE/RFC822)
+	Envelope    *Envelope         // Envelope of MESSAGE/RFC822
+	Parts       []*BodyStructure  // Multipart or MESSAGE/RFC822 contents
+
+	// Extension data
+	MD5               string            // Content-MD5 (single part only)
+	Disposition       string            // Content-Disposition in upper case
+	DispositionParams map[string]string // Disposition parameters
+	Language          []string          // Content-Language
+	Location          string            // Content-Location
+}
+
+// BodyPart describes a BODY[<section>]<<partial>> item of the FETCH command.
+// Section is the part specifier (e.g. "", "TEXT", "1.2.MIME", or
+// "HEADER.FIELDS (SUBJECT FROM)"). Partial, if not nil, contains the origin
+// octet and the maximum number of octets to fetch. Peek causes the BODY.PEEK
+// form to be used, which does not set the \Seen flag.
+//
+// The server echoes only the origin octet of a partial fetch, so the data item
+// name in the response is different from the one in the request (e.g.
+// "BODY.PEEK[]<0.8192>" is returned as "BODY[]<0>"). Use Key to look up the
+// returned data in MessageInfo.Attrs:
+//
+//	part := imap.BodyPart{Partial: &[2]uint32{0, 8192}, Peek: true}
+//	cmd, err := imap.Wait(c.Fetch(seq, part.String()))
+//	...
+//	preview := imap.AsBytes(rsp.MessageInfo().Attrs[part.Key()])
+type BodyPart struct {
+	Section string
+	Partial *[2]uint32
+	Peek    bool
+}
+
+// String returns the FETCH item name for the body part.
+func (p BodyPart) String() string {
+	item := "BODY["
+	if p.Peek {
+		item = "BODY.PEEK["
+	}
+	item += p.Section + "]"
+	if p.Partial != nil {
+		item += "<" + strconv.FormatUint(uint64(p.Partial[0]), 10) + "." +
+			strconv.FormatUint(uint64(p.Partial[1]), 10) + ">"
+	}
+	return item
+}
+
+// Key returns the normalized data item name under which the server returns the
+// body part in a FETCH response. It matches the keys of MessageInfo.Attrs.
+func (p BodyPart) Key() string {
+	key := "BODY[" + toUpper(strings.Join(strings.Fields(p.Section), " ")) + "]"
+	if p.Partial != nil {
+		key += "<" + strconv.FormatUint(uint64(p.Partial[0]), 10) + ">"
+	}
+	return key
+}
+
+// errBodyStructure is returned when a body structure cannot be parsed.
+var errBodyStructure = &ParserError{Info: "bad body structure"}
+
+// NewBodyStructure returns the body structure extracted from a BODYSTRUCTURE
+// or BODY data item of a FETCH response. An error is returned if f is not a
+// valid body structure.
+func NewBodyStructure(f Field) (*BodyStructure, error) {
+	list, ok := f.([]Field)
+	if !ok || len(list) == 0 {
+		return nil, errBodyStructure
+	}
+	if _, ok = list[0].([]Field); ok {
+		return newMultipart(list)
+	}
+	return newSinglePart(list)
+}
+
+// newMultipart parses body-type-mpart and body-ext-mpart ABNF rules.
+func newMultipart(list []Field) (*BodyStructure, error) {
+	b := &BodyStructure{MIMEType: "MULTIPART"}
+	i := 0
+	for ; i < len(list); i++ {
+		if _, ok := list[i].([]Field); !ok {
+			break
+		}
+		part, err := NewBodyStructure(list[i])
+		if err != nil {
+			return nil, err
+		}
+		b.Parts = append(b.Parts, part)
+	}
+	if i == len(list) || TypeOf(list[i])&(Atom|QuotedString|LiteralString) == 0 {
+		return nil, errBodyStructure
+	}
+	b.MIMESubtype = toUpper(AsString(list[i]))
+
+	// Extension data
+	ext := list[i+1:]
+	if len(ext) > 0 {
+		b.Params = asParams(ext[0])
+	}
+	if len(ext) > 1 {
+		b.setExt(ext[1:])
+	}
+	return b, nil
+}
+
+// newSinglePart parses body-type-1part and body-ext-1part ABNF rules.
+func newSinglePart(list []Field) (*BodyStructure, error) {
+	if len(list) < 7 || TypeOf(list[6]) != Number {
+		return nil, errBodyStructure
+	}
+	b := &BodyStructure{
+		MIMEType:    toUpper(AsString(list[0])),
+		MIMESubtype: toUpper(AsString(list[1])),
+		Params:      asParams(list[2]),
+		ID:          AsString(list[3]),
+		Description: AsString(list[4]),
+		Encoding:    toUpper(AsString(list[5])),
+		Size:        AsNumber(list[6]),
+	}
+	if b.MIMEType == "" || b.MIMESubtype == "" {
+		return nil, errBodyStructure
+	}
+	ext := list[7:]
+	switch {
+	case b.MIMEType == "MESSAGE" && b.MIMESubtype == "RFC822":
+		if len(ext) < 3 || TypeOf(ext[0]) != List || TypeOf(ext[1]) != List {
+			if len(ext) > 0 && TypeOf(ext[0]) == List {
+				return nil, errBodyStructure
+			}
+			break // Some servers omit envelope, body, and lines
+		}
+		env, err := NewEnvelope(ext[0])
+		if err != nil {
+			return nil, err
+		}
+		part, err := NewBodyStructure(ext[1])
+		if err != nil {
+			return nil, err
+		}
+		b.Envelope = env
+		b.Parts = []*BodyStructure{part}
+		b.Lines = AsNumber(ext[2])
+		ext = ext[3:]
+	case b.MIMEType == "TEXT":
+		if len(ext) > 0 && TypeOf(ext[0]) == Number {
+			b.Lines = AsNumber(ext[0])
+			ext = ext[1:]
+		}
+	}
+
+	// Extension data
+	if len(ext) > 0 {
+		b.MD5 = AsString(ext[0])
+	}
+	if len(ext) > 1 {
+		b.setExt(ext[1:])
+	}
+	return b, nil
+}
+
+// setExt extracts disposition, language, and location extension data, which
+// are common to single part and multipart bodies. Any additional fields are
+// ignored.
+func (b *BodyStructure) setExt(ext []Field) {
+	if dsp := AsList(ext[0]); len(dsp) == 2 {
+		b.Disposition = toUpper(AsString(dsp[0]))
+		b.DispositionParams = asParams(dsp[1])
+	}
+	if len(ext) > 1 {
+		switch lang := ext[1].(type) {
+		case []Field:
+			for _, f := range lang {
+				b.Language = append(b.Language, AsString(f))
+			}
+		case nil:
+		default:
+			if s := AsString(lang); s != "" {
+				b.Language = []string{s}
+			}
+		}
+	}
+	if len(ext) > 2 {
+		b.Location = AsString(ext[2])
+	}
+}
+
+// asParams converts a parenthesized list of attribute/value pairs into a map.
+// Attribute names are converted to upper case. Nil is returned if f is NIL or
+// not a valid list of pairs.
+func asParams(f Field) map[string]string {
+	list := AsList(f)
+	if len(list) == 0 || len(list)&1 == 1 {
+		return nil
+	}
+	params := make(map[string]string, len(list)/2)
+	for i := 0; i < len(list); i += 2 {
+		params[toUpper(AsString(list[i]))] = AsString(list[i+1])
+	}
+	return params
+}
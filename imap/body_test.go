@@ -0,0 +1,198 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBodyStructure(t *testing.T) {
+	tests := []struct {
+		in  string
+		out *BodyStructure
+	}{
+		// RFC 3501 examples
+		{`("TEXT" "PLAIN" ("CHARSET" "US-ASCII") NIL NIL "7BIT" 3028 92)`,
+			&BodyStructure{
+				MIMEType:    "TEXT",
+				MIMESubtype: "PLAIN",
+				Params:      map[string]string{"CHARSET": "US-ASCII"},
+				Encoding:    "7BIT",
+				Size:        3028,
+				Lines:       92}},
+		{`(("TEXT" "PLAIN" ("CHARSET" "US-ASCII") NIL NIL "7BIT" 1152 23)` +
+			`("TEXT" "PLAIN" ("CHARSET" "US-ASCII" "NAME" "cc.diff") ` +
+			`"<960723163407.20117h@cac.washington.edu>" "Compiler diff" ` +
+			`"BASE64" 4554 73) "MIXED")`,
+			&BodyStructure{
+				MIMEType:    "MULTIPART",
+				MIMESubtype: "MIXED",
+				Parts: []*BodyStructure{{
+					MIMEType:    "TEXT",
+					MIMESubtype: "PLAIN",
+					Params:      map[string]string{"CHARSET": "US-ASCII"},
+					Encoding:    "7BIT",
+					Size:        1152,
+					Lines:       23,
+				}, {
+					MIMEType:    "TEXT",
+					MIMESubtype: "PLAIN",
+					Params:      map[string]string{"CHARSET": "US-ASCII", "NAME": "cc.diff"},
+					ID:          "<960723163407.20117h@cac.washington.edu>",
+					Description: "Compiler diff",
+					Encoding:    "BASE64",
+					Size:        4554,
+					Lines:       73,
+				}}}},
+
+		// Extension data
+		{`("image" "png" ("name" "a.png") NIL NIL "base64" 1024 "md5sum" ` +
+			`("attachment" ("filename" "a.png")) ("en" "de") "http://x/a.png" ext)`,
+			&BodyStructure{
+				MIMEType:          "IMAGE",
+				MIMESubtype:       "PNG",
+				Params:            map[string]string{"NAME": "a.png"},
+				Encoding:          "BASE64",
+				Size:              1024,
+				MD5:               "md5sum",
+				Disposition:       "ATTACHMENT",
+				DispositionParams: map[string]string{"FILENAME": "a.png"},
+				Language:          []string{"en", "de"},
+				Location:          "http://x/a.png"}},
+		{`("TEXT" "HTML" NIL NIL NIL "QUOTED-PRINTABLE" 10 1 NIL ("INLINE" NIL) "en")`,
+			&BodyStructure{
+				MIMEType:    "TEXT",
+				MIMESubtype: "HTML",
+				Encoding:    "QUOTED-PRINTABLE",
+				Size:        10,
+				Lines:       1,
+				Disposition: "INLINE",
+				Language:    []string{"en"}}},
+		{`(("TEXT" "PLAIN" NIL NIL NIL "7BIT" 1 1)("TEXT" "HTML" NIL NIL NIL "7BIT" 2 1) ` +
+			`"ALTERNATIVE" ("BOUNDARY" "xyz") NIL NIL)`,
+			&BodyStructure{
+				MIMEType:    "MULTIPART",
+				MIMESubtype: "ALTERNATIVE",
+				Params:      map[string]string{"BOUNDARY": "xyz"},
+				Parts: []*BodyStructure{{
+					MIMEType:    "TEXT",
+					MIMESubtype: "PLAIN",
+					Encoding:    "7BIT",
+					Size:        1,
+					Lines:       1,
+				}, {
+					MIMEType:    "TEXT",
+					MIMESubtype: "HTML",
+					Encoding:    "7BIT",
+					Size:        2,
+					Lines:       1,
+				}}}},
+
+		// Encapsulated message
+		{`("MESSAGE" "RFC822" NIL NIL NIL "7BIT" 342 ` +
+			`(NIL "Hi" NIL NIL NIL NIL NIL NIL NIL NIL) ` +
+			`("TEXT" "PLAIN" NIL NIL NIL "7BIT" 20 2) 12)`,
+			&BodyStructure{
+				MIMEType:    "MESSAGE",
+				MIMESubtype: "RFC822",
+				Encoding:    "7BIT",
+				Size:        342,
+				Lines:       12,
+				Envelope:    &Envelope{Subject: "Hi"},
+				Parts: []*BodyStructure{{
+					MIMEType:    "TEXT",
+					MIMESubtype: "PLAIN",
+					Encoding:    "7BIT",
+					Size:        20,
+					Lines:       2,
+				}}}},
+		{`("MESSAGE" "RFC822" NIL NIL NIL "7BIT" 342)`,
+			&BodyStructure{
+				MIMEType:    "MESSAGE",
+				MIMESubtype: "RFC822",
+				Encoding:    "7BIT",
+				Size:        342}},
+
+		// Invalid structures
+		{`()`, nil},
+		{`(())`, nil},
+		{`(("TEXT" "PLAIN" NIL NIL NIL "7BIT" 1 1))`, nil},
+		{`(("TEXT" "PLAIN" NIL NIL NIL "7BIT") "MIXED")`, nil},
+		{`("TEXT" "PLAIN" NIL NIL NIL "7BIT")`, nil},
+		{`("TEXT" "PLAIN" NIL NIL NIL "7BIT" "1")`, nil},
+		{`(NIL "PLAIN" NIL NIL NIL "7BIT" 1)`, nil},
+		{`("MESSAGE" "RFC822" NIL NIL NIL "7BIT" 1 (NIL) ("TEXT") 1)`, nil},
+	}
+	c, s := newTestConn(1024)
+	C := newTransport(c, nil)
+	r := newReader(C, MemoryReader{}, "A")
+
+	for _, test := range tests {
+		C.clear()
+		s.Write([]byte("* 1 FETCH (BODYSTRUCTURE " + test.in + ")" + CRLF))
+
+		raw, err := r.Next()
+		rsp, err := raw.Parse()
+		if err != nil {
+			t.Errorf("Parse(%+q) unexpected error; %v", test.in, err)
+			continue
+		}
+		out, err := NewBodyStructure(rsp.MessageInfo().Attrs["BODYSTRUCTURE"])
+		if test.out == nil {
+			if err == nil {
+				t.Errorf("NewBodyStructure(%+q) expected error", test.in)
+			}
+		} else if err != nil {
+			t.Errorf("NewBodyStructure(%+q) unexpected error; %v", test.in, err)
+		} else if !reflect.DeepEqual(out, test.out) {
+			t.Errorf("NewBodyStructure(%+q) expected\n%+v; got\n%+v", test.in, test.out, out)
+		}
+	}
+	if _, err := NewBodyStructure(nil); err == nil {
+		t.Errorf("NewBodyStructure(nil) expected error")
+	}
+}
+
+func TestBodyPart(t *testing.T) {
+	tests := []struct {
+		part BodyPart
+		item string
+		key  string
+		rsp  string
+	}{
+		{BodyPart{}, "BODY[]", "BODY[]", "BODY[]"},
+		{BodyPart{Peek: true}, "BODY.PEEK[]", "BODY[]", "body[]"},
+		{BodyPart{Section: "1.2.mime"}, "BODY[1.2.mime]", "BODY[1.2.MIME]", "BODY[1.2.MIME]"},
+		{BodyPart{Partial: &[2]uint32{0, 8192}, Peek: true}, "BODY.PEEK[]<0.8192>", "BODY[]<0>", "BODY[]<0>"},
+		{BodyPart{Section: "TEXT", Partial: &[2]uint32{1024, 512}}, "BODY[TEXT]<1024.512>", "BODY[TEXT]<1024>", "BODY[TEXT]<1024>"},
+		{BodyPart{Section: "HEADER.FIELDS (Subject  From)", Peek: true},
+			"BODY.PEEK[HEADER.FIELDS (Subject  From)]", "BODY[HEADER.FIELDS (SUBJECT FROM)]", "BODY[HEADER.FIELDS (Subject From)]"},
+	}
+	c, s := newTestConn(1024)
+	C := newTransport(c, nil)
+	r := newReader(C, MemoryReader{}, "A")
+	for _, test := range tests {
+		if item := test.part.String(); item != test.item {
+			t.Errorf("%#v.String() expected %q; got %q", test.part, test.item, item)
+		}
+		if key := test.part.Key(); key != test.key {
+			t.Errorf("%#v.Key() expected %q; got %q", test.part, test.key, key)
+		}
+		C.clear()
+		s.Write([]byte("* 1 FETCH (" + test.rsp + " {5}" + CRLF + "Hello)" + CRLF))
+		raw, err := r.Next()
+		if err != nil {
+			t.Errorf("Next(%q) unexpected error; %v", test.rsp, err)
+			continue
+		}
+		rsp, err := raw.Parse()
+		if err != nil {
+			t.Errorf("Parse(%q) unexpected error; %v", test.rsp, err)
+		} else if v := AsString(rsp.MessageInfo().Attrs[test.part.Key()]); v != "Hello" {
+			t.Errorf("Attrs[%q] expected %q; got %q", test.part.Key(), "Hello", v)
+		}
+	}
+}
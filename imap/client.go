@@ -5,6 +5,7 @@
 package imap
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -13,6 +14,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -35,6 +37,70 @@ var ErrExclusive = errors.New("imap: exclusive client access violation")
 // for each command.
 var ErrNotAllowed = errors.New("imap: command not allowed in the current state")
 
+// ErrLoginDisabled is returned by Client.Login and Client.Auth when the server
+// advertises LOGINDISABLED capability and the connection is not encrypted.
+// Credentials are not sent to the server. Use Client.StartTLS to enable
+// encryption, or set Client.AllowCleartextLogin to override this check.
+var ErrLoginDisabled = errors.New("imap: cleartext login disabled by the server")
+
+// ErrServerBye matches (via errors.Is) the ByeError returned when the server
+// ends the session with an unsolicited BYE response.
+var ErrServerBye = errors.New("imap: server closed the session")
+
+// ErrGreetingBye matches (via errors.Is) the ByeError returned by NewClient
+// when the server refuses the connection with a BYE greeting (e.g. because it
+// is overloaded).
+var ErrGreetingBye = errors.New("imap: server refused the connection")
+
+// ErrCommandNo and ErrCommandBad match (via errors.Is) the ResponseError
+// returned by Command.Result when a command is completed with NO or BAD status.
+// NO means that the command was understood, but it could not be performed
+// (e.g. because the mailbox does not exist or the operation is not permitted
+// right now). BAD means that the server did not recognize the command or its
+// arguments, which usually indicates a bug or a missing server extension.
+var (
+	ErrCommandNo  = errors.New("imap: command failed")
+	ErrCommandBad = errors.New("imap: invalid command")
+)
+
+// ErrOverQuota matches (via errors.Is) the ResponseError returned when a command,
+// such as APPEND, COPY, or MOVE, fails because it would exceed one of the quota
+// limits (OVERQUOTA response code). See RFC 9208 section 4.3 for additional
+// information.
+var ErrOverQuota = errors.New("imap: quota exceeded")
+
+// ByeError is returned by the commands that were in progress when the server
+// sent an unsolicited BYE response (i.e. one that was not caused by the LOGOUT
+// command), and by all subsequent Send calls. Reason is the human-readable text
+// of the BYE response, which is also available in Client.ByeReason. NewClient
+// also returns a ByeError if the server greeting is BYE. In that case, the
+// error matches ErrGreetingBye instead of ErrServerBye.
+type ByeError struct {
+	Reason string
+
+	greeting bool // BYE greeting
+}
+
+func (err *ByeError) Error() string {
+	if err.greeting {
+		return "imap: server refused the connection (" + err.Reason + ")"
+	}
+	return "imap: server closed the session (" + err.Reason + ")"
+}
+
+// Is returns true if target is ErrServerBye, or ErrGreetingBye for a BYE
+// greeting.
+func (err *ByeError) Is(target error) bool {
+	if err.greeting {
+		return target == ErrGreetingBye
+	}
+	return target == ErrServerBye
+}
+
+// errContextDone is returned by Client.recv when the context installed by
+// setContext is done before a response is received.
+var errContextDone = errors.New("imap: context done")
+
 // NotAvailableError is returned when the requested command, feature, or
 // capability is not supported by the client and/or server. The error may be
 // temporary. For example, servers should disable the LOGIN command by
@@ -46,6 +112,18 @@ func (err NotAvailableError) Error() string {
 	return "imap: not available (" + string(err) + ")"
 }
 
+// UseAttrError is returned by Client.CreateSpecial when the server refuses to
+// create the mailbox because it cannot assign one of the requested special-use
+// attributes (USEATTR response code). The completion response is available for
+// inspection. See RFC 6154 section 3 for additional information.
+type UseAttrError struct {
+	*Response
+}
+
+func (err *UseAttrError) Error() string {
+	return "imap: special-use attribute rejected (" + err.Info + ")"
+}
+
 // response transports the output of Client.next through the rch channel.
 type response struct {
 	rsp *Response
@@ -58,13 +136,21 @@ type Client struct {
 	// greeting. Subsequent responses are those that were rejected by all active
 	// command filters. Commands documented as expecting "no specific responses"
 	// (e.g. NOOP) use nil filters by default, which reject all responses.
+	// Responses consumed by the update handler (see SetUpdateHandler) are not
+	// added to this queue.
 	Data []*Response
 
 	// Set of current server capabilities. It is updated automatically anytime
 	// new capabilities are received, which could be in a data response or a
-	// status response code.
+	// status response code. Capability names are stored in upper case (see
+	// HasCap and CapValues).
 	Caps map[string]bool
 
+	// Set of extensions enabled by the ENABLE command (RFC 5161). Only the
+	// extensions that the server confirmed in an ENABLED response are included,
+	// which may be a subset of those requested.
+	Enabled map[string]bool
+
 	// Status of the selected mailbox. It is set to nil unless the Client is in
 	// the Selected state. The fields are updated automatically as the server
 	// sends solicited and unsolicited status updates.
@@ -75,6 +161,27 @@ type Client struct {
 	// this map. The server may not support all commands known to the client.
 	CommandConfig map[string]*CommandConfig
 
+	// Permit sending passwords over an unencrypted connection with the LOGIN
+	// command and PLAIN authentication mechanism. This should only be enabled
+	// when the underlying network link is trusted (e.g. a local socket).
+	AllowCleartextLogin bool
+
+	// Policy for retrying commands that fail with a transient error, such as
+	// NO [INUSE]. Commands are not retried if this is nil (see RetryPolicy).
+	RetryPolicy *RetryPolicy
+
+	// Retry SEARCH and UID SEARCH commands that fail with a BADCHARSET response
+	// code using the first charset listed by the server that can represent the
+	// search criteria. Quoted strings and literals are transcoded from UTF-8
+	// (see CharsetWriter). If none of the listed charsets can be used,
+	// Command.Result returns an error naming the charsets that the server
+	// accepts.
+	SearchCharsetFallback bool
+
+	// Human-readable text of the last BYE response received from the server,
+	// which explains why the session is ending (e.g. "Server shutting down").
+	ByeReason string
+
 	// Server host name for authentication and STARTTLS commands.
 	host string
 
@@ -104,9 +211,40 @@ type Client struct {
 	t *transport
 	r *reader
 
+	// Handler for unilateral server data (see SetUpdateHandler).
+	handler func(rsp *Response) bool
+
+	// Optional limiter for outbound commands (see SetRateLimit).
+	limiter RateLimiter
+
+	// Done channel of the context passed to SendContext or ResultContext. It
+	// interrupts blocking receive operations when closed.
+	ctxDone <-chan struct{}
+
+	// Error returned by Send after an unsolicited BYE response.
+	bye *ByeError
+
 	// Protection against multiple close calls.
 	closer sync.Once
 
+	// Mutex that serializes Send and Recv calls with the keepalive goroutine,
+	// the time when the last of these calls returned, and the channel used to
+	// stop the keepalive goroutine.
+	mu     sync.Mutex
+	active time.Time
+	kaStop chan struct{}
+
+	// Atomic flags that allow Logout to be called concurrently with a blocked
+	// receive operation (see Logout). Keepalive NOOPs are not counted, so that
+	// Logout waits for them and still sends LOGOUT.
+	loggingOut int32 // Set to 1 by the first Logout call
+	receiving  int32 // Number of Recv and interact calls in progress
+
+	// Number of synchronous commands in progress that update the client state
+	// without holding c.mu. The keepalive goroutine does nothing while this is
+	// non-zero (see syncCmd).
+	syncing int32
+
 	// Debug message logging.
 	*debugLog
 }
@@ -115,31 +253,46 @@ type Client struct {
 // The function waits for the server to send a greeting message, and then
 // requests server capabilities if they weren't included in the greeting. An
 // error is returned if either operation fails or does not complete before the
-// timeout, which must be positive to have any effect. If an error is returned,
-// it is the caller's responsibility to close the connection.
+// timeout, which must be positive to have any effect. If the server refuses the
+// connection with a BYE greeting, a *ByeError matching ErrGreetingBye is
+// returned. If an error is returned, it is the caller's responsibility to close
+// the connection.
 func NewClient(conn net.Conn, host string, timeout time.Duration) (c *Client, err error) {
+	return NewClientSize(conn, host, timeout, BufferSize)
+}
+
+// NewClientSize is like NewClient, but uses send and receive buffers of the
+// specified size (in bytes) instead of the BufferSize default. A larger buffer
+// reduces the number of Read calls on conn when receiving bulk data, such as
+// FETCH responses containing large message bodies. The size also limits the
+// length of physical lines. A value <= 0 means use the default.
+func NewClientSize(conn net.Conn, host string, timeout time.Duration, size int) (c *Client, err error) {
+	if size <= 0 {
+		size = BufferSize
+	}
 	log := newDebugLog(DefaultLogger, DefaultLogMask)
 	cch := make(chan chan<- *response, 1)
 
 	c = &Client{
 		Caps:          make(map[string]bool),
+		Enabled:       make(map[string]bool),
 		CommandConfig: defaultCommands(),
 		host:          host,
 		state:         unknown,
 		tag:           *newTagGen(0),
 		cmds:          make(map[string]*Command),
-		t:             newTransport(conn, log),
+		t:             newTransportSize(conn, log, size),
 		debugLog:      log,
 	}
 	c.r = newReader(c.t, MemoryReader{}, string(c.tag.id))
 	c.Logf(LogConn, "Connected to %v (Tag=%s)", conn.RemoteAddr(), c.tag.id)
 
 	if err = c.greeting(timeout); err != nil {
-		c.Logln(LogConn, "Greeting error:", err)
+		c.warnln(LogConn, "Greeting error:", err)
 		return nil, err
 	}
 	c.cch = cch
-	go c.receiver(cch)
+	go c.receiver(cch, string(c.tag.id))
 	return
 }
 
@@ -151,36 +304,147 @@ func (c *Client) State() ConnState {
 	return c.state
 }
 
+// HasCap returns true if the server advertises the specified capability. The
+// name is case-insensitive and may include a value (e.g. "AUTH=PLAIN").
+func (c *Client) HasCap(name string) bool {
+	return c.Caps[toUpper(name)]
+}
+
+// CapValues returns a sorted list of values of all "name=value" capabilities
+// advertised by the server. For example, CapValues("THREAD") returns
+// ["ORDEREDSUBJECT", "REFERENCES"] if the server advertises THREAD=REFERENCES
+// and THREAD=ORDEREDSUBJECT capabilities. The name is case-insensitive.
+func (c *Client) CapValues(name string) []string {
+	return c.getCaps(toUpper(name) + "=")
+}
+
+// AuthMechs returns a sorted list of SASL authentication mechanisms advertised
+// by the server in AUTH=<mechanism> capabilities.
+func (c *Client) AuthMechs() []string {
+	return c.CapValues("AUTH")
+}
+
+// ThreadAlgorithms returns a sorted list of threading algorithms advertised by
+// the server in THREAD=<algorithm> capabilities (RFC 5256).
+func (c *Client) ThreadAlgorithms() []string {
+	return c.CapValues("THREAD")
+}
+
+// Stats contains aggregate statistics for a client connection. Byte counts
+// include all data exchanged with the server, such as the greeting and
+// unilateral server data, measured before compression and encryption.
+type Stats struct {
+	Commands  int64         // Number of completed commands
+	Elapsed   time.Duration // Total send-to-completion time of those commands
+	BytesSent int64         // Bytes sent to the server
+	BytesRecv int64         // Bytes received from the server
+}
+
+// Stats returns a snapshot of the connection statistics. Use cmd.Elapsed and
+// cmd.Bytes for per-command values. This method is safe to call from any
+// goroutine.
+func (c *Client) Stats() Stats {
+	t := c.t
+	sent, recv := t.Bytes()
+	return Stats{
+		Commands:  atomic.LoadInt64(&t.cmds),
+		Elapsed:   time.Duration(atomic.LoadInt64(&t.cmdTime)),
+		BytesSent: sent,
+		BytesRecv: recv,
+	}
+}
+
 // Send issues a new command, returning as soon as the last line is flushed from
 // the send buffer. This may involve waiting for continuation requests if
-// non-synchronizing literals (RFC 2088) are not supported by the server.
+// non-synchronizing literals (RFC 2088) are not supported by the server. If the
+// server advertises LITERAL+ capability, all literals are sent immediately
+// in the "{n+}" form, and any continuation requests that the server sends
+// despite this are ignored. With LITERAL- (RFC 7888), only literals of up to
+// 4096 octets are sent in the non-synchronizing form.
 //
 // This is the raw command interface that does not encode or perform any
 // validation of the supplied fields. It should only be used for implementing
 // new commands that do not change the connection state. For commands already
 // supported by this package, use the provided wrapper methods instead.
 func (c *Client) Send(name string, fields ...Field) (cmd *Command, err error) {
+	return c.sendInit(nil, name, fields...)
+}
+
+// sendInit implements Send. If init is not nil, it is called to configure the
+// new command before it is issued (e.g. to set the response filter options).
+func (c *Client) sendInit(init func(*Command), name string, fields ...Field) (cmd *Command, err error) {
+	if c.limiter != nil {
+		if err = c.throttle(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+	return c.lockedSend(init, name, fields...)
+}
+
+// lockedSend acquires c.mu and calls send.
+func (c *Client) lockedSend(init func(*Command), name string, fields ...Field) (cmd *Command, err error) {
+	c.mu.Lock()
+	defer c.unlock()
+	return c.send(init, name, fields...)
+}
+
+// send implements sendInit. The caller must hold c.mu.
+func (c *Client) send(init func(*Command), name string, fields ...Field) (cmd *Command, err error) {
 	if cmd = newCommand(c, name); cmd == nil {
 		return nil, NotAvailableError(name)
+	} else if init != nil {
+		init(cmd)
+	}
+	if (c.RetryPolicy != nil || c.SearchCharsetFallback && cmd.isSearch()) &&
+		replayable(fields) {
+		cmd.replay, cmd.fields = true, fields
+	}
+	if err = c.issue(cmd, fields); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// issue sends cmd to the server with a new tag. It is called once by send, and
+// again by retry for each new attempt. The caller must hold c.mu.
+func (c *Client) issue(cmd *Command, fields []Field) (err error) {
+	if c.bye != nil {
+		return c.bye
 	} else if cmd.config.States&c.state == 0 {
-		return nil, ErrNotAllowed
+		return ErrNotAllowed
 	} else if len(c.tags) > 0 {
 		other := c.cmds[c.tags[0]]
 		if cmd.config.Exclusive || other.config.Exclusive {
-			return nil, ErrExclusive
+			return ErrExclusive
 		}
 	}
 
 	// Build command
 	raw, err := cmd.build(c.tag.Next(), fields)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Write first line and update command state
-	c.Logln(LogCmd, ">>>", cmd)
+	if cmd.start.IsZero() {
+		cmd.start = time.Now()
+	}
+	cmd.elapsed = 0
+	sent, _ := c.t.Bytes()
+	defer func() {
+		n, _ := c.t.Bytes()
+		cmd.sent += n - sent
+	}()
+	if c.debugLog.mask&LogCmd != 0 {
+		text := cmd.raw
+		if LogRedact {
+			text = string(redactLine([]byte(text)))
+		}
+		c.logAttrs(levelDefault, LogCmd, fmt.Sprintln(">>>", text),
+			"dir", dirClient, "tag", cmd.tag)
+	}
 	if err = c.t.WriteLine(raw.ReadLine()); err != nil {
-		return nil, err
+		return err
 	}
 	c.tags = append(c.tags, cmd.tag)
 	c.cmds[cmd.tag] = cmd
@@ -188,7 +452,7 @@ func (c *Client) Send(name string, fields ...Field) (cmd *Command, err error) {
 	// Write remaining parts, flushing the transport buffer as needed
 	var rsp *Response
 	for i := 0; i < len(raw.literals) && err == nil; i++ {
-		if rsp, err = c.checkContinue(cmd, !raw.nonsync); err == nil {
+		if rsp, err = c.checkContinue(cmd, raw.sync[i]); err == nil {
 			if rsp == nil || rsp.Type == Continue {
 				if _, err = raw.literals[i].WriteTo(c.t); err == nil {
 					err = c.t.WriteLine(raw.ReadLine())
@@ -206,7 +470,57 @@ func (c *Client) Send(name string, fields ...Field) (cmd *Command, err error) {
 		}
 	}
 	c.done(cmd, abort)
-	return nil, err
+	return err
+}
+
+// SendContext is identical to Send, but the command is aborted if ctx is done
+// before the command and all of its literals are sent. The context deadline, if
+// any, is applied as the write deadline of the underlying connection. If the
+// command was only partially sent, the connection is closed because the client
+// and server are no longer synchronized. Use ResultContext or WaitContext to
+// receive the completion response.
+func (c *Client) SendContext(ctx context.Context, name string, fields ...Field) (cmd *Command, err error) {
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	} else if c.limiter != nil {
+		if err = c.throttle(ctx); err != nil {
+			return nil, err
+		}
+	}
+	defer c.setContext(ctx)()
+	if conn := c.t.conn; conn != nil {
+		if d, ok := ctx.Deadline(); ok {
+			conn.SetWriteDeadline(d)
+		}
+		stop, exit := make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(exit)
+			select {
+			case <-ctx.Done():
+				conn.SetWriteDeadline(time.Unix(1, 0)) // Interrupt pending writes
+			case <-stop:
+			}
+		}()
+		defer func() {
+			close(stop)
+			<-exit
+			conn.SetWriteDeadline(time.Time{})
+		}()
+	}
+	if cmd, err = c.lockedSend(nil, name, fields...); err != nil && ctx.Err() != nil {
+		if c.state != Closed {
+			c.close("context done during send")
+		}
+		err = ctx.Err()
+	}
+	return
+}
+
+// WaitContext is identical to Wait, but it returns ctx.Err() if ctx is done
+// before the command is completed (see Command.ResultContext).
+func (c *Client) WaitContext(ctx context.Context, cmd *Command) (*Command, error) {
+	_, err := cmd.ResultContext(ctx, OK)
+	return cmd, err
 }
 
 // Recv receives at most one response from the server, updates the client state,
@@ -219,17 +533,57 @@ func (c *Client) Send(name string, fields ...Field) (cmd *Command, err error) {
 // buffered responses, returning ErrTimeout immediately if none are available.
 // Otherwise, Recv blocks until a response is received or the timeout expires.
 func (c *Client) Recv(timeout time.Duration) error {
+	// Logout must see the receive in progress before it would block on c.mu
+	atomic.AddInt32(&c.receiving, 1)
+	defer atomic.AddInt32(&c.receiving, -1)
+	c.mu.Lock()
+	defer c.unlock()
+	return c.receive(timeout)
+}
+
+// receive implements Recv. The caller must hold c.mu.
+func (c *Client) receive(timeout time.Duration) error {
 	rsp, err := c.recv(timeout)
 	if err == nil && !c.deliver(rsp) {
 		if rsp.Type == Continue {
-			err = ResponseError{rsp, "unexpected continuation request"}
+			if !c.ignoreContinue(rsp) {
+				err = ResponseError{rsp, "unexpected continuation request"}
+			}
 		} else {
-			err = ResponseError{rsp, "undeliverable response"}
+			err = undeliverable(rsp)
 		}
 	}
 	return err
 }
 
+// SetTagPrefix changes the prefix of command tags, which is normally a random
+// string of upper case ASCII letters chosen by NewClient. Using a fixed prefix
+// per connection makes it easier to tell connections apart when their commands
+// are multiplexed in logs or server traces. The prefix must consist of 1 to 26
+// upper case ASCII letters. The tag counter is not reset, so a tag is never
+// reused during the lifetime of the connection. ErrNotAllowed is returned if
+// there are commands or receive operations in progress, because their
+// completion responses would not be recognized.
+func (c *Client) SetTagPrefix(prefix string) error {
+	if len(prefix) < 1 || 26 < len(prefix) {
+		return fmt.Errorf("imap: bad tag prefix %+q", prefix)
+	}
+	for i := 0; i < len(prefix); i++ {
+		if v := prefix[i]; v < 'A' || v > 'Z' {
+			return fmt.Errorf("imap: bad tag prefix %+q", prefix)
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.tags) > 0 || c.rch != nil {
+		return ErrNotAllowed
+	}
+	c.tag.id = append(make([]byte, 0, len(prefix)+20), prefix...)
+	c.r.tagid = []byte(prefix)
+	c.Logf(LogConn, "Tag prefix changed (Tag=%s)", prefix)
+	return nil
+}
+
 // SetLiteralReader installs a custom LiteralReader implementation into the
 // response receiver pipeline. It returns the previously installed LiteralReader
 // instance.
@@ -241,6 +595,149 @@ func (c *Client) SetLiteralReader(lr LiteralReader) LiteralReader {
 	return prev
 }
 
+// SetUpdateHandler installs a function that is called for each untagged
+// response that is not accepted by the filters of the active commands, such as
+// unsolicited EXISTS, EXPUNGE, VANISHED, and FETCH responses. This allows the
+// caller to keep a local model of the mailbox in sync as updates arrive. The
+// handler is called after the client state (e.g. c.Mailbox) is updated. If it
+// returns true, the response is considered consumed and is not appended to
+// c.Data. A nil handler removes the current one. The previously installed
+// handler is returned.
+//
+// With QRESYNC enabled, the server reports expunged messages by UID in VANISHED
+// responses instead of EXPUNGE. Use rsp.Vanished to distinguish these real-time
+// expunges from the historical VANISHED (EARLIER) form, which only describes
+// messages removed before the current session.
+//
+// The handler runs from within c.Recv (and therefore Wait and all synchronous
+// commands) on the goroutine that is receiving responses. It must not block or
+// call any Client methods that send commands or receive responses.
+func (c *Client) SetUpdateHandler(h func(rsp *Response) bool) func(rsp *Response) bool {
+	prev := c.handler
+	c.handler = h
+	return prev
+}
+
+// RateLimiter is the interface for limiting the rate at which commands are
+// sent. Wait must block until another command may be sent, or return an error
+// if ctx is done first. It is implemented by *rate.Limiter from the
+// golang.org/x/time/rate package, which does not need to be imported by
+// clients that do not use rate limiting.
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+// SetRateLimit installs l to limit the rate at which Send and SendContext issue
+// commands. For example, rate.NewLimiter(r, burst) allows at most r commands
+// per second, with bursts of up to burst commands. Once the limit is reached,
+// Send blocks until the next command is allowed. SendContext returns an error
+// instead if ctx is done first, or if its deadline would expire before the
+// command may be sent. A nil limiter disables rate limiting. Commands sent
+// internally by the client, such as keepalive NOOPs and retries, are not
+// limited.
+//
+// This method must not be called concurrently with Send or SendContext.
+func (c *Client) SetRateLimit(l RateLimiter) {
+	c.limiter = l
+}
+
+// throttle blocks until the rate limiter allows another command to be sent or
+// ctx is done. It must not be called while holding c.mu, which would prevent
+// other goroutines from receiving responses in the meantime.
+func (c *Client) throttle(ctx context.Context) error {
+	if err := c.limiter.Wait(ctx); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("imap: %v", err)
+	}
+	return nil
+}
+
+// StartKeepAlive starts a goroutine that sends the NOOP command whenever the
+// connection has been idle for the specified interval. This prevents the server
+// (or a NAT device) from dropping connections that are not used for several
+// minutes. The connection is considered idle when there are no commands in
+// progress and neither Send nor Recv have been called during the interval.
+// NOOP is never sent while another goroutine is inside Send or Recv, or while a
+// synchronous command that changes the connection state (e.g. Select, Login, or
+// StartTLS) is in progress. The goroutine stops automatically when the
+// connection is closed. Calling StartKeepAlive again replaces the previous
+// interval.
+//
+// The responses received during a keepalive NOOP are processed normally. The
+// update handler is called and c.Data, c.Mailbox, and other fields are updated
+// from the keepalive goroutine. The caller is responsible for synchronizing its
+// own access to this state (e.g. by using an update handler that passes the
+// responses to another goroutine via a channel).
+func (c *Client) StartKeepAlive(interval time.Duration) {
+	c.StopKeepAlive()
+	if interval > 0 {
+		c.kaStop = make(chan struct{})
+		go c.keepAlive(interval, c.kaStop, string(c.tag.id))
+	}
+}
+
+// StopKeepAlive stops the goroutine started by StartKeepAlive. If a keepalive
+// NOOP is in progress, StopKeepAlive waits for it to be completed.
+func (c *Client) StopKeepAlive() {
+	if c.kaStop != nil {
+		close(c.kaStop)
+		c.kaStop = nil
+		c.mu.Lock()
+		c.mu.Unlock()
+	}
+}
+
+// SendAuto is identical to Send, but all string and []byte fields, including
+// those in nested []Field lists, are encoded automatically. Each value is sent
+// as an atom if possible, as a quoted string if it contains spaces or other
+// special characters, or as a literal if it contains CR, LF, NUL, or non-ASCII
+// characters. Literals are sent using the normal continuation request process
+// (or the non-synchronizing form, if supported by the server). This means that
+// string fields may not contain pre-encoded syntax, such as quoted strings or
+// parenthesized lists.
+func (c *Client) SendAuto(name string, fields ...Field) (cmd *Command, err error) {
+	return c.Send(name, c.encodeFields(fields)...)
+}
+
+// encodeFields returns a copy of fields with all strings and byte slices
+// converted to atoms, quoted strings, or literals.
+func (c *Client) encodeFields(fields []Field) []Field {
+	enc := make([]Field, len(fields))
+	for i, f := range fields {
+		switch v := f.(type) {
+		case string:
+			if enc[i] = v; !isAtom(v) {
+				enc[i] = c.Quote(v)
+			}
+		case []byte:
+			if enc[i] = string(v); !isAtom(string(v)) {
+				enc[i] = c.Quote(v)
+			}
+		case []Field:
+			enc[i] = c.encodeFields(v)
+		default:
+			enc[i] = f
+		}
+	}
+	return enc
+}
+
+// isAtom returns true if s can be sent as an atom. "NIL" is excluded because
+// it would be interpreted as a nil value in nstring contexts.
+func isAtom(s string) bool {
+	if s == "" || (len(s) == 3 && toUpper(s) == "NIL") {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= char || atomSpecials[c] {
+			return false
+		}
+	}
+	return true
+}
+
 // Quote attempts to represent v, which must be string, []byte, or fmt.Stringer,
 // as a quoted string for use with Client.Send. A literal string representation
 // is used if v cannot be quoted.
@@ -265,11 +762,29 @@ func (c *Client) Quote(v interface{}) Field {
 	return NewLiteral(b)
 }
 
+// quoteMailbox encodes a mailbox name or pattern in modified UTF-7 and returns
+// it as a quoted string or a literal. The name is sent as UTF-8 if UTF8=ACCEPT
+// is enabled (RFC 6855).
+func (c *Client) quoteMailbox(name string) Field {
+	if c.Enabled["UTF8=ACCEPT"] {
+		return c.Quote(name) // Non-ASCII names are sent as literals
+	}
+	return c.Quote(EncodeMailbox(name))
+}
+
 // next returns the next server response obtained directly from the reader.
 func (c *Client) next() (rsp *Response, err error) {
+	_, recv := c.t.Bytes()
 	raw, err := c.r.Next()
 	if err == nil {
-		rsp, err = raw.Parse()
+		if rsp, err = raw.Parse(); rsp != nil {
+			rsp.utf8 = c.Enabled["UTF8=ACCEPT"]
+			_, rsp.size = c.t.Bytes()
+			rsp.size -= recv
+		}
+	}
+	if err == io.EOF && (raw != nil || rsp != nil) {
+		err = io.ErrUnexpectedEOF // Stream ended in the middle of a response
 	}
 	return
 }
@@ -306,7 +821,7 @@ func (c *Client) greeting(timeout time.Duration) (err error) {
 		c.setState(Auth)
 	case BYE:
 		c.setState(Logout)
-		fallthrough
+		return &ByeError{Reason: rsp.Info, greeting: true}
 	default:
 		return ResponseError{rsp, "invalid greeting status"}
 	}
@@ -320,32 +835,96 @@ func (c *Client) greeting(timeout time.Duration) (err error) {
 }
 
 // receiver runs in a separate goroutine, reading a single server response for
-// each request sent on the cch channel.
-func (c *Client) receiver(cch <-chan chan<- *response) {
+// each request sent on the cch channel. The tag prefix is only used for logging;
+// c.tag.id may be changed by SetTagPrefix while the receiver is running.
+func (c *Client) receiver(cch <-chan chan<- *response, tag string) {
 	recv := func() (r *response) {
 		defer func() {
 			if err := recover(); err != nil {
 				r = &response{nil, fmt.Errorf("imap: receiver panic: %v", err)}
-				c.Logf(LogGo, "Receiver panic (Tag=%s): %v\n%s", c.tag.id, err, debug.Stack())
+				c.warnf(LogGo, "Receiver panic (Tag=%s): %v\n%s", tag, err, debug.Stack())
 			}
 		}()
 		rsp, err := c.next()
 		return &response{rsp, err}
 	}
 
-	c.Logf(LogGo, "Receiver started (Tag=%s)", c.tag.id)
-	defer c.Logf(LogGo, "Receiver finished (Tag=%s)", c.tag.id)
+	c.Logf(LogGo, "Receiver started (Tag=%s)", tag)
+	defer c.Logf(LogGo, "Receiver finished (Tag=%s)", tag)
 
 	for rch := range cch {
 		rch <- recv()
 	}
 }
 
+// keepAlive sends the NOOP command after each interval of inactivity until the
+// stop channel is closed or the connection is closed.
+func (c *Client) keepAlive(interval time.Duration, stop <-chan struct{}, tag string) {
+	c.Logf(LogGo, "Keepalive started (Tag=%s, Interval=%v)", tag, interval)
+	defer c.Logf(LogGo, "Keepalive finished (Tag=%s)", tag)
+
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C:
+		}
+		c.mu.Lock()
+		select {
+		case <-stop:
+			c.mu.Unlock()
+			return
+		default:
+		}
+		if atomic.LoadInt32(&c.syncing) > 0 {
+			c.mu.Unlock()
+			timer.Reset(interval)
+			continue
+		} else if c.state == Closed {
+			c.mu.Unlock()
+			return
+		}
+		wait := interval - time.Since(c.active)
+		if wait <= 0 {
+			if len(c.tags) == 0 && c.state&(Login|Auth|Selected) != 0 {
+				cmd, err := c.send(nil, "NOOP")
+				for err == nil && cmd.InProgress() {
+					err = c.receive(netTimeout)
+				}
+				if err != nil {
+					c.warnln(LogConn, "Keepalive NOOP failed:", err)
+				}
+			}
+			c.active = time.Now()
+			wait = interval
+		}
+		c.mu.Unlock()
+		timer.Reset(wait)
+	}
+}
+
+// syncCmd marks the start of a synchronous command that changes the client
+// state (e.g. c.state, c.Mailbox, or the transport) outside of c.mu, and returns
+// the function that marks its end. This keeps the keepalive goroutine from
+// accessing the state concurrently or sending NOOP in the middle of the change.
+func (c *Client) syncCmd() func() {
+	atomic.AddInt32(&c.syncing, 1)
+	return func() { atomic.AddInt32(&c.syncing, -1) }
+}
+
+// unlock records the time when Send or Recv returned and releases c.mu.
+func (c *Client) unlock() {
+	c.active = time.Now()
+	c.mu.Unlock()
+}
+
 // recv returns the next server response, updating the client state beforehand.
 func (c *Client) recv(timeout time.Duration) (rsp *Response, err error) {
 	if c.state == Closed {
 		return nil, io.EOF
-	} else if c.rch == nil && (timeout < 0 || c.cch == nil) {
+	} else if c.rch == nil && ((timeout < 0 && c.ctxDone == nil) || c.cch == nil) {
 		rsp, err = c.next()
 	} else {
 		if c.rch == nil {
@@ -355,7 +934,11 @@ func (c *Client) recv(timeout time.Duration) (rsp *Response, err error) {
 		}
 		var r *response
 		if timeout < 0 {
-			r = <-c.rch
+			select {
+			case r = <-c.rch:
+			case <-c.ctxDone:
+				return nil, errContextDone // Response remains pending in c.rch
+			}
 		} else {
 			select {
 			case r = <-c.rch:
@@ -375,7 +958,9 @@ func (c *Client) recv(timeout time.Duration) (rsp *Response, err error) {
 	}
 	if err == nil {
 		c.update(rsp)
-	} else if rsp == nil {
+	} else if _, ok := err.(*ParserError); rsp == nil || !ok {
+		// Parser errors are recoverable, anything else means that the client
+		// and server are no longer synchronized.
 		defer c.setState(Closed)
 		if err != io.EOF {
 			c.close("protocol error")
@@ -391,6 +976,14 @@ func (c *Client) update(rsp *Response) {
 	if rsp.Label == "CAPABILITY" {
 		c.setCaps(rsp.Fields[1:])
 		return
+	} else if rsp.Label == "ENABLED" && rsp.Type == Data {
+		for _, f := range rsp.Fields[1:] {
+			if v := toUpper(AsAtom(f)); v != "" {
+				c.Enabled[v] = true
+				c.Logln(LogState, "Enabled:", v)
+			}
+		}
+		return
 	}
 	switch rsp.Type {
 	case Data:
@@ -412,6 +1005,17 @@ func (c *Client) update(rsp *Response) {
 			if c.Mailbox.Unseen == rsp.Value() {
 				c.Mailbox.Unseen = 0
 			}
+		case "VANISHED":
+			if uids, earlier := rsp.Vanished(); uids != nil && !earlier {
+				if n, _ := uids.Count(); n < c.Mailbox.Messages {
+					c.Mailbox.Messages -= n
+				} else {
+					c.Mailbox.Messages = 0
+				}
+				if c.Mailbox.Recent > c.Mailbox.Messages {
+					c.Mailbox.Recent = c.Mailbox.Messages
+				}
+			}
 		}
 	case Status:
 		switch rsp.Status {
@@ -425,14 +1029,26 @@ func (c *Client) update(rsp *Response) {
 			c.deliver(abort)
 		case BYE:
 			c.Logln(LogConn, "Logout reason:", rsp.Info)
+			c.ByeReason = rsp.Info
+			if c.state != Logout && atomic.LoadInt32(&c.loggingOut) == 0 {
+				c.bye = &ByeError{Reason: rsp.Info}
+				for _, tag := range c.tags {
+					c.done(c.cmds[tag], rsp)
+				}
+			}
 			c.setState(Logout)
 		}
 		fallthrough
 	case Done:
-		if rsp.Label == "ALERT" {
-			c.Logln(LogConn, "ALERT!", rsp.Info)
+		switch rsp.Label {
+		case "ALERT":
+			c.warnln(LogConn, "ALERT!", rsp.Info)
+			return
+		case "PARSE":
+			c.warnln(LogConn, "Message parse error:", rsp.Info)
 			return
-		} else if c.Mailbox == nil {
+		}
+		if c.Mailbox == nil {
 			return
 		}
 		switch selected := (c.state == Selected); rsp.Label {
@@ -460,6 +1076,16 @@ func (c *Client) update(rsp *Response) {
 			c.Mailbox.Unseen = rsp.Value()
 		case "UIDNOTSTICKY":
 			c.Mailbox.UIDNotSticky = true
+		case "HIGHESTMODSEQ":
+			if len(rsp.Fields) > 1 {
+				c.Mailbox.HighestModSeq = AsNumber64(rsp.Fields[1])
+			}
+		case "NOMODSEQ":
+			c.Mailbox.NoModSeq = true
+		case "MAILBOXID":
+			if len(rsp.Fields) > 1 {
+				c.Mailbox.MailboxID = objectID(rsp.Fields[1])
+			}
 		}
 	}
 }
@@ -472,10 +1098,16 @@ func (c *Client) deliver(rsp *Response) bool {
 		for _, tag := range c.tags {
 			cmd := c.cmds[tag]
 			if filter := cmd.config.Filter; filter != nil && filter(cmd, rsp) {
-				cmd.Data = append(cmd.Data, rsp)
+				if !cmd.merge(rsp) {
+					cmd.Data = append(cmd.Data, rsp)
+				}
+				cmd.recv += rsp.size
 				return true
 			}
 		}
+		if c.handler != nil && rsp.Tag == "*" && c.handler(rsp) {
+			return true
+		}
 		c.Data = append(c.Data, rsp)
 		return true
 	} else if rsp.Type == Done {
@@ -483,7 +1115,8 @@ func (c *Client) deliver(rsp *Response) bool {
 			c.done(cmd, rsp)
 			return true
 		}
-		c.Logln(LogCmd, "<<<", rsp.Tag, "(Unknown)")
+		c.logAttrs(levelWarn, LogCmd, fmt.Sprintln("<<<", rsp.Tag, "(Unknown)"),
+			"dir", dirServer, "tag", rsp.Tag)
 	} else if rsp == abort {
 		for _, tag := range c.tags {
 			c.done(c.cmds[tag], abort)
@@ -496,11 +1129,18 @@ func (c *Client) deliver(rsp *Response) bool {
 // done completes command execution by setting cmd.result to rsp and updating
 // the client's command state.
 func (c *Client) done(cmd *Command, rsp *Response) {
-	if cmd.result != nil {
+	if cmd.result != nil && c.cmds[cmd.tag] != cmd {
 		return
+	} else if cmd.result == nil {
+		cmd.result = rsp
 	}
-	cmd.result = rsp
 	if tag := cmd.tag; c.cmds[tag] != nil {
+		if rsp.Tag == tag {
+			cmd.recv += rsp.size
+		}
+		cmd.elapsed = time.Since(cmd.start)
+		atomic.AddInt64(&c.t.cmds, 1)
+		atomic.AddInt64(&c.t.cmdTime, int64(cmd.elapsed))
 		delete(c.cmds, tag)
 		if c.tags[0] == tag {
 			c.tags = c.tags[1:]
@@ -516,12 +1156,33 @@ func (c *Client) done(cmd *Command, rsp *Response) {
 		}
 	}
 	if rsp == abort {
-		c.Logln(LogCmd, "<<<", cmd.tag, "(Abort)")
-	} else {
-		c.Logln(LogCmd, "<<<", rsp)
+		c.logAttrs(levelWarn, LogCmd, fmt.Sprintln("<<<", cmd.tag, "(Abort)"),
+			"dir", dirServer, "tag", cmd.tag)
+	} else if c.debugLog.mask&LogCmd != 0 {
+		c.logAttrs(levelDefault, LogCmd, fmt.Sprintln("<<<", rsp),
+			"dir", dirServer, "tag", cmd.tag)
 	}
 }
 
+// cancel marks cmd as aborted without waiting for its completion response. The
+// server continues processing the command, so cmd remains in c.cmds until the
+// completion response is received and discarded by c.done.
+func (c *Client) cancel(cmd *Command) {
+	if cmd.result == nil {
+		cmd.result = abort
+		c.logAttrs(levelDefault, LogCmd, fmt.Sprintln("<<<", cmd.tag, "(Canceled)"),
+			"dir", dirServer, "tag", cmd.tag)
+	}
+}
+
+// setContext installs the done channel of ctx for interrupting blocking receive
+// operations. It returns a function that restores the previous channel.
+func (c *Client) setContext(ctx context.Context) func() {
+	prev := c.ctxDone
+	c.ctxDone = ctx.Done()
+	return func() { c.ctxDone = prev }
+}
+
 // checkContinue returns the next continuation request or completion result of
 // cmd. In synchronous mode (sync == true), it flushes the buffer and blocks
 // until a continuation request or cmd completion response is received. In
@@ -544,11 +1205,15 @@ func (c *Client) checkContinue(cmd *Command, sync bool) (rsp *Response, err erro
 			return
 		} else if !c.deliver(rsp) {
 			if rsp.Type == Continue {
-				if !sync {
-					err = ResponseError{rsp, "unexpected continuation request"}
+				cmd.recv += rsp.size
+				if sync {
+					return
+				} else if c.ignoreContinue(rsp) {
+					continue
 				}
+				err = ResponseError{rsp, "unexpected continuation request"}
 			} else {
-				err = ResponseError{rsp, "undeliverable response"}
+				err = undeliverable(rsp)
 			}
 			return
 		}
@@ -556,6 +1221,58 @@ func (c *Client) checkContinue(cmd *Command, sync bool) (rsp *Response, err erro
 	return cmd.Result(0)
 }
 
+// interact calls cont for each continuation request received for cmd and sends
+// the returned lines to the server until cmd is completed. If cont returns an
+// error, the command is cancelled with a "*" line and the error is returned as
+// abort without waiting for cmd completion.
+func (c *Client) interact(cmd *Command, cont ContinueFunc) (abort, err error) {
+	atomic.AddInt32(&c.receiving, 1)
+	defer atomic.AddInt32(&c.receiving, -1)
+	var rsp *Response
+	sent, _ := c.t.Bytes()
+	defer func() {
+		n, _ := c.t.Bytes()
+		cmd.sent += n - sent
+	}()
+	for err == nil && cmd.InProgress() {
+		if rsp, err = c.checkContinue(cmd, true); err == nil && rsp.Type == Continue {
+			var line []byte
+			if line, abort = cont(rsp); abort != nil {
+				if err = c.t.WriteLine([]byte("*")); err == nil {
+					err = c.t.Flush()
+				}
+				return
+			}
+			err = c.t.WriteLine(line)
+		}
+	}
+	return
+}
+
+// undeliverable returns the error for a response that was not accepted by
+// c.deliver. A command completion response with a tag that does not belong to
+// any command in progress indicates a server bug, and is reported with a
+// distinct Reason ("unknown command tag").
+func undeliverable(rsp *Response) error {
+	if rsp.Type == Done {
+		return ResponseError{rsp, "unknown command tag"}
+	}
+	return ResponseError{rsp, "undeliverable response"}
+}
+
+// ignoreContinue returns true if rsp is a continuation request that should be
+// ignored. RFC 2088 does not allow the server to send continuation requests
+// for non-synchronizing literals, but some servers do so anyway. Since such
+// requests carry no information, they are dropped to keep the client and
+// server synchronized.
+func (c *Client) ignoreContinue(rsp *Response) bool {
+	if c.Caps["LITERAL+"] || c.Caps["LITERAL-"] {
+		c.Logln(LogCmd, "Ignoring continuation request:", rsp)
+		return true
+	}
+	return false
+}
+
 // setState changes connection state and performs the associated client updates.
 // If the new state is Selected, it is assumed that c.Mailbox is already set.
 func (c *Client) setState(s ConnState) {
@@ -604,6 +1321,16 @@ func (c *Client) setCaps(caps []Field) {
 	}
 }
 
+// checkCleartext returns ErrLoginDisabled if the server advertises
+// LOGINDISABLED capability, the connection is not encrypted, and the user did
+// not explicitly allow cleartext passwords.
+func (c *Client) checkCleartext() error {
+	if c.Caps["LOGINDISABLED"] && !c.t.Encrypted() && !c.AllowCleartextLogin {
+		return ErrLoginDisabled
+	}
+	return nil
+}
+
 // getCaps returns a sorted list of capabilities that share a common prefix. The
 // prefix is stripped from the returned strings.
 func (c *Client) getCaps(prefix string) []string {
@@ -631,7 +1358,7 @@ func (c *Client) close(reason string) (err error) {
 			c.Logln(LogConn, "Close reason:", reason)
 		}
 		if err = c.t.Close(false); err != nil {
-			c.Logln(LogConn, "Close error:", err)
+			c.warnln(LogConn, "Close error:", err)
 		}
 	})
 	return
@@ -5,12 +5,18 @@
 package imap
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/textproto"
 	"reflect"
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -89,7 +95,7 @@ func newClient(T *testing.T, script ...string) (*Client, *clientT) {
 				t.Fatalf("%s NewClient() expected timeout; got %#v (%v)", caller(), t.C, err)
 			}
 		} else if strings.HasPrefix(script[0], "S: * BYE") {
-			if t.C != nil || err == nil {
+			if t.C != nil || !errors.Is(err, ErrGreetingBye) {
 				t.Fatalf("%s NewClient() expected error; got %#v (%v)", caller(), t.C, err)
 			}
 		}
@@ -269,6 +275,190 @@ func TestClientBasic(T *testing.T) {
 	if rsp, err := cmd.Result(OK); err != nil || rsp.Info != "Quoth the raven, nevermore..." {
 		t.Errorf("cmd.Result() expected OK; got %+q (%v)", rsp, err)
 	}
+	if cmd, err = C.Logout(-1); cmd != nil || err != nil {
+		t.Errorf("C.Logout() expected no-op; got %v (%v)", cmd, err)
+	}
+}
+
+func TestClientLogoutTimeout(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	// Server never completes the command
+	go t.script(`C: A1 LOGOUT` + CRLF)
+	_, err := C.Logout(testConnTimeout)
+	t.join("LOGOUT", nil)
+	if err != ErrTimeout {
+		t.Fatalf("C.Logout() expected ErrTimeout; got %v", err)
+	}
+	t.checkState(Closed)
+}
+
+func TestClientLogoutConcurrent(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	done := make(chan error, 1)
+	go func() { done <- C.Recv(block) }()
+	time.Sleep(testConnTimeout / 5)
+	if _, err := C.Logout(-1); err != nil {
+		t.Fatalf("C.Logout() expected no error; got %v", err)
+	}
+	if err := <-done; err == nil {
+		t.Fatalf("C.Recv() expected an error")
+	}
+	t.checkState(Closed)
+	if cmd, err := C.Logout(-1); cmd != nil || err != nil {
+		t.Errorf("C.Logout() expected no-op; got %v (%v)", cmd, err)
+	}
+
+	// LOGOUT is sent after a keepalive NOOP that is in progress
+	C, t = newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+	go t.script(`C: A1 NOOP` + CRLF)
+	C.StartKeepAlive(testConnTimeout / 4)
+	t.join("NOOP", nil)
+	logout := make(chan error, 1)
+	go func() {
+		_, err := C.Logout(-1)
+		logout <- err
+	}()
+	time.Sleep(testConnTimeout / 5)
+	go t.script(
+		`S: A1 OK NOOP completed`+CRLF,
+		`C: A2 LOGOUT`+CRLF,
+		`S: * BYE LOGOUT Requested`+CRLF,
+		`S: A2 OK Quit`+CRLF,
+		EOF,
+	)
+	t.join("LOGOUT", <-logout)
+	t.waitEOF()
+
+	// Recv that has not acquired c.mu yet
+	C, t = newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+	C.mu.Lock()
+	go func() { done <- C.Recv(block) }()
+	time.Sleep(testConnTimeout / 5)
+	go func() {
+		_, err := C.Logout(-1)
+		logout <- err
+	}()
+	select {
+	case err := <-logout:
+		if err != nil {
+			t.Errorf("C.Logout() expected no error; got %v", err)
+		}
+	case <-time.After(testConnTimeout):
+		t.Errorf("C.Logout() blocked by pending C.Recv()")
+	}
+	C.mu.Unlock()
+	if err := <-done; err == nil {
+		t.Fatalf("C.Recv() expected an error")
+	}
+}
+
+func TestClientBye(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	// In-flight command fails when the server ends the session
+	go t.script(
+		`C: A1 SELECT "INBOX"`+CRLF,
+		`S: * 172 EXISTS`+CRLF,
+		`S: * BYE Server shutting down`+CRLF,
+		EOF,
+	)
+	cmd, err := C.Select("INBOX", false)
+	t.join("SELECT", nil)
+	if _, ok := err.(*ByeError); !ok || !errors.Is(err, ErrServerBye) {
+		t.Fatalf("C.Select() expected ErrServerBye; got %v", err)
+	} else if err.Error() != "imap: server closed the session (Server shutting down)" {
+		t.Errorf("err.Error() got %q", err)
+	}
+	if rsp, _ := cmd.Result(0); rsp == nil || rsp.Status != BYE {
+		t.Errorf("cmd.Result() expected BYE; got %v", rsp)
+	}
+	if C.ByeReason != "Server shutting down" {
+		t.Errorf("C.ByeReason expected %q; got %q", "Server shutting down", C.ByeReason)
+	}
+	t.checkState(Logout)
+
+	// Subsequent commands return the same error
+	if _, err := C.Noop(); !errors.Is(err, ErrServerBye) {
+		t.Errorf("C.Noop() expected ErrServerBye; got %v", err)
+	}
+	if err := C.Recv(block); err != io.EOF {
+		t.Errorf("C.Recv() expected EOF; got %v", err)
+	}
+	t.checkState(Closed)
+	if _, err := C.Noop(); !errors.Is(err, ErrServerBye) {
+		t.Errorf("C.Noop() expected ErrServerBye; got %v", err)
+	}
+	if _, err := C.Logout(-1); err != nil {
+		t.Errorf("C.Logout() expected no error; got %v", err)
+	}
+
+	// BYE in response to LOGOUT is not an error
+	C, t = newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+	go t.script(
+		`C: A1 LOGOUT`+CRLF,
+		`S: * BYE LOGOUT Requested`+CRLF,
+		`S: A1 OK Quit completed`+CRLF,
+		EOF,
+	)
+	_, err = C.Logout(-1)
+	t.join("LOGOUT", err)
+	t.waitEOF()
+	if C.ByeReason != "LOGOUT Requested" || C.bye != nil {
+		t.Errorf("C.ByeReason expected %q; got %q (%v)", "LOGOUT Requested", C.ByeReason, C.bye)
+	}
+}
+
+func TestClientKeepAlive(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	// NOOP is sent after the connection becomes idle
+	go t.script(
+		`C: A1 NOOP`+CRLF,
+		`S: * 3 EXISTS`+CRLF,
+		`S: A1 OK NOOP completed`+CRLF,
+	)
+	C.StartKeepAlive(testConnTimeout / 4)
+	t.join("NOOP", nil)
+	C.StopKeepAlive()
+	if n := len(C.Data); n != 2 || C.Data[1].Label != "EXISTS" {
+		t.Fatalf("C.Data expected EXISTS; got %v", C.Data)
+	}
+
+	// Keepalive waits for a synchronous command to finish updating the client
+	// state (run with -race)
+	go t.script(`C: A2 SELECT "INBOX"` + CRLF)
+	done := make(chan error, 1)
+	go func() {
+		_, err := C.Select("INBOX", false)
+		done <- err
+	}()
+	t.join("SELECT", nil)
+	C.StartKeepAlive(testConnTimeout / 4)
+	time.Sleep(testConnTimeout / 2)
+	go t.script(
+		`S: * 1 EXISTS`+CRLF,
+		`S: A2 OK [READ-WRITE] Ok`+CRLF,
+		`C: A3 NOOP`+CRLF,
+		`S: A3 OK NOOP completed`+CRLF,
+	)
+	if err := <-done; err != nil {
+		t.Fatalf("C.Select() unexpected error; %v", err)
+	}
+	t.join("NOOP", nil)
+	C.StopKeepAlive()
+	t.checkState(Selected)
+
+	// Nothing is sent after StopKeepAlive
+	time.Sleep(testConnTimeout / 2)
+	go t.script(EOF)
+	t.join("EOF", nil)
+	t.waitEOF()
 }
 
 func TestClientLogin(T *testing.T) {
@@ -278,8 +468,8 @@ func TestClientLogin(T *testing.T) {
 
 	// LOGIN should fail when LOGINDISABLED is advertised
 	cmd, err := C.Login("user", "pass")
-	if cmd != nil || err == nil {
-		t.Fatalf("C.Login() expected error; got %#v (%v)", cmd, err)
+	if cmd != nil || err != ErrLoginDisabled {
+		t.Fatalf("C.Login() expected ErrLoginDisabled; got %#v (%v)", cmd, err)
 	}
 
 	// STARTTLS
@@ -317,6 +507,163 @@ func TestClientLogin(T *testing.T) {
 	t.waitEOF()
 }
 
+func TestClientCommandStatus(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * OK [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	// OK
+	go t.script(
+		`C: A1 NOOP`+CRLF,
+		`S: A1 OK NOOP completed`+CRLF,
+	)
+	cmd, err := C.Noop()
+	if err == nil && (cmd.Completion() != nil || cmd.Status() != 0 || cmd.Info() != "") {
+		t.Errorf("cmd expected to be in progress; got %v %v %q", cmd.Completion(), cmd.Status(), cmd.Info())
+	}
+	_, err = Wait(cmd, err)
+	t.join("NOOP", err)
+	if rsp := cmd.Completion(); rsp == nil || rsp.Tag != "A1" || cmd.Status() != OK || cmd.Info() != "NOOP completed" {
+		t.Errorf("cmd expected A1 OK; got %v %v %q", rsp, cmd.Status(), cmd.Info())
+	}
+
+	// NO
+	go t.script(
+		`C: A2 LOGIN "user" "pass"`+CRLF,
+		`S: A2 NO [AUTHENTICATIONFAILED] Invalid credentials`+CRLF,
+	)
+	cmd, err = C.Login("user", "pass")
+	if !errors.Is(err, ErrCommandNo) || errors.Is(err, ErrCommandBad) {
+		t.Errorf("C.Login() expected ErrCommandNo; got %v", err)
+	}
+	t.join("LOGIN", nil)
+	if cmd.Status() != NO || cmd.Info() != "Invalid credentials" {
+		t.Errorf("cmd expected NO; got %v %q", cmd.Status(), cmd.Info())
+	}
+
+	// BAD
+	go t.script(
+		`C: A3 XYZZY`+CRLF,
+		`S: A3 BAD Unknown command`+CRLF,
+		EOF,
+	)
+	cmd, err = Wait(C.Send("XYZZY"))
+	if !errors.Is(err, ErrCommandBad) || errors.Is(err, ErrCommandNo) {
+		t.Errorf("C.Send() expected ErrCommandBad; got %v", err)
+	}
+	t.join("XYZZY", nil)
+	t.waitEOF()
+	if cmd.Status() != BAD || cmd.Info() != "Unknown command" {
+		t.Errorf("cmd expected BAD; got %v %q", cmd.Status(), cmd.Info())
+	}
+}
+
+func TestClientPinCert(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * OK [CAPABILITY IMAP4rev1 STARTTLS] Test server ready`+CRLF)
+	der := tlsConfig.server.Certificates[0].Certificate[0]
+
+	// Pin mismatch
+	bad := append([]byte(nil), der...)
+	bad[len(bad)-1] ^= 1
+	if err := PinCert(bad).VerifyPeerCertificate([][]byte{der}, nil); err == nil {
+		t.Errorf("PinCert() expected verification error")
+	}
+
+	// STARTTLS with the correct pin
+	go t.script(
+		`C: A1 STARTTLS`+CRLF,
+		`S: A1 OK Begin TLS negotiation now`+CRLF,
+		STARTTLS,
+		`C: A2 CAPABILITY`+CRLF,
+		`S: * CAPABILITY IMAP4rev1`+CRLF,
+		`S: A2 OK Thats all she wrote!`+CRLF,
+		EOF,
+	)
+	_, err := C.StartTLS(PinCert(der))
+	t.join("STARTTLS", err)
+	t.checkCaps("IMAP4rev1")
+	t.waitEOF()
+}
+
+func TestClientAutoStartTLS(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * OK [CAPABILITY IMAP4rev1 STARTTLS] Test server ready`+CRLF)
+
+	// STARTTLS advertised
+	go t.script(
+		`C: A1 STARTTLS`+CRLF,
+		`S: A1 OK Begin TLS negotiation now`+CRLF,
+		STARTTLS,
+		`C: A2 CAPABILITY`+CRLF,
+		`S: * CAPABILITY IMAP4rev1 AUTH=PLAIN`+CRLF,
+		`S: A2 OK Thats all she wrote!`+CRLF,
+	)
+	err := C.autoStartTLS(tlsConfig.client)
+	t.join("STARTTLS", err)
+	if !C.t.Encrypted() {
+		t.Fatalf("C.t.Encrypted() expected true")
+	}
+	t.checkCaps("AUTH=PLAIN", "IMAP4rev1")
+
+	// Already encrypted
+	go t.script(EOF)
+	err = C.autoStartTLS(tlsConfig.client)
+	t.join("EOF", err)
+	t.waitEOF()
+
+	// STARTTLS refused
+	C, t = newClient(T, `S: * OK [CAPABILITY IMAP4rev1 STARTTLS] Test server ready`+CRLF)
+	go t.script(
+		`C: A1 STARTTLS`+CRLF,
+		`S: A1 NO TLS unavailable`+CRLF,
+		EOF,
+	)
+	if err = C.autoStartTLS(tlsConfig.client); err == nil {
+		t.Fatalf("C.autoStartTLS() expected error")
+	}
+	t.join("STARTTLS", nil)
+	t.waitEOF()
+
+	// STARTTLS not advertised
+	C, t = newClient(T, `S: * OK [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+	go t.script(EOF)
+	err = C.autoStartTLS(tlsConfig.client)
+	t.join("EOF", err)
+	t.waitEOF()
+
+	// PREAUTH
+	C, t = newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 STARTTLS] Test server ready`+CRLF)
+	go t.script(EOF)
+	if err = C.autoStartTLS(tlsConfig.client); err == nil {
+		t.Fatalf("C.autoStartTLS() expected PREAUTH error")
+	}
+	t.join("EOF", nil)
+	t.waitEOF()
+}
+
+func TestClientLoginCleartext(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * OK [CAPABILITY IMAP4rev1 LOGINDISABLED AUTH=PLAIN] Test server ready`+CRLF)
+
+	// PLAIN should fail when LOGINDISABLED is advertised
+	cmd, err := C.Auth(PlainAuth("user", "pass", ""))
+	if cmd != nil || err != ErrLoginDisabled {
+		t.Fatalf("C.Auth(PLAIN) expected ErrLoginDisabled; got %#v (%v)", cmd, err)
+	}
+
+	// Override
+	C.AllowCleartextLogin = true
+	go t.script(
+		`C: A1 LOGIN "user" "pass"`+CRLF,
+		`S: A1 OK [CAPABILITY IMAP4rev1] LOGIN completed`+CRLF,
+		EOF,
+	)
+	cmd, err = C.Login("user", "pass")
+	t.join("LOGIN", err)
+	t.checkState(Auth)
+	t.waitEOF()
+}
+
 func TestClientSelect(T *testing.T) {
 	//defer un(setLogMask(LogAll))
 	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
@@ -408,6 +755,9 @@ func TestClientSelect(T *testing.T) {
 	if !reflect.DeepEqual(C.Mailbox, status) {
 		t.Errorf("C.Mailbox expected\n%#v; got\n%#v", status, C.Mailbox)
 	}
+	if !C.Mailbox.AllowsNewKeywords() {
+		t.Errorf("C.Mailbox.AllowsNewKeywords() expected true")
+	}
 
 	// RESELECT from Selected state
 	go t.script(
@@ -420,14 +770,15 @@ func TestClientSelect(T *testing.T) {
 		`S: * NO [UIDNOTSTICKY] Non-persistent UIDs`+CRLF,
 		`S: * FLAGS (\Answered \Flagged \Deleted \Seen \Draft)`+CRLF,
 		`S: * OK [PERMANENTFLAGS (\Deleted \Seen)] Limited`+CRLF,
+		`S: * OK [MAILBOXID (F2212ea87-6097-4256-9d51-71338625)] Ok`+CRLF,
 		`S: A4 OK [READ-WRITE] SELECT completed`+CRLF,
 	)
 	cmd, err = C.Select("funny", false)
 	t.join("RESELECT", err)
 	t.checkState(Selected)
 
-	if n := len(cmd.Data); n != 8 {
-		t.Errorf("len(cmd.Data) expected 8; got %v", n)
+	if n := len(cmd.Data); n != 9 {
+		t.Errorf("len(cmd.Data) expected 9; got %v", n)
 	}
 	status = &MailboxStatus{
 		Name:         "funny",
@@ -439,10 +790,14 @@ func TestClientSelect(T *testing.T) {
 		UIDNotSticky: true,
 		Flags:        NewFlagSet(`\Answered`, `\Flagged`, `\Deleted`, `\Seen`, `\Draft`),
 		PermFlags:    NewFlagSet(`\Deleted`, `\Seen`),
+		MailboxID:    "F2212ea87-6097-4256-9d51-71338625",
 	}
 	if !reflect.DeepEqual(C.Mailbox, status) {
 		t.Errorf("C.Mailbox expected\n%#v; got\n%#v", status, C.Mailbox)
 	}
+	if C.Mailbox.AllowsNewKeywords() {
+		t.Errorf("C.Mailbox.AllowsNewKeywords() expected false")
+	}
 
 	go t.script(EOF)
 	t.join("EOF", nil)
@@ -522,6 +877,89 @@ func TestClientMulti2(T *testing.T) {
 	t.waitEOF()
 }
 
+func TestClientTagPrefix(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C1, t1 := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+	C2, t2 := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+	if err := C1.SetTagPrefix("go"); err == nil {
+		t1.Fatalf("C1.SetTagPrefix() expected an error")
+	}
+	if err := C1.SetTagPrefix("GO"); err != nil {
+		t1.Fatalf("C1.SetTagPrefix() unexpected error; %v", err)
+	}
+	if err := C2.SetTagPrefix("XY"); err != nil {
+		t2.Fatalf("C2.SetTagPrefix() unexpected error; %v", err)
+	}
+
+	// Interleaved commands
+	go t1.script(
+		`C: GO1 NOOP`+CRLF,
+		`S: GO1 OK NOOP completed`+CRLF,
+		`C: GO2 NOOP`+CRLF,
+		`S: GO2 OK NOOP completed`+CRLF,
+	)
+	go t2.script(
+		`C: XY1 NOOP`+CRLF,
+		`S: XY1 OK NOOP completed`+CRLF,
+		`S: XY7 OK Unexpected completion`+CRLF,
+	)
+	_, err1 := Wait(C1.Noop())
+	_, err2 := Wait(C2.Noop())
+	if err1 == nil {
+		_, err1 = Wait(C1.Noop())
+	}
+	t1.join("NOOP", err1)
+
+	// Completion for a tag that is not in progress
+	err := C2.Recv(block)
+	t2.join("NOOP", err2)
+	if rerr, ok := err.(ResponseError); !ok || rerr.Reason != "unknown command tag" {
+		t2.Fatalf("C2.Recv() expected unknown command tag error; got %v", err)
+	}
+
+	for _, t := range []*clientT{t1, t2} {
+		go t.script(EOF)
+		t.join("EOF", nil)
+		t.waitEOF()
+	}
+}
+
+func TestClientPipeline(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	go t.script(
+		`C: A1 LIST "" "*"`+CRLF,
+		`C: A2 STATUS "INBOX" (MESSAGES)`+CRLF,
+		`S: * STATUS INBOX (MESSAGES 17)`+CRLF,
+		`S: A2 OK STATUS completed`+CRLF,
+		`S: * LIST () "/" INBOX`+CRLF,
+		`S: A1 OK LIST completed`+CRLF,
+		EOF,
+	)
+	cmd1, err1 := C.List("", "*")
+	cmd2, err2 := C.Status("INBOX", "MESSAGES")
+
+	// Wait in the reverse order
+	_, err2 = Wait(cmd2, err2)
+	if err2 == nil && !cmd1.InProgress() {
+		t.Errorf("cmd1.InProgress() expected true")
+	}
+	_, err1 = Wait(cmd1, err1)
+	if err1 == nil {
+		err1 = err2
+	}
+	t.join("LIST/STATUS", err1)
+
+	if len(cmd1.Data) != 1 || cmd1.Data[0].Label != "LIST" {
+		t.Errorf("cmd1.Data expected LIST; got %v", cmd1.Data)
+	}
+	if len(cmd2.Data) != 1 || cmd2.Data[0].MailboxStatus().Messages != 17 {
+		t.Errorf("cmd2.Data expected STATUS; got %v", cmd2.Data)
+	}
+	t.waitEOF()
+}
+
 func TestClientMulti3(T *testing.T) {
 	//defer un(setLogMask(LogAll))
 	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
@@ -635,57 +1073,278 @@ func TestClientAuthPlain(T *testing.T) {
 	t.waitEOF()
 }
 
-func TestClientAuthExternal1(T *testing.T) {
+func TestClientAuthXOAuth2(T *testing.T) {
 	//defer un(setLogMask(LogAll))
-	C, t := newClient(T, `S: * OK [CAPABILITY IMAP4rev1 AUTH=EXTERNAL] Test server ready`+CRLF)
+	C, t := newClient(T, `S: * OK [CAPABILITY IMAP4rev1 STARTTLS AUTH=XOAUTH2 SASL-IR] Test server ready`+CRLF)
+
+	// AUTH=XOAUTH2 should fail when the connection is not encrypted
+	cmd, err := C.Auth(XOAuth2("user@example.com", "token"))
+	if cmd != nil || err == nil {
+		t.Fatalf("C.Auth(XOAUTH2) expected error; got %#v (%v)", cmd, err)
+	}
 
+	// STARTTLS
 	go t.script(
-		`C: A1 AUTHENTICATE EXTERNAL`+CRLF,
-		`S: + `+CRLF,
+		`C: A1 STARTTLS`+CRLF,
+		`S: A1 OK Begin TLS negotiation now`+CRLF,
+		STARTTLS,
+		`C: A2 CAPABILITY`+CRLF,
+		`S: * CAPABILITY IMAP4rev1 AUTH=XOAUTH2 SASL-IR`+CRLF,
+		`S: A2 OK Thats all she wrote!`+CRLF,
+	)
+	cmd, err = C.StartTLS(tlsConfig.client)
+	t.join("STARTTLS", err)
+
+	// AUTH=XOAUTH2 (invalid token)
+	go t.script(
+		`C: A3 AUTHENTICATE XOAUTH2 dXNlcj11c2VyQGV4YW1wbGUuY29tAWF1dGg9QmVhcmVyIGV4cGlyZWQBAQ==`+CRLF,
+		`S: + eyJzdGF0dXMiOiI0MDEifQ==`+CRLF,
 		`C: `+CRLF,
-		`S: A1 OK [CAPABILITY IMAP4rev1] Success`+CRLF,
+		`S: A3 NO [AUTHENTICATIONFAILED] Invalid credentials (Failure)`+CRLF,
+	)
+	cmd, err = C.Auth(XOAuth2("user@example.com", "expired"))
+	if err == nil {
+		t.Fatalf("C.Auth(XOAUTH2) expected error; got %#v (%v)", cmd, err)
+	}
+	t.join("AUTH=XOAUTH2", nil)
+	t.checkState(Login)
+
+	// AUTH=XOAUTH2
+	go t.script(
+		`C: A4 AUTHENTICATE XOAUTH2 dXNlcj11c2VyQGV4YW1wbGUuY29tAWF1dGg9QmVhcmVyIHRva2VuAQE=`+CRLF,
+		`S: A4 OK [CAPABILITY IMAP4rev1] Success`+CRLF,
 		EOF,
 	)
-	_, err := C.Auth(ExternalAuth(""))
-	t.join("AUTH=EXTERNAL", err)
+	cmd, err = C.Auth(XOAuth2("user@example.com", "token"))
+	t.join("AUTH=XOAUTH2", err)
 	t.checkState(Auth)
-	t.checkCaps("IMAP4rev1")
 	t.waitEOF()
 }
 
-func TestClientAuthExternal2(T *testing.T) {
+func TestClientAuthCRAMMD5(T *testing.T) {
 	//defer un(setLogMask(LogAll))
-	C, t := newClient(T, `S: * OK [CAPABILITY IMAP4rev1 AUTH=EXTERNAL SASL-IR] Test server ready`+CRLF)
+	C, t := newClient(T, `S: * OK [CAPABILITY IMAP4rev1 AUTH=CRAM-MD5] Test server ready`+CRLF)
 
+	// AUTH=CRAM-MD5 (malformed challenge)
 	go t.script(
-		`C: A1 AUTHENTICATE EXTERNAL =`+CRLF,
-		`S: A1 OK [CAPABILITY IMAP4rev1] Success`+CRLF,
+		`C: A1 AUTHENTICATE CRAM-MD5`+CRLF,
+		`S: + Not Base64!`+CRLF,
+		`C: *`+CRLF,
+		`S: A1 BAD Authentication aborted`+CRLF,
+	)
+	cmd, err := C.Auth(CRAMMD5("tim", "tanstaaftanstaaf"))
+	if err == nil || !strings.Contains(err.Error(), "CRAM-MD5") {
+		t.Fatalf("C.Auth(CRAM-MD5) expected error; got %#v (%v)", cmd, err)
+	}
+	t.join("AUTH=CRAM-MD5", nil)
+	t.checkState(Login)
+
+	// AUTH=CRAM-MD5 (RFC 2195 example, rejected and then reused)
+	a := CRAMMD5("tim", "tanstaaftanstaaf")
+	go t.script(
+		`C: A2 AUTHENTICATE CRAM-MD5`+CRLF,
+		`S: + PDE4OTYuNjk3MTcwOTUyQHBvc3RvZmZpY2UucmVzdG9uLm1jaS5uZXQ+`+CRLF,
+		`C: dGltIGI5MTNhNjAyYzdlZGE3YTQ5NWI0ZTZlNzMzNGQzODkw`+CRLF,
+		`S: A2 NO [UNAVAILABLE] Try again later`+CRLF,
+	)
+	_, err = C.Auth(a)
+	if err == nil {
+		t.Fatalf("C.Auth(CRAM-MD5) expected error")
+	}
+	t.join("AUTH=CRAM-MD5", nil)
+	t.checkState(Login)
+
+	go t.script(
+		`C: A3 AUTHENTICATE CRAM-MD5`+CRLF,
+		`S: + PDE4OTYuNjk3MTcwOTUyQHBvc3RvZmZpY2UucmVzdG9uLm1jaS5uZXQ+`+CRLF,
+		`C: dGltIGI5MTNhNjAyYzdlZGE3YTQ5NWI0ZTZlNzMzNGQzODkw`+CRLF,
+		`S: A3 OK [CAPABILITY IMAP4rev1] Success`+CRLF,
 		EOF,
 	)
-	_, err := C.Auth(ExternalAuth(""))
-	t.join("AUTH=EXTERNAL", err)
+	_, err = C.Auth(a)
+	t.join("AUTH=CRAM-MD5", err)
 	t.checkState(Auth)
-	t.checkCaps("IMAP4rev1")
 	t.waitEOF()
 }
 
-func TestClientAuthExternal3(T *testing.T) {
+func TestClientAuthSCRAM(T *testing.T) {
 	//defer un(setLogMask(LogAll))
-	C, t := newClient(T, `S: * OK [CAPABILITY IMAP4rev1 AUTH=EXTERNAL SASL-IR] Test server ready`+CRLF)
+	C, t := newClient(T, `S: * OK [CAPABILITY IMAP4rev1 AUTH=SCRAM-SHA-1 AUTH=SCRAM-SHA-256] Test server ready`+CRLF)
+	defer func(f func() string) { scramNonce = f }(scramNonce)
 
+	// AUTH=SCRAM-SHA-1 (server signature mismatch)
+	scramNonce = func() string { return "fyko+d2lbbFgONRv9qkxdawL" }
 	go t.script(
-		`C: A1 AUTHENTICATE EXTERNAL dGVzdA==`+CRLF,
-		`S: A1 OK [CAPABILITY IMAP4rev1] Success`+CRLF,
+		`C: A1 AUTHENTICATE SCRAM-SHA-1`+CRLF,
+		`S: + `+CRLF,
+		`C: biwsbj11c2VyLHI9ZnlrbytkMmxiYkZnT05Sdjlxa3hkYXdM`+CRLF,
+		`S: + cj1meWtvK2QybGJiRmdPTlJ2OXFreGRhd0wzcmZjTkhZSlkxWlZ2V1ZzN2oscz1RU1hDUitRNnNlazhiZjkyLGk9NDA5Ng==`+CRLF,
+		`C: Yz1iaXdzLHI9ZnlrbytkMmxiYkZnT05Sdjlxa3hkYXdMM3JmY05IWUpZMVpWdldWczdqLHA9djBYOHYzQnoyVDBDSkdiSlF5RjBYK0hJNFRzPQ==`+CRLF,
+		`S: + dj1BQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUFBQUE9`+CRLF,
+		`C: *`+CRLF,
+		`S: A1 BAD Authentication aborted`+CRLF,
+	)
+	_, err := C.Auth(ScramSHA1("user", "pencil"))
+	if err != ErrScramSignature {
+		t.Fatalf("C.Auth(SCRAM-SHA-1) expected ErrScramSignature; got %v", err)
+	}
+	t.join("AUTH=SCRAM-SHA-1", nil)
+	t.checkState(Login)
+
+	// AUTH=SCRAM-SHA-1 (RFC 5802 example)
+	go t.script(
+		`C: A2 AUTHENTICATE SCRAM-SHA-1`+CRLF,
+		`S: + `+CRLF,
+		`C: biwsbj11c2VyLHI9ZnlrbytkMmxiYkZnT05Sdjlxa3hkYXdM`+CRLF,
+		`S: + cj1meWtvK2QybGJiRmdPTlJ2OXFreGRhd0wzcmZjTkhZSlkxWlZ2V1ZzN2oscz1RU1hDUitRNnNlazhiZjkyLGk9NDA5Ng==`+CRLF,
+		`C: Yz1iaXdzLHI9ZnlrbytkMmxiYkZnT05Sdjlxa3hkYXdMM3JmY05IWUpZMVpWdldWczdqLHA9djBYOHYzQnoyVDBDSkdiSlF5RjBYK0hJNFRzPQ==`+CRLF,
+		`S: + dj1ybUY5cHFWOFM3c3VBb1pXamE0ZEpSa0ZzS1E9`+CRLF,
+		`C: `+CRLF,
+		`S: A2 OK [CAPABILITY IMAP4rev1] Success`+CRLF,
 		EOF,
 	)
-	_, err := C.Auth(ExternalAuth("test"))
-	t.join("AUTH=EXTERNAL", err)
+	_, err = C.Auth(ScramSHA1("user", "pencil"))
+	t.join("AUTH=SCRAM-SHA-1", err)
 	t.checkState(Auth)
-	t.checkCaps("IMAP4rev1")
 	t.waitEOF()
-}
 
-func TestClientClose1(T *testing.T) {
+	// AUTH=SCRAM-SHA-1 (server skips server-final-message)
+	C, t = newClient(T, `S: * OK [CAPABILITY IMAP4rev1 AUTH=SCRAM-SHA-1] Test server ready`+CRLF)
+	go t.script(
+		`C: A1 AUTHENTICATE SCRAM-SHA-1`+CRLF,
+		`S: + `+CRLF,
+		`C: biwsbj11c2VyLHI9ZnlrbytkMmxiYkZnT05Sdjlxa3hkYXdM`+CRLF,
+		`S: + cj1meWtvK2QybGJiRmdPTlJ2OXFreGRhd0wzcmZjTkhZSlkxWlZ2V1ZzN2oscz1RU1hDUitRNnNlazhiZjkyLGk9NDA5Ng==`+CRLF,
+		`C: Yz1iaXdzLHI9ZnlrbytkMmxiYkZnT05Sdjlxa3hkYXdMM3JmY05IWUpZMVpWdldWczdqLHA9djBYOHYzQnoyVDBDSkdiSlF5RjBYK0hJNFRzPQ==`+CRLF,
+		`S: A1 OK Success`+CRLF,
+		EOF,
+	)
+	_, err = C.Auth(ScramSHA1("user", "pencil"))
+	if err != ErrScramSignature {
+		t.Fatalf("C.Auth(SCRAM-SHA-1) expected ErrScramSignature; got %v", err)
+	}
+	t.join("AUTH=SCRAM-SHA-1", nil)
+	t.checkState(Closed)
+	t.waitEOF()
+
+	// AUTH=SCRAM-SHA-256 (RFC 7677 example)
+	C, t = newClient(T, `S: * OK [CAPABILITY IMAP4rev1 AUTH=SCRAM-SHA-256] Test server ready`+CRLF)
+	scramNonce = func() string { return "rOprNGfwEbeRWgbNEkqO" }
+	go t.script(
+		`C: A1 AUTHENTICATE SCRAM-SHA-256`+CRLF,
+		`S: + `+CRLF,
+		`C: biwsbj11c2VyLHI9ck9wck5HZndFYmVSV2diTkVrcU8=`+CRLF,
+		`S: + cj1yT3ByTkdmd0ViZVJXZ2JORWtxTyVodllEcFdVYTJSYVRDQWZ1eEZJbGopaE5sRiRrMCxzPVcyMlphSjBTTlk3c29Fc1VFamI2Z1E9PSxpPTQwOTY=`+CRLF,
+		`C: Yz1iaXdzLHI9ck9wck5HZndFYmVSV2diTkVrcU8laHZZRHBXVWEyUmFUQ0FmdXhGSWxqKWhObEYkazAscD1kSHpiWmFwV0lrNGpVaE4rVXRlOXl0YWc5empmTUhnc3FtbWl6N0FuZFZRPQ==`+CRLF,
+		`S: + dj02cnJpVFJCaTIzV3BSUi93dHVwK21NaFVaVW4vZEI1bkxUSlJzamw5NUc0PQ==`+CRLF,
+		`C: `+CRLF,
+		`S: A1 OK [CAPABILITY IMAP4rev1] Success`+CRLF,
+		EOF,
+	)
+	_, err = C.Auth(ScramSHA256("user", "pencil"))
+	t.join("AUTH=SCRAM-SHA-256", err)
+	t.checkState(Auth)
+	t.waitEOF()
+}
+
+func TestClientAuthExternal1(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * OK [CAPABILITY IMAP4rev1 AUTH=EXTERNAL] Test server ready`+CRLF)
+
+	go t.script(
+		`C: A1 AUTHENTICATE EXTERNAL`+CRLF,
+		`S: + `+CRLF,
+		`C: `+CRLF,
+		`S: A1 OK [CAPABILITY IMAP4rev1] Success`+CRLF,
+		EOF,
+	)
+	_, err := C.Auth(ExternalAuth(""))
+	t.join("AUTH=EXTERNAL", err)
+	t.checkState(Auth)
+	t.checkCaps("IMAP4rev1")
+	t.waitEOF()
+}
+
+func TestClientAuthExternal2(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * OK [CAPABILITY IMAP4rev1 AUTH=EXTERNAL SASL-IR] Test server ready`+CRLF)
+
+	go t.script(
+		`C: A1 AUTHENTICATE EXTERNAL =`+CRLF,
+		`S: A1 OK [CAPABILITY IMAP4rev1] Success`+CRLF,
+		EOF,
+	)
+	_, err := C.Auth(ExternalAuth(""))
+	t.join("AUTH=EXTERNAL", err)
+	t.checkState(Auth)
+	t.checkCaps("IMAP4rev1")
+	t.waitEOF()
+}
+
+func TestClientAuthExternal3(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * OK [CAPABILITY IMAP4rev1 AUTH=EXTERNAL SASL-IR] Test server ready`+CRLF)
+
+	go t.script(
+		`C: A1 AUTHENTICATE EXTERNAL dGVzdA==`+CRLF,
+		`S: A1 OK [CAPABILITY IMAP4rev1] Success`+CRLF,
+		EOF,
+	)
+	_, err := C.Auth(ExternalAuth("test"))
+	t.join("AUTH=EXTERNAL", err)
+	t.checkState(Auth)
+	t.checkCaps("IMAP4rev1")
+	t.waitEOF()
+}
+
+func TestClientSendRaw(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * OK [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	var conts []string
+	cont := func(rsp *Response) ([]byte, error) {
+		conts = append(conts, rsp.Info)
+		if rsp.Info == "stop" {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return []byte(strings.ToUpper(rsp.Info)), nil
+	}
+
+	// Two continuations
+	go t.script(
+		`C: A1 XYZZY abc`+CRLF,
+		`S: + one`+CRLF,
+		`C: ONE`+CRLF,
+		`S: + two`+CRLF,
+		`C: TWO`+CRLF,
+		`S: A1 OK Nothing happens.`+CRLF,
+	)
+	cmd, err := Wait(C.SendRaw("XYZZY", cont, "abc"))
+	t.join("XYZZY", err)
+	if !reflect.DeepEqual(conts, []string{"one", "two"}) {
+		t.Errorf("cont expected one, two; got %q", conts)
+	}
+
+	// Callback error cancels the command
+	go t.script(
+		`C: A2 XYZZY`+CRLF,
+		`S: + stop`+CRLF,
+		`C: *`+CRLF,
+		`S: A2 BAD Command cancelled`+CRLF,
+		EOF,
+	)
+	cmd, err = C.SendRaw("XYZZY", cont)
+	t.join("XYZZY", nil)
+	if err != io.ErrUnexpectedEOF || cmd.InProgress() {
+		t.Fatalf("C.SendRaw() expected io.ErrUnexpectedEOF; got %v", err)
+	}
+	if rsp, _ := cmd.Result(0); rsp.Status != BAD {
+		t.Errorf("cmd.Result() expected BAD; got %v", rsp)
+	}
+	t.waitEOF()
+}
+
+func TestClientClose1(T *testing.T) {
 	//defer un(setLogMask(LogAll))
 	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
 
@@ -747,6 +1406,121 @@ func TestClientClose2(T *testing.T) {
 	t.waitEOF()
 }
 
+func TestClientExamine(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	// EXAMINE (no READ-ONLY response code)
+	go t.script(
+		`C: A1 EXAMINE "INBOX"`+CRLF,
+		`S: * FLAGS (\Answered \Seen)`+CRLF,
+		`S: * OK [UIDVALIDITY 645321] UIDs valid.`+CRLF,
+		`S: * 16 EXISTS`+CRLF,
+		`S: A1 OK INBOX examined`+CRLF,
+		EOF,
+	)
+	_, err := C.Examine("INBOX")
+	t.join("EXAMINE", err)
+	t.checkState(Selected)
+	mb := C.Mailbox
+	t.waitEOF()
+	if mb.Name != "INBOX" || !mb.ReadOnly || mb.Messages != 16 || mb.UIDValidity != 645321 {
+		t.Errorf("C.Mailbox unexpected status %v", mb)
+	}
+}
+
+func TestClientContext(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if cmd, err := C.SendContext(ctx, "NOOP"); cmd != nil || err != context.Canceled {
+		t.Fatalf("C.SendContext() expected context.Canceled; got %#v (%v)", cmd, err)
+	}
+
+	// Completion response arrives after the deadline
+	go t.script(
+		`C: A1 NOOP` + CRLF,
+	)
+	ctx, cancel = context.WithTimeout(context.Background(), testConnTimeout/5)
+	defer cancel()
+	cmd, err := C.SendContext(ctx, "NOOP")
+	t.join("NOOP", err)
+	if _, err = C.WaitContext(ctx, cmd); err != context.DeadlineExceeded {
+		t.Fatalf("C.WaitContext() expected context.DeadlineExceeded; got %v", err)
+	}
+	if _, err = cmd.Result(0); err != ErrAborted {
+		t.Fatalf("cmd.Result() expected ErrAborted; got %v", err)
+	}
+	go t.script(
+		`S: A1 OK NOOP completed`+CRLF,
+		`C: A2 NOOP`+CRLF,
+		`S: A2 OK NOOP completed`+CRLF,
+	)
+	_, err = C.WaitContext(context.Background(), cmd)
+	if err != ErrAborted {
+		t.Fatalf("C.WaitContext() expected ErrAborted; got %v", err)
+	}
+	cmd, err = C.SendContext(context.Background(), "NOOP")
+	if err == nil {
+		_, err = C.WaitContext(context.Background(), cmd)
+	}
+	t.join("NOOP", err)
+	if n := len(C.cmds); n != 0 {
+		t.Errorf("len(C.cmds) expected 0; got %d", n)
+	}
+
+	// Deadline expires while waiting for a continuation request
+	go t.script(
+		`C: A3 RENAME {4}` + CRLF,
+	)
+	ctx, cancel = context.WithTimeout(context.Background(), testConnTimeout/5)
+	defer cancel()
+	cmd, err = C.SendContext(ctx, "RENAME", NewLiteral([]byte("test")), "test2")
+	t.join("RENAME", nil)
+	if cmd != nil || err != context.DeadlineExceeded {
+		t.Fatalf("C.SendContext() expected context.DeadlineExceeded; got %#v (%v)", cmd, err)
+	}
+	go t.script(EOF)
+	t.join("EOF", nil)
+	t.waitEOF()
+}
+
+func TestClientUnselect(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	// SELECT
+	go t.script(
+		`C: A1 SELECT "INBOX"`+CRLF,
+		`S: * 16 EXISTS`+CRLF,
+		`S: A1 OK [READ-WRITE] INBOX selected. (Success)`+CRLF,
+	)
+	_, err := C.Select("INBOX", false)
+	t.join("SELECT", err)
+	t.checkState(Selected)
+
+	if cmd, err := C.Unselect(); cmd != nil || err != NotAvailableError("UNSELECT") {
+		t.Fatalf("C.Unselect() expected NotAvailableError; got %#v (%v)", cmd, err)
+	}
+	C.Caps["UNSELECT"] = true
+
+	// UNSELECT
+	go t.script(
+		`C: A2 UNSELECT`+CRLF,
+		`S: A2 OK Unselect completed`+CRLF,
+		EOF,
+	)
+	_, err = C.Unselect()
+	t.join("UNSELECT", err)
+	t.checkState(Auth)
+	t.waitEOF()
+	if C.Mailbox != nil {
+		t.Errorf("C.Mailbox expected nil; got %v", C.Mailbox)
+	}
+}
+
 func TestClientIdle(T *testing.T) {
 	//defer un(setLogMask(LogAll))
 	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 IDLE] Test server ready`+CRLF)
@@ -760,6 +1534,13 @@ func TestClientIdle(T *testing.T) {
 	t.join("IDLE", err)
 	C.Data = nil
 
+	// Timeout should not interrupt the command
+	if err = C.Recv(poll); err != ErrTimeout {
+		t.Fatalf("C.Recv() expected timeout; got %v", err)
+	} else if !cmd1.InProgress() {
+		t.Fatalf("cmd1.InProgress() expected true; got false")
+	}
+
 	// UPDATE
 	go t.script(
 		`S: * 4 EXISTS`+CRLF,
@@ -804,6 +1585,135 @@ func TestClientIdle(T *testing.T) {
 	}
 }
 
+func TestClientIdleLiteral(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 IDLE] Test server ready`+CRLF)
+
+	go t.script(
+		`C: A1 SELECT "INBOX"`+CRLF,
+		`S: * 3 EXISTS`+CRLF,
+		`S: A1 OK [READ-WRITE] Ok`+CRLF,
+		`C: A2 IDLE`+CRLF,
+		`S: + idling`+CRLF,
+	)
+	_, err := C.Select("INBOX", false)
+	if err == nil {
+		_, err = C.Idle()
+	}
+	t.join("IDLE", err)
+	C.Data = nil
+
+	var updates []*Response
+	C.SetUpdateHandler(func(rsp *Response) bool {
+		updates = append(updates, rsp)
+		return true
+	})
+
+	// Unsolicited FETCH responses with literals, followed by a normal update
+	msg := "Subject: New" + CRLF + CRLF + "Hello"
+	go t.script(
+		`S: * 3 FETCH (UID 7 FLAGS (\Recent) BODY[] {21}`+CRLF,
+		`S: `+msg+` BODY[HEADER.FIELDS (TO)] {0}`,
+		`S: `+CRLF,
+		`S: )`+CRLF,
+		`S: * 4 EXISTS`+CRLF,
+	)
+	for len(updates) < 2 && err == nil {
+		err = C.Recv(block)
+	}
+	t.join("UPDATE", err)
+
+	// DONE
+	go t.script(
+		`C: DONE`+CRLF,
+		`S: A2 OK IDLE terminated`+CRLF,
+		EOF,
+	)
+	_, err = C.IdleTerm()
+	t.join("DONE", err)
+	if len(C.Data) != 0 {
+		t.Errorf("C.Data expected empty; got %v", C.Data)
+	}
+	if n := C.Mailbox.Messages; n != 4 {
+		t.Errorf("C.Mailbox.Messages expected 4; got %d", n)
+	}
+	t.waitEOF()
+
+	if len(updates) != 2 || updates[1].Label != "EXISTS" {
+		t.Fatalf("updates expected FETCH and EXISTS; got %v", updates)
+	}
+	info := updates[0].MessageInfo()
+	if info == nil || info.Seq != 3 || info.UID != 7 || !info.Flags[`\Recent`] {
+		t.Fatalf("rsp.MessageInfo() expected seq 3, UID 7; got %#v", info)
+	}
+	if b := info.Body(""); string(b) != msg {
+		t.Errorf("info.Body() expected %q; got %q", msg, b)
+	}
+	if b := info.Attrs["BODY[HEADER.FIELDS (TO)]"]; b == nil || len(AsBytes(b)) != 0 {
+		t.Errorf("info.Attrs[HEADER.FIELDS] expected empty literal; got %#v", b)
+	}
+}
+
+func TestClientNotify(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	if cmd, err := C.Notify("(selected (MessageNew))"); cmd != nil || err != NotAvailableError("NOTIFY") {
+		t.Fatalf("C.Notify() expected NotAvailableError; got %#v (%v)", cmd, err)
+	}
+	C.Caps["NOTIFY"] = true
+
+	spec := `(selected (MessageNew (UID) MessageExpunge)) (mailboxes "Lists/Go" INBOX (MessageNew))`
+	go t.script(
+		`C: A1 SELECT "Drafts"`+CRLF,
+		`S: * 2 EXISTS`+CRLF,
+		`S: A1 OK [READ-WRITE] Ok`+CRLF,
+		`C: A2 NOTIFY SET `+spec+CRLF,
+		`S: A2 OK NOTIFY completed`+CRLF,
+	)
+	_, err := C.Select("Drafts", false)
+	if err == nil {
+		_, err = Wait(C.Notify(spec))
+	}
+	t.join("NOTIFY", err)
+	C.Data = nil
+
+	type event struct{ mbox, label string }
+	var events []event
+	C.SetUpdateHandler(func(rsp *Response) bool {
+		events = append(events, event{C.EventMailbox(rsp), rsp.Label})
+		return true
+	})
+	go t.script(
+		`S: * STATUS "Lists/Go" (MESSAGES 10 UIDNEXT 42)`+CRLF,
+		`S: * 3 EXISTS`+CRLF,
+		`S: * 3 FETCH (UID 9)`+CRLF,
+		`S: * STATUS INBOX (MESSAGES 5 UIDNEXT 6)`+CRLF,
+		`S: * OK [NOTIFICATIONOVERFLOW] Too many events`+CRLF,
+	)
+	for len(events) < 5 && err == nil {
+		err = C.Recv(block)
+	}
+	t.join("UPDATE", err)
+	want := []event{{"Lists/Go", "STATUS"}, {"Drafts", "EXISTS"}, {"Drafts", "FETCH"},
+		{"INBOX", "STATUS"}, {"", "NOTIFICATIONOVERFLOW"}}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events expected %v; got %v", want, events)
+	}
+	if n := C.Mailbox.Messages; n != 3 {
+		t.Errorf("C.Mailbox.Messages expected 3; got %d", n)
+	}
+
+	go t.script(
+		`C: A3 NOTIFY NONE`+CRLF,
+		`S: A3 OK NOTIFY completed`+CRLF,
+		EOF,
+	)
+	_, err = Wait(C.Notify(""))
+	t.join("NOTIFY NONE", err)
+	t.waitEOF()
+}
+
 func TestClientQuota(T *testing.T) {
 	//defer un(setLogMask(LogAll))
 	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 QUOTA] Test server ready`+CRLF)
@@ -841,9 +1751,2562 @@ func TestClientQuota(T *testing.T) {
 		`S: * QUOTAROOT INBOX ""`+CRLF,
 		`S: * QUOTA "" (STORAGE 10 512)`+CRLF,
 		`S: A4 OK Getquota completed`+CRLF,
-		EOF,
 	)
 	_, err = Wait(C.GetQuotaRoot("INBOX"))
 	t.join("GETQUOTAROOT", err)
+
+	// GETQUOTAROOT with multiple roots
+	go t.script(
+		`C: A5 GETQUOTAROOT "shared"`+CRLF,
+		`S: * QUOTAROOT shared "" "#user/shared"`+CRLF,
+		`S: * QUOTA "" (STORAGE 10 512)`+CRLF,
+		`S: * QUOTA "#user/shared" (STORAGE 300 1024 MESSAGE 7 100)`+CRLF,
+		`S: A5 OK Getquota completed`+CRLF,
+		EOF,
+	)
+	cmd, err := Wait(C.GetQuotaRoot("shared"))
+	t.join("GETQUOTAROOT", err)
+	t.waitEOF()
+
+	if n := len(cmd.Data); n != 3 {
+		t.Fatalf("len(cmd.Data) expected 3; got %v", n)
+	}
+	if mbox, roots := cmd.Data[0].QuotaRoot(); mbox != "shared" ||
+		!reflect.DeepEqual(roots, []string{"", "#user/shared"}) {
+		t.Errorf("QuotaRoot() expected shared [ #user/shared]; got %v %v", mbox, roots)
+	}
+	root, quota := cmd.Data[2].Quota()
+	want := []*Quota{{"STORAGE", 300, 1024}, {"MESSAGE", 7, 100}}
+	if root != "#user/shared" || !reflect.DeepEqual(quota, want) {
+		t.Errorf("Quota() expected #user/shared %v; got %v %v", want, root, quota)
+	}
+}
+
+func TestClientQuotaResources(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 QUOTA QUOTA=RES-STORAGE QUOTA=RES-MAILBOX] Test server ready`+CRLF)
+
+	// RFC 9208 and unknown resources
+	go t.script(
+		`C: A1 GETQUOTA "user"`+CRLF,
+		`S: * QUOTA user (MESSAGE 42 1000 MAILBOX 3 10 ANNOTATION-STORAGE 0 64 x-vendor 1 2)`+CRLF,
+		`S: A1 OK Getquota completed`+CRLF,
+	)
+	cmd, err := Wait(C.GetQuota("user"))
+	t.join("GETQUOTA", err)
+	root, quota := cmd.Data[0].Quota()
+	want := []*Quota{{"MESSAGE", 42, 1000}, {"MAILBOX", 3, 10},
+		{"ANNOTATION-STORAGE", 0, 64}, {"X-VENDOR", 1, 2}}
+	if root != "user" || !reflect.DeepEqual(quota, want) {
+		t.Errorf("Quota() expected user %v; got %v %v", want, root, quota)
+	}
+
+	// OVERQUOTA
+	go t.script(
+		`C: A2 APPEND "INBOX" {5}`+CRLF,
+		`S: + Ready`+CRLF,
+		`C: hello`+CRLF,
+		`S: A2 NO [OVERQUOTA] Quota exceeded`+CRLF,
+		`C: A3 APPEND "INBOX" {5}`+CRLF,
+		`S: + Ready`+CRLF,
+		`C: hello`+CRLF,
+		`S: A3 NO [TRYCREATE] No such mailbox`+CRLF,
+		EOF,
+	)
+	_, err = Wait(C.Append("INBOX", nil, nil, NewLiteral([]byte("hello"))))
+	if rsp, ok := err.(ResponseError); !ok || !errors.Is(err, ErrOverQuota) || rsp.Label != "OVERQUOTA" {
+		t.Fatalf("C.Append() expected ErrOverQuota; got %v", err)
+	}
+	_, err = Wait(C.Append("INBOX", nil, nil, NewLiteral([]byte("hello"))))
+	if err == nil || errors.Is(err, ErrOverQuota) {
+		t.Fatalf("C.Append() expected TRYCREATE error; got %v", err)
+	}
+	t.join("APPEND", nil)
+	t.waitEOF()
+}
+
+func TestClientMove(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	// SELECT
+	go t.script(
+		`C: A1 SELECT "INBOX"`+CRLF,
+		`S: * 3 EXISTS`+CRLF,
+		`S: A1 OK [READ-WRITE] INBOX selected. (Success)`+CRLF,
+	)
+	_, err := C.Select("INBOX", false)
+	t.join("SELECT", err)
+
+	// MOVE should fail when the capability is not advertised
+	if cmd, err := C.Move(newSeqSet("2:3"), "Archive"); cmd != nil || err == nil {
+		t.Fatalf("C.Move() expected error; got %#v (%v)", cmd, err)
+	}
+	C.Caps["MOVE"] = true
+
+	// UID MOVE
+	go t.script(
+		`C: A2 UID MOVE 42:43 "Archive"`+CRLF,
+		`S: * OK [COPYUID 432432 42:43 100:101] Moved UIDs.`+CRLF,
+		`S: * 2 EXPUNGE`+CRLF,
+		`S: * 2 EXPUNGE`+CRLF,
+		`S: A2 OK Move completed`+CRLF,
+		EOF,
+	)
+	cmd, err := Wait(C.UIDMove(newSeqSet("42:43"), "Archive"))
+	t.join("UID MOVE", err)
 	t.waitEOF()
+
+	if n := len(cmd.Data); n != 3 {
+		t.Errorf("len(cmd.Data) expected 3; got %v", n)
+	}
+	uidValidity, src, dst, ok := cmd.CopyUID()
+	if !ok || uidValidity != 432432 || src.String() != "42:43" || dst.String() != "100:101" {
+		t.Errorf("cmd.CopyUID() expected 432432 42:43 100:101; got %v %v %v %v", uidValidity, src, dst, ok)
+	}
+}
+
+func TestClientSearch(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 ESEARCH] Test server ready`+CRLF)
+
+	// SELECT
+	go t.script(
+		`C: A1 SELECT "INBOX"`+CRLF,
+		`S: * 23 EXISTS`+CRLF,
+		`S: A1 OK [READ-WRITE] INBOX selected. (Success)`+CRLF,
+	)
+	_, err := C.Select("INBOX", false)
+	t.join("SELECT", err)
+
+	// SEARCH results folded into a set
+	go t.script(
+		`C: A2 SEARCH CHARSET UTF-8 UNSEEN`+CRLF,
+		`S: * SEARCH 8 2 5 3 4`+CRLF,
+		`S: A2 OK SEARCH completed`+CRLF,
+	)
+	cmd, err := Wait(C.Search("UNSEEN"))
+	t.join("SEARCH", err)
+	if v := cmd.SearchSet().String(); v != "2:5,8" {
+		t.Errorf("cmd.SearchSet() expected 2:5,8; got %v", v)
+	}
+
+	// Extended UID SEARCH (stale response from A2 is left in C.Data)
+	C.Data = nil
+	go t.script(
+		`C: A3 UID SEARCH RETURN (MIN MAX COUNT ALL) CHARSET UTF-8 DELETED`+CRLF,
+		`S: * ESEARCH (TAG "A2") ALL 7`+CRLF,
+		`S: * ESEARCH (TAG "A3") UID MIN 1 MAX 5 COUNT 4 ALL 1:3,5`+CRLF,
+		`S: A3 OK UID SEARCH completed`+CRLF,
+	)
+	cmd, err = Wait(C.UIDSearchReturn([]string{"MIN", "MAX", "COUNT", "ALL"}, "DELETED"))
+	t.join("UID SEARCH", err)
+	want := &ESearchResult{Tag: "A3", UID: true, Min: 1, Max: 5, Count: 4, All: newSeqSet("1:3,5")}
+	if v := cmd.ESearchResult(); !reflect.DeepEqual(v, want) {
+		t.Errorf("cmd.ESearchResult() expected %+v; got %+v", want, v)
+	}
+	if v := cmd.SearchSet().String(); v != "1:3,5" {
+		t.Errorf("cmd.SearchSet() expected 1:3,5; got %v", v)
+	}
+	if n := len(C.Data); n != 1 || C.Data[0].DataType() != DataESearch {
+		t.Errorf("C.Data expected one ESEARCH response; got %v", C.Data)
+	}
+
+	// Extended SEARCH without matches
+	go t.script(
+		`C: A4 SEARCH RETURN () CHARSET UTF-8 FLAGGED`+CRLF,
+		`S: * ESEARCH (TAG "A4")`+CRLF,
+		`S: A4 OK SEARCH completed`+CRLF,
+	)
+	cmd, err = Wait(C.SearchReturn(nil, "FLAGGED"))
+	t.join("SEARCH", err)
+	if v := cmd.ESearchResult(); !reflect.DeepEqual(v, &ESearchResult{Tag: "A4"}) {
+		t.Errorf("cmd.ESearchResult() expected no matches; got %+v", v)
+	}
+	if !cmd.SearchSet().Empty() {
+		t.Errorf("cmd.SearchSet() expected empty set; got %v", cmd.SearchSet())
+	}
+
+	// Fallback to plain SEARCH
+	delete(C.Caps, "ESEARCH")
+	go t.script(
+		`C: A5 SEARCH CHARSET UTF-8 ALL`+CRLF,
+		`S: * SEARCH 7 9 8 12`+CRLF,
+		`S: A5 OK SEARCH completed`+CRLF,
+		EOF,
+	)
+	cmd, err = Wait(C.SearchReturn([]string{"COUNT"}, "ALL"))
+	t.join("SEARCH", err)
+	t.waitEOF()
+	want = &ESearchResult{Min: 7, Max: 12, Count: 4, All: newSeqSet("7:9,12")}
+	if v := cmd.ESearchResult(); !reflect.DeepEqual(v, want) {
+		t.Errorf("cmd.ESearchResult() expected %+v; got %+v", want, v)
+	}
+}
+
+func TestClientSearchCharsetFallback(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+	C.SearchCharsetFallback = true
+
+	go t.script(
+		`C: A1 SELECT "INBOX"`+CRLF,
+		`S: * 23 EXISTS`+CRLF,
+		`S: A1 OK [READ-WRITE] INBOX selected. (Success)`+CRLF,
+	)
+	_, err := C.Select("INBOX", false)
+	t.join("SELECT", err)
+
+	// Retry with the first charset that can represent the criteria
+	go t.script(
+		`C: A2 SEARCH CHARSET UTF-8 SUBJECT {5}`+CRLF,
+		`S: + Ready`+CRLF,
+		`C: Café FROM "joe"`+CRLF,
+		`S: A2 NO [BADCHARSET (US-ASCII ISO-8859-1)] Unsupported charset`+CRLF,
+		`C: A3 SEARCH CHARSET ISO-8859-1 SUBJECT {4}`+CRLF,
+		`S: + Ready`+CRLF,
+		"C: Caf\xE9 FROM \"joe\""+CRLF,
+		`S: * SEARCH 3 4`+CRLF,
+		`S: A3 OK SEARCH completed`+CRLF,
+	)
+	cmd, err := Wait(C.Search(new(SearchCriteria).Subject("Café").From("joe").Build()...))
+	t.join("SEARCH", err)
+	if v := cmd.SearchSet().String(); v != "3:4" {
+		t.Errorf("cmd.SearchSet() expected 3:4; got %v", v)
+	}
+
+	// No suitable charset
+	go t.script(
+		`C: A4 UID SEARCH CHARSET UTF-8 SUBJECT {6}`+CRLF,
+		`S: + Ready`+CRLF,
+		`C: 日本`+CRLF,
+		`S: A4 NO [BADCHARSET (US-ASCII ISO-8859-1)] Unsupported charset`+CRLF,
+		EOF,
+	)
+	_, err = Wait(C.UIDSearch(new(SearchCriteria).Subject("日本").Build()...))
+	if err == nil || !strings.Contains(err.Error(), "(US-ASCII ISO-8859-1)") {
+		t.Fatalf("C.UIDSearch() expected charset error; got %v", err)
+	}
+	t.join("UID SEARCH", nil)
+	t.waitEOF()
+}
+func TestClientSort(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 SORT] Test server ready`+CRLF)
+
+	// SELECT
+	go t.script(
+		`C: A1 SELECT "INBOX"`+CRLF,
+		`S: * 5 EXISTS`+CRLF,
+		`S: A1 OK [READ-WRITE] INBOX selected. (Success)`+CRLF,
+	)
+	_, err := C.Select("INBOX", false)
+	t.join("SELECT", err)
+
+	// Invalid sort criteria are rejected before sending
+	if cmd, err := C.Sort([]string{"DATE", "BOGUS"}, ""); cmd != nil || err == nil {
+		t.Fatalf("C.Sort() expected error; got %#v (%v)", cmd, err)
+	}
+	if cmd, err := C.Sort(nil, ""); cmd != nil || err == nil {
+		t.Fatalf("C.Sort() expected error; got %#v (%v)", cmd, err)
+	}
+	for _, keys := range [][]string{{"REVERSE"}, {"DATE", "reverse"}, {"REVERSE", "REVERSE", "DATE"}} {
+		if cmd, err := C.Sort(keys, ""); cmd != nil || err == nil {
+			t.Fatalf("C.Sort(%q) expected error; got %#v (%v)", keys, cmd, err)
+		}
+	}
+
+	// SORT
+	go t.script(
+		`C: A2 SORT (REVERSE DATE SUBJECT) US-ASCII ALL`+CRLF,
+		`S: * SORT 5 3 4 1 2`+CRLF,
+		`S: A2 OK SORT completed`+CRLF,
+	)
+	cmd, err := Wait(C.Sort([]string{"reverse", "DATE", "subject"}, "", "ALL"))
+	t.join("SORT", err)
+	if n := len(cmd.Data); n != 1 {
+		t.Fatalf("len(cmd.Data) expected 1; got %v", n)
+	}
+	if v := cmd.Data[0].SearchResults(); !reflect.DeepEqual(v, []uint32{5, 3, 4, 1, 2}) {
+		t.Errorf("SearchResults() expected [5 3 4 1 2]; got %v", v)
+	}
+
+	// UID SORT
+	go t.script(
+		`C: A3 UID SORT (SIZE) UTF-8 SUBJECT "afternoon"`+CRLF,
+		`S: * SORT 44 7`+CRLF,
+		`S: A3 OK SORT completed`+CRLF,
+		EOF,
+	)
+	cmd, err = Wait(C.UIDSort([]string{"SIZE"}, "UTF-8", "SUBJECT", C.Quote("afternoon")))
+	t.join("UID SORT", err)
+	t.waitEOF()
+	if v := cmd.SortResults(); !reflect.DeepEqual(v, []uint32{44, 7}) {
+		t.Errorf("SortResults() expected [44 7]; got %v", v)
+	}
+}
+
+func TestClientListTree(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 NAMESPACE] Test server ready`+CRLF)
+
+	go t.script(
+		`C: A1 NAMESPACE`+CRLF,
+		`S: * NAMESPACE (("" "/")) (("Other Users." ".")) NIL`+CRLF,
+		`S: A1 OK NAMESPACE completed`+CRLF,
+		`C: A2 LIST "" "*"`+CRLF,
+		`S: * LIST (\HasNoChildren) "/" "Work/Projects/Alpha"`+CRLF,
+		`S: * LIST (\Noselect \HasChildren) "/" "Work"`+CRLF,
+		`S: * LIST (\HasNoChildren) "/" INBOX`+CRLF,
+		`S: * LIST () NIL "Other Users.jdoe"`+CRLF,
+		`S: A2 OK LIST completed`+CRLF,
+		EOF,
+	)
+	root, err := C.ListTree("", "*")
+	t.join("LIST", err)
+	t.waitEOF()
+
+	placeholder := []string{`\Nonexistent`, `\Noselect`}
+	alpha := &MailboxNode{"Alpha", "Work/Projects/Alpha", "/", []string{`\Hasnochildren`}, nil}
+	projects := &MailboxNode{"Projects", "Work/Projects", "/", placeholder, []*MailboxNode{alpha}}
+	jdoe := &MailboxNode{"jdoe", "Other Users.jdoe", "", []string{}, nil}
+	want := &MailboxNode{Children: []*MailboxNode{
+		{"Work", "Work", "/", []string{`\Haschildren`, `\Noselect`}, []*MailboxNode{projects}},
+		{"INBOX", "INBOX", "/", []string{`\Hasnochildren`}, nil},
+		{"Other Users", "Other Users", ".", placeholder, []*MailboxNode{jdoe}},
+	}}
+	if !reflect.DeepEqual(root, want) {
+		t.Errorf("C.ListTree() expected\n%+v; got\n%+v", want.Children, root.Children)
+	}
+}
+
+func TestClientThread(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 THREAD=REFERENCES] Test server ready`+CRLF)
+
+	// SELECT
+	go t.script(
+		`C: A1 SELECT "INBOX"`+CRLF,
+		`S: * 96 EXISTS`+CRLF,
+		`S: A1 OK [READ-WRITE] INBOX selected. (Success)`+CRLF,
+	)
+	_, err := C.Select("INBOX", false)
+	t.join("SELECT", err)
+
+	// Unsupported algorithm
+	if cmd, err := C.Thread("ORDEREDSUBJECT", ""); cmd != nil || err == nil {
+		t.Fatalf("C.Thread() expected error; got %#v (%v)", cmd, err)
+	}
+
+	// THREAD (RFC 5256 examples)
+	go t.script(
+		`C: A2 THREAD REFERENCES US-ASCII ALL`+CRLF,
+		`S: * THREAD (2)(3 6 (4 23)(44 7 96))((11)(12 13))`+CRLF,
+		`S: A2 OK Thread completed`+CRLF,
+	)
+	cmd, err := Wait(C.Thread("references", "", "ALL"))
+	t.join("THREAD", err)
+	threads, err := Threads(cmd)
+	if err != nil {
+		t.Fatalf("Threads() unexpected error; %v", err)
+	}
+	want := []*ThreadMember{
+		{Num: 2},
+		{Num: 3, Children: []*ThreadMember{
+			{Num: 6, Children: []*ThreadMember{
+				{Num: 4, Children: []*ThreadMember{{Num: 23}}},
+				{Num: 44, Children: []*ThreadMember{
+					{Num: 7, Children: []*ThreadMember{{Num: 96}}}}},
+			}},
+		}},
+		{Children: []*ThreadMember{
+			{Num: 11},
+			{Num: 12, Children: []*ThreadMember{{Num: 13}}},
+		}},
+	}
+	if !reflect.DeepEqual(threads, want) {
+		t.Errorf("Threads() expected\n%v; got\n%v", want, threads)
+	}
+
+	// UID THREAD with a flattened pseudo-root and an invalid response
+	go t.script(
+		`C: A3 UID THREAD REFERENCES UTF-8 ALL`+CRLF,
+		`S: * THREAD ((42))`+CRLF,
+		`S: A3 OK Thread completed`+CRLF,
+	)
+	cmd, err = Wait(C.UIDThread("REFERENCES", "UTF-8", "ALL"))
+	t.join("UID THREAD", err)
+	if threads, err = Threads(cmd); err != nil || !reflect.DeepEqual(threads, []*ThreadMember{{Num: 42}}) {
+		t.Errorf("Threads() expected [{42}]; got %v (%v)", threads, err)
+	}
+	go t.script(
+		`C: A4 UID THREAD REFERENCES US-ASCII ALL`+CRLF,
+		`S: * THREAD (1 x)`+CRLF,
+		`S: A4 OK Thread completed`+CRLF,
+		EOF,
+	)
+	cmd, err = Wait(C.UIDThread("REFERENCES", "", "ALL"))
+	t.join("UID THREAD", err)
+	t.waitEOF()
+	if threads, err = Threads(cmd); err == nil {
+		t.Errorf("Threads() expected error; got %v", threads)
+	}
+}
+
+func TestClientCondStore(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 ENABLE CONDSTORE] Test server ready`+CRLF)
+
+	// ENABLE
+	go t.script(
+		`C: A1 ENABLE CONDSTORE`+CRLF,
+		`S: * ENABLED CONDSTORE`+CRLF,
+		`S: A1 OK Conditional Store enabled`+CRLF,
+	)
+	_, err := C.Enable("CONDSTORE")
+	t.join("ENABLE", err)
+	if !C.Enabled["CONDSTORE"] {
+		t.Errorf("C.Enabled expected CONDSTORE; got %v", C.Enabled)
+	}
+
+	// SELECT with HIGHESTMODSEQ
+	go t.script(
+		`C: A2 SELECT "INBOX"`+CRLF,
+		`S: * 172 EXISTS`+CRLF,
+		`S: * OK [UIDVALIDITY 3857529045] Ok`+CRLF,
+		`S: * OK [HIGHESTMODSEQ 715194045007] Ok`+CRLF,
+		`S: * OK [HIGHESTMODSEQ] Malformed`+CRLF,
+		`S: A2 OK [READ-WRITE] Ok`+CRLF,
+	)
+	_, err = C.Select("INBOX", false)
+	t.join("SELECT", err)
+	if m := C.Mailbox; m.HighestModSeq != 715194045007 || m.NoModSeq {
+		t.Errorf("C.Mailbox expected HIGHESTMODSEQ 715194045007; got\n%v", m)
+	}
+
+	// FETCH with MODSEQ
+	go t.script(
+		`C: A3 FETCH 1 (FLAGS MODSEQ)`+CRLF,
+		`S: * 1 FETCH (MODSEQ (624140003) FLAGS (\Seen))`+CRLF,
+		`S: A3 OK Fetch completed`+CRLF,
+	)
+	cmd, err := Wait(C.Fetch(newSeqSet("1"), "FLAGS", "MODSEQ"))
+	t.join("FETCH", err)
+	if n := len(cmd.Data); n != 1 {
+		t.Fatalf("len(cmd.Data) expected 1; got %v", n)
+	}
+	if v := cmd.Data[0].MessageInfo().ModSeq; v != 624140003 {
+		t.Errorf("MessageInfo().ModSeq expected 624140003; got %v", v)
+	}
+
+	// SELECT with NOMODSEQ
+	go t.script(
+		`C: A4 SELECT "Archive"`+CRLF,
+		`S: * 2 EXISTS`+CRLF,
+		`S: * OK [NOMODSEQ] Sorry, this mailbox format doesn't support modsequences`+CRLF,
+		`S: A4 OK [READ-WRITE] Ok`+CRLF,
+		EOF,
+	)
+	_, err = C.Select("Archive", false)
+	t.join("SELECT", err)
+	if m := C.Mailbox; m.HighestModSeq != 0 || !m.NoModSeq {
+		t.Errorf("C.Mailbox expected NOMODSEQ; got\n%v", m)
+	}
+	t.waitEOF()
+}
+
+func TestClientCondStoreModifiers(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 CONDSTORE] Test server ready`+CRLF)
+
+	// SELECT
+	go t.script(
+		`C: A1 SELECT "INBOX"`+CRLF,
+		`S: * 10 EXISTS`+CRLF,
+		`S: * OK [HIGHESTMODSEQ 12111230047] Ok`+CRLF,
+		`S: A1 OK [READ-WRITE] Ok`+CRLF,
+	)
+	_, err := C.Select("INBOX", false)
+	t.join("SELECT", err)
+
+	// UID FETCH (CHANGEDSINCE)
+	go t.script(
+		`C: A2 UID FETCH 1:* (FLAGS) (CHANGEDSINCE 12345)`+CRLF,
+		`S: * 1 FETCH (UID 4 MODSEQ (65402) FLAGS (\Seen))`+CRLF,
+		`S: * 2 FETCH (UID 6 MODSEQ (75403) FLAGS (\Deleted))`+CRLF,
+		`S: A2 OK Fetch completed`+CRLF,
+	)
+	cmd, err := Wait(C.UIDFetchSince(newSeqSet("1:*"), 12345, "FLAGS"))
+	t.join("UID FETCH", err)
+	if n := len(cmd.Data); n != 2 {
+		t.Errorf("len(cmd.Data) expected 2; got %v", n)
+	}
+
+	// STORE (UNCHANGEDSINCE) with MODIFIED response code
+	go t.script(
+		`C: A3 STORE 5,7,9 (UNCHANGEDSINCE 320162338) +FLAGS.SILENT (\Deleted)`+CRLF,
+		`S: * 5 FETCH (MODSEQ (320162350))`+CRLF,
+		`S: A3 OK [MODIFIED 7,9] Conditional STORE failed`+CRLF,
+		EOF,
+	)
+	cmd, err = Wait(C.StoreUnchangedSince(newSeqSet("7,5,9"), 320162338, "+FLAGS.SILENT", NewFlagSet(`\Deleted`)))
+	t.join("STORE", err)
+	t.waitEOF()
+	if set := cmd.Modified(); set == nil || set.String() != "7,9" {
+		t.Errorf("cmd.Modified() expected 7,9; got %v", set)
+	}
+}
+
+func TestClientQResync(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 ENABLE CONDSTORE QRESYNC] Test server ready`+CRLF)
+	known := newSeqSet("41,43:211,214:541")
+
+	// QRESYNC must be enabled first
+	if cmd, err := C.SelectResync("INBOX", 67890007, 20050715194045000, known); cmd != nil || err != NotAvailableError("QRESYNC") {
+		t.Fatalf("C.SelectResync() expected NotAvailableError; got %#v (%v)", cmd, err)
+	}
+
+	// ENABLE
+	go t.script(
+		`C: A1 ENABLE QRESYNC`+CRLF,
+		`S: * ENABLED QRESYNC`+CRLF,
+		`S: A1 OK Enabled`+CRLF,
+	)
+	_, err := C.Enable("QRESYNC")
+	t.join("ENABLE", err)
+
+	// SELECT (QRESYNC)
+	go t.script(
+		`C: A2 SELECT "INBOX" (QRESYNC (67890007 20050715194045000 41,43:211,214:541))`+CRLF,
+		`S: * 314 EXISTS`+CRLF,
+		`S: * OK [UIDVALIDITY 67890007] UIDVALIDITY`+CRLF,
+		`S: * OK [UIDNEXT 567] Predicted next UID`+CRLF,
+		`S: * OK [HIGHESTMODSEQ 20050715194045319] Highest`+CRLF,
+		`S: * VANISHED (EARLIER) 41,43:116,118,120:211,214:540`+CRLF,
+		`S: * 49 FETCH (UID 117 FLAGS (\Seen \Answered) MODSEQ (20050715194045001))`+CRLF,
+		`S: * 50 FETCH (UID 119 FLAGS (\Draft $MDNSent) MODSEQ (20050715194045308))`+CRLF,
+		`S: A2 OK [READ-WRITE] mailbox selected`+CRLF,
+	)
+	cmd, err := C.SelectResync("INBOX", 67890007, 20050715194045000, known)
+	t.join("SELECT", err)
+	t.checkState(Selected)
+	if set := cmd.Vanished(); set == nil || set.String() != "41,43:116,118,120:211,214:540" {
+		t.Errorf("cmd.Vanished() expected 41,43:116,118,120:211,214:540; got %v", set)
+	}
+	if flags := cmd.Flags(); len(flags) != 2 || !flags[49][`\Seen`] || !flags[50]["$MDNSent"] {
+		t.Errorf("cmd.Flags() expected 2 changed messages; got %v", flags)
+	}
+	if v := cmd.HighestModSeq(); v != 20050715194045319 {
+		t.Errorf("cmd.HighestModSeq() expected 20050715194045319; got %v", v)
+	}
+	if m := C.Mailbox; m.Messages != 314 || m.HighestModSeq != 20050715194045319 {
+		t.Errorf("C.Mailbox expected 314 messages; got %v", m)
+	}
+
+	// Unsolicited VANISHED
+	go t.script(
+		`C: A3 NOOP`+CRLF,
+		`S: * VANISHED 405,407`+CRLF,
+		`S: A3 OK NOOP completed`+CRLF,
+		EOF,
+	)
+	_, err = Wait(C.Noop())
+	t.join("NOOP", err)
+	if n := C.Mailbox.Messages; n != 312 {
+		t.Errorf("C.Mailbox.Messages expected 312; got %v", n)
+	}
+	if n := len(C.Data); n == 0 || C.Data[n-1].DataType() != DataVanished {
+		t.Fatalf("C.Data expected VANISHED; got %v", C.Data)
+	}
+	if uids, earlier := C.Data[len(C.Data)-1].Vanished(); earlier || uids.String() != "405,407" {
+		t.Errorf("Vanished() expected 405,407; got %v (%v)", uids, earlier)
+	}
+	t.waitEOF()
+}
+
+func TestClientVanished(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 ENABLE CONDSTORE QRESYNC UIDPLUS] Test server ready`+CRLF)
+
+	// VANISHED modifier requires QRESYNC
+	if cmd, err := C.UIDFetchVanished(newSeqSet("1:*"), 1, "FLAGS"); cmd != nil || err != NotAvailableError("QRESYNC") {
+		t.Fatalf("C.UIDFetchVanished() expected NotAvailableError; got %#v (%v)", cmd, err)
+	}
+
+	// ENABLE and SELECT
+	go t.script(
+		`C: A1 ENABLE QRESYNC`+CRLF,
+		`S: * ENABLED QRESYNC`+CRLF,
+		`S: A1 OK Enabled`+CRLF,
+		`C: A2 SELECT "INBOX"`+CRLF,
+		`S: * 10 EXISTS`+CRLF,
+		`S: A2 OK [READ-WRITE] Ok`+CRLF,
+	)
+	_, err := C.Enable("QRESYNC")
+	if err == nil {
+		_, err = C.Select("INBOX", false)
+	}
+	t.join("SELECT", err)
+
+	var updates []*Response
+	C.SetUpdateHandler(func(rsp *Response) bool {
+		updates = append(updates, rsp)
+		return true
+	})
+
+	// UID FETCH (CHANGEDSINCE VANISHED) with an unsolicited expunge
+	go t.script(
+		`C: A3 UID FETCH 300:500 (FLAGS) (CHANGEDSINCE 12345 VANISHED)`+CRLF,
+		`S: * VANISHED (EARLIER) 300:310,405,411`+CRLF,
+		`S: * 1 FETCH (UID 404 MODSEQ (65402) FLAGS (\Seen))`+CRLF,
+		`S: * VANISHED 420`+CRLF,
+		`S: A3 OK Fetch completed`+CRLF,
+	)
+	cmd, err := Wait(C.UIDFetchVanished(newSeqSet("300:500"), 12345, "FLAGS"))
+	t.join("UID FETCH", err)
+	if set := cmd.Vanished(); set == nil || set.String() != "300:310,405,411" {
+		t.Errorf("cmd.Vanished() expected 300:310,405,411; got %v", set)
+	}
+	if n := len(cmd.Data); n != 2 {
+		t.Errorf("len(cmd.Data) expected 2; got %v", n)
+	}
+	if len(updates) != 1 {
+		t.Fatalf("updates expected 1 response; got %v", updates)
+	}
+	if uids, earlier := updates[0].Vanished(); earlier || uids.String() != "420" {
+		t.Errorf("updates[0].Vanished() expected 420; got %v (%v)", uids, earlier)
+	}
+	if n := C.Mailbox.Messages; n != 9 {
+		t.Errorf("C.Mailbox.Messages expected 9; got %v", n)
+	}
+
+	// UID EXPUNGE
+	go t.script(
+		`C: A4 UID EXPUNGE 3000:3002`+CRLF,
+		`S: * VANISHED 3000:3002`+CRLF,
+		`S: A4 OK UID EXPUNGE completed`+CRLF,
+		EOF,
+	)
+	cmd, err = Wait(C.UIDExpunge(newSeqSet("3000:3002")))
+	t.join("UID EXPUNGE", err)
+	if set := cmd.Vanished(); set == nil || set.String() != "3000:3002" {
+		t.Errorf("cmd.Vanished() expected 3000:3002; got %v", set)
+	}
+	if n := C.Mailbox.Messages; n != 6 {
+		t.Errorf("C.Mailbox.Messages expected 6; got %v", n)
+	}
+	if len(updates) != 1 {
+		t.Errorf("updates expected 1 response; got %v", updates)
+	}
+	t.waitEOF()
+}
+
+func TestClientFlags(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	go t.script(
+		`C: A1 SELECT "INBOX"`+CRLF,
+		`S: * 10 EXISTS`+CRLF,
+		`S: A1 OK [READ-WRITE] Ok`+CRLF,
+	)
+	_, err := C.Select("INBOX", false)
+	t.join("SELECT", err)
+
+	for _, flag := range []string{`\Recent`, "recent", "", `\`, "a b", "(x)", "ü"} {
+		if cmd, err := C.AddFlags(newSeqSet("1"), "Seen", flag); cmd != nil || err == nil {
+			t.Fatalf("C.AddFlags(%q) expected error; got %#v (%v)", flag, cmd, err)
+		}
+	}
+
+	go t.script(
+		`C: A2 STORE 1:2 +FLAGS (\Seen \Flagged $Forwarded)`+CRLF,
+		`S: * 1 FETCH (FLAGS (\Seen \Flagged $Forwarded))`+CRLF,
+		`S: * 2 FETCH (FLAGS (\Seen \Flagged \Deleted $Forwarded))`+CRLF,
+		`S: * 3 FETCH (UID 42)`+CRLF,
+		`S: A2 OK STORE completed`+CRLF,
+	)
+	cmd, err := Wait(C.AddFlags(newSeqSet("1:2"), "seen", `\FLAGGED`, "$Forwarded"))
+	t.join("STORE", err)
+	flags := cmd.Flags()
+	want := map[uint32]FlagSet{
+		1: NewFlagSet(Seen, Flagged, "$Forwarded"),
+		2: NewFlagSet(Seen, Flagged, Deleted, "$Forwarded"),
+	}
+	if !reflect.DeepEqual(flags, want) {
+		t.Errorf("cmd.Flags() expected %v; got %v", want, flags)
+	}
+
+	go t.script(
+		`C: A3 STORE 3 -FLAGS.SILENT (\Deleted)`+CRLF,
+		`S: A3 OK STORE completed`+CRLF,
+	)
+	cmd, err = Wait(C.RemoveFlagsSilent(newSeqSet("3"), Deleted))
+	t.join("STORE", err)
+	if n := len(cmd.Flags()); n != 0 {
+		t.Errorf("len(cmd.Flags()) expected 0; got %d", n)
+	}
+
+	go t.script(
+		`C: A4 UID STORE 40:42 FLAGS (\Answered)`+CRLF,
+		`S: * 2 FETCH (UID 41 FLAGS (\Answered))`+CRLF,
+		`S: * 3 FETCH (FLAGS (\Answered) UID 42)`+CRLF,
+		`S: A4 OK STORE completed`+CRLF,
+		EOF,
+	)
+	cmd, err = Wait(C.UIDSetFlags(newSeqSet("40:42"), "Answered"))
+	t.join("UID STORE", err)
+	t.waitEOF()
+	want = map[uint32]FlagSet{41: NewFlagSet(Answered), 42: NewFlagSet(Answered)}
+	if flags = cmd.Flags(); !reflect.DeepEqual(flags, want) {
+		t.Errorf("cmd.Flags() expected %v; got %v", want, flags)
+	}
+}
+
+func TestClientSendAuto(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	go t.script(
+		`C: A1 LIST "" ("~/Mail/foo bar" Drafts "nil" "\"%\"" {4}`+CRLF,
+		`S: + Ready for additional command text`+CRLF,
+		`C: a`+CRLF+`b`,
+		`C: ) {5}`+CRLF,
+		`S: + Ready for additional command text`+CRLF,
+		`C: ünï`,
+		`C: `+CRLF,
+		`S: A1 OK LIST completed`+CRLF,
+		EOF,
+	)
+	_, err := Wait(C.SendAuto("LIST", "", []Field{"~/Mail/foo bar", []byte("Drafts"), "nil", `"%"`, "a\r\nb"}, "ünï"))
+	t.join("LIST", err)
+	t.waitEOF()
+}
+
+func TestClientCapability(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * OK [CAPABILITY IMAP4rev1 AUTH=PLAIN auth=cram-md5 THREAD=REFERENCES] Ready`+CRLF)
+
+	if !C.HasCap("imap4rev1") || !C.HasCap("Auth=Plain") || C.HasCap("AUTH") {
+		t.Errorf("C.HasCap() returned incorrect results for %v", C.Caps)
+	}
+	if want := []string{"CRAM-MD5", "PLAIN"}; !reflect.DeepEqual(C.AuthMechs(), want) {
+		t.Errorf("C.AuthMechs() expected %q; got %q", want, C.AuthMechs())
+	}
+	if want := []string{"REFERENCES"}; !reflect.DeepEqual(C.ThreadAlgorithms(), want) {
+		t.Errorf("C.ThreadAlgorithms() expected %q; got %q", want, C.ThreadAlgorithms())
+	}
+
+	// CAPABILITY rebuilds the set
+	go t.script(
+		`C: A1 CAPABILITY`+CRLF,
+		`S: * CAPABILITY IMAP4rev1 THREAD=ORDEREDSUBJECT THREAD=REFERENCES CONTEXT=SEARCH`+CRLF,
+		`S: A1 OK Done`+CRLF,
+		EOF,
+	)
+	_, err := C.Capability()
+	t.join("CAPABILITY", err)
+
+	if mechs := C.AuthMechs(); len(mechs) != 0 {
+		t.Errorf("C.AuthMechs() expected none; got %q", mechs)
+	}
+	if want := []string{"ORDEREDSUBJECT", "REFERENCES"}; !reflect.DeepEqual(C.ThreadAlgorithms(), want) {
+		t.Errorf("C.ThreadAlgorithms() expected %q; got %q", want, C.ThreadAlgorithms())
+	}
+	if want := []string{"SEARCH"}; !reflect.DeepEqual(C.CapValues("context"), want) {
+		t.Errorf("C.CapValues() expected %q; got %q", want, C.CapValues("context"))
+	}
+	t.waitEOF()
+}
+
+func TestClientCreateSpecial(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	if cmd, err := C.CreateSpecial("Archive", `\Archive`); cmd != nil || err == nil {
+		t.Fatalf("C.CreateSpecial() expected error; got %#v (%v)", cmd, err)
+	}
+	C.Caps["CREATE-SPECIAL-USE"] = true
+	for _, uses := range [][]string{nil, {`\Archive`, `\Inbox`}, {"Sent"}} {
+		if cmd, err := C.CreateSpecial("X", uses...); cmd != nil || err == nil {
+			t.Fatalf("C.CreateSpecial(%q) expected error; got %#v (%v)", uses, cmd, err)
+		}
+	}
+
+	go t.script(
+		`C: A1 CREATE "Archive" (USE (\Archive \Flagged))`+CRLF,
+		`S: A1 OK MySpecial created`+CRLF,
+	)
+	_, err := C.CreateSpecial("Archive", `\archive`, `\FLAGGED`)
+	t.join("CREATE", err)
+
+	go t.script(
+		`C: A2 CREATE "Drafts" (USE (\Drafts))`+CRLF,
+		`S: A2 NO [USEATTR] \Drafts not supported`+CRLF,
+		EOF,
+	)
+	_, err = C.CreateSpecial("Drafts", `\Drafts`)
+	t.waitEOF()
+	if e, ok := err.(*UseAttrError); !ok || e.Info != `\Drafts not supported` {
+		t.Errorf("C.CreateSpecial() expected UseAttrError; got %v", err)
+	}
+}
+
+func TestClientGmail(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	if cmd, err := C.AddLabels(newSeqSet("1"), "x"); cmd != nil || err != NotAvailableError("X-GM-EXT-1") {
+		t.Fatalf("C.AddLabels() expected NotAvailableError; got %#v (%v)", cmd, err)
+	}
+	if cmd, err := C.Search(new(SearchCriteria).GmailRaw("x").Build()...); cmd != nil || err != NotAvailableError("X-GM-EXT-1") {
+		t.Fatalf("C.Search() expected NotAvailableError; got %#v (%v)", cmd, err)
+	}
+	C.Caps["X-GM-EXT-1"] = true
+
+	go t.script(
+		`C: A1 SELECT "INBOX"`+CRLF,
+		`S: * 10 EXISTS`+CRLF,
+		`S: A1 OK [READ-WRITE] Ok`+CRLF,
+	)
+	_, err := C.Select("INBOX", false)
+	t.join("SELECT", err)
+
+	items := Items().GmailMsgID().GmailThreadID().GmailLabels()
+	go t.script(
+		`C: A2 FETCH 1 (X-GM-MSGID X-GM-THRID X-GM-LABELS)`+CRLF,
+		`S: * 1 FETCH (X-GM-MSGID 1278455344230334865 X-GM-THRID 1266894439832287888 X-GM-LABELS (\Inbox "Custom Label" "Entw&APw-rfe"))`+CRLF,
+		`S: A2 OK FETCH completed`+CRLF,
+	)
+	cmd, err := Wait(C.Fetch(newSeqSet("1"), items.Build()...))
+	t.join("FETCH", err)
+	info := cmd.Data[0].MessageInfo()
+	if info.GmailMsgID != 1278455344230334865 || info.GmailThreadID != 1266894439832287888 {
+		t.Errorf("info expected Gmail IDs; got %v %v", info.GmailMsgID, info.GmailThreadID)
+	}
+	if want := []string{`\Inbox`, "Custom Label", "Entwürfe"}; !reflect.DeepEqual(info.GmailLabels, want) {
+		t.Errorf("info.GmailLabels expected %q; got %q", want, info.GmailLabels)
+	}
+
+	go t.script(
+		`C: A3 STORE 1 +X-GM-LABELS (\Starred "Custom Label" "Entw&APw-rfe")`+CRLF,
+		`S: * 1 FETCH (X-GM-LABELS (\Inbox \Starred "Custom Label" "Entw&APw-rfe"))`+CRLF,
+		`S: A3 OK STORE completed`+CRLF,
+		`C: A4 UID STORE 42 -X-GM-LABELS (\Inbox)`+CRLF,
+		`S: * 1 FETCH (UID 42 X-GM-LABELS ())`+CRLF,
+		`S: A4 OK STORE completed`+CRLF,
+	)
+	_, err = Wait(C.AddLabels(newSeqSet("1"), `\Starred`, "Custom Label", "Entwürfe"))
+	if err == nil {
+		cmd, err = Wait(C.UIDRemoveLabels(newSeqSet("42"), `\Inbox`))
+	}
+	t.join("STORE", err)
+
+	// X-GM-RAW with a UTF-8 literal
+	var s SearchCriteria
+	s.GmailRaw("has:attachment larger:5M").Not(new(SearchCriteria).GmailRaw("Grüße"))
+	go t.script(
+		`C: A5 SEARCH CHARSET UTF-8 X-GM-RAW "has:attachment larger:5M" NOT (X-GM-RAW {7}`+CRLF,
+		`S: + Ready`+CRLF,
+		`C: Grüße)`+CRLF,
+		`S: * SEARCH 2 3`+CRLF,
+		`S: A5 OK SEARCH completed`+CRLF,
+		EOF,
+	)
+	cmd2, err := Wait(C.Search(s.Build()...))
+	t.join("SEARCH", err)
+	t.waitEOF()
+	if v := cmd2.SearchSet().String(); v != "2:3" {
+		t.Errorf("cmd.SearchSet() expected 2:3; got %v", v)
+	}
+	if labels := cmd.Data[0].MessageInfo().GmailLabels; labels == nil || len(labels) != 0 {
+		t.Errorf("info.GmailLabels expected empty; got %q", labels)
+	}
+}
+
+func TestClientGmailMove(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 X-GM-EXT-1] Test server ready`+CRLF)
+
+	go t.script(
+		`C: A1 SELECT "[Gmail]/All Mail"`+CRLF,
+		`S: * 10 EXISTS`+CRLF,
+		`S: A1 OK [READ-WRITE] Ok`+CRLF,
+	)
+	_, err := C.Select("[Gmail]/All Mail", false)
+	t.join("SELECT", err)
+
+	go t.script(
+		`C: A2 UID STORE 42,44 +X-GM-LABELS ("Work/Done")`+CRLF,
+		`S: * 1 FETCH (UID 42 X-GM-LABELS (\Inbox \Starred "Work/Done"))`+CRLF,
+		`S: * 3 FETCH (UID 44 X-GM-LABELS (\Inbox "Work/Done"))`+CRLF,
+		`S: A2 OK STORE completed`+CRLF,
+		`C: A3 UID STORE 42,44 -X-GM-LABELS (\Inbox)`+CRLF,
+		`S: * 1 FETCH (UID 42 X-GM-LABELS (\Starred "Work/Done"))`+CRLF,
+		`S: * 3 FETCH (UID 44 X-GM-LABELS ("Work/Done"))`+CRLF,
+		`S: A3 OK STORE completed`+CRLF,
+	)
+	cmd, err := C.GmailMove(newSeqSet("42,44"), `\Inbox`, "Work/Done")
+	t.join("STORE", err)
+	if labels := cmd.Data[0].MessageInfo().GmailLabels; !reflect.DeepEqual(labels, []string{`\Starred`, "Work/Done"}) {
+		t.Errorf("info.GmailLabels expected [\\Starred Work/Done]; got %q", labels)
+	}
+
+	// Failed removal leaves both labels
+	go t.script(
+		`C: A4 UID STORE 45 +X-GM-LABELS (\Inbox)`+CRLF,
+		`S: A4 OK STORE completed`+CRLF,
+		`C: A5 UID STORE 45 -X-GM-LABELS ("Archive")`+CRLF,
+		`S: A5 NO STORE failed`+CRLF,
+		EOF,
+	)
+	if cmd, err = C.GmailMove(newSeqSet("45"), "Archive", `\Inbox`); err == nil || cmd.Tag() != "A5" {
+		t.Fatalf("C.GmailMove() expected A5 error; got %v (%v)", cmd, err)
+	}
+	t.join("STORE", nil)
+	t.waitEOF()
+}
+
+func TestClientObjectID(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	items := Items().UID().EmailID().ThreadID().Build()
+	if cmd, err := C.UIDFetch(newSeqSet("1"), items...); cmd != nil || err != NotAvailableError("OBJECTID") {
+		t.Fatalf("C.UIDFetch() expected NotAvailableError; got %#v (%v)", cmd, err)
+	}
+	C.Caps["OBJECTID"] = true
+
+	go t.script(
+		`C: A1 SELECT "INBOX"`+CRLF,
+		`S: * 10 EXISTS`+CRLF,
+		`S: A1 OK [READ-WRITE] Ok`+CRLF,
+		`C: A2 UID FETCH 25:26 (UID EMAILID THREADID)`+CRLF,
+		`S: * 1 FETCH (UID 25 EMAILID (M6d99ac3275bb4e) THREADID (T64b478a75b7ea9))`+CRLF,
+		`S: * 2 FETCH (UID 26 EMAILID (0042) THREADID NIL)`+CRLF,
+		`S: A2 OK FETCH completed`+CRLF,
+		EOF,
+	)
+	_, err := C.Select("INBOX", false)
+	var cmd *Command
+	if err == nil {
+		cmd, err = Wait(C.UIDFetch(newSeqSet("25:26"), items...))
+	}
+	t.join("FETCH", err)
+	t.waitEOF()
+
+	want := [][2]string{{"M6d99ac3275bb4e", "T64b478a75b7ea9"}, {"0042", ""}}
+	for i, rsp := range cmd.Data {
+		info := rsp.MessageInfo()
+		if v := [2]string{info.EmailID, info.ThreadID}; v != want[i] {
+			t.Errorf("info[%d] expected %q; got %q", i, want[i], v)
+		}
+	}
+}
+
+func TestClientEnable(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	// ENABLE should fail when the capability is not advertised
+	if cmd, err := C.Enable("CONDSTORE"); cmd != nil || err == nil {
+		t.Fatalf("C.Enable() expected error; got %#v (%v)", cmd, err)
+	}
+	C.Caps["ENABLE"] = true
+
+	// Only a subset of the requested extensions is enabled
+	go t.script(
+		`C: A1 ENABLE CONDSTORE X-GOOD-IDEA QRESYNC`+CRLF,
+		`S: * ENABLED x-good-idea CONDSTORE`+CRLF,
+		`S: A1 OK Enabled`+CRLF,
+		EOF,
+	)
+	cmd, err := C.Enable("CONDSTORE", "X-GOOD-IDEA", "QRESYNC")
+	t.join("ENABLE", err)
+	t.waitEOF()
+
+	if n := len(cmd.Data); n != 1 {
+		t.Errorf("len(cmd.Data) expected 1; got %v", n)
+	}
+	want := map[string]bool{"CONDSTORE": true, "X-GOOD-IDEA": true}
+	if !reflect.DeepEqual(C.Enabled, want) {
+		t.Errorf("C.Enabled expected %v; got %v", want, C.Enabled)
+	}
+}
+
+func TestClientID(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * OK [CAPABILITY IMAP4rev1 ID] Test server ready`+CRLF)
+
+	if cmd, err := C.ID("name"); cmd != nil || err == nil {
+		t.Fatalf("C.ID() expected error; got %#v (%v)", cmd, err)
+	}
+
+	// ID before authentication
+	go t.script(
+		`C: A1 ID ("name" "go-imap" "version" "1.0")`+CRLF,
+		`S: * ID ("name" "Cyrus" "version" "1.5")`+CRLF,
+		`S: A1 OK Success`+CRLF,
+	)
+	cmd, err := Wait(C.ID("name", "go-imap", "version", "1.0"))
+	t.join("ID", err)
+	if n := len(cmd.Data); n != 1 {
+		t.Fatalf("len(cmd.Data) expected 1; got %v", n)
+	}
+	want := map[string]string{"name": "Cyrus", "version": "1.5"}
+	if v := cmd.Data[0].ServerID(); !reflect.DeepEqual(v, want) {
+		t.Errorf("ServerID() expected %v; got %v", want, v)
+	}
+
+	// Empty ID
+	go t.script(
+		`C: A2 ID NIL`+CRLF,
+		`S: * ID NIL`+CRLF,
+		`S: A2 OK Success`+CRLF,
+		EOF,
+	)
+	cmd, err = Wait(C.ID())
+	t.join("ID", err)
+	t.waitEOF()
+	if v := cmd.Data[0].ServerID(); v == nil || len(v) != 0 {
+		t.Errorf("ServerID() expected empty map; got %v", v)
+	}
+}
+
+func TestClientStats(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	greeting := `* PREAUTH [CAPABILITY IMAP4rev1] Test server ready` + CRLF
+	C, t := newClient(T, `S: `+greeting)
+	if s := C.Stats(); s.Commands != 0 || s.BytesSent != 0 || s.BytesRecv != int64(len(greeting)) {
+		t.Fatalf("C.Stats() expected only the greeting; got %+v", s)
+	}
+
+	// Unilateral data is not counted for the command
+	go t.script(
+		`C: A1 NOOP`+CRLF,
+		`S: * 3 EXISTS`+CRLF,
+		`S: A1 OK NOOP completed`+CRLF,
+	)
+	cmd, err := Wait(C.Noop())
+	t.join("NOOP", err)
+	if sent, recv := cmd.Bytes(); sent != 9 || recv != 22 {
+		t.Errorf("cmd.Bytes() expected 9, 22; got %v, %v", sent, recv)
+	}
+	if cmd.Elapsed() <= 0 {
+		t.Errorf("cmd.Elapsed() expected > 0; got %v", cmd.Elapsed())
+	}
+
+	// Literals, continuation requests, and command data are counted
+	go t.script(
+		`C: A2 APPEND "INBOX" {5}`+CRLF,
+		`S: + Ready`+CRLF,
+		`C: hello`+CRLF,
+		`S: * 4 EXISTS`+CRLF,
+		`S: A2 OK APPEND completed`+CRLF,
+	)
+	cmd, err = Wait(C.Append("INBOX", nil, nil, NewLiteral([]byte("hello"))))
+	t.join("APPEND", err)
+	if sent, recv := cmd.Bytes(); sent != 30 || recv != 33 {
+		t.Errorf("cmd.Bytes() expected 30, 33; got %v, %v", sent, recv)
+	}
+
+	go t.script(
+		`C: A3 LIST "" "*"`+CRLF,
+		`S: * LIST () "/" {5}`+CRLF,
+		`S: INBOX`+CRLF,
+		`S: A3 OK LIST completed`+CRLF,
+		EOF,
+	)
+	cmd, err = Wait(C.List("", "*"))
+	t.join("LIST", err)
+	t.waitEOF()
+	if sent, recv := cmd.Bytes(); sent != 16 || recv != 48 {
+		t.Errorf("cmd.Bytes() expected 16, 48; got %v, %v", sent, recv)
+	}
+
+	s := C.Stats()
+	if s.Commands != 3 || s.BytesSent != 9+30+16 || s.BytesRecv != int64(len(greeting))+22+12+33+12+48 {
+		t.Errorf("C.Stats() expected 3 commands; got %+v", s)
+	}
+	if s.Elapsed < cmd.Elapsed() {
+		t.Errorf("C.Stats().Elapsed expected >= %v; got %v", cmd.Elapsed(), s.Elapsed)
+	}
+}
+
+// intervalLimiter is a RateLimiter that allows one command per interval.
+type intervalLimiter struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func (l *intervalLimiter) Wait(ctx context.Context) error {
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	if d, ok := ctx.Deadline(); ok && d.Before(l.next) {
+		return errors.New("wait would exceed context deadline")
+	}
+	select {
+	case <-time.After(l.next.Sub(now)):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	l.next = l.next.Add(l.interval)
+	return nil
+}
+
+func TestClientRateLimit(T *testing.T) {
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+	interval := 40 * time.Millisecond
+	C.SetRateLimit(&intervalLimiter{interval: interval})
+
+	// First command uses the burst, second one waits for a new token
+	go t.script(
+		`C: A1 NOOP`+CRLF,
+		`S: A1 OK NOOP completed`+CRLF,
+		`C: A2 NOOP`+CRLF,
+		`S: A2 OK NOOP completed`+CRLF,
+	)
+	start := time.Now()
+	_, err := Wait(C.Noop())
+	if err == nil {
+		_, err = Wait(C.Noop())
+	}
+	t.join("NOOP", err)
+	if d := time.Since(start); d < interval*8/10 {
+		t.Errorf("C.Noop() expected to be delayed by %v; got %v", interval, d)
+	}
+
+	// Context deadline expires before the next token
+	ctx, cancel := context.WithTimeout(context.Background(), interval/4)
+	cmd, err := C.SendContext(ctx, "NOOP")
+	cancel()
+	if cmd != nil || err == nil {
+		t.Fatalf("C.SendContext() expected an error; got %#v (%v)", cmd, err)
+	}
+
+	// Canceled context
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if cmd, err = C.SendContext(ctx, "NOOP"); cmd != nil || err != context.Canceled {
+		t.Fatalf("C.SendContext() expected context.Canceled; got %#v (%v)", cmd, err)
+	}
+
+	// Disabled limiter
+	C.SetRateLimit(nil)
+	go t.script(
+		`C: A3 NOOP`+CRLF,
+		`S: A3 OK NOOP completed`+CRLF,
+		`C: A4 LOGOUT`+CRLF,
+		`S: * BYE LOGOUT Requested`+CRLF,
+		`S: A4 OK Quit`+CRLF,
+		EOF,
+	)
+	start = time.Now()
+	_, err = Wait(C.Noop())
+	if err == nil {
+		_, err = C.Logout(-1)
+	}
+	t.join("LOGOUT", err)
+	t.waitEOF()
+	if d := time.Since(start); d >= interval/2 {
+		t.Errorf("C.Noop() expected no delay; got %v", d)
+	}
+}
+
+func TestClientLiteralPlus(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 LITERAL+] Test server ready`+CRLF)
+
+	// Unexpected continuation requests are ignored
+	go t.script(
+		`C: A1 APPEND "INBOX" {5+}`+CRLF,
+		`C: hello`+CRLF,
+		`S: + Ready for literal data`+CRLF,
+		`S: A1 OK APPEND completed`+CRLF,
+	)
+	_, err := Wait(C.Append("INBOX", nil, nil, NewLiteral([]byte("hello"))))
+	t.join("APPEND", err)
+
+	go t.script(
+		`C: A2 RENAME {4+}`+CRLF,
+		`C: user {4+}`+CRLF,
+		`S: + Ready for literal data`+CRLF,
+		`C: pass`+CRLF,
+		`S: + Ready for literal data`+CRLF,
+		`S: A2 OK RENAME completed`+CRLF,
+		EOF,
+	)
+	_, err = Wait(C.Send("RENAME", NewLiteral([]byte("user")), NewLiteral([]byte("pass"))))
+	t.join("RENAME", err)
+	t.waitEOF()
+}
+
+func TestClientLiteralMinus(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 LITERAL-] Test server ready`+CRLF)
+
+	small := strings.Repeat("x", 4096)
+	go t.script(
+		`C: A1 APPEND "INBOX" {4096+}`+CRLF,
+		`C: `+small+CRLF,
+		`S: A1 OK APPEND completed`+CRLF,
+	)
+	_, err := Wait(C.Append("INBOX", nil, nil, NewLiteral([]byte(small))))
+	t.join("APPEND", err)
+
+	large := small + "y"
+	go t.script(
+		`C: A2 APPEND "INBOX" {4097}`+CRLF,
+		`S: + Ready for literal data`+CRLF,
+		`C: `+large+CRLF,
+		`S: A2 OK APPEND completed`+CRLF,
+		EOF,
+	)
+	_, err = Wait(C.Append("INBOX", nil, nil, NewLiteral([]byte(large))))
+	t.join("APPEND", err)
+	t.waitEOF()
+}
+
+func TestClientLiteral8(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+	msg := NewLiteral8([]byte("To: joe\r\n\r\n\x00\xFF"))
+
+	// literal8 requires BINARY capability (the tag is still consumed)
+	if cmd, err := C.Append("INBOX", nil, nil, msg); cmd != nil || err != NotAvailableError("BINARY") {
+		t.Fatalf("C.Append() expected NotAvailableError; got %#v (%v)", cmd, err)
+	}
+	C.Caps["BINARY"] = true
+
+	// Synchronizing literal8
+	go t.script(
+		`C: A2 APPEND "INBOX" ~{13}`+CRLF,
+		`S: + Ready for literal data`+CRLF,
+		`C: To: joe`+CRLF,
+		`C: `+CRLF,
+		"C: \x00\xFF",
+		`C: `+CRLF,
+		`S: A2 OK APPEND completed`+CRLF,
+	)
+	_, err := Wait(C.Append("INBOX", nil, nil, msg))
+	t.join("APPEND", err)
+
+	// Non-synchronizing literal8
+	C.Caps["LITERAL+"] = true
+	go t.script(
+		`C: A3 APPEND "INBOX" ~{13+}`+CRLF,
+		`C: To: joe`+CRLF,
+		`C: `+CRLF,
+		"C: \x00\xFF",
+		`C: `+CRLF,
+		`S: A3 OK APPEND completed`+CRLF,
+	)
+	_, err = Wait(C.Append("INBOX", nil, nil, msg))
+	t.join("APPEND", err)
+
+	// Incoming literal8 mixed with a regular literal
+	go t.script(
+		`C: A4 SELECT "INBOX"`+CRLF,
+		`S: * 1 EXISTS`+CRLF,
+		`S: A4 OK [READ-WRITE] Ok`+CRLF,
+		`C: A5 FETCH 1 (BINARY.PEEK[] BODY.PEEK[HEADER])`+CRLF,
+		`S: * 1 FETCH (BINARY[] ~{13}`+CRLF,
+		`S: To: joe`+CRLF,
+		`S: `+CRLF,
+		"S: \x00\xFF",
+		`S:  BODY[HEADER] {11}`+CRLF,
+		`S: To: joe`+CRLF,
+		`S: `+CRLF,
+		`S: )`+CRLF,
+		`S: A5 OK Fetch completed`+CRLF,
+		EOF,
+	)
+	_, err = C.Select("INBOX", false)
+	var cmd *Command
+	if err == nil {
+		cmd, err = Wait(C.Fetch(newSeqSet("1"), "BINARY.PEEK[]", "BODY.PEEK[HEADER]"))
+	}
+	t.join("FETCH", err)
+	t.waitEOF()
+	info := cmd.Data[0].MessageInfo()
+	if l, ok := info.Attrs["BINARY[]"].(Literal); !ok || !l.Info().Bin {
+		t.Errorf("BINARY[] expected literal8; got %#v", info.Attrs["BINARY[]"])
+	} else if v := info.Binary(""); string(v) != "To: joe\r\n\r\n\x00\xFF" {
+		t.Errorf("Binary() expected 8-bit data; got %q", v)
+	}
+	if l, ok := info.Attrs["BODY[HEADER]"].(Literal); !ok || l.Info().Bin {
+		t.Errorf("BODY[HEADER] expected literal; got %#v", info.Attrs["BODY[HEADER]"])
+	}
+}
+
+func TestClientACL(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	if cmd, err := C.GetACL("INBOX"); cmd != nil || err == nil {
+		t.Fatalf("C.GetACL() expected error; got %#v (%v)", cmd, err)
+	}
+	C.Caps["ACL"] = true
+	for _, rights := range []string{"lrs-", "+-l", "LR", "l r", "*"} {
+		if cmd, err := C.SetACL("INBOX", "fred", rights); cmd != nil || err == nil {
+			t.Fatalf("C.SetACL(%q) expected error; got %#v (%v)", rights, cmd, err)
+		}
+	}
+
+	go t.script(
+		`C: A1 SETACL "INBOX" "fred" "+lrs"`+CRLF,
+		`S: A1 OK SETACL completed`+CRLF,
+	)
+	_, err := Wait(C.SetACL("INBOX", "fred", "+lrs"))
+	t.join("SETACL", err)
+
+	go t.script(
+		`C: A2 GETACL "INBOX"`+CRLF,
+		`S: * ACL INBOX fred lrswipkxtecda "Other Users" lr`+CRLF,
+		`S: A2 OK GETACL completed`+CRLF,
+	)
+	cmd, err := Wait(C.GetACL("INBOX"))
+	t.join("GETACL", err)
+	mbox, acl := cmd.Data[0].ACL()
+	want := map[string]string{"fred": "lrswipkxtecda", "Other Users": "lr"}
+	if mbox != "INBOX" || !reflect.DeepEqual(acl, want) {
+		t.Errorf("rsp.ACL() expected INBOX %v; got %q %v", want, mbox, acl)
+	}
+
+	go t.script(
+		`C: A3 DELETEACL "INBOX" "fred"`+CRLF,
+		`S: A3 OK DELETEACL completed`+CRLF,
+	)
+	_, err = Wait(C.DeleteACL("INBOX", "fred"))
+	t.join("DELETEACL", err)
+
+	go t.script(
+		`C: A4 MYRIGHTS "INBOX"`+CRLF,
+		`S: * MYRIGHTS INBOX rwiptsldaex`+CRLF,
+		`S: A4 OK MYRIGHTS completed`+CRLF,
+		EOF,
+	)
+	cmd, err = Wait(C.MyRights("INBOX"))
+	t.join("MYRIGHTS", err)
+	t.waitEOF()
+	if mbox, rights := cmd.Data[0].MyRights(); mbox != "INBOX" || rights != "rwiptsldaex" {
+		t.Errorf("rsp.MyRights() expected INBOX rwiptsldaex; got %q %q", mbox, rights)
+	}
+}
+
+func TestClientMetadata(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 METADATA-SERVER] Test server ready`+CRLF)
+
+	if cmd, err := C.GetMetadata("INBOX", nil, "/private/comment"); cmd != nil || err == nil {
+		t.Fatalf("C.GetMetadata() expected error; got %#v (%v)", cmd, err)
+	}
+	go t.script(
+		`C: A1 GETMETADATA "" "/shared/admin"`+CRLF,
+		`S: * METADATA "" (/shared/admin "mailto:admin@example.com")`+CRLF,
+		`S: A1 OK GETMETADATA complete`+CRLF,
+	)
+	cmd, err := Wait(C.GetMetadata("", nil, "/shared/admin"))
+	t.join("GETMETADATA", err)
+	mbox, entries := cmd.Data[0].Metadata()
+	if v := entries["/shared/admin"]; mbox != "" || v == nil || *v != "mailto:admin@example.com" {
+		t.Errorf("rsp.Metadata() unexpected result %q %v", mbox, entries)
+	}
+
+	C.Caps["METADATA"] = true
+	for _, opts := range []map[string]uint32{{"DEPTH": 2}, {"XYZZY": 1}} {
+		if cmd, err := C.GetMetadata("INBOX", opts, "/private/comment"); cmd != nil || err == nil {
+			t.Fatalf("C.GetMetadata(%v) expected error; got %#v (%v)", opts, cmd, err)
+		}
+	}
+	go t.script(
+		`C: A2 GETMETADATA (MAXSIZE 1024 DEPTH infinity) "INBOX" ("/private/comment" "/shared/comment")`+CRLF,
+		`S: * METADATA INBOX (/private/comment "My comment" /shared/comment NIL /private/comment/bin {3}`+CRLF,
+		`S: a`+"\x00"+`b)`,
+		`S: `+CRLF,
+		`S: A2 OK [METADATA LONGENTRIES 2199] GETMETADATA complete`+CRLF,
+	)
+	opts := map[string]uint32{"depth": MetadataInfinity, "MAXSIZE": 1024}
+	cmd, err = Wait(C.GetMetadata("INBOX", opts, "/private/comment", "/shared/comment"))
+	t.join("GETMETADATA", err)
+	mbox, entries = cmd.Data[0].Metadata()
+	if mbox != "INBOX" || len(entries) != 3 || entries["/shared/comment"] != nil {
+		t.Errorf("rsp.Metadata() unexpected result %q %v", mbox, entries)
+	} else if v := entries["/private/comment"]; v == nil || *v != "My comment" {
+		t.Errorf("rsp.Metadata() expected comment; got %v", v)
+	} else if v := entries["/private/comment/bin"]; v == nil || *v != "a\x00b" {
+		t.Errorf("rsp.Metadata() expected binary value; got %v", v)
+	}
+
+	comment, bin := "New comment", "x\x00y"
+	go t.script(
+		`C: A3 SETMETADATA "INBOX" ("/private/comment" "New comment" "/private/comment/bin" {3}`+CRLF,
+		`S: + Ready for literal data`+CRLF,
+		`C: x`+"\x00"+`y`,
+		`C:  "/shared/comment" NIL)`+CRLF,
+		`S: A3 OK SETMETADATA complete`+CRLF,
+		EOF,
+	)
+	_, err = Wait(C.SetMetadata("INBOX", map[string]*string{
+		"/private/comment":     &comment,
+		"/private/comment/bin": &bin,
+		"/shared/comment":      nil,
+	}))
+	t.join("SETMETADATA", err)
+	t.waitEOF()
+}
+
+func TestClientUpdateHandler(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	go t.script(
+		`C: A1 SELECT "INBOX"`+CRLF,
+		`S: * 10 EXISTS`+CRLF,
+		`S: A1 OK [READ-WRITE] Ok`+CRLF,
+	)
+	_, err := C.Select("INBOX", false)
+	t.join("SELECT", err)
+
+	var labels []string
+	var msgs []uint32
+	C.SetUpdateHandler(func(rsp *Response) bool {
+		labels = append(labels, rsp.Label)
+		msgs = append(msgs, C.Mailbox.Messages)
+		return rsp.Label != "FETCH"
+	})
+	go t.script(
+		`C: A2 FETCH 1 (UID)`+CRLF,
+		`S: * 1 FETCH (UID 42)`+CRLF,
+		`S: * 11 EXISTS`+CRLF,
+		`S: * 3 EXPUNGE`+CRLF,
+		`S: * 5 FETCH (FLAGS (\Seen))`+CRLF,
+		`S: A2 OK FETCH completed`+CRLF,
+	)
+	cmd, err := Wait(C.Fetch(newSeqSet("1"), "UID"))
+	t.join("FETCH", err)
+	if len(cmd.Data) != 1 {
+		t.Errorf("len(cmd.Data) expected 1; got %d", len(cmd.Data))
+	}
+	if want := []string{"EXISTS", "EXPUNGE", "FETCH"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("handler labels expected %v; got %v", want, labels)
+	}
+	if want := []uint32{11, 10, 10}; !reflect.DeepEqual(msgs, want) {
+		t.Errorf("handler C.Mailbox.Messages expected %v; got %v", want, msgs)
+	}
+	if rsp := C.Data[len(C.Data)-1]; rsp.Label != "FETCH" || rsp.MessageInfo().Seq != 5 {
+		t.Errorf("C.Data expected unsolicited FETCH; got %v", rsp)
+	}
+
+	C.SetUpdateHandler(nil)
+	go t.script(
+		`C: A3 NOOP`+CRLF,
+		`S: * 12 EXISTS`+CRLF,
+		`S: A3 OK NOOP completed`+CRLF,
+		EOF,
+	)
+	_, err = Wait(C.Noop())
+	t.join("NOOP", err)
+	t.waitEOF()
+	if n := len(labels); n != 3 {
+		t.Errorf("handler called after removal (%d calls)", n)
+	}
+	if rsp := C.Data[len(C.Data)-1]; rsp.Label != "EXISTS" {
+		t.Errorf("C.Data expected EXISTS; got %v", rsp)
+	}
+}
+
+func TestClientResponseCodes(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	go t.script(
+		`C: A1 NOOP`+CRLF,
+		`S: * OK [ALERT] System shutdown in 10 minutes`+CRLF,
+		`S: * OK [XYZZY 1 2] Unknown code`+CRLF,
+		`S: A1 OK [CAPABILITY IMAP4rev1 IDLE MOVE] NOOP completed`+CRLF,
+	)
+	cmd, err := Wait(C.Noop())
+	t.join("NOOP", err)
+	if v := C.Data[1].Alert(); v != "System shutdown in 10 minutes" {
+		t.Errorf("C.Data[1].Alert() expected shutdown warning; got %q", v)
+	}
+	if rsp := C.Data[2]; rsp.Alert() != "" || rsp.Label != "XYZZY" ||
+		!reflect.DeepEqual(rsp.Fields, []Field{"XYZZY", uint32(1), uint32(2)}) {
+		t.Errorf("C.Data[2] unexpected response code %v", rsp)
+	}
+	if v := cmd.Alert(); v != "" {
+		t.Errorf("cmd.Alert() expected \"\"; got %q", v)
+	}
+	if !C.Caps["IDLE"] || !C.Caps["MOVE"] {
+		t.Errorf("C.Caps expected IDLE and MOVE; got %v", C.Caps)
+	}
+
+	go t.script(
+		`C: A2 SELECT "INBOX"`+CRLF,
+		`S: * 10 EXISTS`+CRLF,
+		`S: A2 OK [READ-WRITE] Ok`+CRLF,
+	)
+	_, err = C.Select("INBOX", false)
+	t.join("SELECT", err)
+
+	go t.script(
+		`C: A3 SEARCH CHARSET UTF-8 ALL`+CRLF,
+		`S: A3 NO [BADCHARSET (US-ASCII "ISO-8859-1")] Unsupported charset`+CRLF,
+	)
+	cmd, err = C.Search("ALL")
+	if err == nil {
+		_, err = cmd.Result(NO)
+	}
+	t.join("SEARCH", err)
+	if cs := cmd.BadCharset(); !reflect.DeepEqual(cs, []string{"US-ASCII", "ISO-8859-1"}) {
+		t.Errorf("cmd.BadCharset() expected [US-ASCII ISO-8859-1]; got %q", cs)
+	}
+
+	go t.script(
+		`C: A4 FETCH 1 (BODY[])`+CRLF,
+		`S: * 1 FETCH (BODY[] "")`+CRLF,
+		`S: A4 NO [ALERT] Message is corrupted`+CRLF,
+		EOF,
+	)
+	cmd, err = C.Fetch(newSeqSet("1"), "BODY[]")
+	if err == nil {
+		_, err = cmd.Result(NO)
+	}
+	t.join("FETCH", err)
+	t.waitEOF()
+	if v := cmd.Alert(); v != "Message is corrupted" {
+		t.Errorf("cmd.Alert() expected alert text; got %q", v)
+	}
+	if cs := cmd.BadCharset(); cs != nil {
+		t.Errorf("cmd.BadCharset() expected nil; got %q", cs)
+	}
+}
+
+func TestClientFetchBinary(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	go t.script(
+		`C: A1 SELECT "INBOX"`+CRLF,
+		`S: * 10 EXISTS`+CRLF,
+		`S: A1 OK [READ-WRITE] Ok`+CRLF,
+	)
+	_, err := C.Select("INBOX", false)
+	t.join("SELECT", err)
+
+	// BINARY capability is required
+	if cmd, err := C.FetchBinary(newSeqSet("1"), "1"); cmd != nil || err != NotAvailableError("BINARY") {
+		t.Fatalf("C.FetchBinary() expected NotAvailableError; got %#v (%v)", cmd, err)
+	}
+	C.Caps["BINARY"] = true
+	for _, section := range []string{"TEXT", "1.", "0", "1.MIME", " 1"} {
+		if cmd, err := C.FetchBinary(newSeqSet("1"), section); cmd != nil || err == nil {
+			t.Fatalf("C.FetchBinary(%q) expected error; got %#v (%v)", section, cmd, err)
+		}
+	}
+
+	// Decoded content returned as literal8
+	go t.script(
+		`C: A2 FETCH 1:2 (BINARY.PEEK[2.1])`+CRLF,
+		`S: * 1 FETCH (BINARY[2.1] ~{6}`+CRLF,
+		"S: \x00\x01\xFFabc",
+		`S: )`+CRLF,
+		`S: * 2 FETCH (BINARY[2.1] {5}`+CRLF,
+		`S: hello)`+CRLF,
+		`S: A2 OK Fetch completed`+CRLF,
+	)
+	cmd, err := Wait(C.FetchBinary(newSeqSet("1:2"), "2.1"))
+	t.join("FETCH", err)
+	if n := len(cmd.Data); n != 2 {
+		t.Fatalf("len(cmd.Data) expected 2; got %v", n)
+	}
+	if v := cmd.Data[0].MessageInfo().Binary("2.1"); string(v) != "\x00\x01\xFFabc" {
+		t.Errorf("Binary(2.1) expected 8-bit data; got %q", v)
+	}
+	if v := cmd.Data[1].MessageInfo().Binary("2.1"); string(v) != "hello" {
+		t.Errorf("Binary(2.1) expected hello; got %q", v)
+	}
+
+	// Decoded size
+	go t.script(
+		`C: A3 UID FETCH 42 (BINARY.SIZE[1])`+CRLF,
+		`S: * 3 FETCH (UID 42 BINARY.SIZE[1] 4096)`+CRLF,
+		`S: A3 OK Fetch completed`+CRLF,
+	)
+	cmd, err = Wait(C.UIDFetch(newSeqSet("42"), "BINARY.SIZE[1]"))
+	t.join("UID FETCH", err)
+	info := cmd.Data[0].MessageInfo()
+	if n, ok := info.BinarySize("1"); n != 4096 || !ok {
+		t.Errorf("BinarySize(1) expected 4096; got %v (%v)", n, ok)
+	}
+	if n, ok := info.BinarySize("2"); n != 0 || ok {
+		t.Errorf("BinarySize(2) expected missing; got %v (%v)", n, ok)
+	}
+
+	// Unknown Content-Transfer-Encoding
+	go t.script(
+		`C: A4 UID FETCH 42 (BINARY.PEEK[])`+CRLF,
+		`S: A4 NO [UNKNOWN-CTE] Can't decode x-uuencode`+CRLF,
+		EOF,
+	)
+	_, err = Wait(C.UIDFetchBinary(newSeqSet("42"), ""))
+	t.waitEOF()
+	if rsp, ok := err.(ResponseError); !ok || rsp.Label != "UNKNOWN-CTE" {
+		t.Errorf("C.UIDFetchBinary() expected UNKNOWN-CTE error; got %v", err)
+	}
+}
+
+func TestClientFetchHeaders(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	go t.script(
+		`C: A1 SELECT "INBOX"`+CRLF,
+		`S: * 10 EXISTS`+CRLF,
+		`S: A1 OK [READ-WRITE] Ok`+CRLF,
+	)
+	_, err := C.Select("INBOX", false)
+	t.join("SELECT", err)
+
+	for _, fields := range [][]string{nil, {"Subject", "a b"}, {"From:"}, {""}} {
+		if cmd, err := C.FetchHeaders(newSeqSet("1"), fields...); cmd != nil || err == nil {
+			t.Fatalf("C.FetchHeaders(%q) expected error; got %#v (%v)", fields, cmd, err)
+		}
+	}
+
+	go t.script(
+		`C: A2 FETCH 1:3 (BODY.PEEK[HEADER.FIELDS (SUBJECT RECEIVED)])`+CRLF,
+		`S: * 1 FETCH (BODY[HEADER.FIELDS (SUBJECT RECEIVED)] {61}`+CRLF,
+		`S: Subject: Hello`+CRLF+`Received: from a`+CRLF+`Received: from b`+CRLF+` by c`+CRLF+CRLF+`)`,
+		`S: `+CRLF,
+		`S: * 2 FETCH (BODY[HEADER.FIELDS ("Subject" "Received")] {2}`+CRLF,
+		`S: `+CRLF+`)`,
+		`S: `+CRLF,
+		`S: * 3 FETCH (FLAGS (\Seen))`+CRLF,
+		`S: A2 OK FETCH completed`+CRLF,
+	)
+	cmd, err := Wait(C.FetchHeaders(newSeqSet("1:3"), "Subject", "received"))
+	t.join("FETCH", err)
+	hdrs := cmd.Headers()
+	want := map[uint32]textproto.MIMEHeader{
+		1: {"Subject": {"Hello"}, "Received": {"from a", "from b by c"}},
+		2: {},
+	}
+	if !reflect.DeepEqual(hdrs, want) {
+		t.Errorf("cmd.Headers() expected %v; got %v", want, hdrs)
+	}
+	if v := hdrs[2].Get("Subject"); v != "" {
+		t.Errorf("hdrs[2].Get() expected \"\"; got %q", v)
+	}
+
+	go t.script(
+		`C: A3 UID FETCH 42 (BODY.PEEK[HEADER.FIELDS (FROM)])`+CRLF,
+		`S: * 1 FETCH (UID 42 BODY[HEADER.FIELDS (FROM)] {15}`+CRLF,
+		`S: From: alice`+CRLF+CRLF+`)`,
+		`S: `+CRLF,
+		`S: A3 OK FETCH completed`+CRLF,
+		EOF,
+	)
+	cmd, err = Wait(C.UIDFetchHeaders(newSeqSet("42"), "From"))
+	t.join("UID FETCH", err)
+	t.waitEOF()
+	want = map[uint32]textproto.MIMEHeader{42: {"From": {"alice"}}}
+	if hdrs = cmd.Headers(); !reflect.DeepEqual(hdrs, want) {
+		t.Errorf("cmd.Headers() expected %v; got %v", want, hdrs)
+	}
+}
+
+func TestClientFetchMerge(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	go t.script(
+		`C: A1 SELECT "INBOX"`+CRLF,
+		`S: * 10 EXISTS`+CRLF,
+		`S: A1 OK [READ-WRITE] Ok`+CRLF,
+	)
+	_, err := C.Select("INBOX", false)
+	t.join("SELECT", err)
+
+	go t.script(
+		`C: A2 FETCH 1:3 (UID FLAGS BODY.PEEK[1] BODY.PEEK[2])`+CRLF,
+		`S: * 1 FETCH (UID 11 FLAGS (\Seen) BODY[1] "a")`+CRLF,
+		`S: * 2 FETCH (UID 12 FLAGS ())`+CRLF,
+		`S: * 1 FETCH (BODY[2] "b")`+CRLF,
+		`S: * 3 FETCH (UID 13 FLAGS ())`+CRLF,
+		`S: * 1 FETCH (FLAGS (\Seen \Deleted))`+CRLF,
+		`S: A2 OK FETCH completed`+CRLF,
+	)
+	cmd, err := Wait(C.Fetch(newSeqSet("1:3"), "UID", "FLAGS", "BODY.PEEK[1]", "BODY.PEEK[2]"))
+	t.join("FETCH", err)
+	if len(cmd.Data) != 3 {
+		t.Fatalf("len(cmd.Data) expected 3; got %d", len(cmd.Data))
+	}
+	info := cmd.Data[0].MessageInfo()
+	if info.Seq != 1 || info.UID != 11 {
+		t.Errorf("info expected Seq=1 UID=11; got Seq=%d UID=%d", info.Seq, info.UID)
+	}
+	if want := NewFlagSet(`\Seen`, `\Deleted`); !reflect.DeepEqual(info.Flags, want) {
+		t.Errorf("info.Flags expected %v; got %v", want, info.Flags)
+	}
+	if a, b := string(info.Body("1")), string(info.Body("2")); a != "a" || b != "b" {
+		t.Errorf("info.Body() expected \"a\", \"b\"; got %q, %q", a, b)
+	}
+	if seq := cmd.Data[1].MessageInfo().Seq; seq != 2 {
+		t.Errorf("cmd.Data[1] expected Seq=2; got %d", seq)
+	}
+
+	// UID commands merge by UID
+	go t.script(
+		`C: A3 UID FETCH 11:12 (FLAGS RFC822.SIZE)`+CRLF,
+		`S: * 1 FETCH (UID 11 FLAGS ())`+CRLF,
+		`S: * 2 FETCH (UID 12 RFC822.SIZE 100)`+CRLF,
+		`S: * 1 FETCH (UID 11 RFC822.SIZE 200)`+CRLF,
+		`S: A3 OK FETCH completed`+CRLF,
+		EOF,
+	)
+	cmd, err = Wait(C.UIDFetch(newSeqSet("11:12"), "FLAGS", "RFC822.SIZE"))
+	t.join("UID FETCH", err)
+	t.waitEOF()
+	if len(cmd.Data) != 2 {
+		t.Fatalf("len(cmd.Data) expected 2; got %d", len(cmd.Data))
+	}
+	if info = cmd.Data[0].MessageInfo(); info.UID != 11 || info.Size != 200 || info.Attrs["FLAGS"] == nil {
+		t.Errorf("info expected UID=11 Size=200 with FLAGS; got %v", info.Attrs)
+	}
+}
+
+func TestClientFetchRFC822(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	go t.script(
+		`C: A1 SELECT "INBOX"`+CRLF,
+		`S: * 10 EXISTS`+CRLF,
+		`S: A1 OK [READ-WRITE] Ok`+CRLF,
+	)
+	_, err := C.Select("INBOX", false)
+	t.join("SELECT", err)
+
+	msg1 := "Subject: One" + CRLF + CRLF + "Hello"
+	msg2 := "Subject: Two" + CRLF + CRLF + "World"
+	go t.script(
+		`C: A2 FETCH 1:2 (BODY.PEEK[])`+CRLF,
+		`S: * 1 FETCH (BODY[] {21}`+CRLF,
+		`S: `+msg1+`)`,
+		`S: `+CRLF,
+		`S: * 2 FETCH (FLAGS () BODY[] {21}`+CRLF,
+		`S: `+msg2+`)`,
+		`S: `+CRLF,
+		`S: A2 OK FETCH completed`+CRLF,
+	)
+	cmd, err := Wait(C.FetchRFC822(newSeqSet("1:2")))
+	t.join("FETCH", err)
+	want := map[uint32][]byte{1: []byte(msg1), 2: []byte(msg2)}
+	if msgs := cmd.Messages(); !reflect.DeepEqual(msgs, want) {
+		t.Errorf("cmd.Messages() expected %v; got %v", want, msgs)
+	}
+	m, err := cmd.Data[1].MessageInfo().Message()
+	if err != nil || m.Header.Get("Subject") != "Two" {
+		t.Errorf("info.Message() expected Subject: Two; got %v (%v)", m, err)
+	}
+
+	// Streaming
+	go t.script(
+		`C: A3 FETCH 1:2 (BODY.PEEK[])`+CRLF,
+		`S: * 1 FETCH (BODY[] {21}`+CRLF,
+		`S: `+msg1+` ENVELOPE (NIL {3}`,
+		`S: `+CRLF,
+		`S: abc NIL NIL NIL NIL NIL NIL NIL NIL NIL))`+CRLF,
+		`S: * 2 FETCH (ENVELOPE (NIL {3}`+CRLF,
+		`S: xyz NIL NIL NIL NIL NIL NIL NIL NIL NIL) BODY[] {21}`+CRLF,
+		`S: `+msg2+`)`,
+		`S: `+CRLF,
+		`S: A3 OK FETCH completed`+CRLF,
+		EOF,
+	)
+	got := make(map[uint32][]byte)
+	cmd, err = C.FetchRFC822Stream(newSeqSet("1:2"), func(seq uint32, r io.Reader) {
+		b := make([]byte, 12)
+		io.ReadFull(r, b) // Remaining bytes are discarded
+		got[seq] = b
+	})
+	t.join("FETCH", err)
+	t.waitEOF()
+	want = map[uint32][]byte{1: []byte("Subject: One"), 2: []byte("Subject: Two")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FetchRFC822Stream expected %v; got %v", want, got)
+	}
+	if msgs := cmd.Messages(); len(msgs) != 0 {
+		t.Errorf("cmd.Messages() expected no messages; got %v", msgs)
+	}
+	if env := AsList(cmd.Data[0].MessageInfo().Attrs["ENVELOPE"]); len(env) < 2 || AsString(env[1]) != "abc" {
+		t.Errorf("ENVELOPE literal expected to be saved to memory; got %v", env)
+	}
+	if _, ok := C.r.LiteralReader.(MemoryReader); !ok {
+		t.Errorf("C.r.LiteralReader was not restored; got %T", C.r.LiteralReader)
+	}
+}
+
+func TestClientAppendReader(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	go t.script(
+		`C: A1 APPEND "INBOX" (\Seen) {12}`+CRLF,
+		`S: + Ready for literal data`+CRLF,
+		`C: Hello, world`,
+		`C: `+CRLF,
+		`S: A1 OK APPEND completed`+CRLF,
+	)
+	_, err := Wait(C.AppendReader("INBOX", NewFlagSet(Seen), nil, 12,
+		strings.NewReader("Hello, world! Not sent.")))
+	t.join("APPEND", err)
+
+	go t.script(
+		`C: A2 APPEND "INBOX" {12}`+CRLF,
+		`S: + Ready for literal data`+CRLF,
+	)
+	cmd, err := C.AppendReader("INBOX", nil, nil, 12, strings.NewReader("Hello"))
+	t.join("APPEND", nil)
+	if cmd != nil || err != ErrShortLiteral {
+		t.Fatalf("C.AppendReader() expected ErrShortLiteral; got %#v (%v)", cmd, err)
+	}
+	if !C.t.Closed() {
+		t.Errorf("C.AppendReader() did not close the connection")
+	}
+}
+
+func TestClientCatenate(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	if cmd, err := C.AppendCatenate("Drafts", nil, nil, CatText([]byte("x"))); cmd != nil || err == nil {
+		t.Fatalf("C.AppendCatenate() expected error; got %#v (%v)", cmd, err)
+	}
+	C.Caps["CATENATE"] = true
+
+	url := "/Drafts;UIDVALIDITY=385759045/;UID=20/;section=HEADER"
+	go t.script(
+		`C: A1 APPEND "Drafts" (\Draft) CATENATE (URL "`+url+`" TEXT {6}`+CRLF,
+		`S: + Ready for literal data`+CRLF,
+		`C: Hello `,
+		`C:  URL "/Drafts;UIDVALIDITY=385759045/;UID=30" TEXT {6}`+CRLF,
+		`S: + Ready for literal data`+CRLF,
+		`C: world!`,
+		`C: )`+CRLF,
+		`S: A1 OK [APPENDUID 385759045 45] APPEND completed`+CRLF,
+		EOF,
+	)
+	cmd, err := Wait(C.AppendCatenate("Drafts", NewFlagSet(Draft), nil,
+		CatURL(url),
+		CatText([]byte("Hello ")),
+		CatURL("/Drafts;UIDVALIDITY=385759045/;UID=30"),
+		CatText([]byte("world!")),
+	))
+	t.join("APPEND", err)
+	t.waitEOF()
+	if _, uid, ok := cmd.AppendUID(); !ok || uid != 45 {
+		t.Errorf("cmd.AppendUID() expected 45; got %v %v", uid, ok)
+	}
+}
+
+func TestClientAppend(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 UIDPLUS] Test server ready`+CRLF)
+
+	// Flags and date (date-day-fixed), literal is sent after the continuation
+	// request
+	idate := time.Date(2013, time.July, 4, 9, 5, 0, 0, time.FixedZone("", -7*60*60))
+	go t.script(
+		`C: A1 APPEND "INBOX" (\Flagged \Seen) " 4-Jul-2013 09:05:00 -0700" {5}`+CRLF,
+		`S: + Ready`+CRLF,
+		`C: hello`+CRLF,
+		`S: A1 OK [APPENDUID 1 42] APPEND completed`+CRLF,
+	)
+	cmd, err := Wait(C.Append("INBOX", NewFlagSet(`\Seen`, `\Flagged`), &idate, NewLiteral([]byte("hello"))))
+	t.join("APPEND", err)
+	if v, uid, ok := cmd.AppendUID(); !ok || v != 1 || uid != 42 {
+		t.Errorf("cmd.AppendUID() expected 1 42; got %v %v %v", v, uid, ok)
+	}
+
+	// Empty flags and zero time are omitted
+	var zero time.Time
+	go t.script(
+		`C: A2 APPEND "INBOX" {5}`+CRLF,
+		`S: + Ready`+CRLF,
+		`C: hello`+CRLF,
+		`S: A2 OK APPEND completed`+CRLF,
+		EOF,
+	)
+	_, err = Wait(C.Append("INBOX", FlagSet{}, &zero, NewLiteral([]byte("hello"))))
+	t.join("APPEND", err)
+	t.waitEOF()
+}
+
+func TestClientUIDPlus(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 UIDPLUS LITERAL+] Test server ready`+CRLF)
+
+	// APPEND
+	go t.script(
+		`C: A1 APPEND "saved-messages" (\Seen) {5+}`+CRLF,
+		`C: hello`+CRLF,
+		`S: A1 OK [APPENDUID 38505 3955] APPEND completed`+CRLF,
+	)
+	cmd, err := Wait(C.Append("saved-messages", NewFlagSet(`\Seen`), nil, NewLiteral([]byte("hello"))))
+	t.join("APPEND", err)
+	if uidValidity, uid, ok := cmd.AppendUID(); !ok || uidValidity != 38505 || uid != 3955 {
+		t.Errorf("cmd.AppendUID() expected 38505 3955; got %v %v %v", uidValidity, uid, ok)
+	}
+	if _, _, _, ok := cmd.CopyUID(); ok {
+		t.Errorf("cmd.CopyUID() expected !ok")
+	}
+
+	// SELECT
+	go t.script(
+		`C: A2 SELECT "INBOX"`+CRLF,
+		`S: * 5 EXISTS`+CRLF,
+		`S: A2 OK [READ-WRITE] INBOX selected. (Success)`+CRLF,
+	)
+	_, err = C.Select("INBOX", false)
+	t.join("SELECT", err)
+
+	// COPY
+	go t.script(
+		`C: A3 COPY 2:4 "meeting"`+CRLF,
+		`S: A3 OK [COPYUID 38505 304,319:320 3956:3958] Done`+CRLF,
+		EOF,
+	)
+	cmd, err = Wait(C.Copy(newSeqSet("2:4"), "meeting"))
+	t.join("COPY", err)
+	t.waitEOF()
+	uidValidity, src, dst, ok := cmd.CopyUID()
+	if !ok || uidValidity != 38505 || src.String() != "304,319:320" || dst.String() != "3956:3958" {
+		t.Errorf("cmd.CopyUID() expected 38505 304,319:320 3956:3958; got %v %v %v %v", uidValidity, src, dst, ok)
+	}
+	if _, _, ok := cmd.AppendUID(); ok {
+		t.Errorf("cmd.AppendUID() expected !ok")
+	}
+}
+
+func TestClientUIDExpunge(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	// SELECT
+	go t.script(
+		`C: A1 SELECT "INBOX"`+CRLF,
+		`S: * 5 EXISTS`+CRLF,
+		`S: A1 OK [READ-WRITE] INBOX selected. (Success)`+CRLF,
+	)
+	_, err := C.Select("INBOX", false)
+	t.join("SELECT", err)
+
+	// UID EXPUNGE should fail when the capability is not advertised
+	if cmd, err := C.UIDExpunge(newSeqSet("3000:3002")); cmd != nil || err == nil {
+		t.Fatalf("C.UIDExpunge() expected error; got %#v (%v)", cmd, err)
+	}
+	if cmd, err := C.Expunge(newSeqSet("3000:3002")); cmd != nil || err == nil {
+		t.Fatalf("C.Expunge() expected error; got %#v (%v)", cmd, err)
+	}
+	C.Caps["UIDPLUS"] = true
+
+	// UID EXPUNGE
+	go t.script(
+		`C: A2 UID EXPUNGE 3000:3002`+CRLF,
+		`S: * 3 EXPUNGE`+CRLF,
+		`S: * 3 EXPUNGE`+CRLF,
+		`S: * 3 EXPUNGE`+CRLF,
+		`S: A2 OK UID EXPUNGE completed`+CRLF,
+	)
+	cmd, err := Wait(C.UIDExpunge(newSeqSet("3000:3002")))
+	t.join("UID EXPUNGE", err)
+	if v := cmd.Expunged(); !reflect.DeepEqual(v, []uint32{3, 3, 3}) {
+		t.Errorf("cmd.Expunged() expected [3 3 3]; got %v", v)
+	}
+	if n := C.Mailbox.Messages; n != 2 {
+		t.Errorf("C.Mailbox.Messages expected 2; got %v", n)
+	}
+
+	// EXPUNGE
+	go t.script(
+		`C: A3 EXPUNGE`+CRLF,
+		`S: * 1 EXPUNGE`+CRLF,
+		`S: A3 OK EXPUNGE completed`+CRLF,
+		EOF,
+	)
+	cmd, err = Wait(C.Expunge(nil))
+	t.join("EXPUNGE", err)
+	if v := cmd.Expunged(); !reflect.DeepEqual(v, []uint32{1}) {
+		t.Errorf("cmd.Expunged() expected [1]; got %v", v)
+	}
+	t.waitEOF()
+}
+
+func TestClientListSubscribed(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	// Names are encoded in modified UTF-7
+	go t.script(
+		`C: A1 SUBSCRIBE "Entw&APw-rfe"`+CRLF,
+		`S: A1 OK SUBSCRIBE completed`+CRLF,
+		`C: A2 UNSUBSCRIBE "&ZeVnLIqe-"`+CRLF,
+		`S: A2 OK UNSUBSCRIBE completed`+CRLF,
+	)
+	_, err := Wait(C.Subscribe("Entwürfe"))
+	if err == nil {
+		_, err = Wait(C.Unsubscribe("日本語"))
+	}
+	t.join("SUBSCRIBE", err)
+
+	// LSUB fallback, attributes are decoded
+	go t.script(
+		`C: A3 LSUB "" "*"`+CRLF,
+		`S: * LSUB (\Noselect \HasChildren) "/" "Entw&APw-rfe"`+CRLF,
+		`S: A3 OK LSUB completed`+CRLF,
+	)
+	cmd, err := Wait(C.ListSubscribed("", "*"))
+	t.join("LSUB", err)
+	want := &MailboxInfo{Attrs: NewFlagSet(`\Noselect`, `\HasChildren`), Delim: "/", Name: "Entwürfe"}
+	if info := cmd.Data[0].MailboxInfo(); !reflect.DeepEqual(info, want) {
+		t.Errorf("MailboxInfo() expected %+v; got %+v", want, info)
+	}
+
+	// LIST-EXTENDED
+	C.Caps["LIST-EXTENDED"] = true
+	go t.script(
+		`C: A4 LIST (SUBSCRIBED) "" "*"`+CRLF,
+		`S: * LIST (\Subscribed \NonExistent) "/" "Gone"`+CRLF,
+		`S: A4 OK LIST completed`+CRLF,
+		EOF,
+	)
+	cmd, err = Wait(C.ListSubscribed("", "*"))
+	t.join("LIST", err)
+	t.waitEOF()
+	if info := cmd.Data[0].MailboxInfo(); !info.Attrs.Has(`\Subscribed`) || !info.Attrs.Has(`\NonExistent`) {
+		t.Errorf("MailboxInfo().Attrs expected \\Subscribed \\NonExistent; got %v", info.Attrs)
+	}
+}
+
+func TestClientListStatus(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 LIST-EXTENDED] Test server ready`+CRLF)
+
+	if v, err := C.ListStatus("", "*"); v != nil || err != NotAvailableError("LIST-STATUS") {
+		t.Fatalf("C.ListStatus() expected NotAvailableError; got %v (%v)", v, err)
+	}
+	C.Caps["LIST-STATUS"] = true
+	C.Data = nil
+	if v, err := C.ListStatus("", "*", "BOGUS"); v != nil || err == nil {
+		t.Fatalf("C.ListStatus() expected error; got %v (%v)", v, err)
+	}
+
+	// STATUS responses are not accepted without the STATUS return option
+	go t.script(
+		`C: A1 LIST "" "x RETURN (STATUS (x"`+CRLF,
+		`S: * STATUS "INBOX" (MESSAGES 17)`+CRLF,
+		`S: A1 OK List completed`+CRLF,
+	)
+	cmd, err := Wait(C.List("", "x RETURN (STATUS (x"))
+	t.join("LIST", err)
+	if len(cmd.Data) != 0 || len(C.Data) != 1 {
+		t.Fatalf("STATUS expected in C.Data; got %v and %v", cmd.Data, C.Data)
+	}
+	C.Data = nil
+
+	// ListExtended with the STATUS return option
+	go t.script(
+		`C: A2 LIST "" "INBOX" RETURN (STATUS (MESSAGES))`+CRLF,
+		`S: * LIST () "/" INBOX`+CRLF,
+		`S: * STATUS INBOX (MESSAGES 17)`+CRLF,
+		`S: A2 OK List completed`+CRLF,
+	)
+	cmd, err = Wait(C.ListExtended("", []string{"INBOX"}, nil, []string{"STATUS (MESSAGES)"}))
+	t.join("LIST", err)
+	if len(cmd.Data) != 2 || len(C.Data) != 0 {
+		t.Fatalf("STATUS expected in cmd.Data; got %v and %v", cmd.Data, C.Data)
+	}
+
+	go t.script(
+		`C: A3 LIST "" "*" RETURN (STATUS (MESSAGES UNSEEN))`+CRLF,
+		`S: * LIST () "/" inbox`+CRLF,
+		`S: * STATUS "INBOX" (MESSAGES 17 UNSEEN 16)`+CRLF,
+		`S: * LIST (\Noselect) "/" "Lists"`+CRLF,
+		`S: * LIST () "/" "Lists/Old Stuff"`+CRLF,
+		`S: * LIST () "/" "Lists/Entw&APw-rfe"`+CRLF,
+		`S: * STATUS "Lists/Entw&APw-rfe" (MESSAGES 2 UNSEEN 0)`+CRLF,
+		`S: * STATUS "Lists/Old Stuff" (MESSAGES 44 UNSEEN 3)`+CRLF,
+		`S: A3 OK List completed`+CRLF,
+		EOF,
+	)
+	list, err := C.ListStatus("", "*", "messages", "unseen")
+	t.join("LIST", err)
+	t.waitEOF()
+	if len(C.Data) != 0 {
+		t.Errorf("C.Data expected empty; got %v", C.Data)
+	}
+
+	want := []struct {
+		name             string
+		messages, unseen uint32
+		status           bool
+	}{
+		{"INBOX", 17, 16, true},
+		{"Lists", 0, 0, false},
+		{"Lists/Old Stuff", 44, 3, true},
+		{"Lists/Entwürfe", 2, 0, true},
+	}
+	if len(list) != len(want) {
+		t.Fatalf("C.ListStatus() expected %d mailboxes; got %d", len(want), len(list))
+	}
+	for i, w := range want {
+		info := list[i]
+		if info.Name != w.name || (info.Status != nil) != w.status {
+			t.Errorf("list[%d] expected %q (status=%v); got %q (%v)", i, w.name, w.status, info.Name, info.Status)
+		} else if s := info.Status; s != nil && (s.Name != w.name || s.Messages != w.messages || s.Unseen != w.unseen) {
+			t.Errorf("list[%d].Status expected %q %d/%d; got %q %d/%d", i, w.name,
+				w.messages, w.unseen, s.Name, s.Messages, s.Unseen)
+		}
+	}
+}
+
+func TestClientListExtended(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	// Extended options require LIST-EXTENDED
+	if cmd, err := C.ListExtended("", []string{"*"}, []string{"SUBSCRIBED"}, nil); cmd != nil || err == nil {
+		t.Fatalf("C.ListExtended() expected error; got %#v (%v)", cmd, err)
+	}
+
+	// SPECIAL-USE only, fall back to plain LIST
+	C.Caps["SPECIAL-USE"] = true
+	go t.script(
+		`C: A1 LIST "" "*"`+CRLF,
+		`S: * LIST (\HasNoChildren \Sent) "/" "[Gmail]/Sent Mail"`+CRLF,
+		`S: A1 OK Success`+CRLF,
+	)
+	cmd, err := Wait(C.ListExtended("", []string{"*"}, nil, []string{"special-use"}))
+	t.join("LIST", err)
+	if v := cmd.Data[0].MailboxInfo().SpecialUse; v != `\Sent` {
+		t.Errorf("MailboxInfo().SpecialUse expected \\Sent; got %q", v)
+	}
+
+	// LIST-EXTENDED only, SPECIAL-USE return option is dropped
+	delete(C.Caps, "SPECIAL-USE")
+	C.Caps["LIST-EXTENDED"] = true
+	go t.script(
+		`C: A2 LIST (SUBSCRIBED) "" ("INBOX" "Drafts") RETURN (CHILDREN)`+CRLF,
+		`S: * LIST (\Subscribed \HasNoChildren) "/" "Drafts"`+CRLF,
+		`S: A2 OK Success`+CRLF,
+	)
+	_, err = Wait(C.ListExtended("", []string{"INBOX", "Drafts"}, []string{"SUBSCRIBED"}, []string{"CHILDREN", "SPECIAL-USE"}))
+	t.join("LIST", err)
+
+	// Both capabilities
+	C.Caps["SPECIAL-USE"] = true
+	go t.script(
+		`C: A3 LIST (SUBSCRIBED) "" "*" RETURN (SPECIAL-USE)`+CRLF,
+		`S: * LIST (\Subscribed \Trash) "/" "Trash"`+CRLF,
+		`S: A3 OK Success`+CRLF,
+		EOF,
+	)
+	cmd, err = Wait(C.ListExtended("", []string{"*"}, []string{"SUBSCRIBED"}, []string{"SPECIAL-USE"}))
+	t.join("LIST", err)
+	t.waitEOF()
+	if v := cmd.Data[0].MailboxInfo().SpecialUse; v != `\Trash` {
+		t.Errorf("MailboxInfo().SpecialUse expected \\Trash; got %q", v)
+	}
+}
+
+func TestClientMailboxNames(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	go t.script(
+		`C: A1 CREATE "Wichtige &ANw-nterlagen"`+CRLF,
+		`S: A1 OK CREATE completed`+CRLF,
+	)
+	_, err := Wait(C.Create("Wichtige Ünterlagen"))
+	t.join("CREATE", err)
+
+	go t.script(
+		`C: A2 RENAME "&-" "&-&AKM-"`+CRLF,
+		`S: A2 OK RENAME completed`+CRLF,
+	)
+	_, err = Wait(C.Rename("&", "&£"))
+	t.join("RENAME", err)
+
+	go t.script(
+		`C: A3 LIST "&-" "Wichtige &ANw-*"`+CRLF,
+		`S: * LIST () "/" "Wichtige &ANw-nterlagen"`+CRLF,
+		`S: * LIST () "/" "&-"`+CRLF,
+		`S: A3 OK LIST completed`+CRLF,
+		EOF,
+	)
+	cmd, err := Wait(C.List("&", "Wichtige Ü*"))
+	t.join("LIST", err)
+	t.waitEOF()
+	for i, name := range []string{"Wichtige Ünterlagen", "&"} {
+		if v := cmd.Data[i].MailboxInfo().Name; v != name {
+			t.Errorf("MailboxInfo().Name expected %q; got %q", name, v)
+		}
+	}
+}
+
+func TestClientUTF8Accept(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1 ENABLE UTF8=ACCEPT] Test server ready`+CRLF)
+
+	// 8-bit headers are rejected until UTF8=ACCEPT is enabled
+	msg := NewLiteral([]byte("Subject: Ünterlagen\r\n\r\nBody"))
+	if cmd, err := C.Append("INBOX", nil, nil, msg); err != ErrUTF8Headers {
+		t.Fatalf("C.Append() expected ErrUTF8Headers; got %#v (%v)", cmd, err)
+	}
+
+	go t.script(
+		`C: A1 ENABLE UTF8=ACCEPT`+CRLF,
+		`S: * ENABLED UTF8=ACCEPT`+CRLF,
+		`S: A1 OK Enabled`+CRLF,
+	)
+	_, err := C.Enable("UTF8=ACCEPT")
+	t.join("ENABLE", err)
+
+	go t.script(
+		`C: A2 SELECT {11}`+CRLF,
+		`S: + Ready for additional command text`+CRLF,
+		`C: Ünterlagen`,
+		`C: `+CRLF,
+		`S: * 0 EXISTS`+CRLF,
+		`S: A2 OK [READ-WRITE] SELECT completed`+CRLF,
+	)
+	_, err = C.Select("Ünterlagen", false)
+	t.join("SELECT", err)
+
+	go t.script(
+		`C: A3 LIST "" "&*"`+CRLF,
+		`S: * LIST () "/" "&-"`+CRLF,
+		`S: * LIST () "/" {11}`+CRLF,
+		`S: Ünterlagen`+CRLF,
+		`S: A3 OK LIST completed`+CRLF,
+	)
+	cmd, err := Wait(C.List("", "&*"))
+	t.join("LIST", err)
+	for i, name := range []string{"&-", "Ünterlagen"} {
+		if v := cmd.Data[i].MailboxInfo().Name; v != name {
+			t.Errorf("MailboxInfo().Name expected %q; got %q", name, v)
+		}
+	}
+
+	go t.script(
+		`C: A4 APPEND "INBOX" {28}`+CRLF,
+		`S: + Ready for literal data`+CRLF,
+		`C: Subject: Ünterlagen`+CRLF+CRLF+`Body`,
+		`C: `+CRLF,
+		`S: A4 OK APPEND completed`+CRLF,
+		EOF,
+	)
+	_, err = Wait(C.Append("INBOX", nil, nil, msg))
+	t.join("APPEND", err)
+	t.waitEOF()
+}
+
+func TestClientStatus(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	// Invalid and unavailable items are rejected before sending
+	if cmd, err := C.Status("INBOX", "MESSAGES", "BOGUS"); cmd != nil || err == nil {
+		t.Fatalf("C.Status() expected error; got %#v (%v)", cmd, err)
+	}
+	if cmd, err := C.Status("INBOX", "HIGHESTMODSEQ"); cmd != nil || err == nil {
+		t.Fatalf("C.Status() expected error; got %#v (%v)", cmd, err)
+	}
+	if cmd, err := C.Status("INBOX", "MAILBOXID"); cmd != nil || err != NotAvailableError("OBJECTID") {
+		t.Fatalf("C.Status() expected NotAvailableError; got %#v (%v)", cmd, err)
+	}
+	C.Caps["CONDSTORE"] = true
+	C.Caps["OBJECTID"] = true
+
+	go t.script(
+		`C: A1 STATUS "INBOX" (MESSAGES UIDNEXT HIGHESTMODSEQ)`+CRLF,
+		`S: * STATUS INBOX (MESSAGES 231 UIDNEXT 44292 HIGHESTMODSEQ 7011231777)`+CRLF,
+		`S: A1 OK STATUS completed`+CRLF,
+		`C: A2 STATUS "Renamed" (MAILBOXID)`+CRLF,
+		`S: * STATUS Renamed (MAILBOXID (F2212ea87-6097-4256-9d51-71338625))`+CRLF,
+		`S: A2 OK STATUS completed`+CRLF,
+		EOF,
+	)
+	cmd, err := Wait(C.Status("INBOX", "messages", "UIDNEXT", "HighestModSeq"))
+	var cmd2 *Command
+	if err == nil {
+		cmd2, err = Wait(C.Status("Renamed", "mailboxid"))
+	}
+	t.join("STATUS", err)
+	t.waitEOF()
+
+	if id := cmd2.Data[0].MailboxStatus().MailboxID; id != "F2212ea87-6097-4256-9d51-71338625" {
+		t.Errorf("MailboxStatus().MailboxID expected F2212ea87-...; got %q", id)
+	}
+
+	want := &MailboxStatus{
+		Name:          "INBOX",
+		Messages:      231,
+		UIDNext:       44292,
+		HighestModSeq: 7011231777,
+	}
+	if v := cmd.Data[0].MailboxStatus(); !reflect.DeepEqual(v, want) {
+		t.Errorf("MailboxStatus() expected\n%v; got\n%v", want, v)
+	}
+}
+
+type logRecord struct {
+	level string
+	msg   string
+	args  []interface{}
+}
+
+// logRecorder implements Logger by saving all messages.
+type logRecorder struct {
+	mu   sync.Mutex
+	recs []logRecord
+}
+
+func (l *logRecorder) Debug(msg string, args ...interface{}) { l.add("DEBUG", msg, args) }
+func (l *logRecorder) Info(msg string, args ...interface{})  { l.add("INFO", msg, args) }
+func (l *logRecorder) Warn(msg string, args ...interface{})  { l.add("WARN", msg, args) }
+
+func (l *logRecorder) add(level, msg string, args []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.recs = append(l.recs, logRecord{level, msg, args})
+}
+
+func (l *logRecorder) find(msg string) *logRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := range l.recs {
+		if l.recs[i].msg == msg {
+			return &l.recs[i]
+		}
+	}
+	return nil
+}
+
+func TestClientStructuredLog(T *testing.T) {
+	l := &logRecorder{}
+
+	// Unstructured logging is restored with nil
+	d := newDebugLog(nil, LogAll)
+	if prev := d.SetStructuredLogger(l); prev != nil {
+		T.Errorf("d.SetStructuredLogger() expected nil; got %v", prev)
+	}
+	if prev := d.SetStructuredLogger(nil); prev != l || d.logger != nil {
+		T.Errorf("d.SetStructuredLogger() expected %v; got %v", l, prev)
+	}
+
+	DefaultStructuredLogger = l
+	defer func() { DefaultStructuredLogger = nil }()
+	defer un(setLogMask(LogConn | LogState | LogCmd | LogRaw))
+	C, t := newClient(T, `S: * OK [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	go t.script(
+		`C: A1 LOGIN "user" "pass"`+CRLF,
+		`S: * OK [ALERT] Password expires soon`+CRLF,
+		`S: A1 OK [CAPABILITY IMAP4rev1] LOGIN completed`+CRLF,
+	)
+	_, err := C.Login("user", "pass")
+	t.join("LOGIN", err)
+
+	tests := []struct {
+		msg   string
+		level string
+		args  []interface{}
+	}{
+		{`Connected to 192.0.2.1:143 (Tag=A)`, "INFO",
+			[]interface{}{"category", "LogConn"}},
+		{`>>> A1 LOGIN "user" <redacted>`, "DEBUG",
+			[]interface{}{"category", "LogCmd", "dir", "client", "tag", "A1"}},
+		{`C: A1 LOGIN "user" <redacted>`, "DEBUG",
+			[]interface{}{"category", "LogRaw", "dir", "client", "tag", "A1"}},
+		{`S: * OK [ALERT] Password expires soon`, "DEBUG",
+			[]interface{}{"category", "LogRaw", "dir", "server"}},
+		{`ALERT! Password expires soon`, "WARN",
+			[]interface{}{"category", "LogConn"}},
+		{`S: A1 OK [CAPABILITY IMAP4rev1] LOGIN completed`, "DEBUG",
+			[]interface{}{"category", "LogRaw", "dir", "server", "tag", "A1"}},
+		{`State change: Login -> Auth`, "INFO",
+			[]interface{}{"category", "LogState"}},
+	}
+	for _, test := range tests {
+		r := l.find(test.msg)
+		if r == nil {
+			t.Errorf("%q not logged", test.msg)
+		} else if r.level != test.level || !reflect.DeepEqual(r.args, test.args) {
+			t.Errorf("%q expected %s %v; got %s %v",
+				test.msg, test.level, test.args, r.level, r.args)
+		}
+	}
+	for _, r := range l.recs {
+		if strings.Contains(r.msg, "pass\"") {
+			t.Errorf("password logged: %q", r.msg)
+		}
+	}
+
+	go t.script(
+		`C: A2 LOGOUT`+CRLF,
+		`S: * BYE LOGOUT Requested`+CRLF,
+		`S: A2 OK Quit completed`+CRLF,
+		EOF,
+	)
+	_, err = C.Logout(-1)
+	t.join("LOGOUT", err)
+	t.waitEOF()
+}
+
+func TestClientRetry(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	C, t := newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+
+	// No retry by default
+	go t.script(
+		`C: A1 DELETE "Shared"`+CRLF,
+		`S: A1 NO [INUSE] Mailbox in use`+CRLF,
+	)
+	cmd, err := Wait(C.Delete("Shared"))
+	t.join("DELETE", nil)
+	if rsp, ok := err.(ResponseError); !ok || rsp.Label != "INUSE" {
+		t.Fatalf("C.Delete() expected NO [INUSE]; got %v", err)
+	}
+
+	p := &RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for n, want := range []time.Duration{1: 10, 2: 20, 3: 40, 4: 50, 5: 50} {
+		if d := p.Delay(n); n > 0 && d != want*time.Millisecond {
+			t.Errorf("p.Delay(%d) expected %v; got %v", n, want*time.Millisecond, d)
+		}
+	}
+
+	// Transient failures are retried with a new tag
+	C.RetryPolicy = NewRetryPolicy(3, time.Millisecond)
+	go t.script(
+		`C: A2 DELETE "Shared"`+CRLF,
+		`S: A2 NO [INUSE] Mailbox in use`+CRLF,
+		`C: A3 DELETE "Shared"`+CRLF,
+		`S: * NO Still busy`+CRLF,
+		`S: A3 NO [UNAVAILABLE] Try again later`+CRLF,
+		`C: A4 DELETE "Shared"`+CRLF,
+		`S: A4 OK DELETE completed`+CRLF,
+	)
+	cmd, err = Wait(C.Delete("Shared"))
+	t.join("DELETE", err)
+	if cmd.Tag() != "A4" {
+		t.Errorf("cmd.Tag() expected A4; got %s", cmd.Tag())
+	}
+
+	// The last failure is returned after MaxAttempts
+	C.RetryPolicy.Codes = append(C.RetryPolicy.Codes, "X-BUSY")
+	C.RetryPolicy.MaxAttempts = 2
+	go t.script(
+		`C: A5 DELETE "Shared"`+CRLF,
+		`S: A5 NO [X-BUSY] Busy`+CRLF,
+		`C: A6 DELETE "Shared"`+CRLF,
+		`S: A6 NO [INUSE] Mailbox in use`+CRLF,
+	)
+	cmd, err = Wait(C.Delete("Shared"))
+	t.join("DELETE", nil)
+	if rsp, ok := err.(ResponseError); !ok || rsp.Tag != "A6" {
+		t.Fatalf("C.Delete() expected A6 NO [INUSE]; got %v", err)
+	}
+
+	// Other failures are not retried
+	go t.script(
+		`C: A7 DELETE "Shared"`+CRLF,
+		`S: A7 NO [NONEXISTENT] No such mailbox`+CRLF,
+		EOF,
+	)
+	_, err = Wait(C.Delete("Shared"))
+	t.join("DELETE", nil)
+	if rsp, ok := err.(ResponseError); !ok || rsp.Label != "NONEXISTENT" {
+		t.Fatalf("C.Delete() expected NO [NONEXISTENT]; got %v", err)
+	}
+	t.waitEOF()
+}
+
+// fetchConn is a net.Conn that sends a greeting, waits for the client to send
+// a command, and then sends a FETCH response with a large message body. It
+// counts the number of Read calls made by the client. As with a real network
+// connection, each Read returns as much data as fits in the caller's buffer,
+// so the count depends on the client's buffer size when the message body is
+// consumed in small chunks.
+type fetchConn struct {
+	testConn
+	r     *bytes.Reader
+	reply []byte
+	sent  chan struct{}
+	reads int
+}
+
+func newFetchConn(size int) *fetchConn {
+	msg := bytes.Repeat([]byte("0123456789abcdefghijklmnopqrstuvwxyz\r\n"), size/38)
+	reply := fmt.Appendf(nil, "* 1 FETCH (BODY[] {%d}\r\n%s)\r\nA1 OK FETCH completed\r\n", len(msg), msg)
+	return &fetchConn{
+		r:     bytes.NewReader([]byte("* OK [CAPABILITY IMAP4rev1] Server ready\r\n")),
+		reply: reply,
+		sent:  make(chan struct{}, 1),
+	}
+}
+func (c *fetchConn) Read(b []byte) (n int, err error) {
+	if c.r.Len() == 0 {
+		if c.reply == nil {
+			return 0, io.EOF
+		}
+		<-c.sent
+		c.r.Reset(c.reply)
+		c.reply = nil
+	}
+	c.reads++
+	return c.r.Read(b)
+}
+func (c *fetchConn) Write(b []byte) (n int, err error) {
+	select {
+	case c.sent <- struct{}{}:
+	default:
+	}
+	return len(b), nil
+}
+
+func BenchmarkClientFetchBufferSize(b *testing.B) {
+	const msgSize = 4 << 20
+	for _, size := range []int{4096, BufferSize, 1 << 20} {
+		b.Run(fmt.Sprintf("%dKB", size>>10), func(b *testing.B) {
+			b.SetBytes(msgSize)
+			reads := 0
+			for i := 0; i < b.N; i++ {
+				conn := newFetchConn(msgSize)
+				C, err := NewClientSize(conn, "localhost", time.Second, size)
+				if err != nil {
+					b.Fatalf("NewClientSize() unexpected error; %v", err)
+				}
+				C.state = Selected
+				n := int64(0)
+				_, err = C.FetchRFC822Stream(newSeqSet("1"), func(seq uint32, r io.Reader) {
+					n, _ = io.Copy(ioutil.Discard, r)
+				})
+				if err != nil {
+					b.Fatalf("C.FetchRFC822Stream() unexpected error; %v", err)
+				} else if n < msgSize-38 {
+					b.Fatalf("FetchRFC822Stream() expected %d bytes; got %d", msgSize, n)
+				}
+				C.Close(false)
+				reads += conn.reads
+			}
+			b.ReportMetric(float64(reads)/float64(b.N), "reads/op")
+		})
+	}
 }
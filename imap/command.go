@@ -6,9 +6,12 @@ package imap
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"net/textproto"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -25,7 +28,10 @@ var abort = new(Response)
 type Command struct {
 	// FIFO queue for command data. These are the responses that were accepted
 	// by this command's filter. New responses are appended to the end as they
-	// are received.
+	// are received. Servers may split the attributes of one message across
+	// several FETCH responses, so each additional FETCH response for the same
+	// message is merged into the first one (see MessageInfo) instead of being
+	// appended.
 	Data []*Response
 
 	// Client that created this Command instance.
@@ -47,12 +53,34 @@ type Command struct {
 	// used to filter FETCH responses.
 	seqset *SeqSet
 
+	// STATUS return option flag for LIST commands (RFC 5819). This is set by
+	// ListExtended and ListStatus.
+	listStatus bool
+
+	// FETCH responses in Data indexed by UID for UID commands or by message
+	// sequence number otherwise.
+	fetched map[uint32]*Response
+
 	// Raw command text without CRLFs or literal strings.
 	raw string
 
 	// Command completion response. This is set to abort if the command is not
 	// in progress, but a valid completion response was not received.
 	result *Response
+
+	// Command fields and the number of times the command was retried. Fields
+	// are only saved if the command may be retried (see Client.RetryPolicy and
+	// Client.SearchCharsetFallback).
+	replay   bool
+	fields   []Field
+	retries  int
+	fallback bool // Charset fallback was attempted
+
+	// Time when the command was first sent, time from then until completion,
+	// and the number of protocol bytes sent and received for this command.
+	start      time.Time
+	elapsed    time.Duration
+	sent, recv int64
 }
 
 // newCommand initializes and returns a new Command instance. Nil is returned if
@@ -95,49 +123,424 @@ func (cmd *Command) Name(full bool) string {
 	return cmd.name
 }
 
+// Elapsed returns the wall-clock time from when the command was sent until its
+// completion response was received. While the command is in progress, the time
+// elapsed so far is returned. If the command was retried (see RetryPolicy), the
+// time includes all attempts and the delays between them.
+func (cmd *Command) Elapsed() time.Duration {
+	if cmd.elapsed == 0 && !cmd.start.IsZero() {
+		return time.Since(cmd.start)
+	}
+	return cmd.elapsed
+}
+
+// Bytes returns the number of bytes sent to and received from the server for
+// this command, including CRLFs and literals. Received bytes include the
+// command completion response and all responses in cmd.Data. Unilateral server
+// data is not counted. The counts are measured before compression.
+func (cmd *Command) Bytes() (sent, recv int64) {
+	return cmd.sent, cmd.recv
+}
+
 // InProgress returns true until the command completion result is available. No
 // new responses will be appended to cmd.Data after this method returns false.
 func (cmd *Command) InProgress() bool {
 	return cmd.result == nil
 }
 
+// Completion returns the tagged completion response of the command without
+// blocking. Nil is returned if the command is still in progress or if it was
+// aborted. If the server ended the session before completing the command, the
+// BYE response is returned instead.
+func (cmd *Command) Completion() *Response {
+	if cmd.result == abort {
+		return nil
+	}
+	return cmd.result
+}
+
+// Status returns the status condition of the completion response (OK, NO, or
+// BAD) without blocking. Zero is returned if the command is not completed.
+func (cmd *Command) Status() RespStatus {
+	if rsp := cmd.Completion(); rsp != nil {
+		return rsp.Status
+	}
+	return 0
+}
+
+// Info returns the human-readable text of the completion response without
+// blocking (e.g. "Mailbox does not exist"). An empty string is returned if the
+// command is not completed.
+func (cmd *Command) Info() string {
+	if rsp := cmd.Completion(); rsp != nil {
+		return rsp.Info
+	}
+	return ""
+}
+
 // Result returns the command completion result. The call blocks until the
 // command is no longer in progress. If expect != 0, an error is returned if the
 // completion status is other than expected. ErrAborted is returned if the
 // command execution was interrupted prior to receiving a completion response.
+// A ByeError is returned, along with the BYE response, if the server ended the
+// session while the command was in progress.
 func (cmd *Command) Result(expect RespStatus) (rsp *Response, err error) {
-	for cmd.result == nil {
-		if err = cmd.client.Recv(block); err != nil {
-			return
+	return cmd.ResultContext(context.Background(), expect)
+}
+
+// ResultContext is identical to Result, but it returns ctx.Err() if ctx is done
+// before the command is completed. In that case, the command is marked as
+// aborted and subsequent calls to Result return ErrAborted. The server may
+// still be executing the command, so its tag remains in use until the
+// completion response is received, at which point the response is discarded.
+// Exclusive commands continue to block other commands until then.
+func (cmd *Command) ResultContext(ctx context.Context, expect RespStatus) (rsp *Response, err error) {
+	if c := cmd.client; cmd.result == nil || c.canRetry(cmd) || c.canFallback(cmd) {
+		defer c.setContext(ctx)()
+		for cmd.result == nil || c.canRetry(cmd) || c.canFallback(cmd) {
+			if cmd.result == nil {
+				err = c.Recv(block)
+			} else if c.canRetry(cmd) {
+				err = c.retry(ctx, cmd)
+			} else {
+				err = c.fallback(cmd)
+			}
+			if err != nil {
+				if err == errContextDone {
+					c.cancel(cmd)
+					err = ctx.Err()
+				}
+				return
+			}
 		}
 	}
 	if rsp = cmd.result; rsp == abort {
 		rsp, err = nil, ErrAborted
+	} else if rsp.Type == Status && rsp.Status == BYE {
+		err = &ByeError{Reason: rsp.Info}
 	} else if expect != 0 && rsp.Status&expect == 0 {
 		err = ResponseError{rsp, "unexpected completion status"}
 	}
 	return
 }
 
+// isSearch returns true for SEARCH and UID SEARCH commands. The UID prefix is
+// not part of cmd.name.
+func (cmd *Command) isSearch() bool {
+	return cmd.name == "SEARCH"
+}
+
 // String returns the raw command text without CRLFs or literal data.
 func (cmd *Command) String() string {
 	return cmd.raw
 }
 
+// Alert returns the text of the first ALERT response code found in the command
+// completion response or in cmd.Data (see Response.Alert). Untagged alerts that
+// were not accepted by the command filter are delivered to Client.Data. An
+// empty string is returned if the code was not found.
+func (cmd *Command) Alert() string {
+	if rsp := cmd.findLabel("ALERT"); rsp != nil {
+		return rsp.Alert()
+	}
+	return ""
+}
+
+// BadCharset returns the list of charsets supported by the server if the
+// command failed with a BADCHARSET response code (see Response.BadCharset). Nil
+// is returned if the code was not found.
+func (cmd *Command) BadCharset() []string {
+	if rsp := cmd.findLabel("BADCHARSET"); rsp != nil {
+		return rsp.BadCharset()
+	}
+	return nil
+}
+
+// AppendUID returns the information from the APPENDUID response code sent by
+// the server after a successful APPEND command (see Response.AppendUID). Ok is
+// set to false if the code was not found.
+func (cmd *Command) AppendUID() (uidValidity, uid uint32, ok bool) {
+	if rsp := cmd.findLabel("APPENDUID"); rsp != nil {
+		uidValidity, uid = rsp.AppendUID()
+		ok = uid != 0
+	}
+	return
+}
+
+// CopyUID returns the information from the COPYUID response code sent by the
+// server after a successful COPY or MOVE command (see Response.CopyUID). The
+// code may appear either in the command completion response or in one of the
+// untagged responses in cmd.Data. Ok is set to false if the code was not found.
+func (cmd *Command) CopyUID() (uidValidity uint32, src, dst *SeqSet, ok bool) {
+	if rsp := cmd.findLabel("COPYUID"); rsp != nil {
+		uidValidity, src, dst = rsp.CopyUID()
+		ok = src != nil && dst != nil
+	}
+	return
+}
+
+// Modified returns the set of messages that were not updated by a conditional
+// STORE command because their mod-sequence changed (RFC 7162 MODIFIED response
+// code). Nil is returned if the code was not found.
+func (cmd *Command) Modified() *SeqSet {
+	if rsp := cmd.findLabel("MODIFIED"); rsp != nil && len(rsp.Fields) > 1 {
+		return AsSeqSet(rsp.Fields[1])
+	}
+	return nil
+}
+
+// SearchSet returns the message sequence numbers or UIDs from all SEARCH and
+// ESEARCH responses in cmd.Data, folded into ranges. An empty set is returned
+// if no messages matched.
+func (cmd *Command) SearchSet() *SeqSet {
+	set := new(SeqSet)
+	for _, rsp := range cmd.Data {
+		switch rsp.Label {
+		case "SEARCH":
+			for _, n := range rsp.SearchResults() {
+				if n != 0 {
+					set.AddNum(n)
+				}
+			}
+		case "ESEARCH":
+			if all := rsp.ESearchResult().All; all != nil {
+				set.AddSet(all)
+			}
+		}
+	}
+	return set
+}
+
+// SortResults returns the message sequence numbers or UIDs from all SORT
+// responses in cmd.Data, in the order sent by the server. Nil is returned if
+// no messages matched.
+func (cmd *Command) SortResults() []uint32 {
+	var v []uint32
+	for _, rsp := range cmd.Data {
+		if rsp.Label == "SORT" {
+			v = append(v, rsp.SearchResults()...)
+		}
+	}
+	return v
+}
+
+// ESearchResult returns the result of a command issued by Client.SearchReturn.
+// If the server sent a plain SEARCH response instead of ESEARCH (or none at
+// all, because there were no matches), all fields except Tag are computed from
+// the SEARCH results. Nil is returned if the command did not generate either
+// type of response and the result is unknown.
+func (cmd *Command) ESearchResult() *ESearchResult {
+	for _, rsp := range cmd.Data {
+		if rsp.Label == "ESEARCH" {
+			return rsp.ESearchResult()
+		}
+	}
+	if cmd.name != "SEARCH" {
+		return nil
+	}
+	v := &ESearchResult{UID: cmd.uid}
+	if set := cmd.SearchSet(); !set.Empty() {
+		v.Min = set.set[0].start
+		v.Max = set.set[len(set.set)-1].stop
+		v.Count, _ = set.Count()
+		v.All = set
+	}
+	return v
+}
+
+// Flags returns the message flags reported in FETCH responses in cmd.Data, such
+// as those sent by the server in response to a STORE command. The map is keyed
+// by UID for UID commands and by message sequence number otherwise. Responses
+// without a FLAGS attribute are ignored.
+func (cmd *Command) Flags() map[uint32]FlagSet {
+	flags := make(map[uint32]FlagSet)
+	for _, rsp := range cmd.Data {
+		if rsp.Label != "FETCH" {
+			continue
+		}
+		info := rsp.MessageInfo()
+		if _, ok := info.Attrs["FLAGS"]; !ok {
+			continue
+		}
+		if cmd.uid {
+			if info.UID != 0 {
+				flags[info.UID] = info.Flags
+			}
+		} else {
+			flags[info.Seq] = info.Flags
+		}
+	}
+	return flags
+}
+
+// merge combines rsp with an earlier FETCH response in cmd.Data for the same
+// message. It returns false if rsp is not a FETCH response or if it is the
+// first response for its message, in which case rsp must be appended to
+// cmd.Data.
+func (cmd *Command) merge(rsp *Response) bool {
+	if rsp.Label != "FETCH" || rsp.Tag != "*" {
+		return false
+	}
+	info := rsp.MessageInfo()
+	key := info.Seq
+	if cmd.uid {
+		if key = info.UID; key == 0 {
+			return false
+		}
+	}
+	if prev := cmd.fetched[key]; prev != nil {
+		prev.MessageInfo().merge(info)
+		return true
+	}
+	if cmd.fetched == nil {
+		cmd.fetched = make(map[uint32]*Response)
+	}
+	cmd.fetched[key] = rsp
+	return false
+}
+
+// Headers returns the header fields fetched by FetchHeaders or UIDFetchHeaders,
+// parsed from the BODY[HEADER.FIELDS (...)] attribute of each FETCH response in
+// cmd.Data. The map is keyed by UID for UID commands and by message sequence
+// number otherwise. Repeated fields are collected in the order they appear.
+// Requested fields that are missing from a message are also missing from its
+// header, so Get returns an empty string for them. Encoded words are not
+// decoded (see DecodeHeader).
+func (cmd *Command) Headers() map[uint32]textproto.MIMEHeader {
+	hdrs := make(map[uint32]textproto.MIMEHeader)
+	for _, rsp := range cmd.Data {
+		if rsp.Label != "FETCH" {
+			continue
+		}
+		info := rsp.MessageInfo()
+		f, ok := headerFields(info.Attrs)
+		if !ok {
+			continue
+		}
+		if cmd.uid {
+			if info.UID != 0 {
+				hdrs[info.UID] = parseHeader(AsBytes(f))
+			}
+		} else {
+			hdrs[info.Seq] = parseHeader(AsBytes(f))
+		}
+	}
+	return hdrs
+}
+
+// Messages returns the raw messages fetched by FetchRFC822 or UIDFetchRFC822,
+// taken from the BODY[] attribute of each FETCH response in cmd.Data (see
+// info.Body). The map is keyed by UID for UID commands and by message sequence
+// number otherwise.
+func (cmd *Command) Messages() map[uint32][]byte {
+	msgs := make(map[uint32][]byte)
+	for _, rsp := range cmd.Data {
+		if rsp.Label != "FETCH" {
+			continue
+		}
+		info := rsp.MessageInfo()
+		b := info.Body("")
+		if b == nil {
+			continue
+		}
+		if cmd.uid {
+			if info.UID != 0 {
+				msgs[info.UID] = b
+			}
+		} else {
+			msgs[info.Seq] = b
+		}
+	}
+	return msgs
+}
+
+// headerFields returns the BODY[HEADER.FIELDS (...)] attribute. Server-specific
+// formatting of the field list (e.g. quoted names) is ignored.
+func headerFields(attrs FieldMap) (Field, bool) {
+	for k, f := range attrs {
+		if strings.HasPrefix(k, "BODY[HEADER.FIELDS ") {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+// Expunged returns the message sequence numbers from all EXPUNGE responses in
+// cmd.Data, in the order they were received. As required by RFC 3501, each
+// number reflects the removal of all messages reported before it. Once QRESYNC
+// is enabled, the server reports expunged messages with VANISHED responses
+// instead (see cmd.Vanished).
+func (cmd *Command) Expunged() []uint32 {
+	var seq []uint32
+	for _, rsp := range cmd.Data {
+		if rsp.Label == "EXPUNGE" {
+			seq = append(seq, rsp.Value())
+		}
+	}
+	return seq
+}
+
+// Vanished returns the union of UIDs from all VANISHED responses in cmd.Data,
+// such as those sent by the server in response to SelectResync. Nil is
+// returned if there were no VANISHED responses.
+func (cmd *Command) Vanished() *SeqSet {
+	var set *SeqSet
+	for _, rsp := range cmd.Data {
+		if uids, _ := rsp.Vanished(); uids != nil {
+			if set == nil {
+				set = new(SeqSet)
+			}
+			set.AddSet(uids)
+		}
+	}
+	return set
+}
+
+// HighestModSeq returns the value of the HIGHESTMODSEQ response code sent by
+// the server during SELECT or EXAMINE (RFC 7162). Zero is returned if the code
+// was not found.
+func (cmd *Command) HighestModSeq() uint64 {
+	if rsp := cmd.findLabel("HIGHESTMODSEQ"); rsp != nil && len(rsp.Fields) > 1 {
+		return AsNumber64(rsp.Fields[1])
+	}
+	return 0
+}
+
+// findLabel returns the command completion response or the first response in
+// cmd.Data with the specified label. Nil is returned if no such response exists.
+func (cmd *Command) findLabel(label string) *Response {
+	if rsp := cmd.result; rsp != nil && rsp != abort && rsp.Label == label {
+		return rsp
+	}
+	for _, rsp := range cmd.Data {
+		if rsp.Label == label {
+			return rsp
+		}
+	}
+	return nil
+}
+
 // rawCommand contains the raw text and literals about to be sent to the server.
 type rawCommand struct {
 	*bytes.Buffer // Command text, including all required CRLFs
 
 	literals []Literal // Literal strings
+	sync     []bool    // Synchronizing flag for each literal
 	nonsync  bool      // Support for non-synchronizing literals (RFC 2088)
+	minus    bool      // Support for LITERAL- (RFC 7888)
 	binary   bool      // Support for binary literals (RFC 3516)
 }
 
+// literalMinusMax is the maximum size of a non-synchronizing literal when the
+// server advertises LITERAL- instead of LITERAL+ (RFC 7888 section 4).
+const literalMinusMax = 4096
+
 // build returns a rawCommand struct constructed from the command parameters.
 func (cmd *Command) build(tag string, fields []Field) (*rawCommand, error) {
 	raw := &rawCommand{
 		Buffer:  bytes.NewBuffer(make([]byte, 0, 128)),
 		nonsync: cmd.client.Caps["LITERAL+"],
+		minus:   cmd.client.Caps["LITERAL-"],
 		binary:  cmd.client.Caps["BINARY"],
 	}
 	raw.WriteString(tag)
@@ -178,7 +581,9 @@ func (raw *rawCommand) WriteFields(fields []Field, SP bool) error {
 		case uint, uint8, uint16, uint32, uint64:
 			raw.WriteString(strconv.FormatUint(uintValue(f), 10))
 		case time.Time:
-			raw.WriteString(v.Format(DATETIME))
+			raw.WriteByte('"')
+			raw.WriteString(FormatDateTime(v))
+			raw.WriteByte('"')
 		case []Field:
 			raw.WriteByte('(')
 			if err := raw.WriteFields(v, false); err != nil {
@@ -197,11 +602,13 @@ func (raw *rawCommand) WriteFields(fields []Field, SP bool) error {
 			}
 			raw.WriteByte('{')
 			raw.WriteString(strconv.FormatUint(uint64(info.Len), 10))
-			if raw.nonsync {
+			nonsync := raw.nonsync || (raw.minus && info.Len <= literalMinusMax)
+			if nonsync {
 				raw.WriteByte('+')
 			}
 			raw.WriteString("}\r\n")
 			raw.literals = append(raw.literals, v)
+			raw.sync = append(raw.sync, !nonsync)
 		case fmt.Stringer:
 			raw.WriteString(v.String())
 		case nil:
@@ -241,11 +648,42 @@ func ByeFilter(_ *Command, rsp *Response) bool {
 	return rsp.Status == BYE
 }
 
+// ListFilter accepts LIST command responses. STATUS responses are also accepted
+// if the command includes the STATUS return option (RFC 5819).
+func ListFilter(cmd *Command, rsp *Response) bool {
+	switch rsp.Label {
+	case "LIST":
+		return true
+	case "STATUS":
+		return cmd.listStatus
+	}
+	return false
+}
+
+// SearchFilter accepts SEARCH command responses. ESEARCH responses are accepted
+// if their TAG correlator matches the command tag or is absent.
+func SearchFilter(cmd *Command, rsp *Response) bool {
+	switch rsp.Label {
+	case "SEARCH":
+		return true
+	case "ESEARCH":
+		tag := rsp.ESearchResult().Tag
+		return tag == "" || tag == cmd.tag
+	}
+	return false
+}
+
 // FetchFilter accepts FETCH and STORE command responses by matching message
 // sequence numbers or UIDs, depending on the command type. UID matches are more
 // exact because there is no risk of mistaking unilateral server data (e.g. an
-// unsolicited flags update) for command data.
+// unsolicited flags update) for command data. VANISHED (EARLIER) responses are
+// accepted for UID commands.
 func FetchFilter(cmd *Command, rsp *Response) bool {
+	if rsp.Label == "VANISHED" {
+		// UID FETCH with the VANISHED modifier (RFC 7162 section 3.2.6)
+		_, earlier := rsp.Vanished()
+		return earlier && cmd.uid
+	}
 	msg := rsp.MessageInfo()
 	if msg == nil {
 		return false // Not a FETCH response
@@ -281,11 +719,13 @@ func LabelFilter(labels ...string) ResponseFilter {
 	}
 }
 
-// SelectFilter accepts SELECT and EXAMINE command responses.
+// SelectFilter accepts SELECT and EXAMINE command responses, including the
+// VANISHED and FETCH responses sent during QRESYNC mailbox resynchronization.
 var SelectFilter = LabelFilter(
 	"FLAGS", "EXISTS", "RECENT",
 	"UNSEEN", "PERMANENTFLAGS", "UIDNEXT", "UIDVALIDITY",
-	"UIDNOTSTICKY",
+	"UIDNOTSTICKY", "HIGHESTMODSEQ", "NOMODSEQ", "MAILBOXID",
+	"VANISHED", "FETCH",
 )
 
 // CommandConfig specifies command execution parameters.
@@ -323,7 +763,7 @@ func defaultCommands() map[string]*CommandConfig {
 		"RENAME":      &CommandConfig{States: auth},
 		"SUBSCRIBE":   &CommandConfig{States: auth},
 		"UNSUBSCRIBE": &CommandConfig{States: auth},
-		"LIST":        &CommandConfig{States: auth, Filter: NameFilter},
+		"LIST":        &CommandConfig{States: auth, Filter: ListFilter},
 		"LSUB":        &CommandConfig{States: auth, Filter: NameFilter},
 		"STATUS":      &CommandConfig{States: auth, Filter: NameFilter},
 		"APPEND":      &CommandConfig{States: auth},
@@ -331,12 +771,12 @@ func defaultCommands() map[string]*CommandConfig {
 		// RFC 3501 (6.4. Client Commands - Selected State)
 		"CHECK":      &CommandConfig{States: sel},
 		"CLOSE":      &CommandConfig{States: sel, Exclusive: true},
-		"EXPUNGE":    &CommandConfig{States: sel, Filter: NameFilter},
-		"SEARCH":     &CommandConfig{States: sel, Filter: NameFilter},
+		"EXPUNGE":    &CommandConfig{States: sel, Filter: LabelFilter("EXPUNGE", "VANISHED")},
+		"SEARCH":     &CommandConfig{States: sel, Filter: SearchFilter},
 		"FETCH":      &CommandConfig{States: sel, Filter: FetchFilter},
 		"STORE":      &CommandConfig{States: sel, Filter: FetchFilter},
 		"COPY":       &CommandConfig{States: sel},
-		"UID SEARCH": &CommandConfig{States: sel, Filter: NameFilter},
+		"UID SEARCH": &CommandConfig{States: sel, Filter: SearchFilter},
 		"UID FETCH":  &CommandConfig{States: sel, Filter: FetchFilter},
 		"UID STORE":  &CommandConfig{States: sel, Filter: FetchFilter},
 		"UID COPY":   &CommandConfig{States: sel},
@@ -349,19 +789,45 @@ func defaultCommands() map[string]*CommandConfig {
 		// RFC 2177
 		"IDLE": &CommandConfig{States: auth, Exclusive: true},
 
+		// RFC 2342
+		"NAMESPACE": &CommandConfig{States: auth, Filter: NameFilter},
+
 		// RFC 2971
-		"ID": &CommandConfig{States: all, Filter: NameFilter},
+		"ID": &CommandConfig{States: login | auth, Filter: NameFilter},
 
 		// RFC 3691
 		"UNSELECT": &CommandConfig{States: sel, Exclusive: true},
 
 		// RFC 4315
-		"UID EXPUNGE": &CommandConfig{States: sel, Filter: NameFilter},
+		"UID EXPUNGE": &CommandConfig{States: sel, Filter: LabelFilter("EXPUNGE", "VANISHED")},
+
+		// RFC 4314
+		"SETACL":    &CommandConfig{States: auth},
+		"DELETEACL": &CommandConfig{States: auth},
+		"GETACL":    &CommandConfig{States: auth, Filter: LabelFilter("ACL")},
+		"MYRIGHTS":  &CommandConfig{States: auth, Filter: LabelFilter("MYRIGHTS")},
 
 		// RFC 4978
 		"COMPRESS": &CommandConfig{States: auth, Exclusive: true},
 
 		// RFC 5161
-		"ENABLE": &CommandConfig{States: all, Filter: LabelFilter("ENABLED")},
+		"ENABLE": &CommandConfig{States: auth, Filter: LabelFilter("ENABLED")},
+
+		// RFC 5256
+		"SORT":       &CommandConfig{States: sel, Filter: NameFilter},
+		"UID SORT":   &CommandConfig{States: sel, Filter: NameFilter},
+		"THREAD":     &CommandConfig{States: sel, Filter: NameFilter},
+		"UID THREAD": &CommandConfig{States: sel, Filter: NameFilter},
+
+		// RFC 5464
+		"GETMETADATA": &CommandConfig{States: auth, Filter: LabelFilter("METADATA")},
+		"SETMETADATA": &CommandConfig{States: auth},
+
+		// RFC 5465
+		"NOTIFY": &CommandConfig{States: auth},
+
+		// RFC 6851
+		"MOVE":     &CommandConfig{States: sel, Filter: LabelFilter("EXPUNGE", "VANISHED", "COPYUID")},
+		"UID MOVE": &CommandConfig{States: sel, Filter: LabelFilter("EXPUNGE", "VANISHED", "COPYUID")},
 	}
 }
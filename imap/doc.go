@@ -77,6 +77,27 @@ asynchronous command into a synchronous one:
 If err is nil when the call returns, the command was completed with the OK
 status and all data responses (if any) are queued in cmd.Data.
 
+Pipelining
+
+Independent commands may be pipelined by sending all of them first and then
+waiting for each one. The commands may be completed by the server in any order.
+Tagged completions are always matched to the right Command, and untagged data
+is routed by the response filters as described above:
+
+	cmd1, err1 := c.List("", "*")
+	cmd2, err2 := c.Status("INBOX", "MESSAGES")
+	...
+	_, err1 = imap.Wait(cmd1, err1)
+	_, err2 = imap.Wait(cmd2, err2)
+
+The commands are not independent when the result of one depends on the
+other, or when the server cannot tell their untagged responses apart. For
+example, the EXPUNGE responses caused by one command change the sequence numbers
+used by another, and the SEARCH responses of two SEARCH commands look the same.
+RFC 3501 section 5.5 describes the rules in more detail. Commands that change
+the connection state are marked as exclusive in Client.CommandConfig, and Send
+returns ErrExclusive if such a command is pipelined with any other.
+
 Logging Out
 
 The Client launches a goroutine to support receive operations with timeouts. The
@@ -88,28 +109,46 @@ RFCs
 
 The following RFCs are implemented by this package:
 
-	http://tools.ietf.org/html/rfc2087 -- IMAP4 QUOTA extension
 	http://tools.ietf.org/html/rfc2088 -- IMAP4 non-synchronizing literals
 	http://tools.ietf.org/html/rfc2177 -- IMAP4 IDLE command
+	http://tools.ietf.org/html/rfc2195 -- IMAP/POP AUTHorize Extension for Simple Challenge/Response
+	http://tools.ietf.org/html/rfc2342 -- IMAP4 Namespace
 	http://tools.ietf.org/html/rfc2971 -- IMAP4 ID extension
 	http://tools.ietf.org/html/rfc3501 -- INTERNET MESSAGE ACCESS PROTOCOL - VERSION 4rev1
 	http://tools.ietf.org/html/rfc3516 -- IMAP4 Binary Content Extension
 	http://tools.ietf.org/html/rfc3691 -- Internet Message Access Protocol (IMAP) UNSELECT command
+	http://tools.ietf.org/html/rfc4314 -- IMAP4 Access Control List (ACL) Extension
 	http://tools.ietf.org/html/rfc4315 -- Internet Message Access Protocol (IMAP) - UIDPLUS extension
+	http://tools.ietf.org/html/rfc4469 -- Internet Message Access Protocol (IMAP) CATENATE Extension
 	http://tools.ietf.org/html/rfc4616 -- The PLAIN Simple Authentication and Security Layer (SASL) Mechanism
+	http://tools.ietf.org/html/rfc4731 -- IMAP4 Extension to SEARCH Command for Controlling What Kind of Information Is Returned
 	http://tools.ietf.org/html/rfc4959 -- IMAP Extension for Simple Authentication and Security Layer (SASL) Initial Client Response
 	http://tools.ietf.org/html/rfc4978 -- The IMAP COMPRESS Extension
 	http://tools.ietf.org/html/rfc5161 -- The IMAP ENABLE Extension
+	http://tools.ietf.org/html/rfc5256 -- Internet Message Access Protocol - SORT and THREAD Extensions
+	http://tools.ietf.org/html/rfc5258 -- Internet Message Access Protocol version 4 - LIST Command Extensions
+	http://tools.ietf.org/html/rfc5464 -- The IMAP METADATA Extension
+	http://tools.ietf.org/html/rfc5465 -- The IMAP NOTIFY Extension
 	http://tools.ietf.org/html/rfc5738 -- IMAP Support for UTF-8
+	http://tools.ietf.org/html/rfc5802 -- Salted Challenge Response Authentication Mechanism (SCRAM) SASL and GSS-API Mechanisms
+	http://tools.ietf.org/html/rfc5819 -- IMAP4 Extension for Returning STATUS Information in Extended LIST
+	http://tools.ietf.org/html/rfc6154 -- IMAP LIST Extension for Special-Use Mailboxes
+	http://tools.ietf.org/html/rfc6851 -- Internet Message Access Protocol (IMAP) - MOVE Extension
+	http://tools.ietf.org/html/rfc6855 -- IMAP Support for UTF-8
+	http://tools.ietf.org/html/rfc7162 -- IMAP Extensions: Quick Flag Changes Resynchronization (CONDSTORE) and Quick Mailbox Resynchronization (QRESYNC)
+	http://tools.ietf.org/html/rfc7677 -- SCRAM-SHA-256 and SCRAM-SHA-256-PLUS Simple Authentication and Security Layer (SASL) Mechanisms
+	http://tools.ietf.org/html/rfc7888 -- IMAP4 Non-synchronizing Literals
+	http://tools.ietf.org/html/rfc8474 -- IMAP Extension for Object Identifiers
+	http://tools.ietf.org/html/rfc9208 -- IMAP QUOTA Extension
 
 The following RFCs are either informational, not fully implemented, or place no
 implementation requirements on the package, but may be relevant to other parts
 of a client application:
 
+	http://tools.ietf.org/html/rfc2087 -- IMAP4 QUOTA extension
 	http://tools.ietf.org/html/rfc2595 -- Using TLS with IMAP, POP3 and ACAP
 	http://tools.ietf.org/html/rfc2683 -- IMAP4 Implementation Recommendations
 	http://tools.ietf.org/html/rfc4466 -- Collected Extensions to IMAP4 ABNF
-	http://tools.ietf.org/html/rfc4469 -- Internet Message Access Protocol (IMAP) CATENATE Extension
 	http://tools.ietf.org/html/rfc4549 -- Synchronization Operations for Disconnected IMAP4 Clients
 	http://tools.ietf.org/html/rfc5530 -- IMAP Response Codes
 */
@@ -53,6 +53,94 @@ var respTypes = []enumName{
 func (v RespType) String() string   { return enumString(uint32(v), respTypes, false) }
 func (v RespType) GoString() string { return enumString(uint32(v), respTypes, true) }
 
+// DataType identifies the format of a Data response, as determined by its
+// Label. It allows responses to be dispatched with a switch statement or a bit
+// mask test (e.g. rsp.DataType()&(DataExists|DataExpunge) != 0) instead of
+// comparing label strings.
+type DataType uint32
+
+// Data response types. DataOther is used for all labels not listed here.
+const (
+	DataCapability = DataType(1 << iota) // CAPABILITY (RFC 3501)
+	DataList                             // LIST (RFC 3501)
+	DataLSub                             // LSUB (RFC 3501)
+	DataStatus                           // STATUS (RFC 3501)
+	DataSearch                           // SEARCH (RFC 3501)
+	DataFlags                            // FLAGS (RFC 3501)
+	DataExists                           // EXISTS (RFC 3501)
+	DataRecent                           // RECENT (RFC 3501)
+	DataExpunge                          // EXPUNGE (RFC 3501)
+	DataFetch                            // FETCH (RFC 3501)
+	DataQuota                            // QUOTA (RFC 2087)
+	DataQuotaRoot                        // QUOTAROOT (RFC 2087)
+	DataNamespace                        // NAMESPACE (RFC 2342)
+	DataACL                              // ACL (RFC 4314)
+	DataMyRights                         // MYRIGHTS (RFC 4314)
+	DataSort                             // SORT (RFC 5256)
+	DataThread                           // THREAD (RFC 5256)
+	DataEnabled                          // ENABLED (RFC 5161)
+	DataID                               // ID (RFC 2971)
+	DataMetadata                         // METADATA (RFC 5464)
+	DataESearch                          // ESEARCH (RFC 4731)
+	DataVanished                         // VANISHED (RFC 7162)
+	DataOther                            // Unknown or extension data
+)
+
+var dataTypes = []enumName{
+	{uint32(DataCapability), "DataCapability"},
+	{uint32(DataList), "DataList"},
+	{uint32(DataLSub), "DataLSub"},
+	{uint32(DataStatus), "DataStatus"},
+	{uint32(DataSearch), "DataSearch"},
+	{uint32(DataFlags), "DataFlags"},
+	{uint32(DataExists), "DataExists"},
+	{uint32(DataRecent), "DataRecent"},
+	{uint32(DataExpunge), "DataExpunge"},
+	{uint32(DataFetch), "DataFetch"},
+	{uint32(DataQuota), "DataQuota"},
+	{uint32(DataQuotaRoot), "DataQuotaRoot"},
+	{uint32(DataNamespace), "DataNamespace"},
+	{uint32(DataACL), "DataACL"},
+	{uint32(DataMyRights), "DataMyRights"},
+	{uint32(DataSort), "DataSort"},
+	{uint32(DataThread), "DataThread"},
+	{uint32(DataEnabled), "DataEnabled"},
+	{uint32(DataID), "DataID"},
+	{uint32(DataMetadata), "DataMetadata"},
+	{uint32(DataESearch), "DataESearch"},
+	{uint32(DataVanished), "DataVanished"},
+	{uint32(DataOther), "DataOther"},
+}
+
+func (v DataType) String() string   { return enumString(uint32(v), dataTypes, false) }
+func (v DataType) GoString() string { return enumString(uint32(v), dataTypes, true) }
+
+// dataLabels maps Data response labels to their DataType values.
+var dataLabels = map[string]DataType{
+	"CAPABILITY": DataCapability,
+	"LIST":       DataList,
+	"LSUB":       DataLSub,
+	"STATUS":     DataStatus,
+	"SEARCH":     DataSearch,
+	"FLAGS":      DataFlags,
+	"EXISTS":     DataExists,
+	"RECENT":     DataRecent,
+	"EXPUNGE":    DataExpunge,
+	"FETCH":      DataFetch,
+	"QUOTA":      DataQuota,
+	"QUOTAROOT":  DataQuotaRoot,
+	"NAMESPACE":  DataNamespace,
+	"ACL":        DataACL,
+	"MYRIGHTS":   DataMyRights,
+	"SORT":       DataSort,
+	"THREAD":     DataThread,
+	"ENABLED":    DataEnabled,
+	"ID":         DataID,
+	"METADATA":   DataMetadata,
+	"ESEARCH":    DataESearch,
+	"VANISHED":   DataVanished,
+}
+
 // RespStatus is the code sent in status messages to indicate success, failure,
 // or changes in the connection state.
 type RespStatus uint8
@@ -125,6 +213,7 @@ var logMasks = []enumName{
 	{uint32(LogState), "LogState"},
 	{uint32(LogCmd), "LogCmd"},
 	{uint32(LogRaw), "LogRaw"},
+	{uint32(LogGo), "LogGo"},
 	{uint32(LogNone), "LogNone"},
 }
 
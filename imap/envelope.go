@@ -0,0 +1,113 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"net/mail"
+	"time"
+)
+
+// Envelope represents the envelope structure of a message, as returned by the
+// ENVELOPE data item of a FETCH response (RFC 3501 section 7.4.2). Fields that
+// are NIL in the server response are left at their zero values.
+type Envelope struct {
+	Date      time.Time  // Date header (zero if missing or unparsable)
+	Subject   string     // Subject header with encoded-words decoded
+	From      []*Address // From header
+	Sender    []*Address // Sender header
+	ReplyTo   []*Address // Reply-To header
+	To        []*Address // To header
+	Cc        []*Address // Cc header
+	Bcc       []*Address // Bcc header
+	InReplyTo string     // In-Reply-To header
+	MessageID string     // Message-ID header
+}
+
+// Address represents a single address in an envelope address list. RFC 2822
+// group syntax is indicated by an address with a NIL Host. The Mailbox of such
+// an address contains the group name, and an address with an empty Mailbox
+// marks the end of the group.
+type Address struct {
+	Name    string // Display name with encoded-words decoded
+	Mailbox string // Local part, or the group name if Host is empty
+	Host    string // Domain name
+}
+
+// String returns the address in the "mailbox@host" form. An empty string is
+// returned for group markers.
+func (a *Address) String() string {
+	if a.Host == "" {
+		return ""
+	}
+	return a.Mailbox + "@" + a.Host
+}
+
+// errEnvelope is returned when an envelope cannot be parsed.
+var errEnvelope = &ParserError{Info: "bad envelope"}
+
+// NewEnvelope returns the envelope extracted from an ENVELOPE data item of a
+// FETCH response. An error is returned if f is not a valid envelope.
+func NewEnvelope(f Field) (*Envelope, error) {
+	list, ok := f.([]Field)
+	if !ok || len(list) != 10 {
+		return nil, errEnvelope
+	}
+	var addrs [6][]*Address
+	for i := range addrs {
+		var err error
+		if addrs[i], err = newAddressList(list[2+i]); err != nil {
+			return nil, err
+		}
+	}
+	return &Envelope{
+		Date:      envelopeDate(list[0]),
+		Subject:   decodeHeader(AsString(list[1])),
+		From:      addrs[0],
+		Sender:    addrs[1],
+		ReplyTo:   addrs[2],
+		To:        addrs[3],
+		Cc:        addrs[4],
+		Bcc:       addrs[5],
+		InReplyTo: AsString(list[8]),
+		MessageID: AsString(list[9]),
+	}, nil
+}
+
+// newAddressList converts a NIL or a parenthesized list of addresses into a
+// slice of Address structs.
+func newAddressList(f Field) ([]*Address, error) {
+	if f == nil {
+		return nil, nil
+	}
+	list, ok := f.([]Field)
+	if !ok {
+		return nil, errEnvelope
+	}
+	addrs := make([]*Address, 0, len(list))
+	for _, f := range list {
+		addr, ok := f.([]Field)
+		if !ok || len(addr) != 4 {
+			return nil, errEnvelope
+		}
+		addrs = append(addrs, &Address{
+			Name:    decodeHeader(AsString(addr[0])),
+			Mailbox: AsString(addr[2]),
+			Host:    AsString(addr[3]),
+		})
+	}
+	return addrs, nil
+}
+
+// envelopeDate parses the envelope Date field, which normally contains the
+// RFC 2822 Date header. Some servers send the IMAP date-time format instead.
+func envelopeDate(f Field) time.Time {
+	if v := AsDateTime(f); !v.IsZero() {
+		return v
+	}
+	if v, err := mail.ParseDate(AsString(f)); err == nil {
+		return v
+	}
+	return time.Time{}
+}
@@ -0,0 +1,101 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEnvelope(t *testing.T) {
+	PDT := time.FixedZone("PDT", -7*60*60)
+	tests := []struct {
+		in  string
+		out *Envelope
+	}{
+		// RFC 3501 example
+		{`("Wed, 17 Jul 1996 02:23:25 -0700 (PDT)" ` +
+			`"IMAP4rev1 WG mtg summary and minutes" ` +
+			`(("Terry Gray" NIL "gray" "cac.washington.edu")) ` +
+			`(("Terry Gray" NIL "gray" "cac.washington.edu")) ` +
+			`(("Terry Gray" NIL "gray" "cac.washington.edu")) ` +
+			`((NIL NIL "imap" "cac.washington.edu")) ` +
+			`((NIL NIL "minutes" "CNRI.Reston.VA.US")` +
+			`("John Klensin" NIL "KLENSIN" "MIT.EDU")) NIL NIL ` +
+			`"<B27397-0100000@cac.washington.edu>")`,
+			&Envelope{
+				Date:    time.Date(1996, time.July, 17, 2, 23, 25, 0, PDT),
+				Subject: "IMAP4rev1 WG mtg summary and minutes",
+				From:    []*Address{{"Terry Gray", "gray", "cac.washington.edu"}},
+				Sender:  []*Address{{"Terry Gray", "gray", "cac.washington.edu"}},
+				ReplyTo: []*Address{{"Terry Gray", "gray", "cac.washington.edu"}},
+				To:      []*Address{{"", "imap", "cac.washington.edu"}},
+				Cc: []*Address{
+					{"", "minutes", "CNRI.Reston.VA.US"},
+					{"John Klensin", "KLENSIN", "MIT.EDU"}},
+				MessageID: "<B27397-0100000@cac.washington.edu>"}},
+
+		// NIL fields, encoded-words, and group syntax
+		{`(NIL NIL NIL NIL NIL NIL NIL NIL NIL NIL)`,
+			&Envelope{}},
+		{`("bad date" "=?UTF-8?Q?Gr=C3=BC=C3=9Fe?=" ` +
+			`(("=?ISO-8859-1?Q?Andr=E9?=" NIL "andre" "example.com")) NIL NIL ` +
+			`((NIL NIL "friends" NIL)("Bob" NIL "bob" "example.com")(NIL NIL NIL NIL)) ` +
+			`NIL NIL "<1@example.com>" "<2@example.com>")`,
+			&Envelope{
+				Subject: "Grüße",
+				From:    []*Address{{"André", "andre", "example.com"}},
+				To: []*Address{
+					{"", "friends", ""},
+					{"Bob", "bob", "example.com"},
+					{"", "", ""}},
+				InReplyTo: "<1@example.com>",
+				MessageID: "<2@example.com>"}},
+		{`(" 7-Jul-1996 02:44:25 -0700" "=?x-unknown?Q?abc?=" NIL NIL NIL NIL NIL NIL NIL NIL)`,
+			&Envelope{
+				Date:    time.Date(1996, time.July, 7, 2, 44, 25, 0, MST),
+				Subject: "=?x-unknown?Q?abc?="}},
+
+		// Invalid envelopes
+		{`()`, nil},
+		{`(NIL NIL NIL NIL NIL NIL NIL NIL NIL)`, nil},
+		{`(NIL NIL "x" NIL NIL NIL NIL NIL NIL NIL)`, nil},
+		{`(NIL NIL (("x" NIL "y")) NIL NIL NIL NIL NIL NIL NIL)`, nil},
+		{`(NIL NIL ("x") NIL NIL NIL NIL NIL NIL NIL)`, nil},
+	}
+	c, s := newTestConn(1024)
+	C := newTransport(c, nil)
+	r := newReader(C, MemoryReader{}, "A")
+
+	for _, test := range tests {
+		C.clear()
+		s.Write([]byte("* 1 FETCH (ENVELOPE " + test.in + ")" + CRLF))
+
+		raw, err := r.Next()
+		rsp, err := raw.Parse()
+		if err != nil {
+			t.Errorf("Parse(%+q) unexpected error; %v", test.in, err)
+			continue
+		}
+		out, err := NewEnvelope(rsp.MessageInfo().Attrs["ENVELOPE"])
+		if test.out == nil {
+			if err == nil {
+				t.Errorf("NewEnvelope(%+q) expected error", test.in)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("NewEnvelope(%+q) unexpected error; %v", test.in, err)
+			continue
+		}
+		if !out.Date.Equal(test.out.Date) {
+			t.Errorf("NewEnvelope(%+q) expected date %v; got %v", test.in, test.out.Date, out.Date)
+		}
+		out.Date, test.out.Date = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(out, test.out) {
+			t.Errorf("NewEnvelope(%+q) expected\n%+v; got\n%+v", test.in, test.out, out)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package imap
+
+import "strings"
+
+// FetchItems builds the list of data items for the FETCH command and keeps
+// track of the normalized names under which the server returns them. The
+// names of some items in the response are different from those in the request
+// (e.g. "BODY.PEEK[HEADER]<0.1024>" is returned as "BODY[HEADER]<0>"), so Keys
+// should be used to look up the returned data in MessageInfo.Attrs. All
+// methods return the receiver to allow chaining. Example:
+//
+//	items := imap.Items().UID().Flags().BodyPeek("HEADER").Partial(0, 1024)
+//	cmd, err := imap.Wait(c.UIDFetch(seq, items.Build()...))
+//	...
+//	info := rsp.MessageInfo()
+//	if missing := items.Missing(info); len(missing) > 0 {
+//		...
+//	}
+//	hdr := imap.AsBytes(info.Attrs[items.Keys()[2]])
+type FetchItems struct {
+	items []fetchItem
+}
+
+// fetchItem is a single FETCH data item.
+type fetchItem struct {
+	name string    // Item name (if part is nil)
+	part *BodyPart // Body section
+}
+
+// Items returns a new, empty FETCH item list.
+func Items() *FetchItems {
+	return new(FetchItems)
+}
+
+// UID requests the unique identifier of the message.
+func (f *FetchItems) UID() *FetchItems { return f.Item("UID") }
+
+// Flags requests the flags that are set for the message.
+func (f *FetchItems) Flags() *FetchItems { return f.Item("FLAGS") }
+
+// Envelope requests the envelope structure of the message.
+func (f *FetchItems) Envelope() *FetchItems { return f.Item("ENVELOPE") }
+
+// InternalDate requests the internal date of the message.
+func (f *FetchItems) InternalDate() *FetchItems { return f.Item("INTERNALDATE") }
+
+// Size requests the RFC 822 size of the message.
+func (f *FetchItems) Size() *FetchItems { return f.Item("RFC822.SIZE") }
+
+// BodyStructure requests the MIME body structure of the message.
+func (f *FetchItems) BodyStructure() *FetchItems { return f.Item("BODYSTRUCTURE") }
+
+// ModSeq requests the mod-sequence value of the message. The server must
+// support the CONDSTORE extension (RFC 4551).
+func (f *FetchItems) ModSeq() *FetchItems { return f.Item("MODSEQ") }
+
+// GmailMsgID requests the Gmail message ID. The server must advertise
+// X-GM-EXT-1 capability.
+func (f *FetchItems) GmailMsgID() *FetchItems { return f.Item("X-GM-MSGID") }
+
+// GmailThreadID requests the Gmail thread ID. The server must advertise
+// X-GM-EXT-1 capability.
+func (f *FetchItems) GmailThreadID() *FetchItems { return f.Item("X-GM-THRID") }
+
+// GmailLabels requests the Gmail labels of the message. The server must
+// advertise X-GM-EXT-1 capability.
+func (f *FetchItems) GmailLabels() *FetchItems { return f.Item("X-GM-LABELS") }
+
+// EmailID requests the immutable message identifier, which is the same for all
+// copies of the message on the server. The server must advertise OBJECTID
+// capability (RFC 8474).
+func (f *FetchItems) EmailID() *FetchItems { return f.Item("EMAILID") }
+
+// ThreadID requests the immutable identifier of the thread that the message
+// belongs to. The server must advertise OBJECTID capability (RFC 8474).
+func (f *FetchItems) ThreadID() *FetchItems { return f.Item("THREADID") }
+
+// Body requests the specified body section (e.g. "", "TEXT", or "1.2.MIME").
+// The server sets the \Seen flag on the message.
+func (f *FetchItems) Body(section string) *FetchItems {
+	return f.add(fetchItem{part: &BodyPart{Section: section}})
+}
+
+// BodyPeek requests the specified body section without setting the \Seen flag
+// on the message.
+func (f *FetchItems) BodyPeek(section string) *FetchItems {
+	return f.add(fetchItem{part: &BodyPart{Section: section, Peek: true}})
+}
+
+// Partial limits the preceding Body or BodyPeek item to at most count octets,
+// starting at the specified origin octet. It panics if the preceding item is
+// not a body section.
+func (f *FetchItems) Partial(origin, count uint32) *FetchItems {
+	if n := len(f.items); n > 0 && f.items[n-1].part != nil {
+		f.items[n-1].part.Partial = &[2]uint32{origin, count}
+		return f
+	}
+	panic("imap: Partial must follow Body or BodyPeek")
+}
+
+// Item requests an arbitrary data item, which is returned by the server under
+// the same name in upper case. Body sections should be requested with Body or
+// BodyPeek.
+func (f *FetchItems) Item(name string) *FetchItems {
+	return f.add(fetchItem{name: name})
+}
+
+// Build returns the data item names for use with Client.Fetch and
+// Client.UIDFetch.
+func (f *FetchItems) Build() []string {
+	items := make([]string, len(f.items))
+	for i, v := range f.items {
+		if v.part != nil {
+			items[i] = v.part.String()
+		} else {
+			items[i] = v.name
+		}
+	}
+	return items
+}
+
+// Keys returns the normalized data item names under which the server returns
+// the requested items in a FETCH response. The keys are in the same order as
+// the names returned by Build and match the keys of MessageInfo.Attrs.
+func (f *FetchItems) Keys() []string {
+	keys := make([]string, len(f.items))
+	for i, v := range f.items {
+		if v.part != nil {
+			keys[i] = v.part.Key()
+		} else {
+			keys[i] = toUpper(v.name)
+		}
+	}
+	return keys
+}
+
+// Missing returns the keys of the requested items that are not present in
+// info.Attrs. Nil is returned if the server sent all requested items.
+func (f *FetchItems) Missing(info *MessageInfo) []string {
+	var missing []string
+	for _, key := range f.Keys() {
+		if _, ok := info.Attrs[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// String returns the item list in the format used by the FETCH command.
+func (f *FetchItems) String() string {
+	return "(" + strings.Join(f.Build(), " ") + ")"
+}
+
+// add appends a new item to the list.
+func (f *FetchItems) add(v fetchItem) *FetchItems {
+	f.items = append(f.items, v)
+	return f
+}
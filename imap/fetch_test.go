@@ -0,0 +1,55 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFetchItems(t *testing.T) {
+	items := Items().UID().Flags().Envelope().BodyPeek("header.fields (From  To)").
+		Partial(0, 1024).Body("1.MIME").ModSeq().Item("x-gm-labels")
+
+	build := []string{"UID", "FLAGS", "ENVELOPE",
+		"BODY.PEEK[header.fields (From  To)]<0.1024>", "BODY[1.MIME]", "MODSEQ",
+		"x-gm-labels"}
+	if v := items.Build(); !reflect.DeepEqual(v, build) {
+		t.Errorf("Build() expected\n%q; got\n%q", build, v)
+	}
+	keys := []string{"UID", "FLAGS", "ENVELOPE", "BODY[HEADER.FIELDS (FROM TO)]<0>",
+		"BODY[1.MIME]", "MODSEQ", "X-GM-LABELS"}
+	if v := items.Keys(); !reflect.DeepEqual(v, keys) {
+		t.Errorf("Keys() expected\n%q; got\n%q", keys, v)
+	}
+	want := `(UID FLAGS ENVELOPE BODY.PEEK[header.fields (From  To)]<0.1024> ` +
+		`BODY[1.MIME] MODSEQ x-gm-labels)`
+	if v := items.String(); v != want {
+		t.Errorf("String() expected %q; got %q", want, v)
+	}
+
+	info := &MessageInfo{Attrs: FieldMap{
+		"UID":                              uint32(1),
+		"FLAGS":                            []Field{},
+		"BODY[HEADER.FIELDS (FROM TO)]<0>": lit("From: a\r\n"),
+		"MODSEQ":                           []Field{uint32(2)},
+	}}
+	missing := []string{"ENVELOPE", "BODY[1.MIME]", "X-GM-LABELS"}
+	if v := items.Missing(info); !reflect.DeepEqual(v, missing) {
+		t.Errorf("Missing() expected %q; got %q", missing, v)
+	}
+	if v := Items().UID().Missing(info); v != nil {
+		t.Errorf("Missing() expected nil; got %q", v)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Partial() expected panic")
+			}
+		}()
+		Items().UID().Partial(0, 1)
+	}()
+}
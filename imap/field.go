@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -61,6 +62,24 @@ func AsNumber(f Field) uint32 {
 	return v
 }
 
+// AsNumber64 returns the value of a numeric field that may exceed the uint32
+// range, such as a CONDSTORE mod-sequence (RFC 7162). Numbers that do not fit
+// into uint32 are parsed as atoms, so both representations are accepted. Zero
+// is returned if the field is not a valid 63-bit unsigned number.
+func AsNumber64(f Field) uint64 {
+	switch v := f.(type) {
+	case uint32:
+		return uint64(v)
+	case string:
+		if !Quoted(f) {
+			if n, err := strconv.ParseUint(v, 10, 63); err == nil {
+				return n
+			}
+		}
+	}
+	return 0
+}
+
 // AsString returns the value of an astring (string or atom) field. Quoted
 // strings are decoded to their original representation. An empty string is
 // returned if TypeOf(f)&(Atom|QuotedString|LiteralString) == 0 or the string is
@@ -108,17 +127,83 @@ func AsList(f Field) []Field {
 	return v
 }
 
+// AsSeqSet returns the value of a sequence set field, such as the UID sets in a
+// COPYUID response code. Nil is returned if f is not a Number or an Atom
+// containing a valid sequence set.
+func AsSeqSet(f Field) *SeqSet {
+	switch v := f.(type) {
+	case uint32:
+		if v != 0 {
+			s := new(SeqSet)
+			s.AddNum(v)
+			return s
+		}
+	case string:
+		if !Quoted(f) {
+			if s, err := NewSeqSet(v); err == nil {
+				return s
+			}
+		}
+	}
+	return nil
+}
+
 // AsDateTime returns the value of a date-time quoted string field (e.g.
 // INTERNALDATE). The zero value of time.Time is returned if f does not contain
 // a valid date-time string.
 func AsDateTime(f Field) time.Time {
-	s, _ := f.(string)
-	if v, err := time.Parse(DATETIME, s); err == nil {
-		return v
+	if s, ok := f.(string); ok && len(s) > 1 && s[0] == '"' {
+		if v, err := ParseDateTime(s); err == nil {
+			return v
+		}
 	}
 	return time.Time{}
 }
 
+// Layouts of the date and date-time strings without the surrounding quotes.
+// The leading underscore allows the day to be one or two digits, optionally
+// preceded by a space.
+const (
+	dateLayout     = "_2-Jan-2006"
+	dateTimeLayout = "_2-Jan-2006 15:04:05 -0700"
+)
+
+// ParseDateTime parses a date-time string in the format used by INTERNALDATE
+// and APPEND (e.g. "17-Jul-1996 02:44:25 -0700"). The surrounding quotes are
+// optional. Days before the 10th may be padded with a space, as required by
+// RFC 3501, or with a zero, or not padded at all.
+func ParseDateTime(s string) (time.Time, error) {
+	return time.Parse(dateTimeLayout, unquoteDate(s))
+}
+
+// ParseDate parses a date string in the format used by SEARCH keys such as
+// BEFORE and SINCE (e.g. "1-Feb-1994"). The surrounding quotes are optional.
+// The returned time is midnight UTC of the specified date.
+func ParseDate(s string) (time.Time, error) {
+	return time.Parse(dateLayout, unquoteDate(s))
+}
+
+// FormatDateTime returns t as a date-time string without the surrounding quotes
+// (e.g. " 7-Jul-1996 02:44:25 -0700"). Days before the 10th are padded with a
+// space.
+func FormatDateTime(t time.Time) string {
+	return t.Format(dateTimeLayout)
+}
+
+// FormatDate returns t as a date string for use in SEARCH keys (e.g.
+// "07-Jul-1996"). The time and time zone are ignored.
+func FormatDate(t time.Time) string {
+	return t.Format(SEARCHDATE)
+}
+
+// unquoteDate removes the quotes around a date or date-time string.
+func unquoteDate(s string) string {
+	if n := len(s); n >= 2 && s[0] == '"' && s[n-1] == '"' {
+		return s[1 : n-1]
+	}
+	return s
+}
+
 // AsMailbox returns the value of a mailbox name field. All valid atoms and
 // strings encoded as quoted UTF-8 or modified UTF-7 are decoded appropriately.
 // The special case-insensitive name "INBOX" is always converted to upper case.
@@ -174,20 +259,58 @@ func (fm FieldMap) String() string {
 	return "(" + strings.Join(v, " ") + ")"
 }
 
+// System flags defined in RFC 3501 section 2.3.2. The \Recent flag is set by
+// the server and cannot be altered by the client.
+const (
+	Seen     = `\Seen`
+	Answered = `\Answered`
+	Flagged  = `\Flagged`
+	Deleted  = `\Deleted`
+	Draft    = `\Draft`
+	Recent   = `\Recent`
+)
+
 // FlagSet represents the flags enabled for a single mailbox or message. The map
 // values are always set to true; a flag must be deleted from the map to
-// indicate that it is not enabled.
+// indicate that it is not enabled. System flags (those beginning with a
+// backslash) are case-insensitive and are stored in title case (e.g. `\Seen`),
+// which is also how they are returned by the response parser. Keywords are
+// case-sensitive and are stored as-is. The String method returns the set as a
+// parenthesized list, so a FlagSet can be passed directly to Client.Store.
 type FlagSet map[string]bool
 
 // NewFlagSet returns a new flag set with the specified flags enabled.
 func NewFlagSet(flags ...string) FlagSet {
 	fs := make(FlagSet, len(flags))
 	for _, v := range flags {
-		fs[v] = true
+		fs.Set(v)
 	}
 	return fs
 }
 
+// Set adds flag to the set.
+func (fs FlagSet) Set(flag string) {
+	fs[normFlag(flag)] = true
+}
+
+// Unset removes flag from the set.
+func (fs FlagSet) Unset(flag string) {
+	delete(fs, normFlag(flag))
+}
+
+// Has returns true if flag is in the set.
+func (fs FlagSet) Has(flag string) bool {
+	return fs[normFlag(flag)]
+}
+
+// normFlag converts system flags to title case. Keywords are returned as-is.
+func normFlag(flag string) string {
+	if len(flag) > 1 && flag[0] == '\\' {
+		return normalize([]byte(flag))
+	}
+	return flag
+}
+
 // AsFlags returns a set of flags extracted from a parenthesized list. The
 // function does not check every atom for the leading backslash, because it is
 // not permitted in user-defined flags (keywords). Nil is returned if TypeOf(f)
@@ -200,7 +323,7 @@ func AsFlagSet(f Field) FlagSet {
 	v := make(FlagSet, len(list))
 	for _, f := range list {
 		if s := AsAtom(f); s != "" {
-			v[s] = true
+			v.Set(s)
 		} else {
 			return nil
 		}
@@ -216,7 +339,7 @@ func (fs FlagSet) Replace(f Field) {
 		}
 		for _, f := range list {
 			if v := AsAtom(f); v != "" {
-				fs[v] = true
+				fs.Set(v)
 			}
 		}
 	}
@@ -89,6 +89,16 @@ func TestField(t *testing.T) {
 		{AsNumber, uint32(1), uint32(1)},
 		{AsNumber, ^uint32(0), ^uint32(0)},
 
+		{AsNumber64, nil, uint64(0)},
+		{AsNumber64, 1, uint64(0)},
+		{AsNumber64, `x`, uint64(0)},
+		{AsNumber64, `"1"`, uint64(0)},
+		{AsNumber64, `9223372036854775808`, uint64(0)},
+		{AsNumber64, uint32(1), uint64(1)},
+		{AsNumber64, ^uint32(0), uint64(^uint32(0))},
+		{AsNumber64, `4294967296`, uint64(4294967296)},
+		{AsNumber64, `9223372036854775807`, uint64(9223372036854775807)},
+
 		{AsString, nil, ``},
 		{AsString, ``, ``},
 		{AsString, `"\"`, ``},
@@ -141,6 +151,15 @@ func TestField(t *testing.T) {
 		{AsList, []Field{`x`}, []Field{"x"}},
 		{AsList, []Field{`\Seen`, `\Flagged`}, []Field{`\Seen`, `\Flagged`}},
 
+		{AsSeqSet, nil, (*SeqSet)(nil)},
+		{AsSeqSet, uint32(0), (*SeqSet)(nil)},
+		{AsSeqSet, ``, (*SeqSet)(nil)},
+		{AsSeqSet, `"1:2"`, (*SeqSet)(nil)},
+		{AsSeqSet, `1:x`, (*SeqSet)(nil)},
+		{AsSeqSet, uint32(1), newSeqSet("1")},
+		{AsSeqSet, `1:2`, newSeqSet("1:2")},
+		{AsSeqSet, `3,1:2,5:*`, newSeqSet("1:3,5:*")},
+
 		{AsDateTime, nil, time.Time{}},
 		{AsDateTime, time.Now(), time.Time{}},
 		{AsDateTime, ``, time.Time{}},
@@ -207,6 +226,7 @@ func TestField(t *testing.T) {
 		{AsFlagSet, []Field{`x`}, NewFlagSet(`x`)},
 		{AsFlagSet, []Field{`x`, `y`}, NewFlagSet(`x`, `y`)},
 		{AsFlagSet, []Field{`\Seen`, `\deleted`}, NewFlagSet(`\Seen`, `\deleted`)},
+		{AsFlagSet, []Field{`\SEEN`, `$Junk`}, FlagSet{`\Seen`: true, `$Junk`: true}},
 	}
 	for _, test := range tests {
 		call := reflect.ValueOf(test.call)
@@ -222,3 +242,77 @@ func TestField(t *testing.T) {
 		t.Errorf("AsBytes took the slow path for *literal")
 	}
 }
+
+func TestFlagSet(t *testing.T) {
+	fs := NewFlagSet(`\seen`, `$Forwarded`)
+	fs.Set(`\FLAGGED`)
+	fs.Set(`$forwarded`)
+	if want := "($Forwarded $forwarded \\Flagged \\Seen)"; fs.String() != want {
+		t.Errorf("String() expected %q; got %q", want, fs.String())
+	}
+	for _, flag := range []string{Seen, `\SEEN`, Flagged, `$Forwarded`, `$forwarded`} {
+		if !fs.Has(flag) {
+			t.Errorf("Has(%q) expected true", flag)
+		}
+	}
+	for _, flag := range []string{Deleted, Recent, `$FORWARDED`, `seen`, `\`} {
+		if fs.Has(flag) {
+			t.Errorf("Has(%q) expected false", flag)
+		}
+	}
+	fs.Unset(`\sEEn`)
+	fs.Unset(`$FORWARDED`)
+	if want := "($Forwarded $forwarded \\Flagged)"; fs.String() != want {
+		t.Errorf("String() expected %q; got %q", want, fs.String())
+	}
+
+	// \Recent is set by the server
+	info := AsFlagSet([]Field{`\Recent`, `\Answered`})
+	if !info.Has(Recent) || !info.Has(Answered) {
+		t.Errorf("Has(Recent) and Has(Answered) expected true for %v", info)
+	}
+}
+
+func TestDateTime(t *testing.T) {
+	pdt := time.FixedZone("", -7*60*60)
+	want := time.Date(1996, time.July, 7, 2, 44, 25, 0, pdt)
+	for _, in := range []string{
+		` 7-Jul-1996 02:44:25 -0700`,
+		`07-Jul-1996 02:44:25 -0700`,
+		`7-Jul-1996 02:44:25 -0700`,
+		`" 7-Jul-1996 02:44:25 -0700"`,
+		`7-JUL-1996 02:44:25 -0700`,
+	} {
+		if v, err := ParseDateTime(in); err != nil || !v.Equal(want) {
+			t.Errorf("ParseDateTime(%q) expected %v; got %v (%v)", in, want, v, err)
+		}
+	}
+	for _, in := range []string{``, `""`, `7-Jul-1996`, `32-Jul-1996 02:44:25 -0700`,
+		`7-Jul-1996 02:44:25`, `"7-Jul-1996 02:44:25 -0700`} {
+		if v, err := ParseDateTime(in); err == nil {
+			t.Errorf("ParseDateTime(%q) expected error; got %v", in, v)
+		}
+	}
+	if s := FormatDateTime(want); s != ` 7-Jul-1996 02:44:25 -0700` {
+		t.Errorf("FormatDateTime() expected %q; got %q", ` 7-Jul-1996 02:44:25 -0700`, s)
+	}
+	if v := AsDateTime(`"` + FormatDateTime(want) + `"`); !v.Equal(want) {
+		t.Errorf("AsDateTime() expected %v; got %v", want, v)
+	}
+	if v := AsDateTime(FormatDateTime(want)); !v.IsZero() {
+		t.Errorf("AsDateTime() expected zero time for unquoted string; got %v", v)
+	}
+
+	want = time.Date(1994, time.February, 1, 0, 0, 0, 0, time.UTC)
+	for _, in := range []string{`1-Feb-1994`, ` 1-Feb-1994`, `01-Feb-1994`, `"1-Feb-1994"`} {
+		if v, err := ParseDate(in); err != nil || !v.Equal(want) {
+			t.Errorf("ParseDate(%q) expected %v; got %v (%v)", in, want, v, err)
+		}
+	}
+	if v, err := ParseDate(`1-Feb-1994 00:00:00 +0000`); err == nil {
+		t.Errorf("ParseDate() expected error; got %v", v)
+	}
+	if s := FormatDate(want.Add(23 * time.Hour)); s != `01-Feb-1994` {
+		t.Errorf("FormatDate() expected %q; got %q", `01-Feb-1994`, s)
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/textproto"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrUTF8Headers is returned by Client.Append when the message headers contain
+// 8-bit characters, but UTF8=ACCEPT is not enabled.
+var ErrUTF8Headers = errors.New("imap: 8-bit message headers require UTF8=ACCEPT")
+
+// CharsetReader, if non-nil, is called by DecodeHeader to convert text encoded
+// in charsets other than UTF-8, US-ASCII, ISO-8859-1, and Windows-1252 to
+// UTF-8. The charset name is always lower-case. It may be set to the
+// charset.NewReader function from the golang.org/x/net/html/charset package.
+var CharsetReader func(charset string, input io.Reader) (io.Reader, error)
+
+// CharsetWriter, if non-nil, is called to convert UTF-8 text to charsets other
+// than UTF-8, US-ASCII, ISO-8859-1, and Windows-1252, such as when the client
+// transcodes search criteria (see Client.SearchCharsetFallback). The charset
+// name is always lower-case. The returned writer must fail if the text cannot
+// be represented in the charset. If it implements io.Closer, it is closed after
+// the text is written. A suitable writer can be obtained from the Encoder of an
+// encoding in the golang.org/x/text/encoding packages.
+var CharsetWriter func(charset string, output io.Writer) (io.Writer, error)
+
+// headerDecoder decodes RFC 2047 encoded-words in header values.
+var headerDecoder = &mime.WordDecoder{CharsetReader: charsetReader}
+
+// DecodeHeader decodes all RFC 2047 encoded-words in header value s, such as a
+// Subject obtained via BODY[HEADER.FIELDS (SUBJECT)]. Both B and Q encodings
+// are supported. Linear white space between adjacent encoded-words is removed,
+// and text that is not encoded is returned unchanged. An error is returned if
+// an encoded-word is malformed or uses an unsupported charset (see
+// CharsetReader).
+func DecodeHeader(s string) (string, error) {
+	return headerDecoder.DecodeHeader(s)
+}
+
+// EncodeHeader encodes header value s for use in a message that is sent to the
+// server with the APPEND command. Words that contain non-ASCII or control
+// characters are converted to RFC 2047 encoded-words using either Q or B
+// encoding, whichever is shorter. Pure-ASCII words are not modified. Each
+// encoded-word is limited to 75 characters, so long runs of non-ASCII text are
+// split into multiple encoded-words. The charset must be "UTF-8" (default if
+// empty) or "ISO-8859-1". UTF-8 is used if s cannot be represented in
+// ISO-8859-1.
+func EncodeHeader(s, charset string) string {
+	if strings.EqualFold(charset, "ISO-8859-1") {
+		charset = "ISO-8859-1"
+	} else {
+		charset = "UTF-8"
+	}
+	words := strings.Split(s, " ")
+	out := make([]string, 0, len(words))
+	for i := 0; i < len(words); {
+		if !needsEncoding(words[i]) {
+			out = append(out, words[i])
+			i++
+			continue
+		}
+		// White space between encoded-words is ignored, so adjacent words must
+		// be encoded together along with the spaces that separate them.
+		j := i + 1
+		for j < len(words) && needsEncoding(words[j]) {
+			j++
+		}
+		out = append(out, encodeWords(strings.Join(words[i:j], " "), charset))
+		i = j
+	}
+	return strings.Join(out, " ")
+}
+
+// needsEncoding returns true if header word w cannot be sent as-is.
+func needsEncoding(w string) bool {
+	for i := 0; i < len(w); i++ {
+		if c := w[i]; (c < ' ' && c != '\t') || c >= 0x7F {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeWords converts s to one or more encoded-words using the shorter of Q
+// and B encodings.
+func encodeWords(s, charset string) string {
+	if charset != "UTF-8" {
+		b := make([]byte, 0, len(s))
+		for _, r := range s {
+			if r > 0xFF {
+				b, charset = nil, "UTF-8"
+				break
+			}
+			b = append(b, byte(r))
+		}
+		if b != nil {
+			s = string(b)
+		}
+	}
+	q := mime.QEncoding.Encode(charset, s)
+	b := mime.BEncoding.Encode(charset, s)
+	if len(b) < len(q) {
+		return b
+	}
+	return q
+}
+
+// has8BitHeader returns true if the header section of message msg contains any
+// 8-bit characters.
+func has8BitHeader(msg []byte) bool {
+	for i, c := range msg {
+		if c >= 0x80 {
+			return true
+		} else if c == '\n' && (bytes.HasPrefix(msg[i+1:], crlf) || bytes.HasPrefix(msg[i+1:], crlf[1:])) {
+			break // End of header
+		}
+	}
+	return false
+}
+
+// parseHeader parses a block of header fields, such as the contents of
+// BODY[HEADER.FIELDS (...)]. Any fields parsed before an error are returned.
+func parseHeader(b []byte) textproto.MIMEHeader {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(b)))
+	h, _ := r.ReadMIMEHeader()
+	if h == nil {
+		h = make(textproto.MIMEHeader)
+	}
+	return h
+}
+
+// decodeHeader decodes any RFC 2047 encoded-words in s. The original string is
+// returned if decoding fails (e.g. because of an unsupported charset).
+func decodeHeader(s string) string {
+	if v, err := DecodeHeader(s); err == nil {
+		return v
+	}
+	return s
+}
+
+// charsetReader returns a reader that converts input from the specified charset
+// to UTF-8. The mime package handles UTF-8, US-ASCII, and ISO-8859-1 on its own.
+func charsetReader(charset string, input io.Reader) (io.Reader, error) {
+	charset = strings.ToLower(charset)
+	switch charset {
+	case "windows-1252", "cp1252":
+		return &cp1252Reader{r: bufio.NewReader(input)}, nil
+	}
+	if CharsetReader != nil {
+		return CharsetReader(charset, input)
+	}
+	return nil, fmt.Errorf("imap: unsupported charset %q", charset)
+}
+
+// encodeCharset converts UTF-8 text s to the specified charset. An error is
+// returned if s is not valid UTF-8, or if it cannot be represented in the
+// charset.
+func encodeCharset(charset string, s []byte) ([]byte, error) {
+	if !utf8.Valid(s) {
+		return nil, errors.New("imap: invalid UTF-8 text")
+	}
+	var limit rune
+	switch charset = strings.ToLower(charset); charset {
+	case "utf-8":
+		return s, nil
+	case "us-ascii":
+		limit = utf8.RuneSelf - 1
+	case "iso-8859-1":
+		limit = 0xFF
+	case "windows-1252", "cp1252":
+		limit = -1
+	default:
+		if CharsetWriter == nil {
+			return nil, fmt.Errorf("imap: unsupported charset %q", charset)
+		}
+		var buf bytes.Buffer
+		w, err := CharsetWriter(charset, &buf)
+		if err == nil {
+			if _, err = w.Write(s); err == nil {
+				if c, ok := w.(io.Closer); ok {
+					err = c.Close()
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	out := make([]byte, 0, len(s))
+	for _, r := range string(s) {
+		if limit < 0 {
+			if b, ok := cp1252Byte(r); ok {
+				out = append(out, b)
+				continue
+			}
+		} else if r <= limit {
+			out = append(out, byte(r))
+			continue
+		}
+		return nil, fmt.Errorf("imap: %q cannot be represented in %s", r, charset)
+	}
+	return out, nil
+}
+
+// cp1252Byte returns the Windows-1252 encoding of r.
+func cp1252Byte(r rune) (byte, bool) {
+	if r < 0x80 || (0xA0 <= r && r <= 0xFF) {
+		return byte(r), true
+	}
+	for i, v := range cp1252 {
+		if v == r && v != utf8.RuneError {
+			return byte(0x80 + i), true
+		}
+	}
+	return 0, false
+}
+
+// cp1252 maps Windows-1252 bytes 0x80-0x9F to Unicode. All other bytes are
+// identical to ISO-8859-1. Undefined values are mapped to the replacement
+// character.
+var cp1252 = [32]rune{
+	'€', '\uFFFD', '‚', 'ƒ', '„', '…', '†', '‡',
+	'ˆ', '‰', 'Š', '‹', 'Œ', '\uFFFD', 'Ž', '\uFFFD',
+	'\uFFFD', '‘', '’', '“', '”', '•', '–', '—',
+	'˜', '™', 'š', '›', 'œ', '\uFFFD', 'ž', 'Ÿ',
+}
+
+// cp1252Reader converts Windows-1252 text to UTF-8.
+type cp1252Reader struct {
+	r   *bufio.Reader
+	buf [utf8.UTFMax]byte
+	out []byte
+}
+
+func (r *cp1252Reader) Read(p []byte) (n int, err error) {
+	for n < len(p) {
+		if len(r.out) > 0 {
+			c := copy(p[n:], r.out)
+			r.out = r.out[c:]
+			n += c
+			continue
+		}
+		var b byte
+		if b, err = r.r.ReadByte(); err != nil {
+			break
+		}
+		if b < 0x80 {
+			p[n] = b
+			n++
+			continue
+		}
+		c := rune(b)
+		if b < 0xA0 {
+			c = cp1252[b-0x80]
+		}
+		r.out = r.buf[:utf8.EncodeRune(r.buf[:], c)]
+	}
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+	return
+}
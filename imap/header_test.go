@@ -0,0 +1,117 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestDecodeHeader(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+		ok  bool
+	}{
+		{"", "", true},
+		{"Plain text", "Plain text", true},
+		{"=?UTF-8?B?w6lsw6h2ZQ==?=", "élève", true},
+		{"=?utf-8?q?caf=C3=A9_au_lait?=", "café au lait", true},
+		{"=?ISO-8859-1?Q?Andr=E9?= Pirard", "André Pirard", true},
+		{"=?windows-1252?Q?=93quoted=94_=80100?=", "“quoted” €100", true},
+		{"=?UTF-8?B?SGVs?= =?UTF-8?B?bG8=?=", "Hello", true},
+		{"=?UTF-8?Q?a?=\r\n =?UTF-8?Q?b?=", "ab", true},
+		{"=?UTF-8?Q?a?= b =?UTF-8?Q?c?=", "a b c", true},
+		{"=?x-test?Q?abc?=", "ABC", true},
+		{"=?x-unknown?Q?abc?=", "", false},
+		{"=?UTF-8?X?abc?=", "=?UTF-8?X?abc?=", true},
+	}
+	prev := CharsetReader
+	defer func() { CharsetReader = prev }()
+	CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		if charset != "x-test" {
+			return nil, errors.New("unknown charset")
+		}
+		b, err := ioutil.ReadAll(input)
+		return strings.NewReader(strings.ToUpper(string(b))), err
+	}
+	for _, test := range tests {
+		out, err := DecodeHeader(test.in)
+		if !test.ok {
+			if err == nil {
+				t.Errorf("DecodeHeader(%q) expected an error; got %q", test.in, out)
+			}
+		} else if err != nil {
+			t.Errorf("DecodeHeader(%q) unexpected error; %v", test.in, err)
+		} else if out != test.out {
+			t.Errorf("DecodeHeader(%q) expected %q; got %q", test.in, test.out, out)
+		}
+	}
+}
+
+func TestEncodeHeader(t *testing.T) {
+	long := strings.Repeat("日本語のテキスト", 8)
+	tests := []struct {
+		in      string
+		charset string
+		out     string // Expected output, if not empty
+	}{
+		{"", "", ""},
+		{"Plain ASCII subject", "", "Plain ASCII subject"},
+		{"Re: café", "", "Re: =?UTF-8?b?Y2Fmw6k=?="},
+		{"Re: internationalé", "", "Re: =?UTF-8?q?international=C3=A9?="},
+		{"Re: café", "iso-8859-1", "Re: =?ISO-8859-1?q?caf=E9?="},
+		{"Re: café au lait", "", "Re: =?UTF-8?b?Y2Fmw6k=?= au lait"},
+		{"Re: café crème", "", "Re: =?UTF-8?b?Y2Fmw6kgY3LDqG1l?="},
+		{"Здравствуйте", "", "=?UTF-8?b?0JfQtNGA0LDQstGB0YLQstGD0LnRgtC1?="},
+		{"Здравствуйте", "ISO-8859-1", "=?UTF-8?b?0JfQtNGA0LDQstGB0YLQstGD0LnRgtC1?="},
+		{"Hello " + long + " world", "", ""},
+	}
+	for _, test := range tests {
+		out := EncodeHeader(test.in, test.charset)
+		if test.out != "" && out != test.out {
+			t.Errorf("EncodeHeader(%q, %q) expected %q; got %q", test.in, test.charset, test.out, out)
+		}
+		for _, w := range strings.Split(out, " ") {
+			if len(w) > 75 {
+				t.Errorf("EncodeHeader(%q, %q) encoded-word too long: %q", test.in, test.charset, w)
+			}
+		}
+		if dec, err := DecodeHeader(out); err != nil || dec != test.in {
+			t.Errorf("DecodeHeader(EncodeHeader(%q, %q)) expected %q; got %q (%v)", test.in, test.charset, test.in, dec, err)
+		}
+	}
+}
+
+func TestEncodeCharset(t *testing.T) {
+	tests := []struct {
+		in      string
+		charset string
+		out     string // Expected output, empty on error
+	}{
+		{"plain", "US-ASCII", "plain"},
+		{"café", "us-ascii", ""},
+		{"café", "ISO-8859-1", "caf\xE9"},
+		{"€5", "iso-8859-1", ""},
+		{"€5 café", "Windows-1252", "\x805 caf\xE9"},
+		{"日本", "windows-1252", ""},
+		{"日本", "UTF-8", "日本"},
+		{"日本", "Shift_JIS", ""},
+		{"bad \xFF", "iso-8859-1", ""},
+	}
+	for _, test := range tests {
+		out, err := encodeCharset(test.charset, []byte(test.in))
+		if test.out == "" {
+			if err == nil {
+				t.Errorf("encodeCharset(%q, %q) expected error; got %q", test.charset, test.in, out)
+			}
+		} else if err != nil || string(out) != test.out {
+			t.Errorf("encodeCharset(%q, %q) expected %q; got %q (%v)", test.charset, test.in, test.out, out, err)
+		}
+	}
+}
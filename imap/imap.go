@@ -6,8 +6,15 @@ package imap
 
 import (
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -30,8 +37,47 @@ func Dial(addr string) (c *Client, err error) {
 	return
 }
 
+// DialStartTLS returns a new Client connected to an IMAP server at addr, which
+// is normally a cleartext port. If the server advertises STARTTLS capability in
+// its greeting (or in the response to CAPABILITY command sent by NewClient),
+// encryption is enabled before the Client is returned. The config is
+// interpreted as described by DialTLS. If STARTTLS is advertised, but the
+// command or the TLS handshake fails, an error is returned and the connection
+// is closed instead of continuing in cleartext. The same happens if the server
+// advertises STARTTLS in a PREAUTH greeting, which does not permit the
+// command. Servers that do not advertise STARTTLS are used without encryption.
+func DialStartTLS(addr string, config *tls.Config) (c *Client, err error) {
+	addr = defaultPort(addr, "143")
+	conn, err := net.DialTimeout("tcp", addr, netTimeout)
+	if err == nil {
+		host, _, _ := net.SplitHostPort(addr)
+		if c, err = NewClient(conn, host, clientTimeout); err == nil {
+			err = c.autoStartTLS(config)
+		}
+		if err != nil {
+			c = nil
+			conn.Close()
+		}
+	}
+	return
+}
+
+// autoStartTLS enables encryption if the server advertises STARTTLS capability.
+func (c *Client) autoStartTLS(config *tls.Config) error {
+	if !c.Caps["STARTTLS"] || c.t.Encrypted() {
+		return nil
+	} else if c.state != Login {
+		return errors.New("imap: STARTTLS is not allowed after PREAUTH greeting")
+	}
+	_, err := c.StartTLS(config)
+	return err
+}
+
 // DialTLS returns a new Client connected to an IMAP server at addr using the
-// specified config for encryption.
+// specified config for encryption. If config is nil, the server certificate is
+// verified using the system roots, and the host name is taken from addr. The
+// host name is also used when config.ServerName is empty. See VerifyCert and
+// PinCert for alternative ways of verifying the server's identity.
 func DialTLS(addr string, config *tls.Config) (c *Client, err error) {
 	addr = defaultPort(addr, "993")
 	conn, err := net.DialTimeout("tcp", addr, netTimeout)
@@ -61,7 +107,9 @@ func Wait(cmd *Command, err error) (*Command, error) {
 // client automatically requests capabilities when the connection is first
 // established, after a successful STARTTLS command, and after user
 // authentication, making it unnecessary to call this method directly in most
-// cases. The current capabilities are available in c.Caps.
+// cases. The current capabilities are available in c.Caps. When the command
+// completes, c.Caps is rebuilt from the server's response, discarding any
+// capabilities that are no longer advertised.
 //
 // This command is synchronous.
 func (c *Client) Capability() (cmd *Command, err error) {
@@ -81,26 +129,43 @@ func (c *Client) Noop() (cmd *Command, err error) {
 // A negative timeout allows the client to wait indefinitely for the normal
 // logout sequence to complete. A timeout of 0 causes the connection to be
 // closed immediately without actually sending the LOGOUT command. A positive
-// timeout behaves as expected, returning ErrTimeout if the normal logout
-// sequence is not completed in the allocated time. The connection is always
-// closed when this method returns.
+// timeout sets a hard deadline for the server to send the BYE response and
+// complete the command. ErrTimeout is returned if the deadline expires, in which
+// case the connection is closed forcibly. A nil error indicates a clean logout.
+// The connection is always closed when this method returns.
+//
+// If the server has already ended the session with an unsolicited BYE response,
+// the connection is closed without sending the LOGOUT command.
+//
+// Logout may be called from another goroutine while Recv (or a synchronous
+// command) is blocked waiting for a response. The LOGOUT command is not sent in
+// that case. The connection is closed immediately, which causes the blocked
+// call to return an error. Calling Logout more than once, or after the
+// connection is closed, has no effect.
 //
 // This command is synchronous.
 func (c *Client) Logout(timeout time.Duration) (cmd *Command, err error) {
-	if c.state == Closed {
-		return nil, ErrNotAllowed
+	if !atomic.CompareAndSwapInt32(&c.loggingOut, 0, 1) {
+		return nil, nil
+	} else if atomic.LoadInt32(&c.receiving) > 0 {
+		return nil, c.close("logout during receive")
+	} else if c.state == Closed {
+		return nil, nil
 	}
+	c.StopKeepAlive()
 	defer c.setState(Closed)
 	defer c.close("logout error")
 
 	c.setState(Logout)
-	if timeout == 0 {
+	if timeout == 0 || c.bye != nil {
 		err = c.close("immediate logout")
 	} else {
 		if timeout > 0 {
 			c.t.conn.SetDeadline(time.Now().Add(timeout))
 		}
-		cmd, err = Wait(c.Send("LOGOUT"))
+		if cmd, err = Wait(c.Send("LOGOUT")); err == nil {
+			return // BYE was received before the command completion
+		}
 	}
 	for err == nil {
 		if err = c.Recv(block); err == io.EOF {
@@ -115,21 +180,30 @@ func (c *Client) Logout(timeout time.Duration) (cmd *Command, err error) {
 
 // StartTLS enables session privacy protection and integrity checking. The
 // server must advertise STARTTLS capability for this command to be available.
-// The client automatically requests new capabilities if the TLS handshake is
-// successful.
+// The config is interpreted as described by DialTLS, with the host name that
+// was passed to NewClient.
+// ErrEncryptionActive is returned if the connection is already encrypted.
+//
+// Capabilities received before the TLS handshake could have been modified by an
+// attacker (e.g. to remove STARTTLS or to hide stronger authentication
+// mechanisms), so they are discarded when the server accepts the command. The
+// client automatically requests new capabilities if the handshake is
+// successful. Otherwise, c.Caps remains empty.
 //
 // This command is synchronous.
 func (c *Client) StartTLS(config *tls.Config) (cmd *Command, err error) {
-	if !c.Caps["STARTTLS"] {
-		return nil, NotAvailableError("STARTTLS")
-	} else if c.t.Encrypted() {
+	defer c.syncCmd()()
+	if c.t.Encrypted() {
 		return nil, ErrEncryptionActive
+	} else if !c.Caps["STARTTLS"] {
+		return nil, NotAvailableError("STARTTLS")
 	}
 	if cmd, err = Wait(c.Send("STARTTLS")); err == nil {
 		if c.rch != nil {
 			// Should never happen
 			panic("imap: receiver is active, cannot perform TLS handshake")
 		}
+		c.setCaps(nil)
 		if err = c.t.EnableTLS(setServerName(config, c.host)); err == nil {
 			_, err = c.Capability()
 		}
@@ -142,12 +216,19 @@ func (c *Client) StartTLS(config *tls.Config) (cmd *Command, err error) {
 //
 // This command is synchronous.
 func (c *Client) Auth(a SASL) (cmd *Command, err error) {
+	defer c.syncCmd()()
 	info := ServerInfo{c.host, c.t.Encrypted(), c.getCaps("AUTH=")}
 	mech, cr, err := a.Start(&info)
 	if err != nil {
 		return
 	} else if name := "AUTH=" + mech; !c.Caps[name] {
 		return nil, NotAvailableError(name)
+	} else if mech == "PLAIN" {
+		if err = c.checkCleartext(); err != nil {
+			return nil, err
+		} else if !info.TLS && !c.AllowCleartextLogin {
+			return nil, NotAvailableError(name)
+		}
 	}
 	args := []Field{mech, nil}[:1]
 
@@ -160,29 +241,33 @@ func (c *Client) Auth(a SASL) (cmd *Command, err error) {
 		}
 		cr = nil
 	}
-	cmd, err = c.Send("AUTHENTICATE", args...)
+	if cmd, err = c.Send("AUTHENTICATE", args...); err != nil {
+		return
+	}
 
 	// Challenge-response loop
 	var rsp *Response
-	var abort error
-	for err == nil && cmd.InProgress() {
-		rsp, err = c.checkContinue(cmd, true)
-		if err == nil && rsp.Type == Continue {
-			if cr == nil {
-				if cr, abort = a.Next(rsp.Challenge()); abort == nil {
-					cr = b64enc(cr)
-				} else if err = c.t.WriteLine([]byte("*")); err == nil {
-					err = c.t.Flush()
-					break
-				}
-			}
-			err, cr = c.t.WriteLine(cr), nil
+	abort, err := c.interact(cmd, func(rsp *Response) ([]byte, error) {
+		if cr != nil {
+			ir := cr
+			cr = nil
+			return ir, nil
 		}
-	}
+		r, err := a.Next(rsp.Challenge())
+		return b64enc(r), err
+	})
 
 	// Wait for command completion
 	if err == nil {
 		if rsp, err = cmd.Result(OK); err == nil {
+			if f, ok := a.(saslFinisher); ok {
+				if err = f.finish(); err != nil {
+					// Server considers the session authenticated
+					c.close("SASL server verification failed")
+					c.setState(Closed)
+					return
+				}
+			}
 			c.setState(Auth)
 			if rsp.Label != "CAPABILITY" {
 				_, err = c.Capability()
@@ -194,13 +279,52 @@ func (c *Client) Auth(a SASL) (cmd *Command, err error) {
 	return
 }
 
+// SendRaw issues a new command that may require additional data from the
+// client after the server sends a continuation request ("+"). This is the
+// interface for implementing interactive extension commands, such as new
+// authentication exchanges. Fields are encoded and sent as described by Send,
+// including any literals. Afterwards, cont is called for each continuation
+// request and the returned line is sent to the server without the CRLF ending.
+// SendRaw returns once the command is completed.
+//
+// If cont returns an error, a line containing a single "*" is sent to cancel
+// the command (RFC 3501 section 6.2.2). The server should then reject the
+// command, usually with BAD status. The error from cont is returned after the
+// command is completed.
+//
+// Like Send, this method does not validate the fields or update the connection
+// state.
+func (c *Client) SendRaw(name string, cont ContinueFunc, fields ...Field) (cmd *Command, err error) {
+	defer c.syncCmd()()
+	if cmd, err = c.Send(name, fields...); err != nil {
+		return
+	}
+	abort, err := c.interact(cmd, cont)
+	if err == nil && abort != nil {
+		if _, err = cmd.Result(0); err == nil {
+			err = abort
+		}
+	}
+	return
+}
+
+// ContinueFunc returns the next line of command data in response to a server
+// continuation request (see Client.SendRaw). The line must not contain CR or
+// LF characters.
+type ContinueFunc func(cont *Response) (line []byte, err error)
+
 // Login performs plaintext username/password authentication. This command is
-// disabled when the server advertises LOGINDISABLED capability. The client
-// automatically requests new capabilities if authentication is successful.
+// disabled when the server advertises LOGINDISABLED capability. ErrLoginDisabled
+// is returned without sending the credentials if the connection is also not
+// encrypted, unless c.AllowCleartextLogin is set. The client automatically
+// requests new capabilities if authentication is successful.
 //
 // This command is synchronous.
 func (c *Client) Login(username, password string) (cmd *Command, err error) {
-	if c.Caps["LOGINDISABLED"] {
+	defer c.syncCmd()()
+	if err = c.checkCleartext(); err != nil {
+		return nil, err
+	} else if c.Caps["LOGINDISABLED"] && !c.AllowCleartextLogin {
 		return nil, NotAvailableError("LOGIN")
 	}
 	cmd, err = Wait(c.Send("LOGIN", c.Quote(username), c.Quote(password)))
@@ -222,81 +346,300 @@ func (c *Client) Login(username, password string) (cmd *Command, err error) {
 // readonly is false, the server may decide not to give read-write access. The
 // server may also change access while the mailbox is open. The current mailbox
 // status is available from c.Mailbox while the client is in the Selected state.
+// If the server supports OBJECTID, c.Mailbox.MailboxID identifies the mailbox
+// independently of its name, allowing it to be recognized after a rename.
 //
 // This command is synchronous.
 func (c *Client) Select(mbox string, readonly bool) (cmd *Command, err error) {
 	return Wait(c.doSelect(mbox, readonly))
 }
 
+// Examine opens a mailbox on the server for read-only access. It is identical
+// to Select(mbox, true), and c.Mailbox.ReadOnly is always true after a
+// successful EXAMINE, even if the server did not send the READ-ONLY response
+// code.
+//
+// This command is synchronous.
+func (c *Client) Examine(mbox string) (cmd *Command, err error) {
+	return Wait(c.doSelect(mbox, true))
+}
+
+// SelectResync opens a mailbox for read-write access and resynchronizes the
+// client's cached state using the QRESYNC extension (RFC 7162). uidValidity and
+// modSeq are the UIDVALIDITY and HIGHESTMODSEQ values from the last time the
+// mailbox was open. knownUIDs, which may be nil, limits the response to the
+// UIDs that the client knows about. The server reports messages expunged since
+// modSeq in cmd.Vanished and changed messages as FETCH responses in cmd.Data.
+// The new mod-sequence to store for next time is available from
+// cmd.HighestModSeq and c.Mailbox.HighestModSeq. QRESYNC must first be enabled
+// with the Enable command.
+//
+// This command is synchronous.
+func (c *Client) SelectResync(mbox string, uidValidity uint32, modSeq uint64, knownUIDs *SeqSet) (cmd *Command, err error) {
+	if !c.Enabled["QRESYNC"] {
+		return nil, NotAvailableError("QRESYNC")
+	}
+	f := []Field{uidValidity, modSeq, nil}[:2]
+	if knownUIDs != nil && !knownUIDs.Empty() {
+		f = append(f, knownUIDs)
+	}
+	return Wait(c.doSelect(mbox, false, []Field{"QRESYNC", f}))
+}
+
 // Create creates a new mailbox on the server.
 func (c *Client) Create(mbox string) (cmd *Command, err error) {
-	return c.Send("CREATE", c.Quote(UTF7Encode(mbox)))
+	return c.Send("CREATE", c.quoteMailbox(mbox))
+}
+
+// CreateSpecial creates a new mailbox and designates it for the specified
+// special uses, such as `\Archive` or `\Sent`. Only the attributes defined in
+// RFC 6154 are accepted, in any letter case. If the server rejects any of the
+// attributes, a *UseAttrError is returned. The server must advertise
+// CREATE-SPECIAL-USE capability.
+//
+// This command is synchronous.
+func (c *Client) CreateSpecial(mbox string, uses ...string) (cmd *Command, err error) {
+	if !c.Caps["CREATE-SPECIAL-USE"] {
+		return nil, NotAvailableError("CREATE-SPECIAL-USE")
+	} else if len(uses) == 0 {
+		return nil, errors.New("imap: no special-use attributes specified")
+	}
+	attrs := make([]Field, len(uses))
+	for i, use := range uses {
+		for _, v := range specialUse {
+			if toUpper(use) == toUpper(v) {
+				attrs[i] = v
+				break
+			}
+		}
+		if attrs[i] == nil {
+			return nil, fmt.Errorf("imap: invalid special-use attribute %q", use)
+		}
+	}
+	cmd, err = Wait(c.Send("CREATE", c.quoteMailbox(mbox), []Field{"USE", attrs}))
+	if rsp, ok := err.(ResponseError); ok && rsp.Label == "USEATTR" {
+		err = &UseAttrError{rsp.Response}
+	}
+	return
 }
 
 // Delete permanently removes a mailbox and all of its contents from the server.
 func (c *Client) Delete(mbox string) (cmd *Command, err error) {
-	return c.Send("DELETE", c.Quote(UTF7Encode(mbox)))
+	return c.Send("DELETE", c.quoteMailbox(mbox))
 }
 
 // Rename changes the name of a mailbox.
 func (c *Client) Rename(old, new string) (cmd *Command, err error) {
-	return c.Send("RENAME", c.Quote(UTF7Encode(old)), c.Quote(UTF7Encode(new)))
+	return c.Send("RENAME", c.quoteMailbox(old), c.quoteMailbox(new))
 }
 
 // Subscribe adds the specified mailbox name to the server's set of "active" or
-// "subscribed" mailboxes as returned by the LSUB command.
+// "subscribed" mailboxes as returned by the ListSubscribed and LSub methods.
+// The name is encoded in modified UTF-7, as in List.
 func (c *Client) Subscribe(mbox string) (cmd *Command, err error) {
-	return c.Send("SUBSCRIBE", c.Quote(UTF7Encode(mbox)))
+	return c.Send("SUBSCRIBE", c.quoteMailbox(mbox))
 }
 
 // Unsubscribe removes the specified mailbox name from the server's set of
-// "active" or "subscribed" mailboxes as returned by the LSUB command.
+// "active" or "subscribed" mailboxes as returned by the ListSubscribed and LSub
+// methods. The name is encoded in modified UTF-7, as in List.
 func (c *Client) Unsubscribe(mbox string) (cmd *Command, err error) {
-	return c.Send("UNSUBSCRIBE", c.Quote(UTF7Encode(mbox)))
+	return c.Send("UNSUBSCRIBE", c.quoteMailbox(mbox))
 }
 
 // List returns a subset of mailbox names from the complete set of all names
 // available to the client.
 //
+// The reference name and mailbox pattern are encoded in modified UTF-7, so they
+// may contain any Unicode characters, including the "*" and "%" wildcards.
+// Mailbox names in the responses are decoded by rsp.MailboxInfo.
+//
 // See RFC 3501 sections 6.3.8 and 7.2.2, and RFC 2683 for detailed information
 // about the LIST and LSUB commands.
 func (c *Client) List(ref, mbox string) (cmd *Command, err error) {
-	return c.Send("LIST", c.Quote(ref), c.Quote(mbox))
+	return c.Send("LIST", c.quoteMailbox(ref), c.quoteMailbox(mbox))
 }
 
 // LSub returns a subset of mailbox names from the set of names that the user
-// has declared as being "active" or "subscribed".
+// has declared as being "active" or "subscribed". LSUB responses are decoded by
+// rsp.MailboxInfo in the same way as LIST responses.
+//
+// LSUB is deprecated by RFC 9051 in favor of the LIST-EXTENDED SUBSCRIBED
+// selection option. Use ListSubscribed, which picks the appropriate command.
 func (c *Client) LSub(ref, mbox string) (cmd *Command, err error) {
-	return c.Send("LSUB", c.Quote(ref), c.Quote(mbox))
+	return c.Send("LSUB", c.quoteMailbox(ref), c.quoteMailbox(mbox))
+}
+
+// ListSubscribed returns a subset of mailbox names from the set of subscribed
+// mailboxes. If the server advertises LIST-EXTENDED capability, the LIST
+// command is sent with the SUBSCRIBED selection option, which returns all
+// mailbox attributes, including `\Subscribed` and `\NonExistent` for
+// subscriptions to mailboxes that no longer exist. Otherwise, the LSUB command
+// is sent instead. Use rsp.MailboxInfo to decode the responses in either case.
+func (c *Client) ListSubscribed(ref, mbox string) (cmd *Command, err error) {
+	if !c.Caps["LIST-EXTENDED"] {
+		return c.LSub(ref, mbox)
+	}
+	return c.ListExtended(ref, []string{mbox}, []string{"SUBSCRIBED"}, nil)
+}
+
+// ListExtended is an extended version of List that accepts multiple mailbox
+// patterns, selection options (e.g. "SUBSCRIBED"), and return options (e.g.
+// "SPECIAL-USE", "CHILDREN"). It requires LIST-EXTENDED capability, except when
+// only a single pattern and no selection options are specified. In that case,
+// a plain LIST command is sent if the server does not advertise LIST-EXTENDED.
+// The SPECIAL-USE return option is ignored if the server does not advertise
+// SPECIAL-USE capability. Servers that do, include special-use attributes in
+// plain LIST responses as well, so they are available via rsp.MailboxInfo in
+// either case. If the STATUS return option is specified with its items (e.g.
+// "STATUS (MESSAGES)"), the STATUS responses are also delivered to cmd.Data.
+//
+// See RFC 5258, RFC 5819, and RFC 6154 for additional information.
+func (c *Client) ListExtended(ref string, patterns []string, selectOpts, returnOpts []string) (cmd *Command, err error) {
+	ret := make([]Field, 0, len(returnOpts))
+	status := false
+	for _, opt := range returnOpts {
+		if opt = toUpper(opt); opt != "SPECIAL-USE" || c.Caps["SPECIAL-USE"] {
+			ret = append(ret, opt)
+		}
+		status = status || opt == "STATUS" || strings.HasPrefix(opt, "STATUS ")
+	}
+	if !c.Caps["LIST-EXTENDED"] {
+		if len(patterns) != 1 || len(selectOpts) > 0 ||
+			len(ret) > 1 || (len(ret) == 1 && ret[0] != "SPECIAL-USE") {
+			return nil, NotAvailableError("LIST-EXTENDED")
+		}
+		return c.List(ref, patterns[0])
+	}
+	f := make([]Field, 0, 5)
+	if len(selectOpts) > 0 {
+		f = append(f, stringsToFields(selectOpts))
+	}
+	f = append(f, c.quoteMailbox(ref))
+	if len(patterns) == 1 {
+		f = append(f, c.quoteMailbox(patterns[0]))
+	} else {
+		list := make([]Field, len(patterns))
+		for i, v := range patterns {
+			list[i] = c.quoteMailbox(v)
+		}
+		f = append(f, list)
+	}
+	if len(ret) > 0 {
+		f = append(f, "RETURN", ret)
+	}
+	return c.sendInit(func(cmd *Command) { cmd.listStatus = status }, "LIST", f...)
 }
 
 // Status requests the status of the indicated mailbox. The currently defined
 // status data items that can be requested are: MESSAGES, RECENT, UIDNEXT,
-// UIDVALIDITY, and UNSEEN. All data items are requested by default.
+// UIDVALIDITY, UNSEEN, HIGHESTMODSEQ (requires CONDSTORE capability), and
+// MAILBOXID (requires OBJECTID capability). All RFC 3501 data items are
+// requested by default. An error is returned without sending the command if an
+// unknown item is specified. Use rsp.MailboxStatus to decode the STATUS
+// response in cmd.Data.
 func (c *Client) Status(mbox string, items ...string) (cmd *Command, err error) {
-	var f []Field
-	if len(items) == 0 {
-		f = []Field{"MESSAGES", "RECENT", "UIDNEXT", "UIDVALIDITY", "UNSEEN"}
-	} else {
-		f = stringsToFields(items)
+	f, err := c.statusItems(items)
+	if err != nil {
+		return nil, err
+	}
+	return c.Send("STATUS", c.quoteMailbox(mbox), f)
+}
+
+// ListStatus lists the mailboxes matching the reference and pattern (see List)
+// and returns the status of each one in a single command, using the STATUS
+// return option of the LIST command (RFC 5819). This avoids a separate STATUS
+// round trip for every mailbox. The items are the same as for Status. The
+// server sends a STATUS response after the LIST response of each mailbox that
+// can be selected. These are matched by mailbox name, and the status is
+// returned in info.Status, which is nil for mailboxes without one (e.g. those
+// with the `\Noselect` attribute). The mailboxes are returned in the order they
+// were listed. The server must advertise LIST-STATUS capability.
+//
+// This command is synchronous.
+func (c *Client) ListStatus(ref, pattern string, items ...string) ([]*MailboxInfo, error) {
+	if !c.Caps["LIST-STATUS"] {
+		return nil, NotAvailableError("LIST-STATUS")
 	}
-	return c.Send("STATUS", c.Quote(UTF7Encode(mbox)), f)
+	f, err := c.statusItems(items)
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := Wait(c.sendInit(func(cmd *Command) { cmd.listStatus = true },
+		"LIST", c.quoteMailbox(ref), c.quoteMailbox(pattern), "RETURN", []Field{"STATUS", f}))
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*MailboxInfo, 0, len(cmd.Data))
+	names := make(map[string]*MailboxInfo, len(cmd.Data))
+	for _, rsp := range cmd.Data {
+		if info := rsp.MailboxInfo(); info != nil {
+			list = append(list, info)
+			names[info.Name] = info
+		}
+	}
+	for _, rsp := range cmd.Data {
+		if status := rsp.MailboxStatus(); status != nil {
+			if info := names[status.Name]; info != nil {
+				info.Status = status
+			}
+		}
+	}
+	return list, nil
 }
 
 // Append appends the literal argument as a new message to the end of the
 // specified destination mailbox. Flags and internal date arguments are optional
-// and may be set to nil.
+// and may be set to nil. An empty flag set and a zero time are also omitted
+// from the command, so the server assigns its own defaults instead of an empty
+// flag list or a date in year 1. The literal is sent after the server's
+// continuation request unless non-synchronizing literals are supported (see
+// Send). If the server supports UIDPLUS, use cmd.AppendUID to obtain the UID
+// assigned to the new message. If the message would exceed a quota limit, the
+// error returned by cmd.Result matches ErrOverQuota.
+//
+// Message headers must be 7-bit (see EncodeHeader) unless UTF8=ACCEPT has been
+// enabled. For literals created by NewLiteral, this is verified before sending
+// the command, and ErrUTF8Headers is returned if the check fails.
 func (c *Client) Append(mbox string, flags FlagSet, idate *time.Time, msg Literal) (cmd *Command, err error) {
-	f := []Field{c.Quote(UTF7Encode(mbox)), nil, nil, nil}[:1]
-	if flags != nil {
+	if l, ok := msg.(*literal); ok && !c.Enabled["UTF8=ACCEPT"] && has8BitHeader(l.data) {
+		return nil, ErrUTF8Headers
+	}
+	f := []Field{c.quoteMailbox(mbox), nil, nil, nil}[:1]
+	if len(flags) > 0 {
 		f = append(f, flags)
 	}
-	if idate != nil {
+	if idate != nil && !idate.IsZero() {
 		f = append(f, *idate)
 	}
 	return c.Send("APPEND", append(f, msg)...)
 }
 
+// AppendReader is like Append, but the message is streamed from r instead of
+// being held in memory. Exactly size bytes are read from r and sent to the
+// server after it accepts the literal. This allows large messages to be
+// appended directly from a file. Message headers are not checked for 8-bit
+// characters.
+//
+// If r returns fewer than size bytes, ErrShortLiteral is returned. Any error
+// that occurs after the server starts accepting message data leaves the
+// connection out of sync, so the connection is closed. A write deadline set on
+// the underlying connection applies to the entire stream, and ErrTimeout is
+// returned if it expires.
+func (c *Client) AppendReader(mbox string, flags FlagSet, idate *time.Time, size int64, r io.Reader) (cmd *Command, err error) {
+	if size < 0 || size > math.MaxUint32 {
+		return nil, fmt.Errorf("imap: invalid message size %d", size)
+	}
+	msg := &readerLiteral{r: r, info: LiteralInfo{Len: uint32(size)}}
+	if cmd, err = c.Append(mbox, flags, idate, msg); err != nil && msg.sent {
+		c.close("append stream error")
+		if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
+			err = ErrTimeout
+		}
+	}
+	return
+}
+
 // Check requests a checkpoint of the currently selected mailbox. A checkpoint
 // is an implementation detail of the server and may be equivalent to a NOOP.
 func (c *Client) Check() (cmd *Command, err error) {
@@ -314,6 +657,7 @@ func (c *Client) Check() (cmd *Command, err error) {
 //
 // This command is synchronous.
 func (c *Client) Close(expunge bool) (cmd *Command, err error) {
+	defer c.syncCmd()()
 	name := "CLOSE"
 	if !expunge {
 		if !c.Caps["UNSELECT"] {
@@ -323,7 +667,7 @@ func (c *Client) Close(expunge bool) (cmd *Command, err error) {
 			}
 			return
 		}
-		name = "UNSELECT"
+		return c.Unselect()
 	}
 	if cmd, err = Wait(c.Send(name)); err == nil {
 		c.setState(Auth)
@@ -331,32 +675,121 @@ func (c *Client) Close(expunge bool) (cmd *Command, err error) {
 	return
 }
 
+// Unselect closes the currently selected mailbox without expunging any
+// messages, returning the client to the authenticated state. The server must
+// advertise UNSELECT capability. Otherwise, use Close(false), which achieves the
+// same result by examining a non-existent mailbox. See RFC 3691 for additional
+// information.
+//
+// This command is synchronous.
+func (c *Client) Unselect() (cmd *Command, err error) {
+	defer c.syncCmd()()
+	if !c.Caps["UNSELECT"] {
+		return nil, NotAvailableError("UNSELECT")
+	}
+	if cmd, err = Wait(c.Send("UNSELECT")); err == nil {
+		c.setState(Auth)
+	}
+	return
+}
+
 // Expunge permanently removes all messages that have the \Deleted flag set from
 // the currently selected mailbox. If UIDPLUS capability is advertised, the
 // operation can be restricted to messages with specific UIDs by specifying a
-// non-nil uids argument.
+// non-nil uids argument (see UIDExpunge). Use cmd.Expunged to obtain the
+// message sequence numbers of the removed messages.
 func (c *Client) Expunge(uids *SeqSet) (cmd *Command, err error) {
 	if uids != nil {
-		if !c.Caps["UIDPLUS"] {
-			return nil, NotAvailableError("UIDPLUS")
-		}
-		return c.Send("UID EXPUNGE", uids)
+		return c.UIDExpunge(uids)
 	}
 	return c.Send("EXPUNGE")
 }
 
+// CatenatePart is a single part of a message composed by AppendCatenate. It is
+// either a literal text or an IMAP URL that refers to a message or a message
+// part on the server. Use CatText and CatURL to create new parts.
+type CatenatePart struct {
+	Text Literal // Literal text (TEXT)
+	URL  string  // IMAP URL (URL), used if Text is nil
+}
+
+// CatText returns a CatenatePart containing literal text b.
+func CatText(b []byte) CatenatePart {
+	return CatenatePart{Text: NewLiteral(b)}
+}
+
+// CatURL returns a CatenatePart that refers to a message or a message part on
+// the server (e.g. "/INBOX;UIDVALIDITY=385759045/;UID=20/;SECTION=1").
+func CatURL(url string) CatenatePart {
+	return CatenatePart{URL: url}
+}
+
+// AppendCatenate is identical to Append, but the new message is composed by
+// the server from one or more parts, which may be literal text or URLs of
+// existing messages and message parts. This avoids downloading the referenced
+// data to the client. Each text part is sent as a separate literal. The server
+// must advertise CATENATE capability. See RFC 4469 for additional information.
+func (c *Client) AppendCatenate(mbox string, flags FlagSet, idate *time.Time, parts ...CatenatePart) (cmd *Command, err error) {
+	if !c.Caps["CATENATE"] {
+		return nil, NotAvailableError("CATENATE")
+	} else if len(parts) == 0 {
+		return nil, errors.New("imap: no catenate parts")
+	}
+	cat := make([]Field, 0, 2*len(parts))
+	for i, p := range parts {
+		if p.Text != nil {
+			l, ok := p.Text.(*literal)
+			if ok && i == 0 && !c.Enabled["UTF8=ACCEPT"] && has8BitHeader(l.data) {
+				return nil, ErrUTF8Headers
+			}
+			cat = append(cat, "TEXT", p.Text)
+		} else {
+			cat = append(cat, "URL", c.Quote(p.URL))
+		}
+	}
+	f := []Field{c.quoteMailbox(mbox), nil, nil, nil}[:1]
+	if flags != nil {
+		f = append(f, flags)
+	}
+	if idate != nil {
+		f = append(f, *idate)
+	}
+	return c.Send("APPEND", append(f, "CATENATE", cat)...)
+}
+
 // Search searches the mailbox for messages that match the given searching
 // criteria. See RFC 3501 section 6.4.4 for a list of all valid search keys. It
 // is the caller's responsibility to quote strings when necessary. All strings
-// must use UTF-8 encoding.
+// must use UTF-8 encoding. SearchCriteria can be used to build a properly
+// encoded spec. Gmail search keys (e.g. X-GM-RAW) require X-GM-EXT-1
+// capability.
 func (c *Client) Search(spec ...Field) (cmd *Command, err error) {
+	if err = c.checkSearch(spec); err != nil {
+		return nil, err
+	}
 	return c.Send("SEARCH", append([]Field{"CHARSET", "UTF-8"}, spec...)...)
 }
 
+// SearchReturn is identical to Search, but it uses the extended SEARCH command
+// (RFC 4731) to request only the specified result options, such as "MIN",
+// "MAX", "COUNT", and "ALL". An empty list of options is equivalent to "ALL".
+// If the server does not advertise the ESEARCH capability, a plain SEARCH
+// command is sent instead. Use cmd.ESearchResult to obtain the results in
+// either case.
+func (c *Client) SearchReturn(opts []string, spec ...Field) (cmd *Command, err error) {
+	if err = c.checkSearch(spec); err != nil {
+		return nil, err
+	}
+	return c.Send("SEARCH", c.searchSpec(opts, spec)...)
+}
+
 // Fetch retrieves data associated with the specified message(s) in the mailbox.
 // See RFC 3501 section 6.4.5 for a list of all valid message data items and
-// macros.
+// macros. The EMAILID and THREADID items require OBJECTID capability.
 func (c *Client) Fetch(seq *SeqSet, items ...string) (cmd *Command, err error) {
+	if err = c.checkFetch(items); err != nil {
+		return nil, err
+	}
 	return c.Send("FETCH", seq, stringsToFields(items))
 }
 
@@ -366,20 +799,37 @@ func (c *Client) Store(seq *SeqSet, item string, value Field) (cmd *Command, err
 }
 
 // Copy copies the specified message(s) to the end of the specified destination
-// mailbox.
+// mailbox. If the server supports UIDPLUS, use cmd.CopyUID to obtain the UIDs
+// of the new messages. If the copy would exceed a quota limit, the error
+// returned by cmd.Result matches ErrOverQuota.
 func (c *Client) Copy(seq *SeqSet, mbox string) (cmd *Command, err error) {
-	return c.Send("COPY", seq, c.Quote(UTF7Encode(mbox)))
+	return c.Send("COPY", seq, c.quoteMailbox(mbox))
 }
 
 // UIDSearch is identical to Search, but the numbers returned in the response
 // are unique identifiers instead of message sequence numbers.
 func (c *Client) UIDSearch(spec ...Field) (cmd *Command, err error) {
+	if err = c.checkSearch(spec); err != nil {
+		return nil, err
+	}
 	return c.Send("UID SEARCH", append([]Field{"CHARSET", "UTF-8"}, spec...)...)
 }
 
+// UIDSearchReturn is identical to SearchReturn, but the numbers returned in the
+// response are unique identifiers instead of message sequence numbers.
+func (c *Client) UIDSearchReturn(opts []string, spec ...Field) (cmd *Command, err error) {
+	if err = c.checkSearch(spec); err != nil {
+		return nil, err
+	}
+	return c.Send("UID SEARCH", c.searchSpec(opts, spec)...)
+}
+
 // UIDFetch is identical to Fetch, but the seq argument is interpreted as
 // containing unique identifiers instead of message sequence numbers.
 func (c *Client) UIDFetch(seq *SeqSet, items ...string) (cmd *Command, err error) {
+	if err = c.checkFetch(items); err != nil {
+		return nil, err
+	}
 	return c.Send("UID FETCH", seq, stringsToFields(items))
 }
 
@@ -392,11 +842,366 @@ func (c *Client) UIDStore(seq *SeqSet, item string, value Field) (cmd *Command,
 // UIDCopy is identical to Copy, but the seq argument is interpreted as
 // containing unique identifiers instead of message sequence numbers.
 func (c *Client) UIDCopy(seq *SeqSet, mbox string) (cmd *Command, err error) {
-	return c.Send("UID COPY", seq, c.Quote(UTF7Encode(mbox)))
+	return c.Send("UID COPY", seq, c.quoteMailbox(mbox))
+}
+
+// UIDExpunge permanently removes messages that have the \Deleted flag set and
+// a UID in the specified set from the currently selected mailbox. Other
+// messages with the \Deleted flag are left intact. The server must advertise
+// UIDPLUS capability; otherwise, the caller may have to fall back to STORE and
+// EXPUNGE. See RFC 4315 for additional information.
+func (c *Client) UIDExpunge(uids *SeqSet) (cmd *Command, err error) {
+	if !c.Caps["UIDPLUS"] {
+		return nil, NotAvailableError("UIDPLUS")
+	}
+	return c.Send("UID EXPUNGE", uids)
+}
+
+// Move moves the specified message(s) to the end of the specified destination
+// mailbox. This is equivalent to COPY followed by STORE +FLAGS.SILENT \Deleted
+// and EXPUNGE, but performed atomically in one round trip. Untagged EXPUNGE
+// responses and the COPYUID response code are delivered to cmd.Data. Use
+// cmd.CopyUID to obtain the UIDs of the moved messages. See RFC 6851 for
+// additional information.
+func (c *Client) Move(seq *SeqSet, mbox string) (cmd *Command, err error) {
+	if !c.Caps["MOVE"] {
+		return nil, NotAvailableError("MOVE")
+	}
+	return c.Send("MOVE", seq, c.quoteMailbox(mbox))
+}
+
+// UIDMove is identical to Move, but the seq argument is interpreted as
+// containing unique identifiers instead of message sequence numbers.
+func (c *Client) UIDMove(seq *SeqSet, mbox string) (cmd *Command, err error) {
+	if !c.Caps["MOVE"] {
+		return nil, NotAvailableError("MOVE")
+	}
+	return c.Send("UID MOVE", seq, c.quoteMailbox(mbox))
+}
+
+// FetchHeaders retrieves the specified header fields of each message without
+// setting the \Seen flag (BODY.PEEK[HEADER.FIELDS (...)]). Field names are
+// converted to upper case. Use cmd.Headers to obtain the parsed header fields
+// after command completion.
+func (c *Client) FetchHeaders(seq *SeqSet, fields ...string) (cmd *Command, err error) {
+	item, err := headerFieldsItem(fields)
+	if err != nil {
+		return nil, err
+	}
+	return c.Send("FETCH", seq, []Field{item})
+}
+
+// UIDFetchHeaders is identical to FetchHeaders, but the seq argument is
+// interpreted as containing unique identifiers instead of message sequence
+// numbers.
+func (c *Client) UIDFetchHeaders(seq *SeqSet, fields ...string) (cmd *Command, err error) {
+	item, err := headerFieldsItem(fields)
+	if err != nil {
+		return nil, err
+	}
+	return c.Send("UID FETCH", seq, []Field{item})
+}
+
+// FetchRFC822 retrieves the complete message, including all headers, without
+// setting the \Seen flag (BODY.PEEK[]). Use cmd.Messages to obtain the raw
+// messages after command completion, or info.Message to parse each one.
+func (c *Client) FetchRFC822(seq *SeqSet) (cmd *Command, err error) {
+	return c.Send("FETCH", seq, []Field{"BODY.PEEK[]"})
+}
+
+// UIDFetchRFC822 is identical to FetchRFC822, but the seq argument is
+// interpreted as containing unique identifiers instead of message sequence
+// numbers.
+func (c *Client) UIDFetchRFC822(seq *SeqSet) (cmd *Command, err error) {
+	return c.Send("UID FETCH", seq, []Field{"BODY.PEEK[]"})
+}
+
+// FetchRFC822Stream is identical to FetchRFC822, but each message is passed to
+// fn as it is received from the server instead of being saved to memory. This
+// is useful for exporting large mailboxes. The message sequence number is
+// passed to fn along with a reader that returns the message contents. The
+// reader is only valid until fn returns, and any unread data is discarded. As
+// with StreamReader, fn is called from within Client.Recv and must not call any
+// Client methods. The BODY[] attributes in cmd.Data are empty literals.
+//
+// The current LiteralReader is used for all other literals, and it is restored
+// when the command completes. No other commands should be in progress while
+// this method is running.
+//
+// This command is synchronous.
+func (c *Client) FetchRFC822Stream(seq *SeqSet, fn func(seq uint32, r io.Reader)) (cmd *Command, err error) {
+	if fn == nil {
+		return nil, errors.New("imap: nil message stream function")
+	}
+	prev := c.r.LiteralReader
+	c.r.LiteralReader = &messageReader{LiteralReader: prev, fn: fn}
+	defer func() { c.r.LiteralReader = prev }()
+	return Wait(c.FetchRFC822(seq))
+}
+
+// FetchBinary retrieves the specified body part of each message with its
+// Content-Transfer-Encoding removed by the server, without setting the \Seen
+// flag (BINARY.PEEK[<section>]). The section must be a part number, such as "1"
+// or "2.1", or an empty string for the entire message. Use info.Binary to
+// obtain the decoded content. If the server does not know how to decode the
+// part, the command fails with a ResponseError whose Label is "UNKNOWN-CTE".
+// The server must advertise BINARY capability. See RFC 3516 for additional
+// information.
+func (c *Client) FetchBinary(seq *SeqSet, section string) (cmd *Command, err error) {
+	item, err := c.binaryItem(section)
+	if err != nil {
+		return nil, err
+	}
+	return c.Send("FETCH", seq, []Field{item})
+}
+
+// UIDFetchBinary is identical to FetchBinary, but the seq argument is
+// interpreted as containing unique identifiers instead of message sequence
+// numbers.
+func (c *Client) UIDFetchBinary(seq *SeqSet, section string) (cmd *Command, err error) {
+	item, err := c.binaryItem(section)
+	if err != nil {
+		return nil, err
+	}
+	return c.Send("UID FETCH", seq, []Field{item})
+}
+
+// FetchSince is identical to Fetch, but only the messages with a mod-sequence
+// greater than modseq are returned (CHANGEDSINCE modifier). The server must
+// advertise CONDSTORE capability. See RFC 7162 section 3.1.4 for additional
+// information.
+func (c *Client) FetchSince(seq *SeqSet, modseq uint64, items ...string) (cmd *Command, err error) {
+	if !c.Caps["CONDSTORE"] {
+		return nil, NotAvailableError("CONDSTORE")
+	}
+	return c.Send("FETCH", seq, stringsToFields(items), []Field{"CHANGEDSINCE", modseq})
+}
+
+// UIDFetchSince is identical to FetchSince, but the seq argument is interpreted
+// as containing unique identifiers instead of message sequence numbers.
+func (c *Client) UIDFetchSince(seq *SeqSet, modseq uint64, items ...string) (cmd *Command, err error) {
+	if !c.Caps["CONDSTORE"] {
+		return nil, NotAvailableError("CONDSTORE")
+	}
+	return c.Send("UID FETCH", seq, stringsToFields(items), []Field{"CHANGEDSINCE", modseq})
+}
+
+// UIDFetchVanished is identical to UIDFetchSince, but the server also reports
+// the UIDs in seq that were expunged since modseq (VANISHED modifier). These are
+// returned in a VANISHED (EARLIER) response, available from cmd.Vanished. The
+// QRESYNC extension must be enabled first. See RFC 7162 section 3.2.6 for
+// additional information.
+func (c *Client) UIDFetchVanished(seq *SeqSet, modseq uint64, items ...string) (cmd *Command, err error) {
+	if !c.Enabled["QRESYNC"] {
+		return nil, NotAvailableError("QRESYNC")
+	}
+	return c.Send("UID FETCH", seq, stringsToFields(items), []Field{"CHANGEDSINCE", modseq, "VANISHED"})
+}
+
+// StoreUnchangedSince is identical to Store, but the data is only altered for
+// messages with a mod-sequence less than or equal to modseq (UNCHANGEDSINCE
+// modifier). Messages that failed the check are reported in the MODIFIED
+// response code, available from cmd.Modified after command completion. The
+// server must advertise CONDSTORE capability. See RFC 7162 section 3.1.3 for
+// additional information.
+func (c *Client) StoreUnchangedSince(seq *SeqSet, modseq uint64, item string, value Field) (cmd *Command, err error) {
+	if !c.Caps["CONDSTORE"] {
+		return nil, NotAvailableError("CONDSTORE")
+	}
+	return c.Send("STORE", seq, []Field{"UNCHANGEDSINCE", modseq}, item, value)
+}
+
+// UIDStoreUnchangedSince is identical to StoreUnchangedSince, but the seq
+// argument is interpreted as containing unique identifiers instead of message
+// sequence numbers.
+func (c *Client) UIDStoreUnchangedSince(seq *SeqSet, modseq uint64, item string, value Field) (cmd *Command, err error) {
+	if !c.Caps["CONDSTORE"] {
+		return nil, NotAvailableError("CONDSTORE")
+	}
+	return c.Send("UID STORE", seq, []Field{"UNCHANGEDSINCE", modseq}, item, value)
+}
+
+// AddLabels adds the specified Gmail labels to the message(s) in the mailbox
+// (+X-GM-LABELS). System labels, such as `\Inbox` and `\Starred`, are sent
+// as atoms, and all other labels are encoded like mailbox names. The server
+// returns the new label lists in FETCH responses, which are decoded by
+// rsp.MessageInfo. The server must advertise X-GM-EXT-1 capability.
+func (c *Client) AddLabels(seq *SeqSet, labels ...string) (cmd *Command, err error) {
+	return c.storeLabels("STORE", seq, "+X-GM-LABELS", labels)
+}
+
+// RemoveLabels removes the specified Gmail labels from the message(s) in the
+// mailbox (-X-GM-LABELS). See AddLabels for additional information.
+func (c *Client) RemoveLabels(seq *SeqSet, labels ...string) (cmd *Command, err error) {
+	return c.storeLabels("STORE", seq, "-X-GM-LABELS", labels)
+}
+
+// UIDAddLabels is identical to AddLabels, but the seq argument is interpreted
+// as containing unique identifiers instead of message sequence numbers.
+func (c *Client) UIDAddLabels(seq *SeqSet, labels ...string) (cmd *Command, err error) {
+	return c.storeLabels("UID STORE", seq, "+X-GM-LABELS", labels)
+}
+
+// UIDRemoveLabels is identical to RemoveLabels, but the seq argument is
+// interpreted as containing unique identifiers instead of message sequence
+// numbers.
+func (c *Client) UIDRemoveLabels(seq *SeqSet, labels ...string) (cmd *Command, err error) {
+	return c.storeLabels("UID STORE", seq, "-X-GM-LABELS", labels)
+}
+
+// GmailMove moves the messages with the specified UIDs from the src Gmail label
+// to dst, without changing any of their other labels. Gmail represents labels
+// as mailboxes, so moving messages between these with MOVE or COPY and EXPUNGE
+// may duplicate messages or drop labels. Instead, dst is added with a UID STORE
+// command, and src is then removed with another. The same UID set is used for
+// both commands, so they affect exactly the same messages, even if sequence
+// numbers change in between. The operation is not atomic. If the second
+// command fails, the messages have both labels. The Command returned is the
+// last one that was sent. The server must advertise X-GM-EXT-1 capability.
+//
+// This command is synchronous.
+func (c *Client) GmailMove(uids *SeqSet, src, dst string) (cmd *Command, err error) {
+	if src == "" || dst == "" {
+		return nil, errors.New("imap: empty label name")
+	}
+	if cmd, err = Wait(c.UIDAddLabels(uids, dst)); err == nil {
+		cmd, err = Wait(c.UIDRemoveLabels(uids, src))
+	}
+	return
+}
+
+// storeLabels implements the Gmail label commands.
+func (c *Client) storeLabels(name string, seq *SeqSet, item string, labels []string) (cmd *Command, err error) {
+	if !c.Caps["X-GM-EXT-1"] {
+		return nil, NotAvailableError("X-GM-EXT-1")
+	} else if len(labels) == 0 {
+		return nil, errors.New("imap: no labels specified")
+	}
+	f := make([]Field, len(labels))
+	for i, label := range labels {
+		if len(label) > 1 && label[0] == '\\' && isAtom(label[1:]) {
+			f[i] = label
+		} else {
+			f[i] = c.quoteMailbox(label)
+		}
+	}
+	return c.Send(name, seq, item, f)
+}
+
+// AddFlags adds flags to the specified message(s) using the "+FLAGS" data item
+// of the STORE command. Names of system flags may be specified with or without
+// the leading backslash (e.g. "Seen" and `\Seen` are equivalent). All other
+// names are treated as keywords. An error is returned without sending the
+// command if a flag name is invalid or if the \Recent flag is specified. Use
+// cmd.Flags to obtain the updated flags reported by the server.
+func (c *Client) AddFlags(seq *SeqSet, flags ...string) (cmd *Command, err error) {
+	return c.storeFlags("STORE", seq, "+FLAGS", flags)
+}
+
+// RemoveFlags removes flags from the specified message(s) using the "-FLAGS"
+// data item of the STORE command. See AddFlags for additional information.
+func (c *Client) RemoveFlags(seq *SeqSet, flags ...string) (cmd *Command, err error) {
+	return c.storeFlags("STORE", seq, "-FLAGS", flags)
+}
+
+// SetFlags replaces the flags of the specified message(s) using the "FLAGS"
+// data item of the STORE command. See AddFlags for additional information.
+func (c *Client) SetFlags(seq *SeqSet, flags ...string) (cmd *Command, err error) {
+	return c.storeFlags("STORE", seq, "FLAGS", flags)
+}
+
+// AddFlagsSilent is identical to AddFlags, but the server does not send the
+// updated flags back to the client ("+FLAGS.SILENT").
+func (c *Client) AddFlagsSilent(seq *SeqSet, flags ...string) (cmd *Command, err error) {
+	return c.storeFlags("STORE", seq, "+FLAGS.SILENT", flags)
+}
+
+// RemoveFlagsSilent is identical to RemoveFlags, but the server does not send
+// the updated flags back to the client ("-FLAGS.SILENT").
+func (c *Client) RemoveFlagsSilent(seq *SeqSet, flags ...string) (cmd *Command, err error) {
+	return c.storeFlags("STORE", seq, "-FLAGS.SILENT", flags)
+}
+
+// SetFlagsSilent is identical to SetFlags, but the server does not send the
+// updated flags back to the client ("FLAGS.SILENT").
+func (c *Client) SetFlagsSilent(seq *SeqSet, flags ...string) (cmd *Command, err error) {
+	return c.storeFlags("STORE", seq, "FLAGS.SILENT", flags)
+}
+
+// UIDAddFlags is identical to AddFlags, but the seq argument is interpreted as
+// containing unique identifiers instead of message sequence numbers.
+func (c *Client) UIDAddFlags(seq *SeqSet, flags ...string) (cmd *Command, err error) {
+	return c.storeFlags("UID STORE", seq, "+FLAGS", flags)
+}
+
+// UIDRemoveFlags is identical to RemoveFlags, but the seq argument is
+// interpreted as containing unique identifiers instead of message sequence
+// numbers.
+func (c *Client) UIDRemoveFlags(seq *SeqSet, flags ...string) (cmd *Command, err error) {
+	return c.storeFlags("UID STORE", seq, "-FLAGS", flags)
+}
+
+// UIDSetFlags is identical to SetFlags, but the seq argument is interpreted as
+// containing unique identifiers instead of message sequence numbers.
+func (c *Client) UIDSetFlags(seq *SeqSet, flags ...string) (cmd *Command, err error) {
+	return c.storeFlags("UID STORE", seq, "FLAGS", flags)
+}
+
+// UIDAddFlagsSilent is identical to AddFlagsSilent, but the seq argument is
+// interpreted as containing unique identifiers instead of message sequence
+// numbers.
+func (c *Client) UIDAddFlagsSilent(seq *SeqSet, flags ...string) (cmd *Command, err error) {
+	return c.storeFlags("UID STORE", seq, "+FLAGS.SILENT", flags)
+}
+
+// UIDRemoveFlagsSilent is identical to RemoveFlagsSilent, but the seq argument
+// is interpreted as containing unique identifiers instead of message sequence
+// numbers.
+func (c *Client) UIDRemoveFlagsSilent(seq *SeqSet, flags ...string) (cmd *Command, err error) {
+	return c.storeFlags("UID STORE", seq, "-FLAGS.SILENT", flags)
+}
+
+// UIDSetFlagsSilent is identical to SetFlagsSilent, but the seq argument is
+// interpreted as containing unique identifiers instead of message sequence
+// numbers.
+func (c *Client) UIDSetFlagsSilent(seq *SeqSet, flags ...string) (cmd *Command, err error) {
+	return c.storeFlags("UID STORE", seq, "FLAGS.SILENT", flags)
+}
+
+// Sort searches the mailbox for messages that match the given searching
+// criteria and returns their message sequence numbers sorted by the specified
+// sort criteria (e.g. "REVERSE", "DATE", "SUBJECT"). The charset defaults to
+// US-ASCII if empty. REVERSE applies to the key that follows it. Use
+// cmd.SortResults to get the ordered results. See RFC 5256 for additional
+// information.
+func (c *Client) Sort(criteria []string, charset string, spec ...Field) (cmd *Command, err error) {
+	return c.sort("SORT", criteria, charset, spec)
+}
+
+// UIDSort is identical to Sort, but the numbers returned in the response are
+// unique identifiers instead of message sequence numbers.
+func (c *Client) UIDSort(criteria []string, charset string, spec ...Field) (cmd *Command, err error) {
+	return c.sort("UID SORT", criteria, charset, spec)
+}
+
+// Thread searches the mailbox for messages that match the given searching
+// criteria and returns them grouped into conversation threads using the
+// specified algorithm (e.g. "REFERENCES"). The server must advertise the
+// THREAD=<algorithm> capability. The charset defaults to US-ASCII if empty.
+// Use the Threads function to decode the results. See RFC 5256 for additional
+// information.
+func (c *Client) Thread(algorithm, charset string, spec ...Field) (cmd *Command, err error) {
+	return c.thread("THREAD", algorithm, charset, spec)
+}
+
+// UIDThread is identical to Thread, but the numbers returned in the response
+// are unique identifiers instead of message sequence numbers.
+func (c *Client) UIDThread(algorithm, charset string, spec ...Field) (cmd *Command, err error) {
+	return c.thread("UID THREAD", algorithm, charset, spec)
 }
 
 // SetQuota changes the resource limits of the specified quota root. See RFC
-// 2087 for additional information.
+// 9208 for additional information.
 func (c *Client) SetQuota(root string, quota ...*Quota) (cmd *Command, err error) {
 	if !c.Caps["QUOTA"] {
 		return nil, NotAvailableError("QUOTA")
@@ -408,9 +1213,10 @@ func (c *Client) SetQuota(root string, quota ...*Quota) (cmd *Command, err error
 	return c.Send("SETQUOTA", c.Quote(root), f)
 }
 
-// GetQuota returns the quota root's resource usage and limits. See RFC 2087 for
-// additional information.
-func (c *Client) GetQuota(root string, quota ...*Quota) (cmd *Command, err error) {
+// GetQuota returns the quota root's resource usage and limits. Use rsp.Quota to
+// decode the QUOTA response in cmd.Data. See RFC 9208 for additional
+// information.
+func (c *Client) GetQuota(root string) (cmd *Command, err error) {
 	if !c.Caps["QUOTA"] {
 		return nil, NotAvailableError("QUOTA")
 	}
@@ -418,23 +1224,162 @@ func (c *Client) GetQuota(root string, quota ...*Quota) (cmd *Command, err error
 }
 
 // GetQuotaRoot returns the list of quota roots for the specified mailbox, and
-// the resource usage and limits for each quota root. See RFC 2087 for
-// additional information.
+// the resource usage and limits for each quota root. The server sends one
+// QUOTAROOT response followed by a QUOTA response for each root, all of which
+// are delivered to cmd.Data. See RFC 9208 for additional information.
 func (c *Client) GetQuotaRoot(mbox string) (cmd *Command, err error) {
 	if !c.Caps["QUOTA"] {
 		return nil, NotAvailableError("QUOTA")
 	}
-	return c.Send("GETQUOTAROOT", c.Quote(UTF7Encode(mbox)))
+	return c.Send("GETQUOTAROOT", c.quoteMailbox(mbox))
+}
+
+// SetACL changes the access rights of identifier on the specified mailbox. The
+// rights string replaces the current rights, unless it begins with "+" or "-",
+// in which case the rights are added to or removed from the existing set. The
+// server must advertise ACL capability. See RFC 4314 for additional
+// information.
+func (c *Client) SetACL(mbox, identifier, rights string) (cmd *Command, err error) {
+	if !c.Caps["ACL"] {
+		return nil, NotAvailableError("ACL")
+	} else if !validRights(rights) {
+		return nil, fmt.Errorf("imap: invalid access rights %q", rights)
+	}
+	return c.Send("SETACL", c.quoteMailbox(mbox), c.Quote(identifier), c.Quote(rights))
+}
+
+// DeleteACL removes all access rights of identifier from the specified mailbox.
+// The server must advertise ACL capability. See RFC 4314 for additional
+// information.
+func (c *Client) DeleteACL(mbox, identifier string) (cmd *Command, err error) {
+	if !c.Caps["ACL"] {
+		return nil, NotAvailableError("ACL")
+	}
+	return c.Send("DELETEACL", c.quoteMailbox(mbox), c.Quote(identifier))
+}
+
+// GetACL returns the access control list of the specified mailbox. Use rsp.ACL
+// to decode the ACL response in cmd.Data. The server must advertise ACL
+// capability. See RFC 4314 for additional information.
+func (c *Client) GetACL(mbox string) (cmd *Command, err error) {
+	if !c.Caps["ACL"] {
+		return nil, NotAvailableError("ACL")
+	}
+	return c.Send("GETACL", c.quoteMailbox(mbox))
+}
+
+// MyRights returns the access rights of the current user on the specified
+// mailbox. Use rsp.MyRights to decode the MYRIGHTS response in cmd.Data. The
+// server must advertise ACL capability. See RFC 4314 for additional
+// information.
+func (c *Client) MyRights(mbox string) (cmd *Command, err error) {
+	if !c.Caps["ACL"] {
+		return nil, NotAvailableError("ACL")
+	}
+	return c.Send("MYRIGHTS", c.quoteMailbox(mbox))
+}
+
+// MetadataInfinity is the DEPTH option value for GetMetadata that requests all
+// entries below the specified ones, regardless of their depth.
+const MetadataInfinity = math.MaxUint32
+
+// GetMetadata requests the values of the specified metadata entries (e.g.
+// "/private/comment") of mailbox mbox, or server entries if mbox is "". The
+// options map may contain "MAXSIZE" to limit the size of the returned values
+// and "DEPTH" (0, 1, or MetadataInfinity) to also return entries below the
+// requested ones. Use rsp.Metadata to decode the METADATA responses in
+// cmd.Data. The server must advertise METADATA capability, or METADATA-SERVER
+// for server entries. See RFC 5464 for additional information.
+func (c *Client) GetMetadata(mbox string, options map[string]uint32, entries ...string) (cmd *Command, err error) {
+	if err = c.checkMetadata(mbox); err != nil {
+		return
+	} else if len(entries) == 0 {
+		return nil, errors.New("imap: no metadata entries specified")
+	}
+	f := make([]Field, 0, 3)
+	if len(options) > 0 {
+		opts := make([]Field, 0, 4)
+		for name, v := range options {
+			switch name = toUpper(name); name {
+			case "MAXSIZE":
+				opts = append([]Field{name, v}, opts...)
+			case "DEPTH":
+				if v == MetadataInfinity {
+					opts = append(opts, name, "infinity")
+				} else if v <= 1 {
+					opts = append(opts, name, v)
+				} else {
+					return nil, fmt.Errorf("imap: invalid metadata depth %d", v)
+				}
+			default:
+				return nil, fmt.Errorf("imap: invalid metadata option %q", name)
+			}
+		}
+		f = append(f, opts)
+	}
+	f = append(f, c.quoteMailbox(mbox))
+	if len(entries) == 1 {
+		f = append(f, c.Quote(entries[0]))
+	} else {
+		list := make([]Field, len(entries))
+		for i, e := range entries {
+			list[i] = c.Quote(e)
+		}
+		f = append(f, list)
+	}
+	return c.Send("GETMETADATA", f...)
+}
+
+// SetMetadata sets the values of the specified metadata entries of mailbox
+// mbox, or server entries if mbox is "". A nil value deletes the entry. Values
+// that cannot be quoted, such as binary data, are sent as literals. The server
+// must advertise METADATA capability, or METADATA-SERVER for server entries.
+// See RFC 5464 for additional information.
+func (c *Client) SetMetadata(mbox string, entries map[string]*string) (cmd *Command, err error) {
+	if err = c.checkMetadata(mbox); err != nil {
+		return
+	} else if len(entries) == 0 {
+		return nil, errors.New("imap: no metadata entries specified")
+	}
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]Field, 0, 2*len(names))
+	for _, name := range names {
+		var v Field
+		if p := entries[name]; p != nil {
+			v = c.Quote(*p)
+		}
+		list = append(list, c.Quote(name), v)
+	}
+	return c.Send("SETMETADATA", c.quoteMailbox(mbox), list)
 }
 
 // Idle places the client into an idle state where the server is free to send
 // unsolicited mailbox update messages. No other commands are allowed to run
 // while the client is idling. Use c.IdleTerm to terminate the command. See RFC
 // 2177 for additional information.
+//
+// The command remains in progress until IdleTerm is called. Server updates are
+// delivered to c.Data by c.Recv, as usual. The timeout passed to c.Recv applies
+// to each response separately, so a loop calling c.Recv with a positive timeout
+// refreshes the timer every time a response is received. ErrTimeout does not
+// affect the IDLE command, allowing the caller to decide whether to continue
+// waiting, terminate the command, or issue a new IDLE (RFC 2177 recommends
+// doing so at least every 29 minutes). A negative timeout disables the timer
+// entirely. Any deadline set on the underlying connection is cleared when the
+// client starts idling.
 func (c *Client) Idle() (cmd *Command, err error) {
 	if !c.Caps["IDLE"] {
 		return nil, NotAvailableError("IDLE")
 	}
+	if conn := c.t.conn; conn != nil {
+		if err = conn.SetDeadline(time.Time{}); err != nil {
+			return nil, err
+		}
+	}
 	if cmd, err = c.Send("IDLE"); err == nil {
 		var rsp *Response
 		if rsp, err = c.checkContinue(cmd, true); err == nil {
@@ -464,52 +1409,154 @@ func (c *Client) IdleTerm() (cmd *Command, err error) {
 	return
 }
 
-// ID provides client identification information to the server. See RFC 2971 for
-// additional information.
+// Notify requests the server to send unsolicited notifications about changes
+// to the selected mailbox and other mailboxes. The spec is sent as-is after
+// "NOTIFY SET", so mailbox names in it must already be quoted and encoded as
+// modified UTF-7. For example, the following spec requests new messages and
+// flag changes in the selected mailbox, and new messages in INBOX:
+//
+// 	(selected (MessageNew (UID FLAGS) MessageExpunge FlagChange)) (mailboxes INBOX (MessageNew))
+//
+// An empty spec sends "NOTIFY NONE", which disables all notifications. The
+// server must advertise NOTIFY capability. See RFC 5465 for additional
+// information.
+//
+// Notifications are delivered like other unilateral server data, so an update
+// handler (see SetUpdateHandler) can process them as they arrive without
+// keeping the client idle. Use EventMailbox to determine which mailbox a
+// notification refers to. If the server is unable to keep track of all events,
+// it sends an untagged OK response with the NOTIFICATIONOVERFLOW code, after
+// which the client should resynchronize its state.
+func (c *Client) Notify(spec string) (cmd *Command, err error) {
+	if !c.Caps["NOTIFY"] {
+		return nil, NotAvailableError("NOTIFY")
+	}
+	if spec = strings.TrimSpace(spec); spec == "" {
+		return c.Send("NOTIFY", "NONE")
+	}
+	return c.Send("NOTIFY", "SET", spec)
+}
+
+// EventMailbox returns the name of the mailbox that an unsolicited response,
+// such as a NOTIFY event, refers to. STATUS, LIST, LSUB, and METADATA responses
+// contain the mailbox name. EXISTS, EXPUNGE, FETCH, and other message updates
+// refer to the currently selected mailbox. An empty string is returned if the
+// mailbox cannot be determined. This method may be called from an update
+// handler.
+func (c *Client) EventMailbox(rsp *Response) string {
+	if rsp.Tag != "*" || rsp.Type != Data {
+		return ""
+	}
+	switch rsp.Label {
+	case "STATUS":
+		if v := rsp.MailboxStatus(); v != nil {
+			return v.Name
+		}
+	case "LIST", "LSUB":
+		if v := rsp.MailboxInfo(); v != nil {
+			return v.Name
+		}
+	case "METADATA":
+		mbox, _ := rsp.Metadata()
+		return mbox
+	case "EXISTS", "RECENT", "EXPUNGE", "FETCH", "VANISHED", "FLAGS":
+		if c.Mailbox != nil {
+			return c.Mailbox.Name
+		}
+	}
+	return ""
+}
+
+// Namespace requests the prefixes and hierarchy delimiters of the personal,
+// other users', and shared namespaces on the server. Use rsp.Namespaces to
+// decode the NAMESPACE response in cmd.Data. See RFC 2342 for additional
+// information.
+func (c *Client) Namespace() (cmd *Command, err error) {
+	if !c.Caps["NAMESPACE"] {
+		return nil, NotAvailableError("NAMESPACE")
+	}
+	return c.Send("NAMESPACE")
+}
+
+// ID provides client identification information to the server. The info
+// arguments are field/value pairs (e.g. "name", "go-imap", "version", "1.0").
+// NIL is sent if info is empty. The server's identification information is
+// returned in an ID response, which can be decoded with rsp.ServerID. See RFC
+// 2971 for additional information.
 func (c *Client) ID(info ...string) (cmd *Command, err error) {
 	if !c.Caps["ID"] {
 		return nil, NotAvailableError("ID")
+	} else if len(info)%2 != 0 {
+		return nil, errors.New("imap: odd number of ID arguments")
 	}
-	f := make([]Field, len(info))
-	for i, v := range info {
-		f[i] = c.Quote(v)
+	var f Field
+	if len(info) > 0 {
+		list := make([]Field, len(info))
+		for i, v := range info {
+			list[i] = c.Quote(v)
+		}
+		f = list
 	}
 	return c.Send("ID", f)
 }
 
+// Compress enables data compression using the DEFLATE algorithm with the
+// default compression level. It is equivalent to CompressDeflate(-1).
+//
+// This command is synchronous.
+func (c *Client) Compress() (cmd *Command, err error) {
+	return c.CompressDeflate(-1)
+}
+
 // CompressDeflate enables data compression using the DEFLATE algorithm. The
-// compression level must be between -1 and 9 (see compress/flate). See RFC 4978
-// for additional information.
+// compression level must be between -1 and 9 (see compress/flate). Compression
+// is enabled in both directions once the server completes the command. Any data
+// received after the command completion is decompressed. See RFC 4978 for
+// additional information.
 //
 // This command is synchronous.
 func (c *Client) CompressDeflate(level int) (cmd *Command, err error) {
+	defer c.syncCmd()()
 	if !c.Caps["COMPRESS=DEFLATE"] {
 		return nil, NotAvailableError("COMPRESS=DEFLATE")
 	} else if c.t.Compressed() {
 		return nil, ErrCompressionActive
 	}
 	if cmd, err = Wait(c.Send("COMPRESS", "DEFLATE")); err == nil {
+		if c.rch != nil {
+			// Should never happen
+			panic("imap: receiver is active, cannot enable compression")
+		}
 		err = c.t.EnableDeflate(level)
 	}
 	return
 }
 
 // Enable takes a list of capability names and requests the server to enable the
-// named extensions. See RFC 5161 for additional information.
+// named extensions (e.g. CONDSTORE). The extensions that were actually enabled
+// are added to c.Enabled. See RFC 5161 for additional information.
+//
+// Once UTF8=ACCEPT is enabled (RFC 6855), mailbox names are sent and received
+// as UTF-8 instead of modified UTF-7. This is transparent to the caller.
 //
 // This command is synchronous.
 func (c *Client) Enable(caps ...string) (cmd *Command, err error) {
-	return Wait(c.Send("ENABLE", stringsToFields(caps)))
+	if !c.Caps["ENABLE"] {
+		return nil, NotAvailableError("ENABLE")
+	}
+	return Wait(c.Send("ENABLE", stringsToFields(caps)...))
 }
 
 // doSelect opens the specified mailbox, returning an error if the command
-// completion status is other than OK or NO.
-func (c *Client) doSelect(mbox string, readonly bool) (cmd *Command, err error) {
+// completion status is other than OK or NO. Optional SELECT parameters (RFC
+// 4466) are appended to the command.
+func (c *Client) doSelect(mbox string, readonly bool, params ...Field) (cmd *Command, err error) {
+	defer c.syncCmd()()
 	name := "SELECT"
 	if readonly {
 		name = "EXAMINE"
 	}
-	if cmd, err = c.Send(name, c.Quote(UTF7Encode(mbox))); err == nil {
+	if cmd, err = c.Send(name, append([]Field{c.quoteMailbox(mbox)}, params...)...); err == nil {
 		prev := c.Mailbox
 		c.setState(Auth)
 		c.Mailbox = newMailboxStatus(mbox)
@@ -517,6 +1564,9 @@ func (c *Client) doSelect(mbox string, readonly bool) (cmd *Command, err error)
 		var rsp *Response
 		if rsp, err = cmd.Result(OK | NO); err == nil {
 			if rsp.Status == OK {
+				if readonly {
+					c.Mailbox.ReadOnly = true // EXAMINE access is always read-only
+				}
 				c.setState(Selected)
 			} else {
 				c.Mailbox = nil
@@ -528,6 +1578,230 @@ func (c *Client) doSelect(mbox string, readonly bool) (cmd *Command, err error)
 	return
 }
 
+// sortKeys contains valid SORT command criteria (RFC 5256 section 3).
+var sortKeys = map[string]bool{
+	"ARRIVAL": true, "CC": true, "DATE": true, "FROM": true,
+	"REVERSE": true, "SIZE": true, "SUBJECT": true, "TO": true,
+}
+
+// searchSpec returns the arguments of an extended SEARCH command, or those of a
+// plain SEARCH command if the server does not support ESEARCH.
+func (c *Client) searchSpec(opts []string, spec []Field) []Field {
+	f := make([]Field, 0, len(spec)+4)
+	if c.Caps["ESEARCH"] {
+		f = append(f, "RETURN", stringsToFields(opts))
+	}
+	return append(append(f, "CHARSET", "UTF-8"), spec...)
+}
+
+// checkSearch returns NotAvailableError if spec contains Gmail search keys
+// (e.g. X-GM-RAW), but the server does not advertise X-GM-EXT-1 capability.
+func (c *Client) checkSearch(spec []Field) error {
+	if !c.Caps["X-GM-EXT-1"] && hasGmailKey(spec) {
+		return NotAvailableError("X-GM-EXT-1")
+	}
+	return nil
+}
+
+// hasGmailKey returns true if spec or any of its nested lists contain an atom
+// beginning with "X-GM-".
+func hasGmailKey(spec []Field) bool {
+	for _, f := range spec {
+		switch v := f.(type) {
+		case string:
+			if len(v) > 5 && toUpper(v[:5]) == "X-GM-" {
+				return true
+			}
+		case []Field:
+			if hasGmailKey(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkFetch returns NotAvailableError if items contain the EMAILID or THREADID
+// item, but the server does not advertise OBJECTID capability.
+func (c *Client) checkFetch(items []string) error {
+	if !c.Caps["OBJECTID"] {
+		for _, item := range items {
+			if item = toUpper(item); item == "EMAILID" || item == "THREADID" {
+				return NotAvailableError("OBJECTID")
+			}
+		}
+	}
+	return nil
+}
+
+// statusItems validates and returns STATUS data items. The RFC 3501 items are
+// returned if the list is empty.
+func (c *Client) statusItems(items []string) ([]Field, error) {
+	if len(items) == 0 {
+		return []Field{"MESSAGES", "RECENT", "UIDNEXT", "UIDVALIDITY", "UNSEEN"}, nil
+	}
+	f := make([]Field, len(items))
+	for i, v := range items {
+		switch item := toUpper(v); item {
+		case "MESSAGES", "RECENT", "UIDNEXT", "UIDVALIDITY", "UNSEEN":
+			f[i] = item
+		case "HIGHESTMODSEQ":
+			if !c.Caps["CONDSTORE"] {
+				return nil, NotAvailableError("CONDSTORE")
+			}
+			f[i] = item
+		case "MAILBOXID":
+			if !c.Caps["OBJECTID"] {
+				return nil, NotAvailableError("OBJECTID")
+			}
+			f[i] = item
+		default:
+			return nil, fmt.Errorf("imap: invalid status item %q", v)
+		}
+	}
+	return f, nil
+}
+
+// sort sends a SORT or UID SORT command after validating the sort criteria.
+func (c *Client) sort(name string, criteria []string, charset string, spec []Field) (cmd *Command, err error) {
+	if !c.Caps["SORT"] {
+		return nil, NotAvailableError("SORT")
+	}
+	if len(criteria) == 0 {
+		return nil, errors.New("imap: empty sort criteria")
+	}
+	keys := make([]Field, len(criteria))
+	for i, k := range criteria {
+		if k = toUpper(k); !sortKeys[k] {
+			return nil, fmt.Errorf("imap: invalid sort key %q", criteria[i])
+		}
+		if i > 0 && keys[i-1] == "REVERSE" && k == "REVERSE" {
+			return nil, errors.New("imap: REVERSE must be followed by a sort key")
+		}
+		keys[i] = k
+	}
+	if keys[len(keys)-1] == "REVERSE" {
+		return nil, errors.New("imap: REVERSE must be followed by a sort key")
+	}
+	if charset == "" {
+		charset = "US-ASCII"
+	}
+	return c.Send(name, append([]Field{keys, charset}, spec...)...)
+}
+
+// systemFlags maps upper-case system flag names without the leading backslash
+// to their canonical form.
+var systemFlags = map[string]string{
+	"SEEN":     Seen,
+	"ANSWERED": Answered,
+	"FLAGGED":  Flagged,
+	"DELETED":  Deleted,
+	"DRAFT":    Draft,
+	"RECENT":   Recent,
+}
+
+// storeFlags sends a STORE or UID STORE command that changes message flags.
+func (c *Client) storeFlags(name string, seq *SeqSet, item string, flags []string) (cmd *Command, err error) {
+	list := make([]Field, len(flags))
+	for i, flag := range flags {
+		if sys, ok := systemFlags[toUpper(flag)]; ok {
+			flag = sys
+		} else {
+			flag = normFlag(flag)
+		}
+		if flag == Recent {
+			return nil, errors.New(`imap: \Recent flag cannot be changed`)
+		} else if !validFlag(flag) {
+			return nil, fmt.Errorf("imap: invalid flag %q", flags[i])
+		}
+		list[i] = flag
+	}
+	return c.Send(name, seq, item, list)
+}
+
+// validFlag returns true if flag is a valid keyword or a backslash followed by
+// an atom.
+func validFlag(flag string) bool {
+	if len(flag) > 0 && flag[0] == '\\' {
+		flag = flag[1:]
+	}
+	for i := 0; i < len(flag); i++ {
+		if c := flag[i]; c >= char || atomSpecials[c] {
+			return false
+		}
+	}
+	return flag != ""
+}
+
+// thread sends a THREAD or UID THREAD command if the algorithm is supported.
+func (c *Client) thread(name, algorithm, charset string, spec []Field) (cmd *Command, err error) {
+	algorithm = toUpper(algorithm)
+	if v := "THREAD=" + algorithm; !c.Caps[v] {
+		return nil, NotAvailableError(v)
+	}
+	if charset == "" {
+		charset = "US-ASCII"
+	}
+	return c.Send(name, append([]Field{algorithm, charset}, spec...)...)
+}
+
+// checkMetadata verifies that the server supports metadata entries of mailbox
+// mbox ("" for server entries).
+func (c *Client) checkMetadata(mbox string) error {
+	if mbox == "" && c.Caps["METADATA-SERVER"] {
+		return nil
+	} else if !c.Caps["METADATA"] {
+		return NotAvailableError("METADATA")
+	}
+	return nil
+}
+
+// validRights returns true if rights is a valid SETACL rights argument,
+// optionally prefixed with "+" or "-" (RFC 4314 section 3.1).
+func validRights(rights string) bool {
+	if len(rights) > 0 && (rights[0] == '+' || rights[0] == '-') {
+		rights = rights[1:]
+	}
+	for i := 0; i < len(rights); i++ {
+		if c := rights[i]; (c < 'a' || 'z' < c) && (c < '0' || '9' < c) {
+			return false
+		}
+	}
+	return true
+}
+
+// headerFieldsItem returns the FETCH data item for the specified header fields.
+func headerFieldsItem(fields []string) (string, error) {
+	if len(fields) == 0 {
+		return "", errors.New("imap: no header fields specified")
+	}
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		if !isAtom(f) || strings.IndexByte(f, ':') != -1 {
+			return "", fmt.Errorf("imap: invalid header field name %q", f)
+		}
+		names[i] = toUpper(f)
+	}
+	part := BodyPart{Section: "HEADER.FIELDS (" + strings.Join(names, " ") + ")", Peek: true}
+	return part.String(), nil
+}
+
+// binaryItem returns the BINARY.PEEK[<section>] data item after verifying that
+// the server supports the BINARY extension.
+func (c *Client) binaryItem(section string) (string, error) {
+	if !c.Caps["BINARY"] {
+		return "", NotAvailableError("BINARY")
+	}
+	if section != "" {
+		for _, part := range strings.Split(section, ".") {
+			if n, err := strconv.ParseUint(part, 10, 32); err != nil || n == 0 {
+				return "", fmt.Errorf("imap: invalid binary section %q", section)
+			}
+		}
+	}
+	return "BINARY.PEEK[" + section + "]", nil
+}
+
 // stringsToFields converts []string to []Field.
 func stringsToFields(s []string) []Field {
 	f := make([]Field, len(s))
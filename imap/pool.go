@@ -0,0 +1,118 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrPoolClosed is returned by Pool.Get after the pool is closed.
+var ErrPoolClosed = errors.New("imap: connection pool closed")
+
+// Pool maintains a set of connections to a single server. IMAP does not allow
+// concurrent execution of most commands on one connection, so a Pool can be
+// used to perform independent operations (e.g. fetching messages from several
+// mailboxes) in parallel. Each Client is used by only one caller at a time.
+//
+// The selected mailbox is part of the connection state. A Client obtained from
+// the pool may have any mailbox selected, or none at all, depending on how it
+// was used before it was released. Callers must always select the mailbox they
+// need, and must not rely on the Client returned by a previous Get.
+//
+// Pool methods are safe for concurrent use. The Clients are not.
+type Pool struct {
+	dial func() (*Client, error)
+	slot chan struct{} // Semaphore limiting the number of open connections
+
+	mu     sync.Mutex
+	idle   []*Client
+	closed bool
+}
+
+// NewPool returns a new connection pool that keeps at most size connections
+// open. The dial function must return a new Client in the Auth state (i.e. it
+// must also perform authentication). Connections are established as needed.
+func NewPool(dial func() (*Client, error), size int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	return &Pool{dial: dial, slot: make(chan struct{}, size)}
+}
+
+// Get returns an idle connection from the pool, or establishes a new one. If
+// the maximum number of connections are in use, Get blocks until one of them is
+// released. Idle connections are checked with the NOOP command before they are
+// returned, and closed connections are replaced. The caller must call release
+// exactly once when it is done with the Client, and must not use the Client
+// afterwards.
+func (p *Pool) Get() (c *Client, release func(), err error) {
+	p.slot <- struct{}{}
+	for {
+		if c, err = p.next(); c == nil {
+			break
+		} else if _, err = Wait(c.Noop()); err == nil {
+			break
+		}
+		c.warnln(LogConn, "Pool connection check failed:", err)
+		if c.State() != Closed {
+			c.Logout(0)
+		}
+	}
+	if c == nil && err == nil {
+		if c, err = p.dial(); err == nil && c.State()&(Auth|Selected) == 0 {
+			c.Logout(0)
+			c, err = nil, ErrNotAllowed
+		}
+	}
+	if err != nil {
+		<-p.slot
+		return nil, nil, err
+	}
+	var once sync.Once
+	return c, func() { once.Do(func() { p.put(c) }) }, nil
+}
+
+// Close logs out of all idle connections and prevents new connections from
+// being established. Connections that are in use are logged out when they are
+// released.
+func (p *Pool) Close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle, p.closed = nil, true
+	p.mu.Unlock()
+	for _, c := range idle {
+		c.Logout(netTimeout)
+	}
+}
+
+// next removes and returns the most recently used idle connection. Nil is
+// returned if there are no idle connections.
+func (p *Pool) next() (*Client, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil, ErrPoolClosed
+	} else if n := len(p.idle); n > 0 {
+		c := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		return c, nil
+	}
+	return nil, nil
+}
+
+// put returns connection c to the pool.
+func (p *Pool) put(c *Client) {
+	p.mu.Lock()
+	keep := !p.closed && c.State() != Closed
+	if keep {
+		p.idle = append(p.idle, c)
+	}
+	p.mu.Unlock()
+	<-p.slot
+	if !keep && c.State() != Closed {
+		c.Logout(netTimeout)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPool(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	var t *clientT
+	dials := 0
+	p := NewPool(func() (*Client, error) {
+		var C *Client
+		C, t = newClient(T, `S: * PREAUTH [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+		dials++
+		return C, nil
+	}, 1)
+
+	// New connection
+	C, release, err := p.Get()
+	if err != nil || dials != 1 {
+		T.Fatalf("p.Get() expected new connection; got %v (%v)", C, err)
+	}
+
+	// Pool is full
+	done := make(chan *Client, 1)
+	go func() {
+		c, release, err := p.Get()
+		if err == nil {
+			release()
+		}
+		done <- c
+	}()
+	select {
+	case <-done:
+		t.Fatalf("p.Get() expected to block")
+	case <-time.After(testConnTimeout / 5):
+	}
+
+	// Connection is checked with NOOP before reuse
+	go t.script(
+		`C: A1 NOOP`+CRLF,
+		`S: A1 OK NOOP completed`+CRLF,
+	)
+	release()
+	release() // No-op
+	if c := <-done; c != C {
+		t.Fatalf("p.Get() expected to reuse the connection; got %v", c)
+	}
+	t.join("NOOP", nil)
+
+	// Close logs out of idle connections
+	go t.script(
+		`C: A2 LOGOUT`+CRLF,
+		`S: * BYE LOGOUT Requested`+CRLF,
+		`S: A2 OK Quit completed`+CRLF,
+		EOF,
+	)
+	p.Close()
+	t.join("LOGOUT", nil)
+	t.checkState(Closed)
+	if c, _, err := p.Get(); c != nil || err != ErrPoolClosed {
+		t.Fatalf("p.Get() expected ErrPoolClosed; got %v (%v)", c, err)
+	}
+	if dials != 1 {
+		t.Errorf("dials expected 1; got %d", dials)
+	}
+}
@@ -58,8 +58,10 @@ type rawResponse struct {
 	*Response
 	*reader
 
-	line []byte // Full response line without literals or CRLFs
-	tail []byte // Unconsumed line ending (parser state)
+	line  []byte // Full response line without literals or CRLFs
+	tail  []byte // Unconsumed line ending (parser state)
+	atoms bool   // Numbers are kept as atoms (parser state)
+	fetch uint32 // FETCH message sequence number (parser state)
 }
 
 // newReader returns a reader configured to accept tagged responses beginning
@@ -306,10 +308,24 @@ func (raw *rawResponse) parseFields(stop byte) (fields []Field, err error) {
 		case QuotedString:
 			f, err = raw.parseQuotedString()
 		case LiteralString:
-			f, err = raw.parseLiteralString()
+			var info LiteralInfo
+			if n := len(fields); raw.fetch != 0 && stop == ')' && n%2 == 1 {
+				// Literal is the value of a FETCH data item
+				if item, ok := fields[n-1].(string); ok {
+					info.Seq, info.Item = raw.fetch, toUpper(item)
+				}
+			}
+			f, err = raw.parseLiteralString(info)
 		case List:
 			raw.tail = raw.tail[1:]
+			atoms, fetch := raw.atoms, raw.fetch
+			raw.atoms = atoms || objectIDKey(fields)
+			raw.fetch = 0
+			if stop == nul && len(fields) == 2 && raw.Label == "FETCH" {
+				raw.fetch = AsNumber(fields[0])
+			}
 			f, err = raw.parseFields(')')
+			raw.atoms, raw.fetch = atoms, fetch
 		default:
 			f, err = raw.parseAtom(raw.Type == Data && stop != ']')
 		}
@@ -398,9 +414,9 @@ func (raw *rawResponse) parseQuotedString() (f Field, err error) {
 
 // parseLiteralString returns the next literal string. The octet count should be
 // the last field in raw.tail. An additional line of text will be appended to
-// raw.line and raw.tail after the literal is received.
-func (raw *rawResponse) parseLiteralString() (f Field, err error) {
-	var info LiteralInfo
+// raw.line and raw.tail after the literal is received. The octet count and
+// format are added to info, which may already describe the literal context.
+func (raw *rawResponse) parseLiteralString(info LiteralInfo) (f Field, err error) {
 	start := 1
 	if raw.tail[0] == '~' {
 		info.Bin = true
@@ -435,6 +451,22 @@ func init() {
 	}
 }
 
+// objectIDKey returns true if the last field in fields is the name of an
+// object identifier (RFC 8474). Identifiers are opaque strings that may consist
+// only of digits, possibly with leading zeros, so they must not be converted to
+// numbers.
+func objectIDKey(fields []Field) bool {
+	if n := len(fields); n > 0 {
+		if s, ok := fields[n-1].(string); ok && !Quoted(s) {
+			switch normalize([]byte(s)) {
+			case "MAILBOXID", "EMAILID", "THREADID":
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // parseAtom returns the next atom, number, or NIL. The syntax rules are relaxed
 // to treat sequences such as "BODY[...]<...>" as a single atom. Numbers are
 // converted to uint32, NIL is converted to nil, everything else becomes a
@@ -494,7 +526,7 @@ func (raw *rawResponse) parseAtom(astring bool) (f Field, err error) {
 	if norm := normalize(atom); flag {
 		f = norm
 	} else if norm != "NIL" {
-		if c := norm[0]; '0' <= c && c <= '9' {
+		if c := norm[0]; '0' <= c && c <= '9' && !raw.atoms {
 			if ui, err := strconv.ParseUint(norm, 10, 32); err == nil {
 				f = uint32(ui)
 			}
@@ -5,6 +5,7 @@
 package imap
 
 import (
+	"io"
 	"reflect"
 	"testing"
 )
@@ -509,7 +510,8 @@ func TestReaderParse(t *testing.T) {
 						uint32(92)}}},
 			}},
 		{`* 12 FETCH (BODY[HEADER] {342}` + CRLF + header + `)`,
-			&Response{Tag: "*", Type: Data, Label: "FETCH", Fields: []Field{uint32(12), "FETCH", []Field{"BODY[HEADER]", lit(header)}}}},
+			&Response{Tag: "*", Type: Data, Label: "FETCH", Fields: []Field{uint32(12), "FETCH", []Field{"BODY[HEADER]",
+				&literal{[]byte(header), LiteralInfo{Len: 342, Seq: 12, Item: "BODY[HEADER]"}}}}}},
 
 		// Literals in BODY[...] are handled, but are not included in Fields
 		{`* 12 FETCH (BODY[HEADER.FIELDS.NOT ({4}` + CRLF + `Date)]<0> NIL)`,
@@ -557,3 +559,60 @@ func TestReaderParse(t *testing.T) {
 		}
 	}
 }
+
+func TestReaderStream(t *testing.T) {
+	var got []string
+	sr := &StreamReader{MinLen: 5}
+	sr.Func = func(r io.Reader, i LiteralInfo) error {
+		b := make([]byte, 4)
+		n, err := io.ReadFull(r, b)
+		got = append(got, string(b[:n]))
+		return err
+	}
+	c, s := newTestConn(1024)
+	C := newTransport(c, nil)
+	r := newReader(C, sr, "A")
+
+	// Partially consumed literal is discarded
+	s.Write([]byte(`* 1 FETCH (BODY[] {10}` + CRLF + `0123456789 BODY[TEXT] {3}` + CRLF + `abc UID 5)` + CRLF))
+	s.Write([]byte(`* 2 EXISTS` + CRLF))
+	raw, err := r.Next()
+	if err != nil {
+		t.Fatalf("Next() unexpected error; %v", err)
+	}
+	rsp, err := raw.Parse()
+	if err != nil {
+		t.Fatalf("Parse() unexpected error; %v", err)
+	}
+	if len(got) != 1 || got[0] != "0123" {
+		t.Errorf("StreamReader.Func expected [\"0123\"]; got %q", got)
+	}
+	info := rsp.MessageInfo()
+	if v := AsString(info.Attrs["BODY[TEXT]"]); v != "abc" {
+		t.Errorf(`Attrs["BODY[TEXT]"] expected "abc"; got %q`, v)
+	}
+	if l, ok := info.Attrs["BODY[]"].(Literal); !ok || l.Info().Len != 10 {
+		t.Errorf(`Attrs["BODY[]"] expected empty literal; got %#v`, info.Attrs["BODY[]"])
+	}
+	if info.UID != 5 {
+		t.Errorf("UID expected 5; got %d", info.UID)
+	}
+	if raw, err = r.Next(); err != nil {
+		t.Fatalf("Next() unexpected error; %v", err)
+	} else if rsp, err = raw.Parse(); err != nil || rsp.Label != "EXISTS" {
+		t.Fatalf("Parse() expected EXISTS; got %v (%v)", rsp, err)
+	}
+
+	// Errors are fatal
+	sr.Func = func(r io.Reader, i LiteralInfo) error {
+		return io.ErrUnexpectedEOF
+	}
+	C.clear()
+	s.Write([]byte(`* 3 FETCH (BODY[] {10}` + CRLF + `0123456789)` + CRLF))
+	if raw, err = r.Next(); err == nil {
+		_, err = raw.Parse()
+	}
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("Parse() expected io.ErrUnexpectedEOF; got %v", err)
+	}
+}
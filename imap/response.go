@@ -5,7 +5,12 @@
 package imap
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"net/mail"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -91,6 +96,13 @@ type Response struct {
 	// this field except when writing a custom decoder (see response.go for
 	// examples).
 	Decoded interface{}
+
+	// Number of bytes received for this response, including literals.
+	size int64
+
+	// utf8 indicates that UTF8=ACCEPT was enabled when the response was
+	// received, so mailbox names are not encoded in modified UTF-7.
+	utf8 bool
 }
 
 // String returns the raw text from which this Response object was constructed.
@@ -99,6 +111,30 @@ func (rsp *Response) String() string {
 	return string(rsp.Raw)
 }
 
+// DataType returns the type of a Data response, which is determined by its
+// Label. Zero is returned for all other response types.
+func (rsp *Response) DataType() DataType {
+	if rsp.Type != Data {
+		return 0
+	} else if v, ok := dataLabels[rsp.Label]; ok {
+		return v
+	}
+	return DataOther
+}
+
+// mailbox returns the value of a mailbox name field. Names are decoded from
+// modified UTF-7 unless UTF8=ACCEPT was enabled (RFC 6855 section 3).
+func (rsp *Response) mailbox(f Field) string {
+	if !rsp.utf8 {
+		return AsMailbox(f)
+	}
+	v := AsString(f)
+	if len(v) == 5 && toUpper(v) == "INBOX" {
+		return "INBOX"
+	}
+	return v
+}
+
 // Value returns the first unsigned 32-bit integer in Fields without descending
 // into parenthesized lists. This decoder is primarily intended for Status/Data
 // responses labeled EXISTS, RECENT, EXPUNGE, UNSEEN, UIDNEXT, and UIDVALIDITY.
@@ -133,6 +169,18 @@ type MailboxInfo struct {
 	Attrs FlagSet // Mailbox attributes (e.g. `\Noinferiors`, `\Noselect`)
 	Delim string  // Hierarchy delimiter (empty string == NIL, i.e. flat name)
 	Name  string  // Mailbox name decoded to UTF-8
+
+	// Special-use attribute (e.g. `\Sent`, `\Trash`) if present in Attrs. See
+	// RFC 6154 for a list of all defined attributes.
+	SpecialUse string
+
+	// Mailbox status returned by Client.ListStatus (LIST-STATUS extension).
+	Status *MailboxStatus
+}
+
+// specialUse contains all mailbox attributes defined in RFC 6154.
+var specialUse = []string{
+	`\All`, `\Archive`, `\Drafts`, `\Flagged`, `\Junk`, `\Sent`, `\Trash`,
 }
 
 // MailboxInfo returns the mailbox attributes extracted from a LIST or LSUB
@@ -144,7 +192,13 @@ func (rsp *Response) MailboxInfo() *MailboxInfo {
 		v = &MailboxInfo{
 			Attrs: AsFlagSet(rsp.Fields[1]),
 			Delim: AsString(rsp.Fields[2]),
-			Name:  AsMailbox(rsp.Fields[3]),
+			Name:  rsp.mailbox(rsp.Fields[3]),
+		}
+		for _, attr := range specialUse {
+			if v.Attrs[attr] {
+				v.SpecialUse = attr
+				break
+			}
 		}
 		rsp.Decoded = v
 	}
@@ -166,6 +220,11 @@ type MailboxStatus struct {
 	UIDNext      uint32  // The next unique identifier value
 	UIDValidity  uint32  // The unique identifier validity value
 	UIDNotSticky bool    // UIDPLUS extension (client-only)
+
+	HighestModSeq uint64 // Highest mod-sequence value (CONDSTORE extension)
+	NoModSeq      bool   // Mailbox does not support mod-sequences (client-only)
+
+	MailboxID string // Immutable identifier that survives renames (OBJECTID extension)
 }
 
 // newMailboxStatus returns an initialized MailboxStatus instance.
@@ -180,19 +239,30 @@ func newMailboxStatus(name string) *MailboxStatus {
 	}
 }
 
+// AllowsNewKeywords returns true if the PERMANENTFLAGS response code contained
+// the special `\*` flag, which indicates that new keywords can be created by
+// storing them on a message, and that they will be kept permanently.
+func (m *MailboxStatus) AllowsNewKeywords() bool {
+	return m.PermFlags[`\*`]
+}
+
 func (m *MailboxStatus) String() string {
 	return fmt.Sprintf("--- %+q ---\n"+
-		"ReadOnly:     %v\n"+
-		"Flags:        %v\n"+
-		"PermFlags:    %v\n"+
-		"Messages:     %v\n"+
-		"Recent:       %v\n"+
-		"Unseen:       %v\n"+
-		"UIDNext:      %v\n"+
-		"UIDValidity:  %v\n"+
-		"UIDNotSticky: %v\n",
+		"ReadOnly:      %v\n"+
+		"Flags:         %v\n"+
+		"PermFlags:     %v\n"+
+		"Messages:      %v\n"+
+		"Recent:        %v\n"+
+		"Unseen:        %v\n"+
+		"UIDNext:       %v\n"+
+		"UIDValidity:   %v\n"+
+		"UIDNotSticky:  %v\n"+
+		"HighestModSeq: %v\n"+
+		"NoModSeq:      %v\n"+
+		"MailboxID:     %v\n",
 		m.Name, m.ReadOnly, m.Flags, m.PermFlags, m.Messages, m.Recent,
-		m.Unseen, m.UIDNext, m.UIDValidity, m.UIDNotSticky)
+		m.Unseen, m.UIDNext, m.UIDValidity, m.UIDNotSticky,
+		m.HighestModSeq, m.NoModSeq, m.MailboxID)
 }
 
 // MailboxStatus returns the mailbox status information extracted from a STATUS
@@ -200,7 +270,7 @@ func (m *MailboxStatus) String() string {
 func (rsp *Response) MailboxStatus() *MailboxStatus {
 	v, ok := rsp.Decoded.(*MailboxStatus)
 	if !ok && rsp.Decoded == nil && rsp.Label == "STATUS" {
-		v = &MailboxStatus{Name: AsMailbox(rsp.Fields[1])}
+		v = &MailboxStatus{Name: rsp.mailbox(rsp.Fields[1])}
 		f := AsList(rsp.Fields[2])
 		for i := 0; i < len(f)-1; i += 2 {
 			switch n := AsNumber(f[i+1]); toUpper(AsAtom(f[i])) {
@@ -214,6 +284,10 @@ func (rsp *Response) MailboxStatus() *MailboxStatus {
 				v.UIDValidity = n
 			case "UNSEEN":
 				v.Unseen = n
+			case "HIGHESTMODSEQ":
+				v.HighestModSeq = AsNumber64(f[i+1])
+			case "MAILBOXID":
+				v.MailboxID = objectID(f[i+1])
 			}
 		}
 		rsp.Decoded = v
@@ -221,11 +295,22 @@ func (rsp *Response) MailboxStatus() *MailboxStatus {
 	return v
 }
 
+// objectID returns the identifier from a parenthesized object ID list, such as
+// the one in a MAILBOXID response code or status item (RFC 8474).
+func objectID(f Field) string {
+	if list := AsList(f); len(list) == 1 {
+		if v, ok := list[0].(string); ok && !Quoted(v) {
+			return v
+		}
+	}
+	return ""
+}
+
 // SearchResults returns a slice of message sequence numbers or UIDs extracted
-// from a SEARCH response.
+// from a SEARCH or SORT response.
 func (rsp *Response) SearchResults() []uint32 {
 	v, ok := rsp.Decoded.([]uint32)
-	if !ok && rsp.Decoded == nil && rsp.Label == "SEARCH" {
+	if !ok && rsp.Decoded == nil && (rsp.Label == "SEARCH" || rsp.Label == "SORT") {
 		if len(rsp.Fields) > 1 {
 			v = make([]uint32, len(rsp.Fields)-1)
 			for i, f := range rsp.Fields[1:] {
@@ -237,6 +322,70 @@ func (rsp *Response) SearchResults() []uint32 {
 	return v
 }
 
+// ESearchResult contains the information from an ESEARCH response (RFC 4731).
+// Min, Max, and Count are zero and All is nil when the corresponding return
+// option was not requested or when no messages matched.
+type ESearchResult struct {
+	Tag   string  // Tag of the command that generated the response
+	UID   bool    // Numbers are UIDs rather than message sequence numbers
+	Min   uint32  // Lowest matching message number
+	Max   uint32  // Highest matching message number
+	Count uint32  // Number of matching messages
+	All   *SeqSet // All matching message numbers
+}
+
+// ESearchResult returns the search results extracted from an ESEARCH response.
+func (rsp *Response) ESearchResult() *ESearchResult {
+	v, ok := rsp.Decoded.(*ESearchResult)
+	if !ok && rsp.Decoded == nil && rsp.Label == "ESEARCH" {
+		v = new(ESearchResult)
+		f := rsp.Fields[1:]
+		if len(f) > 0 && TypeOf(f[0]) == List {
+			if c := AsList(f[0]); len(c) == 2 && toUpper(AsAtom(c[0])) == "TAG" {
+				v.Tag = AsString(c[1])
+			}
+			f = f[1:]
+		}
+		if len(f) > 0 && toUpper(AsAtom(f[0])) == "UID" {
+			v.UID = true
+			f = f[1:]
+		}
+		for i := 0; i+1 < len(f); i += 2 {
+			switch toUpper(AsAtom(f[i])) {
+			case "MIN":
+				v.Min = AsNumber(f[i+1])
+			case "MAX":
+				v.Max = AsNumber(f[i+1])
+			case "COUNT":
+				v.Count = AsNumber(f[i+1])
+			case "ALL":
+				v.All = AsSeqSet(f[i+1])
+			}
+		}
+		rsp.Decoded = v
+	}
+	return v
+}
+
+// Vanished returns the UIDs of expunged messages extracted from a VANISHED
+// response (RFC 7162). Earlier is true for the "VANISHED (EARLIER)" form, which
+// the server sends in response to SELECT with the QRESYNC parameter or UID
+// FETCH with the VANISHED modifier. Such messages were removed before the
+// command was issued and do not affect the current number of messages in the
+// mailbox.
+func (rsp *Response) Vanished() (uids *SeqSet, earlier bool) {
+	if rsp.Label == "VANISHED" && len(rsp.Fields) > 1 {
+		f := rsp.Fields[1:]
+		if tag := AsList(f[0]); len(tag) == 1 && toUpper(AsAtom(tag[0])) == "EARLIER" {
+			earlier, f = true, f[1:]
+		}
+		if len(f) == 1 {
+			uids = AsSeqSet(f[0])
+		}
+	}
+	return
+}
+
 // MailboxFlags returns a FlagSet extracted from a FLAGS or PERMANENTFLAGS
 // response. Note that FLAGS is a Data response, while PERMANENTFLAGS is Status.
 func (rsp *Response) MailboxFlags() FlagSet {
@@ -253,6 +402,11 @@ func (rsp *Response) MailboxFlags() FlagSet {
 // The values of attributes marked optional are valid only if that attribute
 // also appears in Attrs (e.g. UID is valid if and only if Attrs["UID"] != nil).
 // These attributes are extracted from Attrs purely for convenience.
+//
+// When a command receives more than one FETCH response for the same message,
+// the attributes of all responses are combined in the MessageInfo of the first
+// one. Later values replace earlier ones for duplicate keys (e.g. FLAGS), so
+// the Fields of that response may no longer match its MessageInfo.
 type MessageInfo struct {
 	Attrs        FieldMap  // All returned attributes
 	Seq          uint32    // Message sequence number
@@ -260,6 +414,153 @@ type MessageInfo struct {
 	Flags        FlagSet   // Flags that are set for this message (optional)
 	InternalDate time.Time // Internal to the server message timestamp (optional)
 	Size         uint32    // Message size in bytes (optional)
+	ModSeq       uint64    // Mod-sequence value (optional, CONDSTORE extension)
+
+	// Gmail extensions (optional, X-GM-EXT-1 capability). Label names are
+	// decoded to UTF-8; system labels keep their leading backslash.
+	GmailMsgID    uint64   // X-GM-MSGID
+	GmailThreadID uint64   // X-GM-THRID
+	GmailLabels   []string // X-GM-LABELS
+
+	// Object identifiers (optional, OBJECTID extension). The server may return
+	// NIL for THREADID, in which case ThreadID is empty.
+	EmailID  string // EMAILID
+	ThreadID string // THREADID
+
+	env  *Envelope      // Cached Envelope() result
+	body *BodyStructure // Cached BodyStructure() result
+}
+
+// Envelope returns the parsed ENVELOPE attribute. The result is cached, so
+// subsequent calls are cheap. Nil is returned if the attribute is missing or
+// invalid.
+func (info *MessageInfo) Envelope() *Envelope {
+	if info.env == nil {
+		if f := info.Attrs["ENVELOPE"]; f != nil {
+			info.env, _ = NewEnvelope(f)
+		}
+	}
+	return info.env
+}
+
+// BodyStructure returns the parsed BODYSTRUCTURE attribute, or the BODY
+// attribute (non-extensible form) if BODYSTRUCTURE is missing. The result is
+// cached. Nil is returned if neither attribute is present or valid.
+func (info *MessageInfo) BodyStructure() *BodyStructure {
+	if info.body == nil {
+		f := info.Attrs["BODYSTRUCTURE"]
+		if f == nil {
+			f = info.Attrs["BODY"]
+		}
+		if f != nil {
+			info.body, _ = NewBodyStructure(f)
+		}
+	}
+	return info.body
+}
+
+// Body returns the contents of the BODY[<section>] attribute. If the section
+// was only fetched with a partial range (see BodyPart), the data from the range
+// with the lowest origin octet is returned. The RFC822, RFC822.HEADER, and RFC822.TEXT
+// attributes are used for the "", "HEADER", and "TEXT" sections, respectively,
+// if the BODY[] form is missing. Nil is returned if the section is not found.
+func (info *MessageInfo) Body(section string) []byte {
+	key := BodyPart{Section: section}.Key()
+	f, ok := info.Attrs[key]
+	if !ok {
+		min := uint64(1 << 32)
+		for k, v := range info.Attrs {
+			if strings.HasPrefix(k, key) && len(k) > len(key)+2 && k[len(key)] == '<' {
+				n, err := strconv.ParseUint(k[len(key)+1:len(k)-1], 10, 32)
+				if err == nil && n < min {
+					f, ok, min = v, true, n
+				}
+			}
+		}
+	}
+	if !ok {
+		switch key {
+		case "BODY[]":
+			f, ok = info.Attrs["RFC822"]
+		case "BODY[HEADER]":
+			f, ok = info.Attrs["RFC822.HEADER"]
+		case "BODY[TEXT]":
+			f, ok = info.Attrs["RFC822.TEXT"]
+		}
+	}
+	if !ok {
+		return nil
+	}
+	return AsBytes(f)
+}
+
+// Message parses the complete message from the BODY[] attribute (see Body),
+// such as the one requested by Client.FetchRFC822. An error is returned if the
+// attribute is missing or the message header is malformed.
+func (info *MessageInfo) Message() (*mail.Message, error) {
+	b := info.Body("")
+	if b == nil {
+		return nil, errors.New("imap: message body not found")
+	}
+	return mail.ReadMessage(bytes.NewReader(b))
+}
+
+// Binary returns the decoded contents of the BINARY[<section>] attribute (RFC
+// 3516), such as the one requested by Client.FetchBinary. Nil is returned if
+// the section is not found.
+func (info *MessageInfo) Binary(section string) []byte {
+	if f, ok := info.Attrs["BINARY["+section+"]"]; ok {
+		return AsBytes(f)
+	}
+	return nil
+}
+
+// BinarySize returns the decoded size of the specified body part from the
+// BINARY.SIZE[<section>] attribute (RFC 3516). Ok is false if the attribute is
+// missing.
+func (info *MessageInfo) BinarySize(section string) (n uint32, ok bool) {
+	f, ok := info.Attrs["BINARY.SIZE["+section+"]"]
+	if ok && TypeOf(f) != Number {
+		ok = false
+	}
+	return AsNumber(f), ok
+}
+
+// merge adds all attributes of other to info, replacing any existing values,
+// and updates the convenience fields and cached results.
+func (info *MessageInfo) merge(other *MessageInfo) {
+	if info.Attrs == nil {
+		info.Attrs = make(FieldMap, len(other.Attrs))
+	}
+	for k, v := range other.Attrs {
+		info.Attrs[k] = v
+		switch k {
+		case "UID":
+			info.UID = other.UID
+		case "FLAGS":
+			info.Flags = other.Flags
+		case "INTERNALDATE":
+			info.InternalDate = other.InternalDate
+		case "RFC822.SIZE":
+			info.Size = other.Size
+		case "MODSEQ":
+			info.ModSeq = other.ModSeq
+		case "X-GM-MSGID":
+			info.GmailMsgID = other.GmailMsgID
+		case "X-GM-THRID":
+			info.GmailThreadID = other.GmailThreadID
+		case "X-GM-LABELS":
+			info.GmailLabels = other.GmailLabels
+		case "EMAILID":
+			info.EmailID = other.EmailID
+		case "THREADID":
+			info.ThreadID = other.ThreadID
+		case "ENVELOPE":
+			info.env = nil
+		case "BODY", "BODYSTRUCTURE":
+			info.body = nil
+		}
+	}
 }
 
 // MessageInfo returns the message attributes extracted from a FETCH response.
@@ -275,20 +576,41 @@ func (rsp *Response) MessageInfo() *MessageInfo {
 			InternalDate: AsDateTime(kv["INTERNALDATE"]),
 			Size:         AsNumber(kv["RFC822.SIZE"]),
 		}
+		if modseq := AsList(kv["MODSEQ"]); len(modseq) == 1 {
+			v.ModSeq = AsNumber64(modseq[0])
+		}
+		if f, ok := kv["X-GM-MSGID"]; ok {
+			v.GmailMsgID = AsNumber64(f)
+		}
+		if f, ok := kv["X-GM-THRID"]; ok {
+			v.GmailThreadID = AsNumber64(f)
+		}
+		if f, ok := kv["X-GM-LABELS"]; ok {
+			labels := AsList(f)
+			v.GmailLabels = make([]string, len(labels))
+			for i, label := range labels {
+				v.GmailLabels[i] = rsp.mailbox(label)
+			}
+		}
+		v.EmailID = objectID(kv["EMAILID"])
+		v.ThreadID = objectID(kv["THREADID"])
 		rsp.Decoded = v
 	}
 	return v
 }
 
 // Quota represents a single resource limit on a mailbox quota root returned in
-// a QUOTA response, as described in RFC 2087.
+// a QUOTA response, as described in RFC 9208.
 type Quota struct {
-	Resource string // Resource name (e.g. STORAGE, MESSAGE)
-	Usage    uint32 // Current usage (in units of 1024 octets for STORAGE)
-	Limit    uint32 // Current limit
+	Resource string // Resource name (e.g. STORAGE, MESSAGE, MAILBOX)
+	Usage    uint64 // Current usage (in units of 1024 octets for STORAGE)
+	Limit    uint64 // Current limit
 }
 
-// Quota returns the resource quotas extracted from a QUOTA response.
+// Quota returns the resource quotas extracted from a QUOTA response. Resource
+// names are not interpreted, so those defined by RFC 9208 (STORAGE, MESSAGE,
+// MAILBOX, ANNOTATION-STORAGE) and any other resources are returned in the
+// order they were listed. The names are converted to upper case.
 func (rsp *Response) Quota() (root string, quota []*Quota) {
 	type vt struct {
 		root  string
@@ -305,8 +627,8 @@ func (rsp *Response) Quota() (root string, quota []*Quota) {
 		for i := 0; i < len(list); i += 3 {
 			quota[i/3] = &Quota{
 				Resource: toUpper(AsAtom(list[i])),
-				Usage:    AsNumber(list[i+1]),
-				Limit:    AsNumber(list[i+2]),
+				Usage:    AsNumber64(list[i+1]),
+				Limit:    AsNumber64(list[i+2]),
 			}
 		}
 		rsp.Decoded = &vt{root, quota}
@@ -325,7 +647,7 @@ func (rsp *Response) QuotaRoot() (mbox string, roots []string) {
 	}
 	v, ok := rsp.Decoded.(*vt)
 	if !ok && rsp.Decoded == nil && rsp.Label == "QUOTAROOT" {
-		mbox = AsMailbox(rsp.Fields[1])
+		mbox = rsp.mailbox(rsp.Fields[1])
 		roots = make([]string, len(rsp.Fields[2:]))
 		for i, root := range rsp.Fields[2:] {
 			roots[i] = AsString(root)
@@ -337,6 +659,224 @@ func (rsp *Response) QuotaRoot() (mbox string, roots []string) {
 	return
 }
 
+// ACL returns the mailbox name and a map of identifiers to access rights
+// extracted from an ACL response. See RFC 4314 for additional information.
+func (rsp *Response) ACL() (mbox string, acl map[string]string) {
+	type vt struct {
+		mbox string
+		acl  map[string]string
+	}
+	v, ok := rsp.Decoded.(*vt)
+	if !ok && rsp.Decoded == nil && rsp.Label == "ACL" {
+		if len(rsp.Fields) < 2 || len(rsp.Fields)%2 != 0 {
+			return
+		}
+		mbox = rsp.mailbox(rsp.Fields[1])
+		acl = make(map[string]string, len(rsp.Fields)/2-1)
+		for i := 2; i < len(rsp.Fields); i += 2 {
+			acl[AsString(rsp.Fields[i])] = AsString(rsp.Fields[i+1])
+		}
+		rsp.Decoded = &vt{mbox, acl}
+	} else if ok {
+		mbox, acl = v.mbox, v.acl
+	}
+	return
+}
+
+// MyRights returns the mailbox name and the access rights of the current user
+// extracted from a MYRIGHTS response. See RFC 4314 for additional information.
+func (rsp *Response) MyRights() (mbox, rights string) {
+	type vt struct{ mbox, rights string }
+	v, ok := rsp.Decoded.(*vt)
+	if !ok && rsp.Decoded == nil && rsp.Label == "MYRIGHTS" {
+		if len(rsp.Fields) != 3 {
+			return
+		}
+		mbox = rsp.mailbox(rsp.Fields[1])
+		rights = AsString(rsp.Fields[2])
+		rsp.Decoded = &vt{mbox, rights}
+	} else if ok {
+		mbox, rights = v.mbox, v.rights
+	}
+	return
+}
+
+// Metadata returns the mailbox name ("" for server entries) and the metadata
+// entries extracted from a METADATA response. NIL values are returned as nil
+// pointers. If the server sent an unsolicited notification containing only the
+// names of changed entries, all values are nil. See RFC 5464 for additional
+// information.
+func (rsp *Response) Metadata() (mbox string, entries map[string]*string) {
+	type vt struct {
+		mbox    string
+		entries map[string]*string
+	}
+	v, ok := rsp.Decoded.(*vt)
+	if !ok && rsp.Decoded == nil && rsp.Label == "METADATA" && rsp.Type == Data {
+		if len(rsp.Fields) < 3 {
+			return
+		}
+		mbox = rsp.mailbox(rsp.Fields[1])
+		if list, ok := rsp.Fields[2].([]Field); ok {
+			if len(list)%2 != 0 {
+				return "", nil
+			}
+			entries = make(map[string]*string, len(list)/2)
+			for i := 0; i < len(list); i += 2 {
+				var val *string
+				if list[i+1] != nil {
+					s := AsString(list[i+1])
+					val = &s
+				}
+				entries[AsString(list[i])] = val
+			}
+		} else {
+			entries = make(map[string]*string, len(rsp.Fields)-2)
+			for _, f := range rsp.Fields[2:] {
+				entries[AsString(f)] = nil
+			}
+		}
+		rsp.Decoded = &vt{mbox, entries}
+	} else if ok {
+		mbox, entries = v.mbox, v.entries
+	}
+	return
+}
+
+// Alert returns the human-readable text of a status response containing the
+// ALERT response code. RFC 3501 requires this text to be presented to the user.
+// An empty string is returned for all other responses.
+func (rsp *Response) Alert() string {
+	if rsp.Label == "ALERT" && rsp.Type&(Status|Done) != 0 {
+		return rsp.Info
+	}
+	return ""
+}
+
+// BadCharset returns the list of supported charsets from a BADCHARSET response
+// code, which the server sends when a SEARCH command specifies an unsupported
+// charset. The list is optional, so a non-nil empty slice is returned if the
+// server did not include it. Nil is returned for all other responses.
+func (rsp *Response) BadCharset() []string {
+	v, ok := rsp.Decoded.([]string)
+	if !ok && rsp.Decoded == nil && rsp.Label == "BADCHARSET" {
+		v = make([]string, 0, 4)
+		if len(rsp.Fields) > 1 {
+			for _, f := range AsList(rsp.Fields[1]) {
+				if cs := AsString(f); cs != "" {
+					v = append(v, cs)
+				}
+			}
+		}
+		rsp.Decoded = v
+	}
+	return v
+}
+
+// AppendUID returns the UIDVALIDITY of the destination mailbox and the UID
+// assigned to the appended message, extracted from an APPENDUID response code.
+// See RFC 4315 for additional information.
+func (rsp *Response) AppendUID() (uidValidity, uid uint32) {
+	type vt struct{ uidValidity, uid uint32 }
+	v, ok := rsp.Decoded.(*vt)
+	if !ok && rsp.Decoded == nil && rsp.Label == "APPENDUID" {
+		if len(rsp.Fields) != 3 {
+			return
+		}
+		uidValidity = AsNumber(rsp.Fields[1])
+		uid = AsNumber(rsp.Fields[2])
+		rsp.Decoded = &vt{uidValidity, uid}
+	} else if ok {
+		uidValidity, uid = v.uidValidity, v.uid
+	}
+	return
+}
+
+// CopyUID returns the UIDVALIDITY of the destination mailbox and the UIDs of
+// the source and destination messages extracted from a COPYUID response code.
+// Messages in src and dst are listed in the same order. See RFC 4315 for
+// additional information.
+func (rsp *Response) CopyUID() (uidValidity uint32, src, dst *SeqSet) {
+	type vt struct {
+		uidValidity uint32
+		src, dst    *SeqSet
+	}
+	v, ok := rsp.Decoded.(*vt)
+	if !ok && rsp.Decoded == nil && rsp.Label == "COPYUID" {
+		if len(rsp.Fields) != 4 {
+			return
+		}
+		uidValidity = AsNumber(rsp.Fields[1])
+		src = AsSeqSet(rsp.Fields[2])
+		dst = AsSeqSet(rsp.Fields[3])
+		rsp.Decoded = &vt{uidValidity, src, dst}
+	} else if ok {
+		uidValidity, src, dst = v.uidValidity, v.src, v.dst
+	}
+	return
+}
+
+// ServerID returns the server identification information extracted from an ID
+// response. Field names are converted to lower case, and NIL values are
+// converted to empty strings. An empty map is returned if the server sent NIL.
+func (rsp *Response) ServerID() map[string]string {
+	v, ok := rsp.Decoded.(map[string]string)
+	if !ok && rsp.Decoded == nil && rsp.Label == "ID" && len(rsp.Fields) > 1 {
+		f := AsList(rsp.Fields[1])
+		v = make(map[string]string, len(f)/2)
+		for i := 0; i < len(f)-1; i += 2 {
+			v[strings.ToLower(AsString(f[i]))] = AsString(f[i+1])
+		}
+		rsp.Decoded = v
+	}
+	return v
+}
+
+// Namespace represents a single namespace description returned in a NAMESPACE
+// response, as described in RFC 2342.
+type Namespace struct {
+	Prefix string // Namespace prefix decoded to UTF-8
+	Delim  string // Hierarchy delimiter (empty string == NIL, i.e. flat name)
+}
+
+// Namespaces contains the personal, other users', and shared namespaces
+// returned in a NAMESPACE response. A nil slice indicates that the server
+// returned NIL for the corresponding namespace type.
+type Namespaces struct {
+	Personal []Namespace
+	Other    []Namespace
+	Shared   []Namespace
+}
+
+// Namespaces returns the namespace information extracted from a NAMESPACE
+// response. Namespace response extensions are ignored.
+func (rsp *Response) Namespaces() *Namespaces {
+	v, ok := rsp.Decoded.(*Namespaces)
+	if !ok && rsp.Decoded == nil && rsp.Label == "NAMESPACE" {
+		if len(rsp.Fields) < 4 {
+			return nil
+		}
+		v = &Namespaces{
+			Personal: rsp.asNamespaces(rsp.Fields[1]),
+			Other:    rsp.asNamespaces(rsp.Fields[2]),
+			Shared:   rsp.asNamespaces(rsp.Fields[3]),
+		}
+		rsp.Decoded = v
+	}
+	return v
+}
+
+// asNamespaces converts a list of namespace descriptions into a slice of
+// Namespace structs. Nil is returned if f is NIL or an empty list.
+func (rsp *Response) asNamespaces(f Field) (ns []Namespace) {
+	for _, d := range AsList(f) {
+		if d := AsList(d); len(d) >= 2 {
+			ns = append(ns, Namespace{rsp.mailbox(d[0]), AsString(d[1])})
+		}
+	}
+	return
+}
+
 // ResponseError wraps a Response pointer for use in an error context, such as
 // when a command fails with a NO or BAD status condition. For Status and Done
 // response types, the value of Response.Info may be presented to the user.
@@ -353,3 +893,21 @@ func (rsp ResponseError) Error() string {
 	}
 	return fmt.Sprintf("imap: %s (%+q%s)", rsp.Reason, line, ellipsis)
 }
+
+// Is returns true if target is ErrCommandNo or ErrCommandBad and the response
+// is a command completion with the matching status, or if target is
+// ErrOverQuota and the response contains the OVERQUOTA response code.
+func (rsp ResponseError) Is(target error) bool {
+	if rsp.Response == nil {
+		return false
+	}
+	switch target {
+	case ErrCommandNo:
+		return rsp.Type == Done && rsp.Status == NO
+	case ErrCommandBad:
+		return rsp.Type == Done && rsp.Status == BAD
+	case ErrOverQuota:
+		return rsp.Label == "OVERQUOTA"
+	}
+	return false
+}
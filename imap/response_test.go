@@ -25,6 +25,20 @@ func TestResponseDecoders(t *testing.T) {
 		{`A142 OK [READ-WRITE] SELECT completed`,
 			"String", "A142 OK [READ-WRITE] SELECT completed"},
 
+		// Data response type
+		{`* 172 EXISTS`,
+			"DataType", DataExists},
+		{`* 22 EXPUNGE`,
+			"DataType", DataExpunge},
+		{`* 12 FETCH (FLAGS (\Seen))`,
+			"DataType", DataFetch},
+		{`* LIST () "/" INBOX`,
+			"DataType", DataList},
+		{`* XYZZY 1 2`,
+			"DataType", DataOther},
+		{`* OK [UNSEEN 12] Message 12 is first unseen`,
+			"DataType", DataType(0)},
+
 		// Numeric value -> uint32
 		{`* STATUS blurdybloop (MESSAGES 231 UIDNEXT 44292)`,
 			"Value", uint32(0)},
@@ -86,6 +100,18 @@ func TestResponseDecoders(t *testing.T) {
 				Attrs: NewFlagSet(`\Noselect`, `\Noinferiors`),
 				Delim: "",
 				Name:  "foobar"}},
+		{`* LIST (\HasNoChildren \Sent) "/" "[Gmail]/Sent Mail"`,
+			"MailboxInfo", &MailboxInfo{
+				Attrs:      NewFlagSet(`\Hasnochildren`, `\Sent`),
+				Delim:      "/",
+				Name:       "[Gmail]/Sent Mail",
+				SpecialUse: `\Sent`}},
+		{`* LIST (\Marked \all \HasChildren) "." "All Mail" ("CHILDINFO" ("SUBSCRIBED"))`,
+			"MailboxInfo", &MailboxInfo{
+				Attrs:      NewFlagSet(`\Marked`, `\All`, `\Haschildren`),
+				Delim:      ".",
+				Name:       "All Mail",
+				SpecialUse: `\All`}},
 		{`* LSUB (\noselect \marked) "/" ~peter/mail/&U,BTFw-/&ZeVnLIqe-`,
 			"MailboxInfo", &MailboxInfo{
 				Attrs: NewFlagSet(`\Noselect`, `\Marked`),
@@ -118,6 +144,25 @@ func TestResponseDecoders(t *testing.T) {
 				UIDNext:     42,
 				UIDValidity: 123,
 				Unseen:      5}},
+		{`* STATUS INBOX (HIGHESTMODSEQ 7011231777 MESSAGES 3)`,
+			"MailboxStatus", &MailboxStatus{
+				Name:          "INBOX",
+				Messages:      3,
+				HighestModSeq: 7011231777}},
+		{`* STATUS foo (MAILBOXID (F2212ea87-6097-4256-9d51-71338625) MESSAGES 3)`,
+			"MailboxStatus", &MailboxStatus{
+				Name:      "foo",
+				Messages:  3,
+				MailboxID: "F2212ea87-6097-4256-9d51-71338625"}},
+		{`* STATUS foo (MAILBOXID (12345))`,
+			"MailboxStatus", &MailboxStatus{
+				Name:      "foo",
+				MailboxID: "12345"}},
+		{`* STATUS foo (MAILBOXID (007) MESSAGES 007)`,
+			"MailboxStatus", &MailboxStatus{
+				Name:      "foo",
+				Messages:  7,
+				MailboxID: "007"}},
 
 		// SEARCH -> []uint32
 		{`* NOT SEARCH`,
@@ -130,6 +175,10 @@ func TestResponseDecoders(t *testing.T) {
 			"SearchResults", []uint32{1, 2}},
 		{`* SEARCH 2 3 6`,
 			"SearchResults", []uint32{2, 3, 6}},
+		{`* SORT`,
+			"SearchResults", []uint32(nil)},
+		{`* SORT 5 3 4 1 2`,
+			"SearchResults", []uint32{5, 3, 4, 1, 2}},
 
 		// FLAGS and PERMANENTFLAGS -> FlagSet
 		{`* NOT FLAGS`,
@@ -174,12 +223,20 @@ func TestResponseDecoders(t *testing.T) {
 				InternalDate: time.Date(1996, time.July, 7, 2, 44, 25, 0, UTC)}},
 		{`* 12 FETCH (body[header] {342}` + CRLF + header + ` UID 1 FLAGS () INTERNALDATE "17-Jul-1996 02:44:25 -0700" RFC822.SIZE 1024)`,
 			"MessageInfo", &MessageInfo{
-				Attrs:        FieldMap{"BODY[HEADER]": lit(header), "UID": uint32(1), "FLAGS": []Field(nil), "INTERNALDATE": `"17-Jul-1996 02:44:25 -0700"`, "RFC822.SIZE": uint32(1024)},
+				Attrs: FieldMap{"BODY[HEADER]": &literal{[]byte(header), LiteralInfo{Len: 342, Seq: 12, Item: "BODY[HEADER]"}},
+					"UID": uint32(1), "FLAGS": []Field(nil), "INTERNALDATE": `"17-Jul-1996 02:44:25 -0700"`, "RFC822.SIZE": uint32(1024)},
 				Seq:          12,
 				UID:          1,
 				Flags:        NewFlagSet(),
 				InternalDate: time.Date(1996, time.July, 17, 2, 44, 25, 0, MST),
 				Size:         1024}},
+		{`* 7 FETCH (UID 25 MODSEQ (12121231000) FLAGS (\Seen))`,
+			"MessageInfo", &MessageInfo{
+				Attrs:  FieldMap{"UID": uint32(25), "MODSEQ": []Field{"12121231000"}, "FLAGS": []Field{`\Seen`}},
+				Seq:    7,
+				UID:    25,
+				Flags:  NewFlagSet(`\Seen`),
+				ModSeq: 12121231000}},
 
 		// QUOTA -> (string, []*Quota)
 		{`* NOT QUOTA`,
@@ -197,6 +254,9 @@ func TestResponseDecoders(t *testing.T) {
 		{`* QUOTA "inbox" (storage 10 512 message 20 100)`,
 			"Quota", []interface{}{
 				"inbox", []*Quota{&Quota{"STORAGE", 10, 512}, &Quota{"MESSAGE", 20, 100}}}},
+		{`* QUOTA "" (STORAGE 5000000000 8589934592)`,
+			"Quota", []interface{}{
+				"", []*Quota{&Quota{"STORAGE", 5000000000, 8589934592}}}},
 
 		// QUOTAROOT -> (string, []string)
 		{`* NOT QUOTAROOT`,
@@ -211,6 +271,58 @@ func TestResponseDecoders(t *testing.T) {
 		{`* QUOTAROOT "inbox" root1 "root2"`,
 			"QuotaRoot", []interface{}{
 				"INBOX", []string{"root1", "root2"}}},
+
+		// ID -> map[string]string
+		{`* NOT ID`,
+			"ServerID", map[string]string(nil)},
+		{`* ID NIL`,
+			"ServerID", map[string]string{}},
+		{`* ID ("name" "Cyrus" "version" "1.5" "OS" NIL "support-URL" {22}` + CRLF + `mailto:cyrus@andrew.cm)`,
+			"ServerID", map[string]string{
+				"name": "Cyrus", "version": "1.5", "os": "", "support-url": "mailto:cyrus@andrew.cm"}},
+
+		// NAMESPACE -> Namespaces
+		{`* NOT NAMESPACE`,
+			"Namespaces", (*Namespaces)(nil)},
+		{`* NAMESPACE NIL NIL`,
+			"Namespaces", (*Namespaces)(nil)},
+		{`* NAMESPACE (("" "/")) NIL NIL`,
+			"Namespaces", &Namespaces{
+				Personal: []Namespace{{"", "/"}}}},
+		{`* NAMESPACE NIL NIL (("" "."))`,
+			"Namespaces", &Namespaces{
+				Shared: []Namespace{{"", "."}}}},
+		{`* NAMESPACE (("" "/")("#mh/" "/" "X-PARAM" ("FLAG1" "FLAG2"))) (("~" "/")) (("#shared/" "/")("#public/" "/")("#ftp/" "/")("#news." "."))`,
+			"Namespaces", &Namespaces{
+				Personal: []Namespace{{"", "/"}, {"#mh/", "/"}},
+				Other:    []Namespace{{"~", "/"}},
+				Shared:   []Namespace{{"#shared/", "/"}, {"#public/", "/"}, {"#ftp/", "/"}, {"#news.", "."}}}},
+		{`* NAMESPACE (("INBOX." NIL)) NIL (("&ZeVnLIqe-/" "/")) EXTRA`,
+			"Namespaces", &Namespaces{
+				Personal: []Namespace{{"INBOX.", ""}},
+				Shared:   []Namespace{{"\u65E5\u672C\u8A9E/", "/"}}}},
+
+		// APPENDUID -> (uint32, uint32)
+		{`* NOT APPENDUID`,
+			"AppendUID", []interface{}{
+				uint32(0), uint32(0)}},
+		{`A003 OK [APPENDUID 38505 3955] APPEND completed`,
+			"AppendUID", []interface{}{
+				uint32(38505), uint32(3955)}},
+		{`A003 OK [APPENDUID 38505] APPEND completed`,
+			"AppendUID", []interface{}{
+				uint32(0), uint32(0)}},
+
+		// COPYUID -> (uint32, *SeqSet, *SeqSet)
+		{`* NOT COPYUID`,
+			"CopyUID", []interface{}{
+				uint32(0), (*SeqSet)(nil), (*SeqSet)(nil)}},
+		{`* OK [COPYUID 38505 304 3956] Done`,
+			"CopyUID", []interface{}{
+				uint32(38505), newSeqSet("304"), newSeqSet("3956")}},
+		{`A1 OK [COPYUID 38505 304,319:320 3956:3958] Done`,
+			"CopyUID", []interface{}{
+				uint32(38505), newSeqSet("304,319:320"), newSeqSet("3956:3958")}},
 	}
 	c, s := newTestConn(1024)
 	C := newTransport(c, nil)
@@ -241,3 +353,56 @@ func TestResponseDecoders(t *testing.T) {
 		}
 	}
 }
+
+func TestMessageInfoAccessors(t *testing.T) {
+	c, s := newTestConn(1024)
+	C := newTransport(c, nil)
+	r := newReader(C, MemoryReader{}, "A")
+
+	s.Write([]byte(`* 12 FETCH (UID 100 ` +
+		`ENVELOPE ("Wed, 17 Jul 1996 02:23:25 -0700 (PDT)" "IMAP4rev1 WG mtg summary and minutes" ` +
+		`(("Terry Gray" NIL "gray" "cac.washington.edu")) NIL NIL NIL NIL NIL NIL "<B27397-0100000@cac.washington.edu>") ` +
+		`BODYSTRUCTURE ("TEXT" "PLAIN" ("CHARSET" "US-ASCII") NIL NIL "7BIT" 3028 92) ` +
+		`BODY[TEXT]<100> "later" BODY[TEXT]<0> "first" RFC822.HEADER {10}` + CRLF +
+		`Subject:` + CRLF + ` X-NEW "value")` + CRLF))
+	raw, err := r.Next()
+	if err != nil {
+		t.Fatalf("Next() unexpected error; %v", err)
+	}
+	rsp, err := raw.Parse()
+	if err != nil {
+		t.Fatalf("Parse() unexpected error; %v", err)
+	}
+	info := rsp.MessageInfo()
+	env := info.Envelope()
+	if env == nil || env.Subject != "IMAP4rev1 WG mtg summary and minutes" ||
+		len(env.From) != 1 || env.From[0].Mailbox != "gray" {
+		t.Errorf("Envelope() unexpected result %+v", env)
+	} else if info.Envelope() != env {
+		t.Errorf("Envelope() result not cached")
+	}
+	body := info.BodyStructure()
+	if body == nil || body.MIMEType != "TEXT" || body.Size != 3028 {
+		t.Errorf("BodyStructure() unexpected result %+v", body)
+	} else if info.BodyStructure() != body {
+		t.Errorf("BodyStructure() result not cached")
+	}
+	bodyTests := []struct {
+		section string
+		out     string
+	}{
+		{"TEXT", "first"},
+		{"text", "first"},
+		{"HEADER", "Subject:\r\n"},
+		{"", ""},
+		{"1", ""},
+	}
+	for _, test := range bodyTests {
+		if v := string(info.Body(test.section)); v != test.out {
+			t.Errorf("Body(%q) expected %q; got %q", test.section, test.out, v)
+		}
+	}
+	if v := AsString(info.Attrs["X-NEW"]); v != "value" {
+		t.Errorf(`Attrs["X-NEW"] expected "value"; got %q`, v)
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultRetryCodes are the response codes that indicate a transient failure
+// (RFC 5530). INUSE means that an operation could not be performed because a
+// resource (e.g. a mailbox) is in use by another process. UNAVAILABLE means
+// that a subsystem required by the operation is temporarily unavailable.
+var DefaultRetryCodes = []string{"INUSE", "UNAVAILABLE"}
+
+// RetryPolicy controls the automatic retry of commands that fail with a NO
+// response containing one of the specified response codes. The policy is
+// applied by Command.Result (and therefore by all synchronous commands) when it
+// receives the completion response. The command is sent again with a new tag
+// after a delay, which starts at Backoff and is doubled for each subsequent
+// attempt up to MaxBackoff. The responses from the failed attempts are
+// discarded. If all attempts fail, the last completion response is returned.
+//
+// Commands with literals that cannot be sent more than once (e.g. those created
+// by Client.AppendReader) are never retried.
+type RetryPolicy struct {
+	Codes       []string      // Response codes (in upper case) that trigger a retry
+	MaxAttempts int           // Maximum number of times a command is sent
+	Backoff     time.Duration // Delay before the first retry
+	MaxBackoff  time.Duration // Maximum delay between retries (0 = no limit)
+}
+
+// NewRetryPolicy returns a RetryPolicy that retries commands failing with one of
+// the DefaultRetryCodes. The command is sent at most maxAttempts times. More
+// codes may be added to the Codes field of the returned policy.
+func NewRetryPolicy(maxAttempts int, backoff time.Duration) *RetryPolicy {
+	return &RetryPolicy{
+		Codes:       append([]string(nil), DefaultRetryCodes...),
+		MaxAttempts: maxAttempts,
+		Backoff:     backoff,
+	}
+}
+
+// Retry returns true if the command completion response rsp indicates a
+// transient failure according to the policy.
+func (p *RetryPolicy) Retry(rsp *Response) bool {
+	if rsp == nil || rsp.Type != Done || rsp.Status != NO || rsp.Label == "" {
+		return false
+	}
+	for _, code := range p.Codes {
+		if rsp.Label == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Delay returns the delay before the n-th retry (n >= 1).
+func (p *RetryPolicy) Delay(n int) time.Duration {
+	d := p.Backoff
+	for ; n > 1 && (p.MaxBackoff <= 0 || d < p.MaxBackoff); n-- {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return d
+}
+
+// canRetry returns true if cmd is completed and should be sent again according
+// to c.RetryPolicy.
+func (c *Client) canRetry(cmd *Command) bool {
+	p := c.RetryPolicy
+	return p != nil && cmd.replay && cmd.result != nil && cmd.result != abort &&
+		cmd.retries+1 < p.MaxAttempts && p.Retry(cmd.result)
+}
+
+// retry waits for the backoff delay and sends cmd again with a new tag. The
+// command is aborted if it cannot be sent.
+func (c *Client) retry(ctx context.Context, cmd *Command) error {
+	cmd.retries++
+	delay := c.RetryPolicy.Delay(cmd.retries)
+	c.Logf(LogCmd, "Retrying %s in %v (%s)", cmd.tag, delay, cmd.result.Label)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return c.reissue(cmd, cmd.fields)
+}
+
+// reissue discards the responses of the previous attempt and sends cmd again
+// with a new tag and the specified fields. The command is aborted if it cannot
+// be sent.
+func (c *Client) reissue(cmd *Command, fields []Field) error {
+	c.mu.Lock()
+	defer c.unlock()
+	cmd.Data, cmd.result, cmd.fields = nil, nil, fields
+	err := c.issue(cmd, fields)
+	if err != nil && cmd.result == nil {
+		cmd.result = abort
+	}
+	return err
+}
+
+// canFallback returns true if cmd is completed with a BADCHARSET response code,
+// and it should be sent again using another charset according to
+// c.SearchCharsetFallback. The fallback is attempted at most once.
+func (c *Client) canFallback(cmd *Command) bool {
+	rsp := cmd.result
+	return c.SearchCharsetFallback && !cmd.fallback && cmd.replay &&
+		rsp != nil && rsp != abort && rsp.Status == NO && rsp.Label == "BADCHARSET"
+}
+
+// fallback transcodes the search criteria of cmd to the first charset listed in
+// the BADCHARSET response code that can represent them, and sends the command
+// again. An error is returned if no such charset is found.
+func (c *Client) fallback(cmd *Command) error {
+	cmd.fallback = true
+	charsets := cmd.result.BadCharset()
+	for _, cs := range charsets {
+		if strings.EqualFold(cs, "UTF-8") {
+			continue
+		}
+		if fields, err := transcodeSearch(cmd.fields, cs); err == nil {
+			c.Logf(LogCmd, "Retrying %s with CHARSET %s", cmd.tag, cs)
+			return c.reissue(cmd, fields)
+		}
+	}
+	return fmt.Errorf("imap: search criteria cannot be represented in "+
+		"the charsets supported by the server (%s)", strings.Join(charsets, " "))
+}
+
+// transcodeSearch returns a copy of SEARCH command fields with the CHARSET
+// argument replaced by charset, and all quoted strings and literals converted
+// from UTF-8 to that charset.
+func transcodeSearch(fields []Field, charset string) ([]Field, error) {
+	out, err := transcodeFields(fields, charset)
+	if err == nil {
+		for i := 0; i < len(out)-1; i++ {
+			if s, ok := out[i].(string); ok && toUpper(s) == "CHARSET" {
+				out[i+1] = charset
+				break
+			}
+		}
+	}
+	return out, err
+}
+
+// transcodeFields converts quoted strings and literals in fields from UTF-8 to
+// the specified charset.
+func transcodeFields(fields []Field, charset string) ([]Field, error) {
+	out := make([]Field, len(fields))
+	for i, f := range fields {
+		var b []byte
+		switch v := f.(type) {
+		case string:
+			if !Quoted(v) {
+				out[i] = v
+				continue
+			}
+			s, _ := Unquote(v)
+			b = []byte(s)
+		case *literal:
+			b = v.data
+		case []Field:
+			list, err := transcodeFields(v, charset)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = list
+			continue
+		default:
+			out[i] = f
+			continue
+		}
+		b, err := encodeCharset(charset, b)
+		if err != nil {
+			return nil, err
+		}
+		if q := QuoteBytes(b, false); q != nil {
+			out[i] = string(q)
+		} else {
+			out[i] = NewLiteral(b)
+		}
+	}
+	return out, nil
+}
+
+// replayable returns true if all literals in fields can be sent more than once.
+func replayable(fields []Field) bool {
+	for _, f := range fields {
+		switch v := f.(type) {
+		case []Field:
+			if !replayable(v) {
+				return false
+			}
+		case Literal:
+			if _, ok := v.(*literal); !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
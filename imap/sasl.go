@@ -4,7 +4,20 @@
 
 package imap
 
-import "errors"
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+)
 
 // Note:
 //   Most of this code was copied, with some modifications, from net/smtp. It
@@ -37,6 +50,13 @@ type SASL interface {
 	Next(challenge []byte) (response []byte, err error)
 }
 
+// saslFinisher is implemented by mechanisms that authenticate the server.
+// Client.Auth calls finish after the server accepts the login, and fails if the
+// mechanism has not yet verified the server.
+type saslFinisher interface {
+	finish() error
+}
+
 type externalAuth []byte
 
 // ExternalAuth returns an implementation of the EXTERNAL authentication
@@ -59,20 +79,215 @@ type plainAuth []byte
 
 // PlainAuth returns an implementation of the PLAIN authentication mechanism, as
 // described in RFC 4616. Authorization identity may be left blank to indicate
-// that it is the same as the username.
+// that it is the same as the username. The password is sent in the clear, so
+// Client.Auth refuses to use this mechanism over an unencrypted connection
+// unless Client.AllowCleartextLogin is set.
 func PlainAuth(username, password, identity string) SASL {
 	return plainAuth(identity + "\x00" + username + "\x00" + password)
 }
 
 func (a plainAuth) Start(s *ServerInfo) (mech string, ir []byte, err error) {
+	return "PLAIN", a, nil
+}
+
+func (a plainAuth) Next(challenge []byte) (response []byte, err error) {
+	return nil, errors.New("unexpected server challenge")
+}
+
+type xoauth2Auth []byte
+
+// XOAuth2 returns an implementation of the XOAUTH2 authentication mechanism
+// used by Gmail and Outlook.com for OAuth 2.0 bearer token authentication. If
+// the token is rejected, the server sends a Base64-encoded JSON error message as
+// a challenge, to which the client replies with an empty response. The command
+// then completes with the NO status, leaving the client in the Login state.
+func XOAuth2(username, token string) SASL {
+	return xoauth2Auth("user=" + username + "\x01auth=Bearer " + token + "\x01\x01")
+}
+
+func (a xoauth2Auth) Start(s *ServerInfo) (mech string, ir []byte, err error) {
 	if !s.TLS {
-		err = NotAvailableError("AUTH=PLAIN")
+		err = NotAvailableError("AUTH=XOAUTH2")
 	} else {
-		mech, ir = "PLAIN", a
+		mech, ir = "XOAUTH2", a
 	}
 	return
 }
 
-func (a plainAuth) Next(challenge []byte) (response []byte, err error) {
+func (a xoauth2Auth) Next(challenge []byte) (response []byte, err error) {
+	return []byte{}, nil
+}
+
+type cramMD5Auth struct {
+	username, secret string
+	done             bool
+}
+
+// CRAMMD5 returns an implementation of the CRAM-MD5 authentication mechanism,
+// as described in RFC 2195. The server sends a challenge, to which the client
+// replies with the username and the HMAC-MD5 digest of the challenge keyed by
+// the secret. The secret itself is never sent to the server.
+func CRAMMD5(username, secret string) SASL {
+	return &cramMD5Auth{username: username, secret: secret}
+}
+
+func (a *cramMD5Auth) Start(s *ServerInfo) (mech string, ir []byte, err error) {
+	a.done = false
+	return "CRAM-MD5", nil, nil
+}
+
+func (a *cramMD5Auth) Next(challenge []byte) (response []byte, err error) {
+	if a.done {
+		return nil, errors.New("unexpected server challenge")
+	} else if len(challenge) == 0 {
+		return nil, errors.New("invalid CRAM-MD5 challenge")
+	}
+	a.done = true
+	h := hmac.New(md5.New, []byte(a.secret))
+	h.Write(challenge)
+	response = make([]byte, 0, len(a.username)+1+hex.EncodedLen(h.Size()))
+	response = append(append(response, a.username...), ' ')
+	return append(response, hex.EncodeToString(h.Sum(nil))...), nil
+}
+
+// ErrScramSignature is returned by Client.Auth when the server signature in
+// the final SCRAM message does not match the one computed by the client, or
+// when the server completes authentication without sending it. Either way, the
+// server has not proven that it knows the user's password.
+var ErrScramSignature = errors.New("imap: SCRAM server signature mismatch")
+
+// scramNonce returns a new random client nonce. It is replaced by the tests.
+var scramNonce = func() string {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+type scramAuth struct {
+	mech       string
+	h          func() hash.Hash
+	user, pass string
+	nonce      string // Client nonce
+	bare       string // client-first-message-bare
+	sig        []byte // Expected server signature
+	step       int
+	verified   bool // Server signature was checked
+}
+
+// ScramSHA1 returns an implementation of the SCRAM-SHA-1 authentication
+// mechanism, as described in RFC 5802. The password is never sent to the
+// server, and the server must prove that it knows the password as well. If the
+// server signature does not match or is never sent, Client.Auth fails with
+// ErrScramSignature. If the server accepted the login anyway, the connection is
+// closed.
+// Channel binding is not supported. The password is used as-is, without
+// SASLprep normalization.
+func ScramSHA1(username, password string) SASL {
+	return &scramAuth{mech: "SCRAM-SHA-1", h: sha1.New, user: username, pass: password}
+}
+
+// ScramSHA256 returns an implementation of the SCRAM-SHA-256 authentication
+// mechanism, as described in RFC 7677. See ScramSHA1 for more information.
+func ScramSHA256(username, password string) SASL {
+	return &scramAuth{mech: "SCRAM-SHA-256", h: sha256.New, user: username, pass: password}
+}
+
+func (a *scramAuth) Start(s *ServerInfo) (mech string, ir []byte, err error) {
+	user := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(a.user)
+	a.nonce = scramNonce()
+	a.bare = "n=" + user + ",r=" + a.nonce
+	a.sig, a.step, a.verified = nil, 0, false
+	return a.mech, []byte("n,," + a.bare), nil
+}
+
+func (a *scramAuth) Next(challenge []byte) (response []byte, err error) {
+	a.step++
+	switch a.step {
+	case 1:
+		return a.clientFinal(challenge)
+	case 2:
+		attr := scramAttrs(challenge)
+		if e, ok := attr["e"]; ok {
+			return nil, errors.New("imap: SCRAM server error: " + e)
+		}
+		sig, err := base64.StdEncoding.DecodeString(attr["v"])
+		if err != nil || !hmac.Equal(sig, a.sig) {
+			return nil, ErrScramSignature
+		}
+		a.verified = true
+		return []byte{}, nil
+	}
 	return nil, errors.New("unexpected server challenge")
 }
+
+func (a *scramAuth) finish() error {
+	if !a.verified {
+		return ErrScramSignature
+	}
+	return nil
+}
+
+// clientFinal verifies server-first-message and returns client-final-message
+// containing the client proof.
+func (a *scramAuth) clientFinal(challenge []byte) ([]byte, error) {
+	attr := scramAttrs(challenge)
+	nonce := attr["r"]
+	salt, err := base64.StdEncoding.DecodeString(attr["s"])
+	iter, _ := strconv.Atoi(attr["i"])
+	if _, ok := attr["m"]; ok || err != nil || len(salt) == 0 || iter <= 0 ||
+		len(nonce) <= len(a.nonce) || !strings.HasPrefix(nonce, a.nonce) {
+		return nil, errors.New("invalid " + a.mech + " challenge")
+	}
+	final := "c=biws,r=" + nonce // "biws" is base64("n,,")
+	msg := a.bare + "," + string(challenge) + "," + final
+
+	salted := scramHi(a.h, []byte(a.pass), salt, iter)
+	clientKey := scramHMAC(a.h, salted, "Client Key")
+	h := a.h()
+	h.Write(clientKey)
+	proof := scramHMAC(a.h, h.Sum(nil), msg)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	a.sig = scramHMAC(a.h, scramHMAC(a.h, salted, "Server Key"), msg)
+	return []byte(final + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+// scramAttrs parses a comma-separated list of SCRAM attribute-value pairs.
+func scramAttrs(msg []byte) map[string]string {
+	attr := make(map[string]string)
+	for _, kv := range bytes.Split(msg, []byte(",")) {
+		if len(kv) >= 2 && kv[1] == '=' {
+			attr[string(kv[:1])] = string(kv[2:])
+		}
+	}
+	return attr
+}
+
+// scramHMAC returns HMAC(key, msg) using hash function h.
+func scramHMAC(h func() hash.Hash, key []byte, msg string) []byte {
+	m := hmac.New(h, key)
+	m.Write([]byte(msg))
+	return m.Sum(nil)
+}
+
+// scramHi implements the Hi function from RFC 5802, which is PBKDF2 with
+// HMAC as the pseudorandom function and an output length of one hash block.
+func scramHi(h func() hash.Hash, pass, salt []byte, iter int) []byte {
+	m := hmac.New(h, pass)
+	m.Write(salt)
+	m.Write([]byte{0, 0, 0, 1})
+	u := m.Sum(nil)
+	out := append([]byte(nil), u...)
+	for i := 1; i < iter; i++ {
+		m.Reset()
+		m.Write(u)
+		u = m.Sum(u[:0])
+		for j := range out {
+			out[j] ^= u[j]
+		}
+	}
+	return out
+}
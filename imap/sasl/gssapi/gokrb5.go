@@ -0,0 +1,77 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build gokrb5
+// +build gokrb5
+
+package gssapi
+
+import (
+	"errors"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// Gokrb5 returns a Provider that obtains service tickets using the Kerberos
+// client cl, which must already be logged in. Mutual authentication is not
+// requested, so the server's identity is not verified by Kerberos. Usage:
+//
+//	gssapi.Register(gssapi.Gokrb5(cl))
+//	_, err := c.Auth(gssapi.GSSAPI("imap", ""))
+func Gokrb5(cl *client.Client) Provider {
+	return func(service, host string) (Context, error) {
+		tkt, key, err := cl.GetServiceTicket(service + "/" + host)
+		if err != nil {
+			return nil, err
+		}
+		flags := []int{gssapi.ContextFlagInteg}
+		tok, err := spnego.NewKRB5TokenAPREQ(cl, tkt, key, flags, nil)
+		if err != nil {
+			return nil, err
+		}
+		b, err := tok.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		return &krb5Context{token: b, key: key}, nil
+	}
+}
+
+// krb5Context is a Kerberos V5 security context established with a single
+// AP-REQ token.
+type krb5Context struct {
+	token []byte
+	key   types.EncryptionKey
+}
+
+func (c *krb5Context) Init(input []byte) (output []byte, done bool, err error) {
+	if input != nil {
+		return nil, false, errors.New("gssapi: unexpected context token")
+	}
+	return c.token, true, nil
+}
+
+func (c *krb5Context) Wrap(msg []byte) ([]byte, error) {
+	wt, err := gssapi.NewInitiatorWrapToken(msg, c.key)
+	if err != nil {
+		return nil, err
+	}
+	return wt.Marshal()
+}
+
+func (c *krb5Context) Unwrap(token []byte) ([]byte, error) {
+	var wt gssapi.WrapToken
+	if err := wt.Unmarshal(token, true); err != nil {
+		return nil, err
+	} else if wt.Flags&0x02 != 0 {
+		return nil, errors.New("gssapi: sealed messages are not supported")
+	} else if ok, err := wt.Verify(c.key, keyusage.GSSAPI_ACCEPTOR_SEAL); !ok {
+		return nil, err
+	}
+	return wt.Payload, nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package gssapi implements the GSSAPI (Kerberos V5) SASL authentication
+mechanism, as described in RFC 4752, for use with imap.Client.Auth.
+
+The package does not implement Kerberos itself. The security context is created
+by a provider, which is an implementation of the Context interface. A provider
+based on github.com/jcmturner/gokrb5 is included in this package, but it is
+only compiled when the "gokrb5" build tag is specified, so that programs that
+do not need Kerberos authentication do not depend on that library. Other
+providers (e.g. SSPI on Windows) may be installed with Register.
+
+Only the authentication step is supported. The client always selects the "no
+security layer" option during the final negotiation, so the connection is not
+protected by GSSAPI integrity or confidentiality services after authentication.
+Use TLS for that purpose.
+*/
+package gssapi
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/mxk/go-imap/imap"
+)
+
+// Context is the interface to the GSSAPI security context established with the
+// server.
+type Context interface {
+	// Init continues security context establishment, as defined by
+	// GSS_Init_sec_context. The input token is nil on the first call. The output
+	// token, if any, must be sent to the server. Done is true once the context
+	// is established.
+	Init(input []byte) (output []byte, done bool, err error)
+
+	// Wrap and Unwrap protect and verify messages exchanged after the context
+	// is established, as defined by GSS_Wrap and GSS_Unwrap. Confidentiality is
+	// not required.
+	Wrap(msg []byte) ([]byte, error)
+	Unwrap(token []byte) ([]byte, error)
+}
+
+// Provider returns a new security context for the specified service on host
+// (e.g. "imap" and "mail.example.com").
+type Provider func(service, host string) (Context, error)
+
+// ErrNoProvider is returned when GSSAPI authentication is attempted without a
+// registered Provider.
+var ErrNoProvider = errors.New("gssapi: no security context provider")
+
+var provider struct {
+	sync.Mutex
+	p Provider
+}
+
+// Register installs the Provider used by GSSAPI, replacing the previous one.
+func Register(p Provider) {
+	provider.Lock()
+	defer provider.Unlock()
+	provider.p = p
+}
+
+// noSecurityLayer is the security layer bit mask value for "no security layer"
+// (RFC 4752 section 3.3).
+const noSecurityLayer = 1
+
+type gssapiAuth struct {
+	service string
+	host    string
+	authzid string
+	ctx     Context
+	init    bool // Create a new context for each authentication attempt
+	done    bool // Security context established
+	layer   bool // Security layer negotiated
+}
+
+// GSSAPI returns an implementation of the GSSAPI authentication mechanism that
+// uses the registered Provider to create a security context for the specified
+// service (normally "imap") on host. If host is blank, the server name passed
+// to imap.NewClient is used.
+func GSSAPI(service, host string) imap.SASL {
+	return &gssapiAuth{service: service, host: host, init: true}
+}
+
+// New returns an implementation of the GSSAPI authentication mechanism that
+// uses an existing security context. Authorization identity may be left blank
+// to indicate that it is derived from the authentication credentials. The
+// context cannot be reused for another authentication attempt.
+func New(ctx Context, authzid string) imap.SASL {
+	return &gssapiAuth{authzid: authzid, ctx: ctx}
+}
+
+func (a *gssapiAuth) Start(s *imap.ServerInfo) (mech string, ir []byte, err error) {
+	if a.init {
+		provider.Lock()
+		p := provider.p
+		provider.Unlock()
+		if p == nil {
+			return "", nil, ErrNoProvider
+		}
+		host := a.host
+		if host == "" {
+			host = s.Name
+		}
+		if a.ctx, err = p(a.service, host); err != nil {
+			return
+		}
+	}
+	a.done, a.layer = false, false
+	if ir, a.done, err = a.ctx.Init(nil); err == nil {
+		mech = "GSSAPI"
+		if ir == nil {
+			ir = []byte{}
+		}
+	}
+	return
+}
+
+func (a *gssapiAuth) Next(challenge []byte) (response []byte, err error) {
+	if !a.done {
+		response, a.done, err = a.ctx.Init(challenge)
+		return
+	} else if a.layer {
+		return nil, errors.New("gssapi: unexpected server challenge")
+	} else if len(challenge) == 0 {
+		// Empty challenge sent by some servers after context establishment
+		return []byte{}, nil
+	}
+
+	// Security layer negotiation. The server sends a bit mask of supported
+	// layers followed by the maximum message size. The client must reply with
+	// the selected layer, its own maximum size, and the authorization identity.
+	msg, err := a.ctx.Unwrap(challenge)
+	if err != nil {
+		return
+	} else if len(msg) != 4 {
+		return nil, errors.New("gssapi: bad security layer message")
+	} else if msg[0]&noSecurityLayer == 0 {
+		return nil, errors.New("gssapi: server requires a security layer")
+	}
+	a.layer = true
+	return a.ctx.Wrap(append([]byte{noSecurityLayer, 0, 0, 0}, a.authzid...))
+}
@@ -0,0 +1,92 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gssapi
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/mxk/go-imap/imap"
+)
+
+// testContext establishes the context after two tokens and "wraps" messages by
+// adding a prefix.
+type testContext struct {
+	spn  string
+	step int
+}
+
+func (c *testContext) Init(input []byte) (output []byte, done bool, err error) {
+	switch c.step++; c.step {
+	case 1:
+		return []byte("token1"), false, nil
+	case 2:
+		if string(input) != "reply1" {
+			return nil, false, errors.New("bad input")
+		}
+		return []byte("token2"), true, nil
+	}
+	return nil, false, errors.New("context established")
+}
+
+func (c *testContext) Wrap(msg []byte) ([]byte, error) {
+	return append([]byte("W:"), msg...), nil
+}
+
+func (c *testContext) Unwrap(token []byte) ([]byte, error) {
+	if !bytes.HasPrefix(token, []byte("W:")) {
+		return nil, errors.New("bad token")
+	}
+	return token[2:], nil
+}
+
+func TestGSSAPI(t *testing.T) {
+	var ctx *testContext
+	Register(nil)
+	a := GSSAPI("imap", "")
+	if _, _, err := a.Start(&imap.ServerInfo{Name: "mail.example.com"}); err != ErrNoProvider {
+		t.Fatalf("a.Start() expected ErrNoProvider; got %v", err)
+	}
+	Register(func(service, host string) (Context, error) {
+		ctx = &testContext{spn: service + "/" + host}
+		return ctx, nil
+	})
+	defer Register(nil)
+
+	mech, ir, err := a.Start(&imap.ServerInfo{Name: "mail.example.com"})
+	if mech != "GSSAPI" || string(ir) != "token1" || err != nil {
+		t.Fatalf("a.Start() expected GSSAPI token1; got %q %q (%v)", mech, ir, err)
+	} else if ctx.spn != "imap/mail.example.com" {
+		t.Fatalf("ctx.spn expected imap/mail.example.com; got %q", ctx.spn)
+	}
+	tests := []struct {
+		challenge string
+		response  string
+		ok        bool
+	}{
+		{"reply1", "token2", true},
+		{"", "", true},
+		{"W:\x06\x00\x10\x00", "", false}, // Security layer required
+		{"W:\x07\x00\x10\x00", "W:\x01\x00\x00\x00", true},
+		{"W:\x07\x00\x10\x00", "", false},
+	}
+	for _, test := range tests {
+		rsp, err := a.Next([]byte(test.challenge))
+		if string(rsp) != test.response || (err == nil) != test.ok {
+			t.Errorf("a.Next(%q) expected %q; got %q (%v)", test.challenge, test.response, rsp, err)
+		}
+	}
+
+	// Existing context with authorization identity
+	a = New(&testContext{}, "admin")
+	if _, ir, err := a.Start(&imap.ServerInfo{}); string(ir) != "token1" || err != nil {
+		t.Fatalf("a.Start() expected token1; got %q (%v)", ir, err)
+	}
+	a.Next([]byte("reply1"))
+	if rsp, err := a.Next([]byte("W:\x01\x00\x00\x00")); string(rsp) != "W:\x01\x00\x00\x00admin" || err != nil {
+		t.Errorf("a.Next() expected authzid; got %q (%v)", rsp, err)
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package imap
+
+import "time"
+
+// SEARCHDATE is the date format used by SEARCH keys such as BEFORE and SINCE
+// (RFC 3501 date).
+const SEARCHDATE = "02-Jan-2006"
+
+// SearchCriteria builds the list of search keys for the SEARCH command. String
+// values are quoted or converted to literals, as needed, and dates are encoded
+// using SEARCHDATE format. All methods return the receiver to allow chaining.
+// The zero value is an empty criteria list, which matches all messages.
+//
+// Client.Search and Client.UIDSearch always specify the UTF-8 charset, so
+// non-ASCII values are sent as UTF-8 literal strings. Example:
+//
+//	var s imap.SearchCriteria
+//	s.Unseen().From("alice@example.com").Since(time.Now().AddDate(0, 0, -7))
+//	cmd, err := imap.Wait(c.Search(s.Build()...))
+type SearchCriteria struct {
+	keys []Field
+}
+
+// Build returns the search keys for use with Client.Search. The "ALL" key is
+// returned if the criteria list is empty.
+func (s *SearchCriteria) Build() []Field {
+	if len(s.keys) == 0 {
+		return []Field{"ALL"}
+	}
+	return append([]Field(nil), s.keys...)
+}
+
+// All matches all messages in the mailbox.
+func (s *SearchCriteria) All() *SearchCriteria { return s.add("ALL") }
+
+// Answered matches messages with the \Answered flag set.
+func (s *SearchCriteria) Answered() *SearchCriteria { return s.add("ANSWERED") }
+
+// Deleted matches messages with the \Deleted flag set.
+func (s *SearchCriteria) Deleted() *SearchCriteria { return s.add("DELETED") }
+
+// Draft matches messages with the \Draft flag set.
+func (s *SearchCriteria) Draft() *SearchCriteria { return s.add("DRAFT") }
+
+// Flagged matches messages with the \Flagged flag set.
+func (s *SearchCriteria) Flagged() *SearchCriteria { return s.add("FLAGGED") }
+
+// New matches messages that have the \Recent flag set but not the \Seen flag.
+func (s *SearchCriteria) New() *SearchCriteria { return s.add("NEW") }
+
+// Old matches messages that do not have the \Recent flag set.
+func (s *SearchCriteria) Old() *SearchCriteria { return s.add("OLD") }
+
+// Recent matches messages that have the \Recent flag set.
+func (s *SearchCriteria) Recent() *SearchCriteria { return s.add("RECENT") }
+
+// Seen matches messages that have the \Seen flag set.
+func (s *SearchCriteria) Seen() *SearchCriteria { return s.add("SEEN") }
+
+// Unanswered matches messages that do not have the \Answered flag set.
+func (s *SearchCriteria) Unanswered() *SearchCriteria { return s.add("UNANSWERED") }
+
+// Undeleted matches messages that do not have the \Deleted flag set.
+func (s *SearchCriteria) Undeleted() *SearchCriteria { return s.add("UNDELETED") }
+
+// Undraft matches messages that do not have the \Draft flag set.
+func (s *SearchCriteria) Undraft() *SearchCriteria { return s.add("UNDRAFT") }
+
+// Unflagged matches messages that do not have the \Flagged flag set.
+func (s *SearchCriteria) Unflagged() *SearchCriteria { return s.add("UNFLAGGED") }
+
+// Unseen matches messages that do not have the \Seen flag set.
+func (s *SearchCriteria) Unseen() *SearchCriteria { return s.add("UNSEEN") }
+
+// Keyword matches messages with the specified keyword flag set.
+func (s *SearchCriteria) Keyword(flag string) *SearchCriteria {
+	return s.add("KEYWORD", flag)
+}
+
+// Unkeyword matches messages that do not have the specified keyword flag set.
+func (s *SearchCriteria) Unkeyword(flag string) *SearchCriteria {
+	return s.add("UNKEYWORD", flag)
+}
+
+// Bcc matches messages that contain addr in the BCC header field.
+func (s *SearchCriteria) Bcc(addr string) *SearchCriteria {
+	return s.add("BCC", searchString(addr))
+}
+
+// Cc matches messages that contain addr in the CC header field.
+func (s *SearchCriteria) Cc(addr string) *SearchCriteria {
+	return s.add("CC", searchString(addr))
+}
+
+// From matches messages that contain addr in the FROM header field.
+func (s *SearchCriteria) From(addr string) *SearchCriteria {
+	return s.add("FROM", searchString(addr))
+}
+
+// To matches messages that contain addr in the TO header field.
+func (s *SearchCriteria) To(addr string) *SearchCriteria {
+	return s.add("TO", searchString(addr))
+}
+
+// Subject matches messages that contain text in the SUBJECT header field.
+func (s *SearchCriteria) Subject(text string) *SearchCriteria {
+	return s.add("SUBJECT", searchString(text))
+}
+
+// Body matches messages that contain text in the body of the message.
+func (s *SearchCriteria) Body(text string) *SearchCriteria {
+	return s.add("BODY", searchString(text))
+}
+
+// Text matches messages that contain text in the header or body of the
+// message.
+func (s *SearchCriteria) Text(text string) *SearchCriteria {
+	return s.add("TEXT", searchString(text))
+}
+
+// Header matches messages that have a header with the specified field name and
+// that contain value in the field body. An empty value matches all messages
+// that have the header field.
+func (s *SearchCriteria) Header(field, value string) *SearchCriteria {
+	return s.add("HEADER", searchString(field), searchString(value))
+}
+
+// GmailRaw matches messages using the Gmail web interface search syntax (e.g.
+// "has:attachment larger:5M"). Non-ASCII queries are sent as UTF-8 literals.
+// The server must advertise X-GM-EXT-1 capability.
+func (s *SearchCriteria) GmailRaw(query string) *SearchCriteria {
+	return s.add("X-GM-RAW", searchString(query))
+}
+
+// Before matches messages with an internal date earlier than t.
+func (s *SearchCriteria) Before(t time.Time) *SearchCriteria {
+	return s.add("BEFORE", FormatDate(t))
+}
+
+// On matches messages with an internal date within the day of t.
+func (s *SearchCriteria) On(t time.Time) *SearchCriteria {
+	return s.add("ON", FormatDate(t))
+}
+
+// Since matches messages with an internal date within or later than the day of
+// t.
+func (s *SearchCriteria) Since(t time.Time) *SearchCriteria {
+	return s.add("SINCE", FormatDate(t))
+}
+
+// SentBefore matches messages with a Date header earlier than t.
+func (s *SearchCriteria) SentBefore(t time.Time) *SearchCriteria {
+	return s.add("SENTBEFORE", FormatDate(t))
+}
+
+// SentOn matches messages with a Date header within the day of t.
+func (s *SearchCriteria) SentOn(t time.Time) *SearchCriteria {
+	return s.add("SENTON", FormatDate(t))
+}
+
+// SentSince matches messages with a Date header within or later than the day of
+// t.
+func (s *SearchCriteria) SentSince(t time.Time) *SearchCriteria {
+	return s.add("SENTSINCE", FormatDate(t))
+}
+
+// Larger matches messages with an RFC 822 size larger than n octets.
+func (s *SearchCriteria) Larger(n uint32) *SearchCriteria {
+	return s.add("LARGER", n)
+}
+
+// Smaller matches messages with an RFC 822 size smaller than n octets.
+func (s *SearchCriteria) Smaller(n uint32) *SearchCriteria {
+	return s.add("SMALLER", n)
+}
+
+// Seq matches messages with sequence numbers in the specified set.
+func (s *SearchCriteria) Seq(seq *SeqSet) *SearchCriteria {
+	return s.add(seq)
+}
+
+// UID matches messages with unique identifiers in the specified set.
+func (s *SearchCriteria) UID(uids *SeqSet) *SearchCriteria {
+	return s.add("UID", uids)
+}
+
+// Not matches messages that do not match all of the keys in c.
+func (s *SearchCriteria) Not(c *SearchCriteria) *SearchCriteria {
+	return s.add("NOT", c.key())
+}
+
+// Or matches messages that match all of the keys in either a or b.
+func (s *SearchCriteria) Or(a, b *SearchCriteria) *SearchCriteria {
+	return s.add("OR", a.key(), b.key())
+}
+
+// add appends new search keys to the criteria list.
+func (s *SearchCriteria) add(keys ...Field) *SearchCriteria {
+	s.keys = append(s.keys, keys...)
+	return s
+}
+
+// key returns the criteria list as a single search key, which is a
+// parenthesized list if there are multiple keys.
+func (s *SearchCriteria) key() Field {
+	if len(s.keys) == 1 {
+		return s.keys[0]
+	}
+	return s.Build()
+}
+
+// searchString returns v as a quoted string or a literal if v contains
+// non-ASCII or other characters that cannot be quoted.
+func searchString(v string) Field {
+	if q := Quote(v, false); q != "" {
+		return q
+	}
+	return NewLiteral([]byte(v))
+}
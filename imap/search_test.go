@@ -0,0 +1,48 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSearchCriteria(t *testing.T) {
+	date := time.Date(2013, time.February, 3, 23, 59, 59, 0, time.UTC)
+	uids, _ := NewSeqSet("1:100,200:*")
+	not := func(c *SearchCriteria) *SearchCriteria { return new(SearchCriteria).Not(c) }
+	tests := []struct {
+		in  *SearchCriteria
+		out string
+	}{
+		{new(SearchCriteria), `ALL`},
+		{new(SearchCriteria).Flagged().Unseen(), `FLAGGED UNSEEN`},
+		{new(SearchCriteria).From("alice@example.com"), `FROM "alice@example.com"`},
+		{new(SearchCriteria).Subject(`say "hi"`), `SUBJECT "say \"hi\""`},
+		{new(SearchCriteria).Subject("привет"), "SUBJECT {12}\r\n"},
+		{new(SearchCriteria).Body("a\r\nb"), "BODY {4}\r\n"},
+		{new(SearchCriteria).Header("X-Spam", ""), `HEADER "X-Spam" ""`},
+		{new(SearchCriteria).Since(date).Before(date.AddDate(0, 0, 10)), `SINCE 03-Feb-2013 BEFORE 13-Feb-2013`},
+		{new(SearchCriteria).Larger(1024).Smaller(4096), `LARGER 1024 SMALLER 4096`},
+		{new(SearchCriteria).Keyword("$Junk"), `KEYWORD $Junk`},
+		{new(SearchCriteria).UID(uids), `UID 1:100,200:*`},
+		{new(SearchCriteria).Seq(uids), `1:100,200:*`},
+		{not(new(SearchCriteria).Seen()), `NOT SEEN`},
+		{not(new(SearchCriteria).Seen().Flagged()), `NOT (SEEN FLAGGED)`},
+		{new(SearchCriteria).Or(
+			new(SearchCriteria).To("bob"),
+			not(new(SearchCriteria).Cc("bob")),
+		), `OR (TO "bob") (NOT (CC "bob"))`},
+	}
+	for _, test := range tests {
+		raw := &rawCommand{Buffer: new(bytes.Buffer)}
+		if err := raw.WriteFields(test.in.Build(), false); err != nil {
+			t.Errorf("WriteFields(%v) unexpected error; %v", test.in.Build(), err)
+		} else if out := raw.String(); out != test.out {
+			t.Errorf("Build() expected %q; got %q", test.out, out)
+		}
+	}
+}
@@ -105,6 +105,29 @@ func (s seq) Merge(t seq) (union seq, ok bool) {
 	return
 }
 
+// star is the value of "*" when a seq is converted to uint64 bounds. It is
+// greater than all valid seq-numbers, and conveniently converts to 0 (i.e. "*")
+// when truncated to uint32.
+const star = 1 << 32
+
+// bounds returns the first and last values in s as uint64, with "*" converted
+// to star. This makes it possible to treat all sequence values as static
+// ranges, where "n:*" contains all numbers >= n, as well as "*".
+func (s seq) bounds() (lo, hi uint64) {
+	if lo, hi = uint64(s.start), uint64(s.stop); lo == 0 {
+		lo = star
+	}
+	if hi == 0 {
+		hi = star
+	}
+	return
+}
+
+// seqBounds is the inverse of seq.bounds.
+func seqBounds(lo, hi uint64) seq {
+	return seq{uint32(lo), uint32(hi)}
+}
+
 // String returns sequence value s as a seq-number or seq-range string.
 func (s seq) String() string {
 	if s.start == s.stop {
@@ -185,15 +208,187 @@ func (s SeqSet) Dynamic() bool {
 }
 
 // Contains returns true if the non-zero sequence number or UID q is contained
-// in the set. The dynamic range "n:*" contains all q >= n. It is the caller's
-// responsibility to handle the special case where q is the maximum UID in the
-// mailbox and q < n (i.e. the set cannot match UIDs against "*:n" or "*" since
-// it doesn't know what the maximum value is).
+// in the set. The dynamic range "n:*" contains all q >= n, and "*" is treated
+// as the maximum uint32 value. It is the caller's responsibility to handle the
+// special case where q is the maximum UID in the mailbox and q < n (i.e. the
+// set cannot match UIDs against "*:n" or "*" since it doesn't know what the
+// maximum value is).
 func (s SeqSet) Contains(q uint32) bool {
 	if _, ok := s.search(q); ok {
 		return q != 0
 	}
-	return false
+	n := len(s.set)
+	return q == star-1 && n > 0 && s.set[n-1].start == 0
+}
+
+// Count returns the number of static values in the set. If the set contains "*"
+// or "n:*" values, the total number of values is unknown and bounded is false.
+// In that case, n includes only the static values that precede the dynamic one.
+func (s SeqSet) Count() (n uint32, bounded bool) {
+	for _, v := range s.set {
+		if v.stop == 0 {
+			return n, false
+		}
+		n += v.stop - v.start + 1
+	}
+	return n, true
+}
+
+// Union returns a new set containing all values from s and t.
+func (s SeqSet) Union(t *SeqSet) *SeqSet {
+	u := &SeqSet{set: append([]seq(nil), s.set...)}
+	u.AddSet(t)
+	return u
+}
+
+// Intersect returns a new set containing the values that are present in both s
+// and t. The dynamic range "n:*" contains "*" and all numbers >= n, so "*" is
+// preserved in the result if both sets contain it (e.g. "1:*" intersected with
+// "5:*" is "5:*").
+func (s SeqSet) Intersect(t *SeqSet) *SeqSet {
+	u := new(SeqSet)
+	for i, j := 0, 0; i < len(s.set) && j < len(t.set); {
+		alo, ahi := s.set[i].bounds()
+		blo, bhi := t.set[j].bounds()
+		lo, hi := alo, ahi
+		if lo < blo {
+			lo = blo
+		}
+		if hi > bhi {
+			hi = bhi
+		}
+		if lo <= hi {
+			u.insert(seqBounds(lo, hi))
+		}
+		if ahi < bhi {
+			i++
+		} else {
+			j++
+		}
+	}
+	return u
+}
+
+// Diff returns a new set containing the values from s that are not present in
+// t. Removing "*" from "n:*" results in the static range "n:4294967295".
+func (s SeqSet) Diff(t *SeqSet) *SeqSet {
+	u := new(SeqSet)
+	j := 0
+	for _, v := range s.set {
+		lo, hi := v.bounds()
+		for ; j < len(t.set); j++ {
+			blo, bhi := t.set[j].bounds()
+			if bhi < lo {
+				continue
+			} else if blo > hi {
+				break
+			}
+			if blo > lo {
+				u.insert(seqBounds(lo, blo-1))
+			}
+			if lo = bhi + 1; lo > hi {
+				break
+			}
+		}
+		if lo <= hi {
+			u.insert(seqBounds(lo, hi))
+		}
+	}
+	return u
+}
+
+// Numbers returns all values in the set as a sorted slice. It returns nil if
+// the set is empty or contains "*" or "n:*" values, since the full list of
+// numbers is unknown. Use EachUpTo to iterate over dynamic sets.
+func (s SeqSet) Numbers() []uint32 {
+	n, bounded := s.Count()
+	if n == 0 || !bounded {
+		return nil
+	}
+	nums := make([]uint32, 0, n)
+	s.Each(func(q uint32) bool {
+		nums = append(nums, q)
+		return true
+	})
+	return nums
+}
+
+// Each calls fn for each value in the set in ascending order until fn returns
+// false. Dynamic values ("*" and "n:*") are skipped.
+func (s SeqSet) Each(fn func(q uint32) bool) {
+	s.each(^uint32(0), false, fn)
+}
+
+// EachUpTo calls fn for each value in the set in ascending order until fn
+// returns false. Max is the largest valid value in the mailbox (e.g. the number
+// of messages or the highest UID), which replaces "*". Values greater than max
+// are skipped. Per RFC 3501, "n:*" is treated as "max:n" when n > max, so it
+// only contains max.
+func (s SeqSet) EachUpTo(max uint32, fn func(q uint32) bool) {
+	s.each(max, true, fn)
+}
+
+// each implements Each and EachUpTo without allocating memory for each value.
+func (s SeqSet) each(max uint32, dynamic bool, fn func(q uint32) bool) {
+	var last uint32 // All values <= last have been visited
+	for _, v := range s.set {
+		start, stop := v.start, v.stop
+		if stop == 0 {
+			if !dynamic || max == 0 {
+				return
+			} else if stop = max; start == 0 || start > max {
+				start = max
+			}
+		} else if stop > max {
+			stop = max
+		}
+		if start <= last {
+			if last == max {
+				return
+			}
+			start = last + 1
+		}
+		if start > stop {
+			continue
+		}
+		for q := start; ; q++ {
+			if !fn(q) {
+				return
+			} else if q == stop {
+				break
+			}
+		}
+		last = stop
+	}
+}
+
+// Split partitions the set into one or more sets, each of which has a string
+// representation that is at most maxLen bytes long. This can be used to keep
+// the length of command lines below the server limit (see RFC 2683 section
+// 3.2.1.5). The values are kept in order, so the first set contains the lowest
+// numbers. A single sequence value that is longer than maxLen is returned in a
+// set by itself. If maxLen <= 0, the entire set is returned as a single set.
+// Nil is returned for an empty set.
+func (s SeqSet) Split(maxLen int) []*SeqSet {
+	var out []*SeqSet
+	cur, n := new(SeqSet), 0
+	for _, v := range s.set {
+		vn := len(v.String())
+		if n > 0 {
+			if maxLen > 0 && n+1+vn > maxLen {
+				out = append(out, cur)
+				cur, n = new(SeqSet), 0
+			} else {
+				n++ // Comma
+			}
+		}
+		cur.set = append(cur.set, v)
+		n += vn
+	}
+	if n > 0 {
+		out = append(out, cur)
+	}
+	return out
 }
 
 // String returns a sorted representation of all contained sequence values.
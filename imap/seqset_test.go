@@ -6,6 +6,7 @@ package imap
 
 import (
 	"math/rand"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -412,11 +413,11 @@ func TestSeqSetInfo(t *testing.T) {
 		{"2", 3, false},
 		{"2", max, false},
 
-		{"*", 0, false}, // Contains("*") is always false, use Dynamic() instead
+		{"*", 0, false}, // "*" only contains max, use Dynamic() instead
 		{"*", 1, false},
 		{"*", 2, false},
 		{"*", 3, false},
-		{"*", max, false},
+		{"*", max, true},
 
 		{"1:*", 0, false},
 		{"1:*", 1, true},
@@ -508,7 +509,7 @@ func TestSeqSetInfo(t *testing.T) {
 		{"1,3:5,7,9,42,*", 41, false},
 		{"1,3:5,7,9,42,*", 42, true},
 		{"1,3:5,7,9,42,*", 43, false},
-		{"1,3:5,7,9,42,*", max, false},
+		{"1,3:5,7,9,42,*", max, true},
 
 		{"1,3:5,7,9,42,60:70,100:*", 0, false},
 		{"1,3:5,7,9,42,60:70,100:*", 1, true},
@@ -726,3 +727,187 @@ func TestSeqSetAddNumRangeSet(t *testing.T) {
 		}
 	}
 }
+
+func TestSeqSetSplit(t *testing.T) {
+	tests := []struct {
+		set    string
+		maxLen int
+		out    []string
+	}{
+		{"", 10, nil},
+		{"1:4,7", 0, []string{"1:4,7"}},
+		{"1:4,7", 5, []string{"1:4,7"}},
+		{"1:4,7", 4, []string{"1:4", "7"}},
+		{"1:4,7", 1, []string{"1:4", "7"}},
+		{"1,3,5,7,9,11", 5, []string{"1,3,5", "7,9", "11"}},
+		{"1,3,5,7,9,11", 6, []string{"1,3,5", "7,9,11"}},
+		{"1,3,100:200,4000:*", 8, []string{"1,3", "100:200", "4000:*"}},
+		{"1,3,100:200,4000:*", 100, []string{"1,3,100:200,4000:*"}},
+	}
+	for _, test := range tests {
+		s, _ := NewSeqSet(test.set)
+		var out []string
+		for _, v := range s.Split(test.maxLen) {
+			checkSeqSet(v, t)
+			out = append(out, v.String())
+		}
+		if !reflect.DeepEqual(out, test.out) {
+			t.Errorf("%q.Split(%d) expected %q; got %q", test.set, test.maxLen, test.out, out)
+		}
+	}
+
+	// Folding is independent of insertion order
+	s := &SeqSet{}
+	for i := uint32(2000); i > 0; i-- {
+		s.AddNum(i)
+		if i%3 == 0 {
+			s.AddNum(i + 5000)
+		}
+	}
+	for _, v := range s.Split(1000) {
+		if n := len(v.String()); n > 1000 {
+			t.Errorf("Split(1000) returned a set of length %d", n)
+		}
+	}
+	if v := s.Split(12)[0].String(); v != "1:2000,5003" {
+		t.Errorf("Split(12)[0] expected %q; got %q", "1:2000,5003", v)
+	}
+}
+
+func TestSeqSetAlgebra(t *testing.T) {
+	tests := []struct {
+		a, b                   string
+		union, intersect, diff string
+	}{
+		{"", "", "", "", ""},
+		{"1:5", "", "1:5", "", "1:5"},
+		{"", "1:5", "1:5", "", ""},
+		{"1:5", "3:8", "1:8", "3:5", "1:2"},
+		{"1:10", "3,5:6,9", "1:10", "3,5:6,9", "1:2,4,7:8,10"},
+		{"1:3,7:9", "4:6", "1:9", "", "1:3,7:9"},
+		{"1:5", "1:3,4:6", "1:6", "1:5", ""},
+		{"1:*", "5:*", "1:*", "5:*", "1:4"},
+		{"1:*", "*", "1:*", "*", "1:4294967295"},
+		{"*", "1:*", "1:*", "*", ""},
+		{"*", "1:10", "1:10,*", "", "*"},
+		{"5:*", "1:10", "1:*", "5:10", "11:*"},
+		{"1:10", "5:*", "1:*", "5:10", "1:4"},
+		{"1,3,5:*", "2:6,8", "1:*", "3,5:6,8", "1,7,9:*"},
+		{"4294967295", "*", "4294967295,*", "", "4294967295"},
+	}
+	for _, test := range tests {
+		a, _ := NewSeqSet(test.a)
+		b, _ := NewSeqSet(test.b)
+		u, i, d := a.Union(b), a.Intersect(b), a.Diff(b)
+		checkSeqSet(u, t)
+		checkSeqSet(i, t)
+		checkSeqSet(d, t)
+		if out := u.String(); out != test.union {
+			t.Errorf("%q.Union(%q) expected %q; got %q", test.a, test.b, test.union, out)
+		}
+		if out := i.String(); out != test.intersect {
+			t.Errorf("%q.Intersect(%q) expected %q; got %q", test.a, test.b, test.intersect, out)
+		}
+		if out := d.String(); out != test.diff {
+			t.Errorf("%q.Diff(%q) expected %q; got %q", test.a, test.b, test.diff, out)
+		}
+		if out := a.String(); out != test.a {
+			t.Errorf("%q modified by set operations; got %q", test.a, out)
+		}
+	}
+}
+
+func TestSeqSetContainsCount(t *testing.T) {
+	tests := []struct {
+		set     string
+		in      []uint32
+		out     []uint32
+		n       uint32
+		bounded bool
+	}{
+		{"", nil, []uint32{0, 1, 4294967295}, 0, true},
+		{"1:3,5", []uint32{1, 2, 3, 5}, []uint32{0, 4, 6}, 4, true},
+		{"1:4294967295", []uint32{1, 4294967295}, []uint32{0}, 4294967295, true},
+		{"2,4:*", []uint32{2, 4, 100, 4294967295}, []uint32{0, 1, 3}, 1, false},
+		{"1,*", []uint32{1, 4294967295}, []uint32{0, 2, 4294967294}, 1, false},
+	}
+	for _, test := range tests {
+		s, _ := NewSeqSet(test.set)
+		for _, q := range test.in {
+			if !s.Contains(q) {
+				t.Errorf("%q.Contains(%d) expected true", test.set, q)
+			}
+		}
+		for _, q := range test.out {
+			if s.Contains(q) {
+				t.Errorf("%q.Contains(%d) expected false", test.set, q)
+			}
+		}
+		if n, bounded := s.Count(); n != test.n || bounded != test.bounded {
+			t.Errorf("%q.Count() expected %d, %v; got %d, %v", test.set, test.n, test.bounded, n, bounded)
+		}
+	}
+}
+
+func TestSeqSetEach(t *testing.T) {
+	tests := []struct {
+		set  string
+		max  uint32
+		nums []uint32 // Numbers() and Each()
+		upTo []uint32 // EachUpTo(max)
+	}{
+		{"", 10, nil, nil},
+		{"1:3,5", 10, []uint32{1, 2, 3, 5}, []uint32{1, 2, 3, 5}},
+		{"1:3,5", 2, []uint32{1, 2, 3, 5}, []uint32{1, 2}},
+		{"1:3,5", 0, []uint32{1, 2, 3, 5}, nil},
+		{"4294967294:4294967295", max, []uint32{max - 1, max}, []uint32{max - 1, max}},
+		{"2,*", 5, nil, []uint32{2, 5}},
+		{"5,*", 5, nil, []uint32{5}},
+		{"7,*", 5, nil, []uint32{5}},
+		{"2,4:*", 6, nil, []uint32{2, 4, 5, 6}},
+		{"2,8:*", 6, nil, []uint32{2, 6}},
+		{"2,8:*", 0, nil, nil},
+		{"4294967295:*", max, nil, []uint32{max}},
+	}
+	for _, test := range tests {
+		s, _ := NewSeqSet(test.set)
+		if nums := s.Numbers(); !reflect.DeepEqual(nums, test.nums) {
+			t.Errorf("%q.Numbers() expected %v; got %v", test.set, test.nums, nums)
+		}
+		var each []uint32
+		s.Each(func(q uint32) bool {
+			each = append(each, q)
+			return true
+		})
+		if !s.Dynamic() && !reflect.DeepEqual(each, test.nums) {
+			t.Errorf("%q.Each() expected %v; got %v", test.set, test.nums, each)
+		}
+		var upTo []uint32
+		s.EachUpTo(test.max, func(q uint32) bool {
+			upTo = append(upTo, q)
+			return true
+		})
+		if !reflect.DeepEqual(upTo, test.upTo) {
+			t.Errorf("%q.EachUpTo(%d) expected %v; got %v", test.set, test.max, test.upTo, upTo)
+		}
+	}
+
+	// Early termination and large ranges
+	s, _ := NewSeqSet("1:1000000,2000000:*")
+	n := 0
+	s.EachUpTo(3000000, func(q uint32) bool {
+		n++
+		return q < 1500
+	})
+	if n != 1500 {
+		t.Errorf("EachUpTo() expected 1500 calls; got %d", n)
+	}
+	n = 0
+	s.Each(func(q uint32) bool {
+		n++
+		return true
+	})
+	if n != 1000000 {
+		t.Errorf("Each() expected 1000000 calls; got %d", n)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package imap
+
+import "errors"
+
+// ErrUIDValidity is returned by Session.Reconnect when the UIDVALIDITY value of
+// the previously selected mailbox has changed. The connection is restored and
+// the mailbox is selected, but any UIDs cached by the caller are no longer
+// valid and must be discarded.
+var ErrUIDValidity = errors.New("imap: mailbox UIDVALIDITY changed")
+
+// Session maintains a connection to a single server and is able to restore the
+// authenticated and selected states after the connection is lost. This is
+// useful for long-lived connections, such as those used for IDLE, which may be
+// dropped by the network or the server at any time.
+//
+// The dial function passed to NewSession must return a new Client in the Login
+// or Auth state, and it is called every time the connection is established. It
+// may perform additional steps, such as StartTLS. Authentication is performed
+// by the Session only if credentials were provided via SetLogin or SetAuth.
+// Storing credentials is optional, since it requires the Session to keep
+// secrets in memory. Without them, the dial function must perform
+// authentication itself (e.g. by prompting the user).
+//
+// Only the mailbox opened by s.Select is restored after reconnecting. Use
+// s.Close to close it, so that the Session stops tracking it. Other commands
+// should be issued directly via s.Client.
+type Session struct {
+	Client *Client // Current connection (nil until Connect is called)
+
+	dial func() (*Client, error)
+	auth func(c *Client) (*Command, error)
+
+	// Mailbox opened by Select and its UIDVALIDITY value
+	mbox        string
+	readonly    bool
+	uidValidity uint32
+}
+
+// NewSession returns a new Session that uses the dial function to establish
+// server connections. Call s.Connect to create the first connection.
+func NewSession(dial func() (*Client, error)) *Session {
+	return &Session{dial: dial}
+}
+
+// SetLogin stores the username and password in memory for the lifetime of the
+// Session, or until ClearCredentials is called. They are used with the LOGIN
+// command every time a new connection is established.
+func (s *Session) SetLogin(username, password string) {
+	s.auth = func(c *Client) (*Command, error) {
+		return c.Login(username, password)
+	}
+}
+
+// SetAuth stores the SASL mechanism in memory for the lifetime of the Session,
+// or until ClearCredentials is called. It is used with the AUTHENTICATE command
+// every time a new connection is established, so it must support being started
+// more than once.
+func (s *Session) SetAuth(a SASL) {
+	s.auth = func(c *Client) (*Command, error) {
+		return c.Auth(a)
+	}
+}
+
+// ClearCredentials removes any credentials stored by SetLogin or SetAuth.
+func (s *Session) ClearCredentials() {
+	s.auth = nil
+}
+
+// Connect establishes a new connection to the server and performs
+// authentication if the credentials are known. The current connection, if any,
+// is closed.
+func (s *Session) Connect() error {
+	s.close()
+	c, err := s.dial()
+	if err != nil {
+		return err
+	}
+	if s.auth != nil && c.State() == Login {
+		if _, err = s.auth(c); err != nil {
+			c.Logout(0)
+			return err
+		}
+	}
+	s.Client = c
+	return nil
+}
+
+// Select opens a mailbox on the server, as described by Client.Select, and
+// records it for Reconnect.
+//
+// This command is synchronous.
+func (s *Session) Select(mbox string, readonly bool) (cmd *Command, err error) {
+	if s.Client == nil {
+		return nil, ErrNotAllowed
+	}
+	if cmd, err = s.Client.Select(mbox, readonly); err == nil {
+		s.mbox, s.readonly = mbox, readonly
+		s.uidValidity = s.Client.Mailbox.UIDValidity
+	}
+	return
+}
+
+// Close closes the selected mailbox, as described by Client.Close, and stops
+// tracking it.
+//
+// This command is synchronous.
+func (s *Session) Close(expunge bool) (cmd *Command, err error) {
+	if s.Client == nil {
+		return nil, ErrNotAllowed
+	}
+	if cmd, err = s.Client.Close(expunge); err == nil {
+		s.mbox, s.uidValidity = "", 0
+	}
+	return
+}
+
+// Reconnect closes the current connection, establishes a new one, and selects
+// the mailbox that was previously opened by s.Select. ErrUIDValidity is
+// returned if the mailbox UIDVALIDITY value has changed.
+func (s *Session) Reconnect() error {
+	if err := s.Connect(); err != nil || s.mbox == "" {
+		return err
+	}
+	prev := s.uidValidity
+	if _, err := s.Select(s.mbox, s.readonly); err != nil {
+		return err
+	} else if prev != 0 && prev != s.uidValidity {
+		return ErrUIDValidity
+	}
+	return nil
+}
+
+// close terminates the current connection without waiting for the server.
+func (s *Session) close() {
+	if c := s.Client; c != nil {
+		if c.State() != Closed {
+			c.Logout(0)
+		}
+		s.Client = nil
+	}
+}
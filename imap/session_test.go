@@ -0,0 +1,77 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package imap
+
+import "testing"
+
+func TestSessionReconnect(T *testing.T) {
+	//defer un(setLogMask(LogAll))
+	var t *clientT
+	var scripts [][]string
+	s := NewSession(func() (*Client, error) {
+		var C *Client
+		C, t = newClient(T, `S: * OK [CAPABILITY IMAP4rev1] Test server ready`+CRLF)
+		go t.script(scripts[0]...)
+		scripts = scripts[1:]
+		return C, nil
+	})
+	login := []string{
+		`C: A1 LOGIN "user" "pass"` + CRLF,
+		`S: A1 OK [CAPABILITY IMAP4rev1] LOGIN completed` + CRLF,
+	}
+	sel := func(uidValidity string) []string {
+		return append(login,
+			`C: A2 SELECT "INBOX"`+CRLF,
+			`S: * OK [UIDVALIDITY `+uidValidity+`] UIDs valid`+CRLF,
+			`S: A2 OK [READ-WRITE] SELECT completed`+CRLF,
+		)
+	}
+	s.SetLogin("user", "pass")
+
+	// Connect and select
+	scripts = [][]string{sel("1")}
+	err := s.Connect()
+	if err == nil {
+		_, err = s.Select("INBOX", false)
+	}
+	t.join("CONNECT", err)
+	t.checkState(Selected)
+
+	// Connection drops, UIDVALIDITY is the same
+	go t.script(EOF)
+	t.join("EOF", nil)
+	t.waitEOF()
+	scripts = [][]string{sel("1")}
+	err = s.Reconnect()
+	t.join("RECONNECT", err)
+	t.checkState(Selected)
+
+	// Connection drops, UIDVALIDITY changes
+	go t.script(EOF)
+	t.join("EOF", nil)
+	t.waitEOF()
+	scripts = [][]string{sel("2")}
+	err = s.Reconnect()
+	t.join("RECONNECT", nil)
+	if err != ErrUIDValidity {
+		t.Fatalf("s.Reconnect() expected ErrUIDValidity; got %v", err)
+	}
+	t.checkState(Selected)
+
+	// Close the mailbox; only authentication is restored
+	go t.script(
+		`C: A3 CLOSE`+CRLF,
+		`S: A3 OK CLOSE completed`+CRLF,
+		EOF,
+	)
+	_, err = s.Close(true)
+	t.join("CLOSE", err)
+	t.waitEOF()
+	s.ClearCredentials()
+	scripts = [][]string{nil}
+	err = s.Reconnect()
+	t.join("RECONNECT", err)
+	t.checkState(Login)
+}
@@ -5,7 +5,9 @@
 package imap
 
 import (
+	"errors"
 	"io"
+	"io/ioutil"
 	"unicode/utf8"
 )
 
@@ -168,6 +170,12 @@ func unquote(q []byte) (s []byte, ok bool) {
 type LiteralInfo struct {
 	Len uint32 // Literal octet count
 	Bin bool   // RFC 3516 literal8 binary format flag
+
+	// For incoming literals that are FETCH data item values, Seq is the message
+	// sequence number and Item is the data item name in upper case (e.g.
+	// "BODY[]" or "BODY[HEADER]"). Seq is zero for all other literals.
+	Seq  uint32
+	Item string
 }
 
 // Literal represents a single incoming or outgoing literal string, as described
@@ -222,6 +230,31 @@ func (l *literal) Info() LiteralInfo {
 	return l.info
 }
 
+// ErrShortLiteral is returned when the source of an outgoing literal runs out of
+// data before the number of bytes announced to the server has been sent.
+var ErrShortLiteral = errors.New("imap: literal source returned fewer bytes than expected")
+
+// readerLiteral is an outgoing literal that streams its data from an io.Reader.
+// The sent flag is set once the server has accepted the literal and at least
+// one write has been attempted.
+type readerLiteral struct {
+	r    io.Reader
+	info LiteralInfo
+	sent bool
+}
+
+func (l *readerLiteral) WriteTo(w io.Writer) (n int64, err error) {
+	l.sent = true
+	if n, err = io.CopyN(w, l.r, int64(l.info.Len)); err == io.EOF {
+		err = ErrShortLiteral
+	}
+	return
+}
+
+func (l *readerLiteral) Info() LiteralInfo {
+	return l.info
+}
+
 // LiteralReader is the interface for receiving literal strings from the server.
 //
 // ReadLiteral reads exactly i.Length bytes from r into a new literal. It must
@@ -244,6 +277,58 @@ func (MemoryReader) ReadLiteral(r io.Reader, i LiteralInfo) (Literal, error) {
 	return &literal{b[:n], i}, err
 }
 
+// StreamReader implements the LiteralReader interface by passing incoming
+// literals directly to a callback function without saving them to memory. This
+// allows large message bodies (e.g. BODY[] in a FETCH response) to be written
+// to disk as they are received from the server. Install it with
+// Client.SetLiteralReader.
+//
+// Func is called from within Client.Recv, while the response containing the
+// literal is being received, so it must not call any Client methods. The
+// reader r is only valid until Func returns, and the response is not parsed and
+// delivered until all of its literals have been consumed. If Func returns nil
+// without reading all Len bytes, the rest of the literal is read and discarded
+// to keep the connection synchronized. A non-nil error aborts the receive
+// operation and leaves the connection in an unusable state.
+//
+// Literals shorter than MinLen octets are saved to memory without calling
+// Func, which keeps strings such as those in ENVELOPE or BODYSTRUCTURE
+// available to the normal decoders. Streamed literals are represented in the
+// response by an empty Literal with the original LiteralInfo.
+type StreamReader struct {
+	Func   func(r io.Reader, i LiteralInfo) error
+	MinLen uint32
+}
+
+func (sr *StreamReader) ReadLiteral(r io.Reader, i LiteralInfo) (Literal, error) {
+	if i.Len < sr.MinLen || sr.Func == nil {
+		return MemoryReader{}.ReadLiteral(r, i)
+	}
+	l := &literal{info: i}
+	if err := sr.Func(r, i); err != nil {
+		return l, err
+	}
+	_, err := io.Copy(ioutil.Discard, r)
+	return l, err
+}
+
+// messageReader is a LiteralReader installed by Client.FetchRFC822Stream. It
+// passes BODY[] literals in FETCH responses to fn and uses the previously
+// installed LiteralReader for all other literals.
+type messageReader struct {
+	LiteralReader
+	fn func(seq uint32, r io.Reader)
+}
+
+func (mr *messageReader) ReadLiteral(r io.Reader, i LiteralInfo) (Literal, error) {
+	if i.Seq == 0 || i.Item != "BODY[]" {
+		return mr.LiteralReader.ReadLiteral(r, i)
+	}
+	mr.fn(i.Seq, r)
+	_, err := io.Copy(ioutil.Discard, r)
+	return &literal{info: i}, err
+}
+
 // toUpper returns a copy of s with all ASCII characters converted to upper
 // case. This is a faster version of strings.ToUpper for ASCII-only strings.
 func toUpper(s string) string {
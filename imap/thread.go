@@ -0,0 +1,87 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package imap
+
+// ThreadMember is a single node in a tree of threaded messages returned by the
+// THREAD command. Num is a message sequence number or UID, depending on the
+// command type. A Num of 0 indicates a missing (dummy) parent of two or more
+// sibling threads, as described in RFC 5256 section 4.
+type ThreadMember struct {
+	Num      uint32
+	Children []*ThreadMember
+}
+
+// errThread is returned when a THREAD response cannot be parsed.
+var errThread = &ParserError{Info: "bad thread list"}
+
+// Threads returns all message threads extracted from THREAD responses in
+// cmd.Data. Each thread consisting of a single message is returned as a
+// ThreadMember without children.
+func Threads(cmd *Command) ([]*ThreadMember, error) {
+	var threads []*ThreadMember
+	for _, rsp := range cmd.Data {
+		if rsp.Label != "THREAD" {
+			continue
+		}
+		for _, f := range rsp.Fields[1:] {
+			list, ok := f.([]Field)
+			if !ok {
+				return nil, errThread
+			}
+			t, err := newThread(list)
+			if err != nil {
+				return nil, err
+			}
+			threads = append(threads, t)
+		}
+	}
+	return threads, nil
+}
+
+// newThread converts a parenthesized thread list into a tree. Each message
+// number in the list is the parent of the following one, and all nested lists
+// are children of the last message. A list that begins with a nested list has
+// an unknown parent, which is represented by a ThreadMember with Num set to 0.
+// If such a parent has only one child, the child is returned instead.
+func newThread(list []Field) (*ThreadMember, error) {
+	var root, last *ThreadMember
+	i := 0
+	for ; i < len(list); i++ {
+		n, ok := list[i].(uint32)
+		if !ok {
+			break
+		} else if n == 0 {
+			return nil, errThread
+		}
+		t := &ThreadMember{Num: n}
+		if root == nil {
+			root = t
+		} else {
+			last.Children = append(last.Children, t)
+		}
+		last = t
+	}
+	for ; i < len(list); i++ {
+		sub, ok := list[i].([]Field)
+		if !ok {
+			return nil, errThread
+		}
+		t, err := newThread(sub)
+		if err != nil {
+			return nil, err
+		}
+		if root == nil {
+			root = &ThreadMember{}
+			last = root
+		}
+		last.Children = append(last.Children, t)
+	}
+	if root == nil {
+		return nil, errThread
+	} else if root.Num == 0 && len(root.Children) == 1 {
+		return root.Children[0], nil
+	}
+	return root, nil
+}
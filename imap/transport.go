@@ -6,12 +6,14 @@ package imap
 
 import (
 	"bufio"
+	"bytes"
 	"compress/flate"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync/atomic"
 )
 
 // Labels for identifying the source of log entries.
@@ -48,8 +50,9 @@ var (
 	ErrEncryptionActive  = errors.New("imap: encryption already enabled")
 )
 
-// BufferSize sets the size of the send and receive buffers (in bytes). This is
-// also the length limit of physical lines. In practice, the client should
+// BufferSize sets the default size of the send and receive buffers (in bytes).
+// This is also the length limit of physical lines. NewClientSize can be used to
+// override it for a single connection. In practice, the client should
 // restrict line length to approximately 1000 bytes, as described in RFC 2683.
 var BufferSize = 65536
 
@@ -114,6 +117,11 @@ func (l *ioLink) Close() error {
 //
 // 	transport <--> buffer <--> [compression] <--> [encryption] <--> network
 type transport struct {
+	// Connection statistics (see Client.Stats). These are accessed atomically
+	// and must remain at the start of the struct for 64-bit alignment.
+	sent, recv    int64 // Protocol bytes written and read (uncompressed)
+	cmds, cmdTime int64 // Number and total duration (ns) of completed commands
+
 	buf     *bufio.ReadWriter // I/O buffer
 	bufLink *ioLink           // Buffer Read/Write provider
 	cmpLink *ioLink           // Compression Read/Write provider
@@ -121,15 +129,22 @@ type transport struct {
 
 	// Debug logging
 	*debugLog
+	redactNext redactState // Redaction state for the next client line
 }
 
 // newTransport wraps an existing network connection in a new transport
 // instance. The connection may already be encrypted.
 func newTransport(conn net.Conn, log *debugLog) *transport {
+	return newTransportSize(conn, log, BufferSize)
+}
+
+// newTransportSize is like newTransport, but uses send and receive buffers of
+// the specified size.
+func newTransportSize(conn net.Conn, log *debugLog, size int) *transport {
 	lnk := &ioLink{Reader: conn, Writer: conn}
 	buf := bufio.NewReadWriter(
-		bufio.NewReaderSize(lnk, BufferSize),
-		bufio.NewWriterSize(lnk, BufferSize),
+		bufio.NewReaderSize(lnk, size),
+		bufio.NewWriterSize(lnk, size),
 	)
 	return &transport{buf: buf, bufLink: lnk, conn: conn, debugLog: log}
 }
@@ -158,6 +173,7 @@ func (t *transport) Closed() bool {
 func (t *transport) ReadLine() (line []byte, err error) {
 	line, err = t.buf.ReadSlice(lf)
 	n := len(line)
+	atomic.AddInt64(&t.recv, int64(n))
 
 	// Copy bytes out of the read buffer
 	if n > 0 {
@@ -213,7 +229,9 @@ func (t *transport) WriteLine(line []byte) error {
 	// Write the line followed by CRLF
 	if err == nil {
 		if _, err = t.buf.Write(line); err == nil {
-			_, err = t.buf.Write(crlf)
+			if _, err = t.buf.Write(crlf); err == nil {
+				atomic.AddInt64(&t.sent, int64(len(line)+2))
+			}
 		}
 	}
 	t.LogLine(client, line, err)
@@ -224,6 +242,7 @@ func (t *transport) WriteLine(line []byte) error {
 // (0 <= n <= len(p)) and any error encountered.
 func (t *transport) Read(p []byte) (n int, err error) {
 	n, err = t.buf.Read(p)
+	atomic.AddInt64(&t.recv, int64(n))
 	t.LogBytes(server, n, err)
 	return
 }
@@ -233,10 +252,17 @@ func (t *transport) Read(p []byte) (n int, err error) {
 // that caused the write to stop early.
 func (t *transport) Write(p []byte) (n int, err error) {
 	n, err = t.buf.Write(p)
+	atomic.AddInt64(&t.sent, int64(n))
 	t.LogBytes(client, n, err)
 	return
 }
 
+// Bytes returns the number of protocol bytes written to and read from the
+// transport, before compression and encryption.
+func (t *transport) Bytes() (sent, recv int64) {
+	return atomic.LoadInt64(&t.sent), atomic.LoadInt64(&t.recv)
+}
+
 // Flush sends any buffered data to the server.
 func (t *transport) Flush() error {
 	err := t.buf.Flush()
@@ -247,12 +273,21 @@ func (t *transport) Flush() error {
 }
 
 // EnableDeflate turns on DEFLATE compression. See flate.NewWriter for
-// information about compression levels.
+// information about compression levels. Any data that is already buffered, but
+// not yet read, is assumed to be compressed and is passed to the inflater ahead
+// of new data from the network. This happens if the server sends compressed
+// data in the same packet as the response that enabled compression.
 func (t *transport) EnableDeflate(level int) error {
 	if t.Compressed() {
 		return ErrCompressionActive
 	}
-	conn := &ioLink{Reader: t.conn, Writer: t.conn}
+	var r io.Reader = t.conn
+	if n := t.buf.Reader.Buffered(); n > 0 {
+		b := make([]byte, n)
+		t.buf.Reader.Read(b)
+		r = io.MultiReader(bytes.NewReader(b), t.conn)
+	}
+	conn := &ioLink{Reader: r, Writer: t.conn}
 	inflater := flate.NewReader(conn)
 	deflater, err := flate.NewWriter(conn, level)
 
@@ -311,17 +346,31 @@ func (t *transport) Close(flush bool) error {
 	return conn.Close()
 }
 
-// LogLine logs a physical line transfer from the client or server.
+// LogLine logs a physical line transfer from the client or server. If LogRedact
+// is set, credentials in LOGIN and AUTHENTICATE commands are replaced with
+// redactedText.
 func (t *transport) LogLine(src byte, line []byte, err error) {
 	if t.debugLog == nil || t.debugLog.mask&LogRaw != LogRaw {
 		return
 	}
+	if src == client {
+		if LogRedact {
+			line = t.redact(line)
+		}
+	} else if t.redactNext == redactAuth && !isContinue(line) {
+		t.redactNext = redactNone
+	}
+	args := []interface{}{"dir", logDir(src)}
+	if tag := lineTag(line); tag != "" {
+		args = append(args, "tag", tag)
+	}
 	ellipsis := ""
 	if len(line) > rawLimit {
 		line, ellipsis = line[:rawLimit], "..."
 	}
 	if err == nil {
-		t.Logf(LogRaw, "%c: %s%s", src, line, ellipsis)
+		t.logAttrs(levelDefault, LogRaw,
+			fmt.Sprintf("%c: %s%s", src, line, ellipsis), args...)
 		return
 	}
 	var info string
@@ -330,7 +379,8 @@ func (t *transport) LogLine(src byte, line []byte, err error) {
 	} else {
 		info = err.Error()
 	}
-	t.Logf(LogRaw, "%c: %s%s (%s)", src, line, ellipsis, info)
+	t.logAttrs(levelWarn, LogRaw,
+		fmt.Sprintf("%c: %s%s (%s)", src, line, ellipsis, info), args...)
 }
 
 // LogBytes logs a literal byte transfer from the client or server.
@@ -339,8 +389,161 @@ func (t *transport) LogBytes(src byte, n int, err error) {
 		return
 	}
 	if err == nil {
-		t.Logf(LogRaw, "%c: literal %d bytes", src, n)
+		t.logAttrs(levelDefault, LogRaw,
+			fmt.Sprintf("%c: literal %d bytes", src, n), "dir", logDir(src))
 		return
 	}
-	t.Logf(LogRaw, "%c: literal %d bytes (%v)", src, n, err)
+	t.logAttrs(levelWarn, LogRaw,
+		fmt.Sprintf("%c: literal %d bytes (%v)", src, n, err), "dir", logDir(src))
+}
+
+// LogRedact controls the redaction of credentials in debug messages. When set,
+// the LOGIN password and all AUTHENTICATE data (the initial response and the
+// client's responses to server challenges) are replaced with "<redacted>" in
+// the LogCmd and LogRaw messages. It may be cleared to debug authentication
+// problems.
+var LogRedact = true
+
+// redactedText replaces credentials in logged command lines.
+const redactedText = "<redacted>"
+
+// redactState tells the transport how to redact the next client line.
+type redactState uint8
+
+const (
+	redactNone redactState = iota // Next line is a new command
+	redactPass                    // Next line begins with the LOGIN password
+	redactAuth                    // Next line is an AUTHENTICATE response
+)
+
+// redact returns a copy of a client line with all credentials removed, and
+// updates the redaction state for the following lines. Continuation lines,
+// which follow a literal, are only recognized when the preceding lines were
+// also passed through redact.
+func (t *transport) redact(line []byte) []byte {
+	switch t.redactNext {
+	case redactPass:
+		t.redactNext = redactNone
+		if len(line) > 0 {
+			return []byte(" " + redactedText)
+		}
+	case redactAuth:
+		if string(line) != "*" {
+			return []byte(redactedText)
+		}
+		t.redactNext = redactNone
+	default:
+		line, t.redactNext = redactCmd(line)
+	}
+	return line
+}
+
+// isContinue returns true if line is a command continuation request.
+func isContinue(line []byte) bool {
+	return len(line) > 0 && line[0] == '+' && (len(line) == 1 || line[1] == ' ')
+}
+
+// redactLine returns a copy of a command line with the password removed from
+// the LOGIN command and the initial response removed from the AUTHENTICATE
+// command. All other lines are returned unmodified. The line may also be the
+// text of a command with its literals removed (Command.String).
+func redactLine(line []byte) []byte {
+	line, _ = redactCmd(line)
+	return line
+}
+
+// redactCmd implements redactLine. It also returns the redaction state for the
+// next line when line is the first physical line of a command.
+func redactCmd(line []byte) ([]byte, redactState) {
+	i := bytes.IndexByte(line, ' ') + 1
+	if i <= 1 {
+		return line, redactNone
+	}
+	n := bytes.IndexByte(line[i:], ' ')
+	if n < 0 {
+		return line, redactNone
+	}
+	name, args := line[i:i+n], line[i+n+1:]
+	var keep int // Number of bytes in args to keep
+	switch {
+	case bytes.EqualFold(name, []byte("LOGIN")):
+		if keep = skipString(args); keep == len(args) {
+			if keep > 0 && args[keep-1] == '}' {
+				return line, redactPass // User name is a literal
+			}
+			return line, redactNone
+		}
+	case bytes.EqualFold(name, []byte("AUTHENTICATE")):
+		if keep = bytes.IndexByte(args, ' '); keep < 0 {
+			return line, redactAuth // No initial response
+		}
+	default:
+		return line, redactNone
+	}
+	next := redactNone
+	if keep < 0 {
+		keep = 0 // Unknown format; redact everything
+	} else if bytes.EqualFold(name, []byte("AUTHENTICATE")) {
+		next = redactAuth
+	}
+	off := len(line) - len(args) + keep
+	out := make([]byte, 0, off+1+len(redactedText))
+	out = append(out, line[:off]...)
+	if keep > 0 {
+		out = append(out, ' ')
+	}
+	return append(out, redactedText...), next
+}
+
+// skipString returns the length of the string (atom, quoted string, or literal
+// prefix) at the start of b. If b does not begin with a valid string, -1 is
+// returned.
+func skipString(b []byte) int {
+	if len(b) == 0 {
+		return -1
+	}
+	switch b[0] {
+	case '"':
+		for i := 1; i < len(b); i++ {
+			if b[i] == '\\' {
+				i++
+			} else if b[i] == '"' {
+				return i + 1
+			}
+		}
+		return -1
+	case '{':
+		if i := bytes.IndexByte(b, '}'); i > 0 {
+			return i + 1
+		}
+		return -1
+	}
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		return i
+	}
+	return len(b)
+}
+
+// lineTag returns the command tag at the start of a physical line, or an empty
+// string if the line is untagged or is not the first line of a command or
+// response.
+func lineTag(line []byte) string {
+	i := bytes.IndexByte(line, ' ')
+	if i <= 0 {
+		return ""
+	}
+	for _, c := range line[:i] {
+		if c <= ' ' || c >= 0x7F || bytes.IndexByte([]byte(`(){%*"\]+`), c) >= 0 {
+			return ""
+		}
+	}
+	return string(line[:i])
+}
+
+// logDir returns the direction of a data transfer from the given source.
+func logDir(src byte) string {
+	if src == client {
+		return dirClient
+	}
+	return dirServer
 }
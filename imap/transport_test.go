@@ -185,12 +185,13 @@ func tlsNewConfig() (client, server *tls.Config, err error) {
 	tpl := x509.Certificate{
 		SerialNumber:          new(big.Int).SetInt64(0),
 		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
 		NotBefore:             now.UTC(),
 		NotAfter:              now.Add(5 * time.Minute).UTC(),
 		BasicConstraintsValid: true,
 		IsCA: true,
 	}
-	priv, err := rsa.GenerateKey(rand.Reader, 512)
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
 	if err != nil {
 		return
 	}
@@ -340,6 +341,40 @@ func TestTransportDeflate(t *testing.T) {
 	tLOGOUT(t, C, S, "B004")
 }
 
+func TestTransportDeflatePending(t *testing.T) {
+	c, s := newTestConn(1024)
+	C, S := newTransport(c, nil), newTransport(s, nil)
+
+	tGREETING(t, C, S)
+
+	// Server sends compressed data immediately after the command completion
+	in := []string{"B001 OK DEFLATE active", "* OK Compressed data"}
+	if err := S.send(in[0]); err != nil {
+		t.Fatalf("S.send(%q) unexpected error; %v", in[0], err)
+	}
+	if err := S.EnableDeflate(0); err != nil {
+		t.Fatalf("S.EnableDeflate(0) unexpected error; %v", err)
+	}
+	if err := S.send(in[1]); err != nil {
+		t.Fatalf("S.send(%q) unexpected error; %v", in[1], err)
+	}
+
+	// Compressed data is buffered while the completion is being read
+	if out, err := C.readln(); out != in[0] || err != nil {
+		t.Fatalf("C.readln() expected %q; got %q (%v)", in[0], out, err)
+	}
+	if C.buf.Reader.Buffered() == 0 {
+		t.Fatal("C.buf.Reader.Buffered() expected > 0")
+	}
+	if err := C.EnableDeflate(9); err != nil {
+		t.Fatalf("C.EnableDeflate(9) unexpected error; %v", err)
+	}
+	if out, err := C.readln(); out != in[1] || err != nil {
+		t.Fatalf("C.readln() expected %q; got %q (%v)", in[1], out, err)
+	}
+	tLOGOUT(t, C, S, "B002")
+}
+
 func TestTransportTLS(t *testing.T) {
 	c, s := newTestConn(1024)
 	C, S := newTransport(c, nil), newTransport(s, nil)
@@ -455,6 +490,80 @@ func TestTransportErrors(t *testing.T) {
 	}
 }
 
+func TestTransportRedact(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{`A1 NOOP`, `A1 NOOP`},
+		{`A1 LOGIN`, `A1 LOGIN`},
+		{`A1 LOGIN user pass`, `A1 LOGIN user <redacted>`},
+		{`A1 login "user" "pass"`, `A1 login "user" <redacted>`},
+		{`A1 LOGIN "us\"er x" "pass"`, `A1 LOGIN "us\"er x" <redacted>`},
+		{`A1 LOGIN {4} "pass"`, `A1 LOGIN {4} <redacted>`},
+		{`A1 LOGIN "user" {4}`, `A1 LOGIN "user" <redacted>`},
+		{`A1 LOGIN {4}`, `A1 LOGIN {4}`},
+		{`A1 LOGIN "user`, `A1 LOGIN <redacted>`},
+		{`A1 AUTHENTICATE PLAIN`, `A1 AUTHENTICATE PLAIN`},
+		{`A1 AUTHENTICATE PLAIN AHVzZXIAcGFzcw==`, `A1 AUTHENTICATE PLAIN <redacted>`},
+		{`A1 Authenticate EXTERNAL =`, `A1 Authenticate EXTERNAL <redacted>`},
+		{`A1 SELECT "LOGIN pass"`, `A1 SELECT "LOGIN pass"`},
+	}
+	for _, test := range tests {
+		if out := string(redactLine([]byte(test.in))); out != test.out {
+			t.Errorf("redactLine(%q) expected %q; got %q", test.in, test.out, out)
+		}
+	}
+}
+
+func TestTransportRedactStream(t *testing.T) {
+	tests := []struct{ src, in, out string }{
+		// Literal user name
+		{"C", `A1 LOGIN {4}`, `C: A1 LOGIN {4}`},
+		{"S", `+ Ready`, `S: + Ready`},
+		{"C", ` "pass"`, `C:  <redacted>`},
+		{"C", `A2 NOOP`, `C: A2 NOOP`},
+
+		// Literal user name and password
+		{"C", `A3 LOGIN {4}`, `C: A3 LOGIN {4}`},
+		{"C", ` {4}`, `C:  <redacted>`},
+		{"C", ``, `C: `},
+		{"C", `A4 NOOP`, `C: A4 NOOP`},
+
+		// SASL exchange
+		{"C", `A5 AUTHENTICATE CRAM-MD5`, `C: A5 AUTHENTICATE CRAM-MD5`},
+		{"S", `+ PDE4OTYuNjk3MTcwOTUyQHBvc3RvZmZpY2UucmVzdG9uLm1jaS5uZXQ+`,
+			`S: + PDE4OTYuNjk3MTcwOTUyQHBvc3RvZmZpY2UucmVzdG9uLm1jaS5uZXQ+`},
+		{"C", `dGltIGI5MTNhNjAyYzdlZGE3YTQ5NWI0ZTZlNzMzNGQzODkw`, `C: <redacted>`},
+		{"S", `A5 OK CRAM-MD5 authentication successful`,
+			`S: A5 OK CRAM-MD5 authentication successful`},
+		{"C", `A6 NOOP`, `C: A6 NOOP`},
+
+		// SASL-IR followed by a challenge and an abort
+		{"C", `A7 AUTHENTICATE PLAIN AHVzZXIAcGFzcw==`, `C: A7 AUTHENTICATE PLAIN <redacted>`},
+		{"S", `+`, `S: +`},
+		{"C", `*`, `C: *`},
+		{"S", `A7 BAD Aborted`, `S: A7 BAD Aborted`},
+		{"C", `A8 NOOP`, `C: A8 NOOP`},
+	}
+	l := &logRecorder{}
+	d := newDebugLog(nil, LogRaw)
+	d.SetStructuredLogger(l)
+	T := &transport{debugLog: d}
+	for i, test := range tests {
+		T.LogLine(test.src[0], []byte(test.in), nil)
+		if out := l.recs[i].msg; out != test.out {
+			t.Errorf("LogLine(%q) expected %q; got %q", test.in, test.out, out)
+		}
+	}
+
+	// Redaction disabled
+	defer func() { LogRedact = true }()
+	LogRedact = false
+	in := `A9 LOGIN "user" "pass"`
+	T.LogLine(client, []byte(in), nil)
+	if out := l.recs[len(l.recs)-1].msg; out != "C: "+in {
+		t.Errorf("LogLine(%q) expected %q; got %q", in, "C: "+in, out)
+	}
+}
+
 func tGREETING(t *testing.T, C, S *transport) {
 	// Send greeting
 	in := "* IMAP4rev1 Server ready"
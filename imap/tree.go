@@ -0,0 +1,119 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package imap
+
+import (
+	"sort"
+	"strings"
+)
+
+// MailboxNode is a single node in a tree of mailboxes returned by ListTree or
+// MailboxTree. The root node has an empty FullName and represents the top of
+// the hierarchy. Parent mailboxes that were not returned by the server are
+// represented by nodes with the `\Nonexistent` and `\Noselect` attributes.
+// System attributes are converted to title case, as in FlagSet.
+type MailboxNode struct {
+	Name     string         // Last component of the mailbox name
+	FullName string         // Full mailbox name decoded to UTF-8
+	Delim    string         // Hierarchy delimiter (empty string == flat name)
+	Attrs    []string       // Sorted mailbox attributes (e.g. `\Noselect`)
+	Children []*MailboxNode // Child mailboxes in the order they were listed
+}
+
+// placeholderAttrs are the attributes of nodes created for missing parents.
+var placeholderAttrs = []string{`\Nonexistent`, `\Noselect`}
+
+// ListTree lists the mailboxes matching the reference and pattern (see List),
+// and assembles them into a tree using the hierarchy delimiter of each
+// mailbox. If the server advertises NAMESPACE capability, the namespaces are
+// requested first and passed to MailboxTree.
+//
+// This command is synchronous.
+func (c *Client) ListTree(ref, pattern string) (*MailboxNode, error) {
+	var ns []Namespace
+	if c.Caps["NAMESPACE"] {
+		cmd, err := Wait(c.Namespace())
+		if err != nil {
+			return nil, err
+		}
+		for _, rsp := range cmd.Data {
+			if v := rsp.Namespaces(); v != nil {
+				ns = append(ns, v.Personal...)
+				ns = append(ns, v.Other...)
+				ns = append(ns, v.Shared...)
+			}
+		}
+	}
+	cmd, err := Wait(c.List(ref, pattern))
+	if err != nil {
+		return nil, err
+	}
+	return MailboxTree(cmd, ns), nil
+}
+
+// MailboxTree assembles the mailboxes from LIST or LSUB responses in cmd.Data
+// into a tree. Each name is split into components using its own hierarchy
+// delimiter, because the delimiter may differ between namespaces. The optional
+// ns list, which can be obtained with Client.Namespace, is used to group
+// mailboxes without a hierarchy delimiter under the prefix of the namespace
+// that contains them. Parents that are missing from the listing are created as
+// placeholders. A parent that is listed after its children replaces the
+// placeholder.
+func MailboxTree(cmd *Command, ns []Namespace) *MailboxNode {
+	t := mailboxTree{root: new(MailboxNode), ns: ns, index: make(map[string]*MailboxNode)}
+	for _, rsp := range cmd.Data {
+		if info := rsp.MailboxInfo(); info != nil {
+			n := t.insert(info.Name, info.Delim)
+			n.Delim = info.Delim
+			n.Attrs = make([]string, 0, len(info.Attrs))
+			for attr := range info.Attrs {
+				n.Attrs = append(n.Attrs, attr)
+			}
+			sort.Strings(n.Attrs)
+		}
+	}
+	return t.root
+}
+
+// mailboxTree is the state of the tree builder used by MailboxTree.
+type mailboxTree struct {
+	root  *MailboxNode
+	ns    []Namespace
+	index map[string]*MailboxNode // Nodes by full name
+}
+
+// insert returns the node for the specified mailbox name, creating it and any
+// missing parents as needed. New nodes are initialized as placeholders.
+func (t *mailboxTree) insert(name, delim string) *MailboxNode {
+	if n := t.index[name]; n != nil {
+		return n
+	}
+	parent, short := t.root, name
+	if i := strings.LastIndex(name, delim); delim != "" && i > 0 && i+len(delim) < len(name) {
+		parent, short = t.insert(name[:i], delim), name[i+len(delim):]
+	} else if ns := t.namespace(name); delim == "" && ns != nil {
+		if prefix := strings.TrimSuffix(ns.Prefix, ns.Delim); prefix != "" {
+			parent, short = t.insert(prefix, ns.Delim), name[len(ns.Prefix):]
+		}
+	}
+	n := &MailboxNode{Name: short, FullName: name, Delim: delim}
+	n.Attrs = append(n.Attrs, placeholderAttrs...)
+	parent.Children = append(parent.Children, n)
+	t.index[name] = n
+	return n
+}
+
+// namespace returns the namespace with the longest non-empty prefix that
+// contains the specified mailbox, or nil if there isn't one.
+func (t *mailboxTree) namespace(name string) (ns *Namespace) {
+	for i := range t.ns {
+		p := t.ns[i].Prefix
+		if p != "" && len(p) < len(name) && strings.HasPrefix(name, p) &&
+			(ns == nil || len(p) > len(ns.Prefix)) {
+			ns = &t.ns[i]
+		}
+	}
+	return
+}
@@ -24,6 +24,21 @@ var ErrBadUTF7 = errors.New("imap: bad utf-7 encoding")
 var u7enc = base64.NewEncoding(
 	"ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+,")
 
+// EncodeMailbox converts a mailbox name from UTF-8 to modified UTF-7 encoding,
+// as required by RFC 3501 section 5.1.3. All Client methods that accept mailbox
+// names perform this conversion automatically.
+func EncodeMailbox(name string) string {
+	return UTF7Encode(name)
+}
+
+// DecodeMailbox converts a mailbox name from modified UTF-7 encoding to UTF-8.
+// ErrBadUTF7 is returned if the name is not valid modified UTF-7 (e.g. it
+// contains an unescaped "&" character). Use AsMailbox to decode mailbox names
+// in server responses.
+func DecodeMailbox(name string) (string, error) {
+	return UTF7Decode(name)
+}
+
 // UTF7Encode converts a string from UTF-8 encoding to modified UTF-7. This
 // encoding is used by the Mailbox International Naming Convention (RFC 3501
 // section 5.1.3). Invalid UTF-8 byte sequences are replaced by the Unicode
@@ -239,3 +239,31 @@ func TestUTF7Inverse(t *testing.T) {
 		}
 	}
 }
+
+func TestMailboxEncoding(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"", ""},
+		{"INBOX", "INBOX"},
+		{"&", "&-"},
+		{"&-", "&--"},
+		{"Wichtige Ünterlagen", "Wichtige &ANw-nterlagen"},
+		{"~peter/mail/台北/日本語", "~peter/mail/&U,BTFw-/&ZeVnLIqe-"},
+	}
+	for _, test := range tests {
+		out := EncodeMailbox(test.in)
+		if out != test.out {
+			t.Errorf("EncodeMailbox(%+q) expected %+q; got %+q", test.in, test.out, out)
+		}
+		if in, err := DecodeMailbox(out); in != test.in || err != nil {
+			t.Errorf("DecodeMailbox(%+q) expected %+q; got %+q (%v)", out, test.in, in, err)
+		}
+	}
+	for _, in := range []string{"&", "&Jjo", "Ü"} {
+		if out, err := DecodeMailbox(in); err == nil {
+			t.Errorf("DecodeMailbox(%+q) expected error; got %+q", in, out)
+		}
+	}
+}
@@ -5,8 +5,11 @@
 package imap
 
 import (
+	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -17,10 +20,13 @@ import (
 	"time"
 )
 
-// Default debug logging configuration for new Client instances.
+// Default debug logging configuration for new Client instances. If
+// DefaultStructuredLogger is not nil, it receives all messages instead of
+// DefaultLogger.
 var (
-	DefaultLogger  = log.New(os.Stderr, "[imap] ", log.Ltime)
-	DefaultLogMask = LogNone
+	DefaultLogger           = log.New(os.Stderr, "[imap] ", log.Ltime)
+	DefaultLogMask          = LogNone
+	DefaultStructuredLogger Logger
 )
 
 // prng is a deterministic pseudo-random number generator seeded using the
@@ -31,10 +37,43 @@ var prng = rand.New(&prngSource{src: rand.NewSource(time.Now().UnixNano())})
 // It causes the Client to use predictable tag ids for scripting.
 var gotest = false
 
+// Logger is the interface of a structured, leveled logger that can be used as
+// the destination of debug messages. It is satisfied by *slog.Logger. The
+// args are alternating key/value pairs. Each message has a "category" key set
+// to the LogMask that enabled it. Messages about the data stream and command
+// execution may also have a "tag" key (command tag) and a "dir" key ("client"
+// or "server").
+//
+// Messages are still filtered by the LogMask before they are passed to the
+// Logger. Connection and state messages are logged at the Info level, errors
+// and server alerts at the Warn level, and everything else at the Debug level.
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+}
+
+// logLevel is the level of a message sent to a structured Logger.
+type logLevel int
+
+const (
+	levelDefault logLevel = iota // Level determined by the LogMask
+	levelDebug
+	levelInfo
+	levelWarn
+)
+
+// Directions of the data transfers reported by the "dir" message key.
+const (
+	dirClient = "client"
+	dirServer = "server"
+)
+
 // debugLog handles all logging operations for Client and transport.
 type debugLog struct {
-	log  *log.Logger // Message destination
-	mask LogMask     // Enabled message categories
+	log    *log.Logger // Message destination
+	mask   LogMask     // Enabled message categories
+	logger Logger      // Structured message destination (overrides log)
 }
 
 // newDebugLog returns a new debugLog instance.
@@ -42,7 +81,7 @@ func newDebugLog(log *log.Logger, mask LogMask) *debugLog {
 	if log == nil {
 		log = DefaultLogger
 	}
-	return &debugLog{log, mask}
+	return &debugLog{log, mask, DefaultStructuredLogger}
 }
 
 // SetLogger sets the destination of debug messages and returns the previous
@@ -56,6 +95,19 @@ func (d *debugLog) SetLogger(log *log.Logger) *log.Logger {
 	return prev
 }
 
+// SetStructuredLogger sets a structured destination of debug messages, such as
+// *slog.Logger, and returns the previous one. While it is set, the logger
+// configured by SetLogger is not used. Pass nil to return to unstructured
+// logging.
+func (d *debugLog) SetStructuredLogger(l Logger) Logger {
+	if d == nil {
+		return nil
+	}
+	prev := d.logger
+	d.logger = l
+	return prev
+}
+
 // SetLogMask enables/disables debug message categories and returns the previous
 // mask.
 func (d *debugLog) SetLogMask(mask LogMask) LogMask {
@@ -72,7 +124,7 @@ func (d *debugLog) SetLogMask(mask LogMask) LogMask {
 // mask.
 func (d *debugLog) Log(mask LogMask, v ...interface{}) {
 	if d != nil && d.mask&mask == mask {
-		d.log.Output(2, fmt.Sprint(v...))
+		d.output(levelDefault, mask, fmt.Sprint(v...))
 	}
 }
 
@@ -81,7 +133,7 @@ func (d *debugLog) Log(mask LogMask, v ...interface{}) {
 // mask.
 func (d *debugLog) Logf(mask LogMask, format string, v ...interface{}) {
 	if d != nil && d.mask&mask == mask {
-		d.log.Output(2, fmt.Sprintf(format, v...))
+		d.output(levelDefault, mask, fmt.Sprintf(format, v...))
 	}
 }
 
@@ -90,7 +142,57 @@ func (d *debugLog) Logf(mask LogMask, format string, v ...interface{}) {
 // mask.
 func (d *debugLog) Logln(mask LogMask, v ...interface{}) {
 	if d != nil && d.mask&mask == mask {
-		d.log.Output(2, fmt.Sprintln(v...))
+		d.output(levelDefault, mask, fmt.Sprintln(v...))
+	}
+}
+
+// warnf is the same as Logf, but uses the Warn level for structured logging.
+func (d *debugLog) warnf(mask LogMask, format string, v ...interface{}) {
+	if d != nil && d.mask&mask == mask {
+		d.output(levelWarn, mask, fmt.Sprintf(format, v...))
+	}
+}
+
+// warnln is the same as Logln, but uses the Warn level for structured logging.
+func (d *debugLog) warnln(mask LogMask, v ...interface{}) {
+	if d != nil && d.mask&mask == mask {
+		d.output(levelWarn, mask, fmt.Sprintln(v...))
+	}
+}
+
+// logAttrs records msg in the debug log if logging is enabled for the specified
+// mask. The key/value pairs in args are only used by structured loggers.
+func (d *debugLog) logAttrs(level logLevel, mask LogMask, msg string, args ...interface{}) {
+	if d != nil && d.mask&mask == mask {
+		d.output(level, mask, msg, args...)
+	}
+}
+
+// output sends the message to the configured destination. The call depth is
+// fixed, so it must only be called by the exported and unexported logging
+// methods above.
+func (d *debugLog) output(level logLevel, mask LogMask, msg string, args ...interface{}) {
+	l := d.logger
+	if l == nil {
+		d.log.Output(3, msg)
+		return
+	}
+	if n := len(msg); n > 0 && msg[n-1] == '\n' {
+		msg = msg[:n-1]
+	}
+	if level == levelDefault {
+		if level = levelDebug; mask&(LogConn|LogState) != 0 {
+			level = levelInfo
+		}
+	}
+	args = append([]interface{}{"category", mask.String()}, args...)
+	switch level {
+	case levelDebug:
+		l.Debug(msg, args...)
+	case levelInfo:
+		l.Info(msg, args...)
+	default:
+		l.Warn(msg, args...)
 	}
 }
 
@@ -155,13 +257,50 @@ func setServerName(config *tls.Config, host string) *tls.Config {
 	if config == nil {
 		config = &tls.Config{ServerName: host}
 	} else if config.ServerName == "" {
-		c := *config
-		c.ServerName = host
-		config = &c
+		config = config.Clone()
+		config.ServerName = host
 	}
 	return config
 }
 
+// VerifyCert returns a TLS configuration that replaces the default certificate
+// verification with a call to verify. The certificates presented by the server
+// are passed in the order received, with the leaf certificate first. The
+// handshake fails if verify returns an error. This can be used to accept
+// self-signed certificates in a controlled way. Host name, expiration, and
+// chain of trust are not checked unless verify does so.
+func VerifyCert(verify func(certs []*x509.Certificate) error) *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+			if len(raw) == 0 {
+				return errors.New("imap: server did not present a certificate")
+			}
+			certs := make([]*x509.Certificate, len(raw))
+			for i, der := range raw {
+				cert, err := x509.ParseCertificate(der)
+				if err != nil {
+					return err
+				}
+				certs[i] = cert
+			}
+			return verify(certs)
+		},
+	}
+}
+
+// PinCert returns a TLS configuration that rejects all server certificates
+// other than the one specified by der (ASN.1 DER encoding).
+func PinCert(der []byte) *tls.Config {
+	der = append([]byte(nil), der...)
+	return VerifyCert(func(certs []*x509.Certificate) error {
+		if !bytes.Equal(certs[0].Raw, der) {
+			return errors.New("imap: server certificate does not match the pin")
+		}
+		return nil
+	})
+}
+
 var b64codec = base64.StdEncoding
 
 // b64enc encodes src to Base64 representation, returning the result as a new
@@ -45,6 +45,44 @@ type (
 	Recv []byte
 )
 
+// Throttle is a script action that changes the way subsequent server writes
+// ("S: ..." lines, Send actions, and data written by a ScriptFunc) are delivered
+// to the client. Each write is split into pieces of at most Chunk bytes, with a
+// Delay between the pieces. Throttle{} restores normal writes. See Slow.
+type Throttle struct {
+	Delay time.Duration
+	Chunk int
+}
+
+// Slow returns a Throttle action that causes the server to send data in
+// chunk-sized pieces (e.g. one byte at a time) with the specified delay between
+// them. This exercises the client's handling of lines and literals that are
+// split across multiple network reads. The delay should be well below Timeout.
+func Slow(delay time.Duration, chunk int) Throttle {
+	return Throttle{delay, chunk}
+}
+
+// ConnFunc is a script action that operates directly on the server side of the
+// simulated network connection, bypassing the IMAP transport (buffering,
+// compression, and encryption). Drop and FailAfter are predefined actions for
+// simulating network failures.
+type ConnFunc func(c *Conn) error
+
+// Drop returns a script action that closes the network connection immediately,
+// without sending BYE or flushing any buffered data. The client sees an
+// unexpected EOF, which may be in the middle of a line or literal.
+func Drop() ConnFunc {
+	return func(c *Conn) error { return c.Close() }
+}
+
+// FailAfter returns a script action that causes the connection to be closed
+// after the server writes n more bytes. The write that exceeds the limit is
+// sent only partially, and the script ends without error at that point. Any
+// remaining actions are not executed.
+func FailAfter(n int) ConnFunc {
+	return func(c *Conn) error { return c.FailAfter(n) }
+}
+
 // ScriptFunc is function type called during script execution to control the
 // server state. STARTTLS, DEFLATE, and CLOSE are predefined script actions for
 // the most common operations.
@@ -57,12 +95,34 @@ var (
 	CLOSE    = func(s imap.MockServer) error { return s.Close(true) }
 )
 
+// Unsolicited returns a script action that sends untagged responses to the
+// client at the current point of the script (e.g. "* 5 EXISTS" between the
+// command and its completion, or before a continuation request). The lines may
+// be written with or without the "S: " prefix. All lines are buffered and then
+// flushed together, so the client receives them as a single burst of data,
+// which is how a real server usually sends them.
+func Unsolicited(lines ...string) ScriptFunc {
+	return func(s imap.MockServer) error {
+		for _, ln := range lines {
+			ln = strings.TrimPrefix(ln, "S: ")
+			if !strings.HasPrefix(ln, "* ") {
+				return fmt.Errorf("%+q is not an untagged response", ln)
+			}
+			if err := s.WriteLine([]byte(ln)); err != nil {
+				return err
+			}
+		}
+		return s.Flush()
+	}
+}
+
 // T wraps existing test state and provides methods for testing the IMAP client
 // against the scripted server.
 type T struct {
 	*testing.T
 
 	s  imap.MockServer    // Server instance
+	sc *Conn              // Server connection
 	ch <-chan interface{} // Script result channel
 
 	c  *imap.Client // Client instance
@@ -77,7 +137,7 @@ func Server(t *testing.T, script ...interface{}) *T {
 	c, s := NewConn("client", "server", 0)
 	c.SetTimeout(Timeout)
 	s.SetTimeout(Timeout)
-	mt := &T{T: t, s: imap.NewMockServer(s), cn: c}
+	mt := &T{T: t, s: imap.NewMockServer(s), sc: s, cn: c}
 	mt.Script(script...)
 	return mt
 }
@@ -117,11 +177,13 @@ func (t *T) DialTLS(config *tls.Config) (*imap.Client, error) {
 }
 
 // Script runs a server script in a separate goroutine. A script is a sequence
-// of string, Send, Recv, and ScriptFunc actions. Strings represent lines of
-// text to be sent ("S: ...") or received ("C: ...") by the server. There is an
-// implicit CRLF at the end of each line. Send and Recv allow the server to send
-// and receive raw bytes (usually literal strings). ScriptFunc allows server
-// state changes by calling methods on the provided imap.MockServer instance.
+// of string, Send, Recv, Throttle, ConnFunc, and ScriptFunc actions. Strings
+// represent lines of text to be sent ("S: ...") or received ("C: ...") by the
+// server. There is an implicit CRLF at the end of each line. Send and Recv allow
+// the server to send and receive raw bytes (usually literal strings). Throttle
+// controls how server writes are split (see Slow), and ConnFunc simulates
+// network failures (see Drop and FailAfter). ScriptFunc allows server state
+// changes by calling methods on the provided imap.MockServer instance.
 func (t *T) Script(script ...interface{}) {
 	select {
 	case <-t.ch:
@@ -172,7 +234,9 @@ func (t *T) script(script []interface{}, ch chan<- interface{}) {
 		case string:
 			if strings.HasPrefix(v, "S: ") {
 				err := t.s.WriteLine([]byte(v[3:]))
-				t.flush(ln, v, err)
+				if t.flush(ln, v, err) {
+					return
+				}
 			} else if strings.HasPrefix(v, "C: ") {
 				b, err := t.s.ReadLine()
 				t.compare(ln, v[3:], string(b), err)
@@ -181,11 +245,19 @@ func (t *T) script(script []interface{}, ch chan<- interface{}) {
 			}
 		case Send:
 			_, err := t.s.Write(v)
-			t.flush(ln, v, err)
+			if t.flush(ln, v, err) {
+				return
+			}
 		case Recv:
 			b := make([]byte, len(v))
 			_, err := io.ReadFull(t.s, b)
 			t.compare(ln, string(v), string(b), err)
+		case Throttle:
+			t.sc.SetWriteChunk(v.Chunk, v.Delay)
+		case ConnFunc:
+			if err := v(t.sc); err != nil {
+				panicf("[#%d] ConnFunc error: %v", ln, err)
+			}
 		case ScriptFunc:
 			t.run(ln, v)
 		case func(s imap.MockServer) error:
@@ -197,13 +269,19 @@ func (t *T) script(script []interface{}, ch chan<- interface{}) {
 }
 
 // flush sends any buffered data to the client and panics if there is an error.
-func (t *T) flush(ln int, v interface{}, err error) {
+// It returns true if the write failed because of a FailAfter action, which ends
+// the script.
+func (t *T) flush(ln int, v interface{}, err error) bool {
 	if err == nil {
 		err = t.s.Flush()
 	}
-	if err != nil {
-		panicf("[#%d] %+q write error: %v", ln, v, err)
+	if err == nil {
+		return false
+	} else if t.sc.writeFailed() {
+		return true
 	}
+	panicf("[#%d] %+q write error: %v", ln, v, err)
+	return false
 }
 
 // compare panics if v != b or err != nil.
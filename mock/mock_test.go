@@ -5,9 +5,12 @@
 package mock_test
 
 import (
+	"bytes"
+	"errors"
 	"io"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/mxk/go-imap/imap"
 	"github.com/mxk/go-imap/mock"
@@ -32,8 +35,21 @@ func TestGreeting(T *testing.T) {
 	t = mock.Server(T,
 		`S: * OK [CAPABILITY IMAP4rev1] Server ready`,
 	)
-	_, err = t.Dial()
+	c, err := t.Dial()
+	t.Join(err)
+	if s := c.State(); s != imap.Login {
+		t.Errorf("c.State() expected Login; got %v", s)
+	}
+
+	// Connection that starts in the authenticated state
+	t = mock.Server(T,
+		`S: * PREAUTH [CAPABILITY IMAP4rev1] Server ready`,
+	)
+	c, err = t.Dial()
 	t.Join(err)
+	if s := c.State(); s != imap.Auth {
+		t.Errorf("c.State() expected Auth; got %v", s)
+	}
 
 	// TLS negotiated before the greeting
 	t = mock.Server(T,
@@ -48,12 +64,46 @@ func TestGreeting(T *testing.T) {
 		`S: * BYE Server not ready`,
 		mock.CLOSE,
 	)
-	if _, err = t.Dial(); err == nil {
-		t.Errorf("t.Dial() expected an error")
+	c, err = t.Dial()
+	if e, ok := err.(*imap.ByeError); c != nil || !ok || e.Reason != "Server not ready" ||
+		!errors.Is(err, imap.ErrGreetingBye) || errors.Is(err, imap.ErrServerBye) {
+		t.Errorf("t.Dial() expected ErrGreetingBye; got %#v (%v)", c, err)
 	}
 	t.Join(nil)
 }
 
+func TestStartTLS(T *testing.T) {
+	t := mock.Server(T,
+		`S: * OK [CAPABILITY IMAP4rev1 STARTTLS LOGINDISABLED AUTH=X-WEAK] Server ready`,
+	)
+	c, err := t.Dial()
+	t.Join(err)
+
+	// Capabilities sent before the handshake are discarded
+	t.Script(
+		`C: A1 STARTTLS`,
+		`S: A1 OK [CAPABILITY IMAP4rev1 AUTH=X-WEAK] Begin TLS negotiation now`,
+		mock.STARTTLS,
+		`C: A2 CAPABILITY`,
+		`S: * CAPABILITY IMAP4rev1 AUTH=PLAIN`,
+		`S: A2 OK Thats all she wrote!`,
+	)
+	t.Join(t.StartTLS(nil))
+	for _, v := range []string{"STARTTLS", "LOGINDISABLED", "AUTH=X-WEAK"} {
+		if c.Caps[v] {
+			t.Errorf("c.Caps[%q] expected false after STARTTLS", v)
+		}
+	}
+	if !c.Caps["IMAP4REV1"] || !c.Caps["AUTH=PLAIN"] || len(c.Caps) != 2 {
+		t.Errorf("c.Caps expected IMAP4rev1 AUTH=PLAIN; got %v", c.Caps)
+	}
+
+	// STARTTLS is refused when the connection is already encrypted
+	if err = t.StartTLS(nil); err != imap.ErrEncryptionActive {
+		t.Errorf("t.StartTLS() expected ErrEncryptionActive; got %v", err)
+	}
+}
+
 func TestSession(T *testing.T) {
 	t := mock.Server(T,
 		`S: * OK [CAPABILITY IMAP4rev1 STARTTLS LOGINDISABLED] Server ready`,
@@ -174,3 +224,218 @@ func TestLiteral(T *testing.T) {
 	_, err = imap.Wait(c.List("", "*"))
 	t.Join(err)
 }
+
+func TestUnsolicited(T *testing.T) {
+	t := mock.Server(T,
+		`S: * PREAUTH [CAPABILITY IMAP4rev1] Server ready`,
+	)
+	c, err := t.Dial()
+	t.Join(err)
+
+	var updates []string
+	c.SetUpdateHandler(func(rsp *imap.Response) bool {
+		updates = append(updates, rsp.String())
+		return rsp.Label == "EXISTS"
+	})
+
+	// Pushed between the command and its completion
+	t.Script(
+		`C: A1 NOOP`,
+		mock.Unsolicited(`* 5 EXISTS`, `S: * 1 RECENT`),
+		`S: A1 OK NOOP completed`,
+	)
+	_, err = imap.Wait(c.Noop())
+	t.Join(err)
+
+	// Pushed before the continuation request of a literal
+	t.Script(
+		`C: A2 APPEND "INBOX" {5}`,
+		mock.Unsolicited(`* 6 EXISTS`),
+		`S: + Ready for literal data`,
+		mock.Recv("hello"),
+		`C: `,
+		`S: A2 OK APPEND completed`,
+	)
+	_, err = imap.Wait(c.Append("INBOX", nil, nil, imap.NewLiteral([]byte("hello"))))
+	t.Join(err)
+
+	want := []string{"* 5 EXISTS", "* 1 RECENT", "* 6 EXISTS"}
+	if strings.Join(updates, "|") != strings.Join(want, "|") {
+		t.Errorf("updates expected %q; got %q", want, updates)
+	}
+	if n := len(c.Data); n != 2 || c.Data[1].Label != "RECENT" {
+		t.Errorf("c.Data expected RECENT; got %v", c.Data)
+	}
+}
+
+func TestSlow(T *testing.T) {
+	t := mock.Server(T,
+		mock.Slow(time.Millisecond, 1),
+		`S: * PREAUTH [CAPABILITY IMAP4rev1] Server ready`,
+	)
+	c, err := t.Dial()
+	t.Join(err)
+
+	// Lines and literals delivered one byte at a time
+	t.Script(
+		`C: A1 SELECT "INBOX"`,
+		`S: * 2 EXISTS`,
+		`S: A1 OK [READ-WRITE] SELECT completed`,
+		`C: A2 UID FETCH 1:2 (BODY.PEEK[])`,
+		`S: * 1 FETCH (UID 1 BODY[] {12}`,
+		mock.Send("hello\r\nworld"),
+		`S: )`,
+		mock.Slow(2*time.Millisecond, 5),
+		`S: * 2 FETCH (UID 2 BODY[] {0}`,
+		`S: )`,
+		`S: A2 OK FETCH completed`,
+		mock.Throttle{},
+	)
+	_, err = c.Select("INBOX", false)
+	set, _ := imap.NewSeqSet("1:2")
+	var cmd *imap.Command
+	if err == nil {
+		cmd, err = imap.Wait(c.UIDFetch(set, "BODY.PEEK[]"))
+	}
+	t.Join(err)
+
+	if n := len(cmd.Data); n != 2 {
+		t.Fatalf("len(cmd.Data) expected 2; got %d", n)
+	}
+	body := imap.AsString(cmd.Data[0].MessageInfo().Attrs["BODY[]"])
+	if body != "hello\r\nworld" {
+		t.Errorf("BODY[] expected %q; got %q", "hello\r\nworld", body)
+	}
+}
+
+func TestDrop(T *testing.T) {
+	t := mock.Server(T,
+		`S: * PREAUTH [CAPABILITY IMAP4rev1] Server ready`,
+	)
+	c, err := t.Dial()
+	t.Join(err)
+
+	// Connection dropped in the middle of a literal
+	t.Script(
+		`C: A1 LIST "" "*"`,
+		`S: * LIST () "/" {10}`,
+		mock.Send("fo"),
+		mock.Drop(),
+	)
+	_, err = imap.Wait(c.List("", "*"))
+	t.Join(nil)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("c.List() expected ErrUnexpectedEOF; got %v", err)
+	}
+	if s := c.State(); s != imap.Closed {
+		t.Fatalf("c.State() expected Closed; got %v", s)
+	}
+	if _, err = c.Noop(); err == nil {
+		t.Errorf("c.Noop() expected an error")
+	}
+}
+
+func TestFailAfter(T *testing.T) {
+	t := mock.Server(T,
+		`S: * PREAUTH [CAPABILITY IMAP4rev1] Server ready`,
+	)
+	c, err := t.Dial()
+	t.Join(err)
+
+	// Write fails in the middle of a line
+	t.Script(
+		`C: A1 NOOP`,
+		mock.FailAfter(20),
+		`S: * 3 EXISTS`,
+		`S: A1 OK NOOP completed`,
+		`S: * 4 EXISTS`,
+	)
+	_, err = imap.Wait(c.Noop())
+	t.Join(nil)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("c.Noop() expected ErrUnexpectedEOF; got %v", err)
+	}
+	if s := c.State(); s != imap.Closed {
+		t.Fatalf("c.State() expected Closed; got %v", s)
+	}
+	if n := len(c.Data); n != 2 || c.Data[1].Label != "EXISTS" {
+		t.Errorf("c.Data expected EXISTS; got %v", c.Data)
+	}
+}
+
+func TestRecord(T *testing.T) {
+	c, s := mock.NewConn("client", "server", 0)
+	c.SetTimeout(mock.Timeout)
+	s.SetTimeout(mock.Timeout)
+	r := mock.Record(c)
+
+	// Lines and literals split at arbitrary points
+	exchange := []struct{ from, data string }{
+		{"S", "* PREAUTH [CAPABILITY IMAP4rev1] Server `ready`\r\n"},
+		{"C", "A1 APPEND \"INBOX\" {5}\r\n"},
+		{"S", "+ Ready for literal data\r\n"},
+		{"C", "hel"},
+		{"C", "lo\r\n"},
+		{"S", "A1 OK APPEND completed\r\n"},
+		{"C", "A2 LIST \"\" \"*\"\r\n"},
+		{"S", "* LIST () \"/\" {8}\r\nfoo"},
+		{"S", "\r\nbar\r\nA2 OK LIST"},
+		{"S", " completed\r\n"},
+	}
+	buf := make([]byte, 64)
+	for _, x := range exchange {
+		w, rd := io.Writer(s), io.Reader(r)
+		if x.from == "C" {
+			w, rd = r, s
+		}
+		if _, err := w.Write([]byte(x.data)); err != nil {
+			T.Fatalf("Write(%q) unexpected error; %v", x.data, err)
+		}
+		if _, err := io.ReadFull(rd, buf[:len(x.data)]); err != nil {
+			T.Fatalf("Read(%q) unexpected error; %v", x.data, err)
+		}
+	}
+
+	var out bytes.Buffer
+	r.WriteTo(&out)
+	want := []string{
+		"\"S: * PREAUTH [CAPABILITY IMAP4rev1] Server `ready`\",",
+		"`C: A1 APPEND \"INBOX\" {5}`,",
+		"`S: + Ready for literal data`,",
+		"mock.Recv(\"hello\"),",
+		"`C: `,",
+		"`S: A1 OK APPEND completed`,",
+		"`C: A2 LIST \"\" \"*\"`,",
+		"`S: * LIST () \"/\" {8}`,",
+		"mock.Send(\"foo\\r\\nbar\"),",
+		"`S: `,",
+		"`S: A2 OK LIST completed`,",
+		"",
+	}
+	if got := out.String(); got != strings.Join(want, "\n") {
+		T.Fatalf("r.WriteTo() expected\n%s\ngot\n%s", strings.Join(want, "\n"), got)
+	}
+
+	// Replayed script drives the client through the same session
+	script, err := mock.Replay(strings.NewReader("// Recorded session\n" + out.String()))
+	if err != nil {
+		T.Fatalf("mock.Replay() unexpected error; %v", err)
+	}
+	if len(script) != len(want)-1 {
+		T.Fatalf("len(script) expected %d; got %d", len(want)-1, len(script))
+	}
+	t := mock.Server(T, script[0])
+	cl, err := t.Dial()
+	t.Join(err)
+
+	t.Script(script[1:6]...)
+	_, err = imap.Wait(cl.Append("INBOX", nil, nil, imap.NewLiteral([]byte("hello"))))
+	t.Join(err)
+
+	t.Script(script[6:]...)
+	cmd, err := imap.Wait(cl.List("", "*"))
+	t.Join(err)
+	if n := len(cmd.Data); n != 1 || cmd.Data[0].MailboxInfo().Name != "foo\r\nbar" {
+		t.Errorf("cmd.Data expected foo\\r\\nbar; got %v", cmd.Data)
+	}
+}
@@ -5,6 +5,7 @@
 package mock
 
 import (
+	"errors"
 	"io"
 	"net"
 	"sync"
@@ -19,8 +20,18 @@ type Conn struct {
 	rd time.Time     // Read deadline
 	wd time.Time     // Write deadline
 	t  time.Duration // Read/write timeout
+
+	chunk int           // Maximum number of bytes per write (0 = no limit)
+	delay time.Duration // Delay between chunks
+	fail  int           // Bytes to write before a failure (-1 = no failure)
+
+	failed bool // Set when the failure requested by FailAfter is triggered
 }
 
+// ErrWriteFailed is returned by Conn.Write when the failure requested by
+// FailAfter is triggered.
+var ErrWriteFailed = errors.New("mock: injected write failure")
+
 // NewConn creates a pair of connected net.Conn instances. The addresses are
 // arbitrary strings used to distinguish the two ends of the connection. bufSize
 // is the maximum number of bytes that can be written to each connection before
@@ -32,7 +43,7 @@ func NewConn(addrA, addrB string, bufSize int) (A *Conn, B *Conn) {
 	mu := new(sync.Mutex)
 	a := newHalfConn(mu, addrA, bufSize)
 	b := newHalfConn(mu, addrB, bufSize)
-	return &Conn{mu: mu, r: a, w: b}, &Conn{mu: mu, r: b, w: a}
+	return &Conn{mu: mu, r: a, w: b, fail: -1}, &Conn{mu: mu, r: b, w: a, fail: -1}
 }
 
 // Read reads data from the connection. It can be made to time out and return a
@@ -51,8 +62,47 @@ func (c *Conn) Read(b []byte) (n int, err error) {
 
 // Write writes data to the connection. It can be made to time out and return a
 // net.Error with Timeout() == true after a deadline or a per-Write timeout; see
-// SetDeadline, SetWriteDeadline, and SetTimeout.
+// SetDeadline, SetWriteDeadline, and SetTimeout. The data may be split into
+// multiple chunks; see SetWriteChunk. A failure may be injected with FailAfter.
 func (c *Conn) Write(b []byte) (n int, err error) {
+	c.mu.Lock()
+	chunk, delay, fail := c.chunk, c.delay, c.fail
+	if fail >= 0 {
+		if c.fail -= len(b); c.fail < 0 {
+			c.fail, c.failed = -1, true
+		}
+	}
+	c.mu.Unlock()
+	if fail >= 0 && len(b) > fail {
+		if fail > 0 {
+			n, err = c.Write(b[:fail])
+		}
+		c.Close()
+		if err == nil {
+			err = ErrWriteFailed
+		}
+		return
+	}
+	if chunk <= 0 || len(b) <= chunk {
+		return c.write(b)
+	}
+	for len(b) > 0 && err == nil {
+		p := b
+		if len(p) > chunk {
+			p = p[:chunk]
+		}
+		if n > 0 && delay > 0 {
+			time.Sleep(delay)
+		}
+		var m int
+		m, err = c.write(p)
+		n, b = n+m, b[m:]
+	}
+	return
+}
+
+// write writes a single chunk of data to the connection.
+func (c *Conn) write(b []byte) (n int, err error) {
 	var t timer
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -122,6 +172,41 @@ func (c *Conn) SetTimeout(d time.Duration) error {
 	return nil
 }
 
+// SetWriteChunk causes future Write calls to send the data in pieces of at most
+// chunk bytes, sleeping for delay between consecutive pieces. This allows the
+// remote side to receive partial lines and literals in separate Read calls.
+// Deadlines and timeouts apply to each piece separately. A value <= 0 for chunk
+// disables splitting.
+func (c *Conn) SetWriteChunk(chunk int, delay time.Duration) error {
+	if chunk < 0 {
+		chunk = 0
+	}
+	c.mu.Lock()
+	c.chunk, c.delay = chunk, delay
+	c.mu.Unlock()
+	return nil
+}
+
+// FailAfter causes the connection to be closed after n more bytes are written.
+// The Write call that exceeds the limit writes the bytes that fit and returns
+// ErrWriteFailed. The remote side receives the partial data followed by EOF.
+func (c *Conn) FailAfter(n int) error {
+	if n < 0 {
+		n = -1
+	}
+	c.mu.Lock()
+	c.fail = n
+	c.mu.Unlock()
+	return nil
+}
+
+// writeFailed returns true if the failure requested by FailAfter was triggered.
+func (c *Conn) writeFailed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failed
+}
+
 // close closes the connection.
 func (c *Conn) close() {
 	if c.r.buf != nil {
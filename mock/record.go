@@ -0,0 +1,223 @@
+// Copyright 2013 The Go-IMAP Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mock
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Recorder is a net.Conn wrapper that captures all data exchanged with a real
+// IMAP server and converts it into a server script. Lines sent by the server
+// become "S: ..." actions and lines sent by the client become "C: ..." actions.
+// Literal strings are recorded as Send (server) and Recv (client) actions,
+// followed by the remainder of the line that contained the literal.
+//
+// The recorder sees the raw bytes written to and read from the underlying
+// connection. Use it on top of an encrypted connection (e.g. one returned by
+// tls.Dial), and do not use STARTTLS or COMPRESS commands while recording.
+type Recorder struct {
+	net.Conn
+
+	mu     sync.Mutex
+	script []interface{} // Completed actions
+	s, c   segmenter     // Server and client data streams
+}
+
+// Record returns a Recorder that proxies all Read and Write calls to conn.
+func Record(conn net.Conn) *Recorder {
+	return &Recorder{
+		Conn: conn,
+		s:    segmenter{dir: "S: ", lit: func(b []byte) interface{} { return Send(b) }},
+		c:    segmenter{dir: "C: ", lit: func(b []byte) interface{} { return Recv(b) }},
+	}
+}
+
+// Read reads data sent by the server.
+func (r *Recorder) Read(b []byte) (n int, err error) {
+	n, err = r.Conn.Read(b)
+	if n > 0 {
+		r.mu.Lock()
+		r.script = r.s.feed(r.script, b[:n])
+		r.mu.Unlock()
+	}
+	return
+}
+
+// Write writes data to the server.
+func (r *Recorder) Write(b []byte) (n int, err error) {
+	n, err = r.Conn.Write(b)
+	if n > 0 {
+		r.mu.Lock()
+		r.script = r.c.feed(r.script, b[:n])
+		r.mu.Unlock()
+	}
+	return
+}
+
+// Script returns the actions recorded so far, which can be passed directly to
+// Server or T.Script. Any incomplete line or literal is returned as a final
+// Send or Recv action.
+func (r *Recorder) Script() []interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	script := append([]interface{}(nil), r.script...)
+	if len(r.c.buf) > 0 {
+		script = append(script, r.c.lit(append([]byte(nil), r.c.buf...)))
+	}
+	if len(r.s.buf) > 0 {
+		script = append(script, r.s.lit(append([]byte(nil), r.s.buf...)))
+	}
+	return script
+}
+
+// WriteTo writes the recorded script to w as Go source code, one action per
+// line. The output can be pasted into a call to Server or parsed by Replay.
+func (r *Recorder) WriteTo(w io.Writer) (n int64, err error) {
+	for _, v := range r.Script() {
+		var m int
+		switch v := v.(type) {
+		case string:
+			m, err = fmt.Fprintf(w, "%s,\n", quote(v))
+		case Send:
+			m, err = fmt.Fprintf(w, "mock.Send(%q),\n", []byte(v))
+		case Recv:
+			m, err = fmt.Fprintf(w, "mock.Recv(%q),\n", []byte(v))
+		}
+		if n += int64(m); err != nil {
+			break
+		}
+	}
+	return
+}
+
+// Replay parses a script written by Recorder.WriteTo. Each non-empty line must
+// contain one action: a raw (`...`) or interpreted ("...") string literal, a
+// call to mock.Send or mock.Recv with a string literal argument, or one of the
+// predefined actions mock.STARTTLS, mock.DEFLATE, and mock.CLOSE. Trailing
+// commas and lines beginning with "//" are ignored.
+func Replay(r io.Reader) (script []interface{}, err error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 64*1024*1024)
+	for ln := 1; sc.Scan(); ln++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimSuffix(line, ","))
+		v, err := replayAction(line)
+		if err != nil {
+			return nil, fmt.Errorf("mock: replay line %d: %v", ln, err)
+		}
+		script = append(script, v)
+	}
+	return script, sc.Err()
+}
+
+// replayAction converts one line of a recorded script into a script action.
+func replayAction(line string) (interface{}, error) {
+	name := strings.TrimPrefix(line, "mock.")
+	switch name {
+	case "STARTTLS":
+		return STARTTLS, nil
+	case "DEFLATE":
+		return DEFLATE, nil
+	case "CLOSE":
+		return CLOSE, nil
+	}
+	if strings.HasPrefix(line, "`") || strings.HasPrefix(line, `"`) {
+		return strconv.Unquote(line)
+	}
+	for _, fn := range []string{"Send", "Recv"} {
+		arg := strings.TrimPrefix(name, fn+"(")
+		if len(arg) == len(name) || !strings.HasSuffix(arg, ")") {
+			continue
+		}
+		s, err := strconv.Unquote(arg[:len(arg)-1])
+		if err != nil {
+			return nil, err
+		} else if fn == "Send" {
+			return Send(s), nil
+		}
+		return Recv(s), nil
+	}
+	return nil, fmt.Errorf("unknown action %+q", line)
+}
+
+// segmenter splits one direction of an IMAP data stream into lines and literal
+// strings.
+type segmenter struct {
+	dir string                     // Line prefix ("S: " or "C: ")
+	lit func(b []byte) interface{} // Literal action constructor
+	buf []byte                     // Incomplete line or literal
+	rem int                        // Remaining literal bytes
+}
+
+// feed appends all lines and literals completed by b to script.
+func (s *segmenter) feed(script []interface{}, b []byte) []interface{} {
+	for len(b) > 0 {
+		if s.rem > 0 {
+			n := s.rem
+			if n > len(b) {
+				n = len(b)
+			}
+			s.buf = append(s.buf, b[:n]...)
+			if b, s.rem = b[n:], s.rem-n; s.rem == 0 {
+				script = append(script, s.lit(s.buf))
+				s.buf = nil
+			}
+			continue
+		}
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			s.buf = append(s.buf, b...)
+			break
+		}
+		line := append(s.buf, b[:i]...)
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		script = append(script, s.dir+string(line))
+		b, s.buf, s.rem = b[i+1:], nil, literalLen(line)
+	}
+	return script
+}
+
+// literalLen returns the length of the literal string announced at the end of
+// line, or 0 if there isn't one. Both synchronizing ({n}) and non-synchronizing
+// ({n+}) forms are recognized, as well as their literal8 (~{n}) equivalents.
+func literalLen(line []byte) int {
+	if len(line) < 3 || line[len(line)-1] != '}' {
+		return 0
+	}
+	i := bytes.LastIndexByte(line, '{')
+	if i < 0 {
+		return 0
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(string(line[i+1:len(line)-1]), "+"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// quote returns s as a Go string literal, preferring the raw form when s is
+// valid UTF-8 without backquotes or control characters.
+func quote(s string) string {
+	if !utf8.ValidString(s) || strings.ContainsRune(s, '`') {
+		return strconv.Quote(s)
+	}
+	for _, c := range s {
+		if c < ' ' || c == 0x7f || c == utf8.RuneError {
+			return strconv.Quote(s)
+		}
+	}
+	return "`" + s + "`"
+}
@@ -44,12 +44,13 @@ func newConfig() (client, server *tls.Config) {
 	tpl := x509.Certificate{
 		SerialNumber:          new(big.Int).SetInt64(42),
 		Subject:               pkix.Name{CommonName: ServerName},
+		DNSNames:              []string{ServerName},
 		NotBefore:             now.Add(-2 * time.Hour).UTC(),
 		NotAfter:              now.Add(2 * time.Hour).UTC(),
 		BasicConstraintsValid: true,
 		IsCA: true,
 	}
-	priv, err := rsa.GenerateKey(rand.Reader, 512)
+	priv, err := rsa.GenerateKey(rand.Reader, 1024)
 	if err != nil {
 		panic(err)
 	}